@@ -1,19 +1,26 @@
 package main
 
 import (
+	"context"
 	"errors"
+	stdflag "flag"
 	"fmt"
+	"net/http"
 	"os"
 	"os/signal"
 	"runtime"
 	"syscall"
+	"time"
 
 	flag "github.com/bborbe/flagenv"
+	"github.com/playnet-public/democracy.bot/pkg/metrics"
 	"github.com/playnet-public/democracy.bot/pkg/votes"
+	"github.com/playnet-public/democracy.bot/pkg/web"
 
 	"github.com/bwmarrin/discordgo"
 	raven "github.com/getsentry/raven-go"
 	"github.com/golang/glog"
+	"github.com/gorilla/securecookie"
 	"github.com/kolide/kit/version"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
@@ -31,15 +38,21 @@ var (
 	versionPtr  = flag.Bool("version", true, "show or hide version info")
 
 	apiToken     = flag.String("apiToken", "", "discord api token")
-	port         = flag.String("port", "80", "auth server port")
+	port         = flag.String("port", "80", "http server port for the api, dashboard, metrics and health checks")
 	callback     = flag.String("callback", "http://localhost/callback", "oauth callback url")
 	clientID     = flag.String("clientID", "", "oauth client id")
 	clientSecret = flag.String("clientSecret", "", "oauth client secret")
+	sessionKey   = flag.String("sessionKey", "", "dashboard session signing key, random if empty")
+
+	handlerTimeout = flag.Duration("handlerTimeout", 10*time.Second, "deadline of a command or reaction after which its discord lookups fail")
+	rateLimits     = flag.Bool("rateLimits", true, "limit the commands and reactions per user and guild")
 
 	dbHost     = flag.String("dbHost", "localhost", "db server host")
 	dbUser     = flag.String("dbUser", "db", "db user")
 	dbName     = flag.String("dbName", "db", "db name")
 	dbPassword = flag.String("dbPassword", "dev", "db password")
+	sqlitePath = flag.String("sqlitePath", "democracy.db", "sqlite database file")
+	storeType  = flag.String("store", "postgres", "storage backend: postgres, sqlite or memory")
 
 	sentry *raven.Client
 )
@@ -82,11 +95,20 @@ func main() {
 	if err != nil {
 		panic(err)
 	}
+
+	if stdflag.Arg(0) == "migrate" {
+		err = migrate(log, stdflag.Arg(1))
+		if err != nil {
+			log.Fatal("migration failed", zap.Error(err))
+		}
+		return
+	}
+
 	errs := make(chan error)
 
 	// catch system interrupts
 	go func() {
-		c := make(chan os.Signal)
+		c := make(chan os.Signal, 1)
 		signal.Notify(c, syscall.SIGINT, syscall.SIGTERM)
 		errs <- fmt.Errorf("%s", <-c)
 	}()
@@ -123,17 +145,31 @@ func do(log *zap.Logger) error {
 		return err
 	}
 
+	store, err := newStore(log)
+	if err != nil {
+		log.Error("failed to open store", zap.String("store", *storeType), zap.Error(err))
+		return err
+	}
 	voteHandler := votes.NewVoteHandler(log)
-	err = voteHandler.InitDB(*dbHost, *dbName, *dbUser, *dbPassword)
+	voteHandler.SetStore(votes.NewCachedStore(store))
+	voteHandler.RegisterMetrics(metrics.Default)
 	bot := votes.New(log)
+	bot.SetReporter(votes.ReporterFunc(func(err error, tags map[string]string) {
+		raven.CaptureError(err, tags)
+	}))
+	if *rateLimits {
+		bot.Use(votes.RateLimit(votes.DefaultRateLimits))
+	}
+	bot.Use(votes.Timeout(*handlerTimeout))
 
 	bot.AddMessageHandler("reset", bot.ResetDemocracy)
-	bot.AddMessageHandler("vote", voteHandler.Vote)
-	bot.AddReactionHandler("Vote created", voteHandler.React)
-	bot.AddReactionHandler("[Vote]", voteHandler.React)
+	voteHandler.Register(bot)
 
 	log.Info("adding handlers")
 	discord.AddHandler(bot.Ready)
+	discord.AddHandler(bot.Connect)
+	discord.AddHandler(bot.Disconnect)
+	discord.AddHandler(bot.RateLimit)
 	discord.AddHandler(bot.MessageCreate)
 	discord.AddHandler(bot.ReactionAdd)
 
@@ -142,16 +178,150 @@ func do(log *zap.Logger) error {
 		log.Error("failed to open discord session", zap.Error(err))
 	}
 
+	stop := make(chan struct{})
+	session := votes.NewSession(discord)
+	go voteHandler.RunScheduler(session, time.Minute, stop)
+	go voteHandler.RunReconciler(session, 15*time.Minute, stop)
+	go logCacheStats(log, voteHandler, 10*time.Minute, stop)
+
+	health := web.NewHealth(log)
+	health.Add("discord", bot.Connected)
+	health.Add("store", store.Ping)
+	server := newServer(log, voteHandler, session, health)
+
 	log.Info("running")
 	sc := make(chan os.Signal, 1)
 	signal.Notify(sc, syscall.SIGINT, syscall.SIGTERM, os.Interrupt, os.Kill)
 	<-sc
 
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	server.Shutdown(ctx)
+	cancel()
+	close(stop)
+	voteHandler.Close()
+	bot.Close()
 	discord.Close()
 
 	return nil
 }
 
+// newServer serving the REST API, metrics, health checks and, with an oauth client id, the dashboard on the http server port
+func newServer(log *zap.Logger, voteHandler *votes.VoteHandler, session votes.Session, health *web.Health) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle(web.APIPrefix+"/", web.NewAPI(log, voteHandler, session))
+	mux.Handle("/metrics", metrics.Default)
+	health.Register(mux)
+	if *clientID != "" {
+		key := []byte(*sessionKey)
+		if len(key) == 0 {
+			log.Warn("no session key provided, dashboard logins are lost on restart")
+			key = securecookie.GenerateRandomKey(32)
+		}
+		mux.Handle("/", web.NewDashboard(log, voteHandler, session, web.Config{
+			ClientID:     *clientID,
+			ClientSecret: *clientSecret,
+			Callback:     *callback,
+			SessionKey:   key,
+		}))
+	} else {
+		log.Info("no oauth client id provided, dashboard disabled")
+	}
+	server := &http.Server{Addr: ":" + *port, Handler: mux}
+	go func() {
+		log.Info("starting http server", zap.String("port", *port))
+		err := server.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			log.Error("http server failed", zap.Error(err))
+		}
+	}()
+	return server
+}
+
+// logCacheStats of voteHandler every interval until stop is closed
+func logCacheStats(log *zap.Logger, voteHandler *votes.VoteHandler, interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			voteStats, authorStats := voteHandler.CacheStats()
+			log.Info("cache stats",
+				zap.Uint64("voteHits", voteStats.Hits),
+				zap.Uint64("voteMisses", voteStats.Misses),
+				zap.Float64("voteHitRate", voteStats.HitRate()),
+				zap.Uint64("authorHits", authorStats.Hits),
+				zap.Uint64("authorMisses", authorStats.Misses),
+				zap.Float64("authorHitRate", authorStats.HitRate()),
+			)
+		}
+	}
+}
+
+// newStore for the backend selected by the store flag
+func newStore(log *zap.Logger) (votes.Store, error) {
+	switch *storeType {
+	case "postgres":
+		store, err := votes.NewPostgresStore(log, *dbHost, *dbName, *dbUser, *dbPassword)
+		if err != nil {
+			return nil, err
+		}
+		return store, store.Migrate()
+	case "sqlite":
+		store, err := votes.NewSQLiteStore(log, *sqlitePath)
+		if err != nil {
+			return nil, err
+		}
+		return store, store.Migrate()
+	case "memory":
+		log.Warn("using in-memory store, all votes are lost on restart")
+		return votes.NewMemoryStore(), nil
+	}
+	return nil, fmt.Errorf("unknown store '%s', expected postgres, sqlite or memory", *storeType)
+}
+
+// migrate the schema of the sql store selected by the store flag: status, up or down
+func migrate(log *zap.Logger, cmd string) error {
+	var store *votes.SQLStore
+	var err error
+	switch *storeType {
+	case "postgres":
+		store, err = votes.NewPostgresStore(log, *dbHost, *dbName, *dbUser, *dbPassword)
+	case "sqlite":
+		store, err = votes.NewSQLiteStore(log, *sqlitePath)
+	default:
+		return fmt.Errorf("store '%s' has no migrations, expected postgres or sqlite", *storeType)
+	}
+	if err != nil {
+		return err
+	}
+	switch cmd {
+	case "up":
+		err = store.Migrate()
+	case "down":
+		err = store.MigrateDown()
+	case "status", "":
+	default:
+		return fmt.Errorf("unknown migrate command '%s', expected status, up or down", cmd)
+	}
+	if err != nil {
+		return err
+	}
+	states, err := store.MigrationStatus()
+	if err != nil {
+		return err
+	}
+	for _, state := range states {
+		applied := "pending"
+		if !state.Applied.IsZero() {
+			applied = "applied " + state.Applied.Format("02-01-2006 - 15:04:05")
+		}
+		fmt.Printf("%4d  %-40s %s\n", state.Version, state.Name, applied)
+	}
+	return nil
+}
+
 //TODO: Move this to playnet common libs
 func newLogger(dbg bool) *zap.Logger {
 	highPriority := zap.LevelEnablerFunc(func(lvl zapcore.Level) bool {
@@ -0,0 +1,643 @@
+// Package discordtest provides an in-process fake of Discord for testing the bot without network access.
+package discordtest
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Call of a Discord operation recorded by the Session
+type Call struct {
+	Method string
+	Args   []string
+}
+
+func (c Call) String() string {
+	return fmt.Sprintf("%s(%s)", c.Method, strings.Join(c.Args, ", "))
+}
+
+// Session faking Discord in memory.
+// It records all messages, reactions, roles and channels and every call made through it.
+type Session struct {
+	mu     sync.Mutex
+	nextID uint64
+
+	me       *discordgo.User
+	users    map[string]*discordgo.User
+	guilds   map[string]*discordgo.Guild
+	channels map[string]*discordgo.Channel
+	messages map[string]*discordgo.Message
+	// order of the messages in a channel
+	order map[string][]string
+	// reactions of a message by emoji, in the order they were added
+	reactions map[string]map[string][]string
+	deleted   []*discordgo.Message
+	calls     []Call
+	failures  map[string]error
+}
+
+// NewSession for a bot user without any guilds
+func NewSession() *Session {
+	s := &Session{
+		nextID:    1000,
+		users:     make(map[string]*discordgo.User),
+		guilds:    make(map[string]*discordgo.Guild),
+		channels:  make(map[string]*discordgo.Channel),
+		messages:  make(map[string]*discordgo.Message),
+		order:     make(map[string][]string),
+		reactions: make(map[string]map[string][]string),
+		failures:  make(map[string]error),
+	}
+	s.me = s.AddUser("democracy.bot")
+	s.me.Bot = true
+	return s
+}
+
+func (s *Session) id() string {
+	s.nextID++
+	return strconv.FormatUint(s.nextID, 10)
+}
+
+// record a call and return the failure set for method
+func (s *Session) record(method string, args ...string) error {
+	s.calls = append(s.calls, Call{Method: method, Args: args})
+	return s.failures[method]
+}
+
+// Fail all following calls of method with err, a nil err lets them succeed again
+func (s *Session) Fail(method string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err == nil {
+		delete(s.failures, method)
+		return
+	}
+	s.failures[method] = err
+}
+
+// NotFound error as returned by Discord for unknown objects
+func NotFound(what string) error {
+	return &discordgo.RESTError{
+		Response:     &http.Response{StatusCode: http.StatusNotFound, Status: "404 Not Found"},
+		ResponseBody: []byte(fmt.Sprintf(`{"code": 0, "message": "Unknown %s"}`, what)),
+		Message:      &discordgo.APIErrorMessage{Message: "Unknown " + what},
+	}
+}
+
+// AddUser with name
+func (s *Session) AddUser(name string) *discordgo.User {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user := &discordgo.User{ID: s.id(), Username: name, Discriminator: "0001"}
+	s.users[user.ID] = user
+	return user
+}
+
+// discordEpoch of snowflake IDs in milliseconds since the unix epoch
+const discordEpoch = 1420070400000
+
+// AddUserAt with name whose snowflake ID encodes that the account was created at created
+func (s *Session) AddUserAt(name string, created time.Time) *discordgo.User {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextID++
+	ms := uint64(created.UnixNano()/int64(time.Millisecond) - discordEpoch)
+	id := strconv.FormatUint(ms<<22|s.nextID&0x3fffff, 10)
+	user := &discordgo.User{ID: id, Username: name, Discriminator: "0001"}
+	s.users[user.ID] = user
+	return user
+}
+
+// AddGuild owned by owner with the bot as its only member
+func (s *Session) AddGuild(name string, owner *discordgo.User) *discordgo.Guild {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	guild := &discordgo.Guild{ID: s.id(), Name: name, OwnerID: owner.ID}
+	s.guilds[guild.ID] = guild
+	s.addMember(guild, s.me)
+	return guild
+}
+
+// AddMember user to guild
+func (s *Session) AddMember(guild *discordgo.Guild, user *discordgo.User) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.addMember(s.guilds[guild.ID], user)
+}
+
+// AddMemberAt user to guild, having joined at joined
+func (s *Session) AddMemberAt(guild *discordgo.Guild, user *discordgo.User, joined time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.addMember(s.guilds[guild.ID], user)
+	members := s.guilds[guild.ID].Members
+	members[len(members)-1].JoinedAt = joined.Format(time.RFC3339)
+}
+
+func (s *Session) addMember(guild *discordgo.Guild, user *discordgo.User) {
+	guild.Members = append(guild.Members, &discordgo.Member{GuildID: guild.ID, User: user})
+	guild.MemberCount++
+}
+
+// RemoveMember user from guild, like a kick or leave
+func (s *Session) RemoveMember(guild *discordgo.Guild, user *discordgo.User) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	g := s.guilds[guild.ID]
+	for i, m := range g.Members {
+		if m.User.ID == user.ID {
+			g.Members = append(g.Members[:i], g.Members[i+1:]...)
+			g.MemberCount--
+			return
+		}
+	}
+}
+
+// AddChannel with name to guild
+func (s *Session) AddChannel(guild *discordgo.Guild, name string) *discordgo.Channel {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.addChannel(guild.ID, name, discordgo.ChannelTypeGuildText)
+}
+
+func (s *Session) addChannel(guildID, name string, ctype discordgo.ChannelType) *discordgo.Channel {
+	c := &discordgo.Channel{ID: s.id(), GuildID: guildID, Name: name, Type: ctype}
+	s.channels[c.ID] = c
+	if guild, ok := s.guilds[guildID]; ok {
+		c.Position = len(guild.Channels)
+		guild.Channels = append(guild.Channels, c)
+	}
+	return c
+}
+
+// AddRole with name to guild
+func (s *Session) AddRole(guild *discordgo.Guild, name string) *discordgo.Role {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	role := &discordgo.Role{ID: s.id(), Name: name}
+	g := s.guilds[guild.ID]
+	g.Roles = append(g.Roles, role)
+	return role
+}
+
+// Message by author posted to channel, returned as the event Discord would send
+func (s *Session) Message(channel string, author *discordgo.User, content string) *discordgo.MessageCreate {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	msg := s.post(channel, author, content, nil)
+	return &discordgo.MessageCreate{Message: copyMessage(msg)}
+}
+
+// React with emoji on message as user, returned as the event Discord would send
+func (s *Session) React(channel, message, emoji string, user *discordgo.User) *discordgo.MessageReactionAdd {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.react(message, emoji, user.ID)
+	return &discordgo.MessageReactionAdd{MessageReaction: &discordgo.MessageReaction{
+		UserID:    user.ID,
+		MessageID: message,
+		ChannelID: channel,
+		Emoji:     discordgo.Emoji{Name: emoji},
+	}}
+}
+
+func (s *Session) post(channel string, author *discordgo.User, content string, embed *discordgo.MessageEmbed) *discordgo.Message {
+	msg := &discordgo.Message{ID: s.id(), ChannelID: channel, Author: author, Content: content}
+	if embed != nil {
+		msg.Embeds = []*discordgo.MessageEmbed{embed}
+	}
+	s.messages[msg.ID] = msg
+	s.order[channel] = append(s.order[channel], msg.ID)
+	return msg
+}
+
+func (s *Session) react(message, emoji, user string) {
+	if s.reactions[message] == nil {
+		s.reactions[message] = make(map[string][]string)
+	}
+	for _, u := range s.reactions[message][emoji] {
+		if u == user {
+			return
+		}
+	}
+	s.reactions[message][emoji] = append(s.reactions[message][emoji], user)
+}
+
+// Messages in channel in the order they were posted
+func (s *Session) Messages(channel string) []*discordgo.Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var msgs []*discordgo.Message
+	for _, id := range s.order[channel] {
+		if msg, ok := s.messages[id]; ok {
+			msgs = append(msgs, copyMessage(msg))
+		}
+	}
+	return msgs
+}
+
+// Deleted messages in the order they were deleted
+func (s *Session) Deleted() []*discordgo.Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	msgs := make([]*discordgo.Message, len(s.deleted))
+	for i, msg := range s.deleted {
+		msgs[i] = copyMessage(msg)
+	}
+	return msgs
+}
+
+// Reactions with emoji on message by user ID
+func (s *Session) Reactions(message, emoji string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.reactions[message][emoji]...)
+}
+
+// Channels of guild
+func (s *Session) Channels(guild *discordgo.Guild) []*discordgo.Channel {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var channels []*discordgo.Channel
+	for _, c := range s.guilds[guild.ID].Channels {
+		cp := *c
+		channels = append(channels, &cp)
+	}
+	return channels
+}
+
+// ChannelByName in guild, nil if there is none
+func (s *Session) ChannelByName(guild *discordgo.Guild, name string) *discordgo.Channel {
+	for _, c := range s.Channels(guild) {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// Calls made through the session, optionally only those of method
+func (s *Session) Calls(method ...string) []Call {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(method) == 0 {
+		return append([]Call(nil), s.calls...)
+	}
+	var calls []Call
+	for _, c := range s.calls {
+		for _, m := range method {
+			if c.Method == m {
+				calls = append(calls, c)
+			}
+		}
+	}
+	return calls
+}
+
+// BotUser the fake is logged in as
+func (s *Session) BotUser() *discordgo.User {
+	return s.me
+}
+
+// StateGuild like Guild, the fake state knows everything
+func (s *Session) StateGuild(guildID string) (*discordgo.Guild, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.guild(guildID)
+}
+
+// StateChannel like Channel, the fake state knows everything
+func (s *Session) StateChannel(channelID string) (*discordgo.Channel, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.channels[channelID]
+	if !ok {
+		return nil, discordgo.ErrStateNotFound
+	}
+	cp := *c
+	return &cp, nil
+}
+
+// StateMessage like ChannelMessage, the fake state knows everything
+func (s *Session) StateMessage(channelID, messageID string) (*discordgo.Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	msg, err := s.message(channelID, messageID)
+	if err != nil {
+		return nil, discordgo.ErrStateNotFound
+	}
+	return copyMessage(msg), nil
+}
+
+// User by ID
+func (s *Session) User(userID string) (*discordgo.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.record("User", userID); err != nil {
+		return nil, err
+	}
+	user, ok := s.users[userID]
+	if !ok {
+		return nil, NotFound("User")
+	}
+	cp := *user
+	return &cp, nil
+}
+
+// Guild by ID
+func (s *Session) Guild(guildID string) (*discordgo.Guild, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.record("Guild", guildID); err != nil {
+		return nil, err
+	}
+	return s.guild(guildID)
+}
+
+func (s *Session) guild(guildID string) (*discordgo.Guild, error) {
+	guild, ok := s.guilds[guildID]
+	if !ok {
+		return nil, NotFound("Guild")
+	}
+	cp := *guild
+	cp.Channels = nil
+	for _, c := range guild.Channels {
+		channel := *c
+		cp.Channels = append(cp.Channels, &channel)
+	}
+	cp.Members = append([]*discordgo.Member(nil), guild.Members...)
+	cp.Roles = append([]*discordgo.Role(nil), guild.Roles...)
+	return &cp, nil
+}
+
+// ChannelMessage by ID
+func (s *Session) ChannelMessage(channelID, messageID string) (*discordgo.Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.record("ChannelMessage", channelID, messageID); err != nil {
+		return nil, err
+	}
+	msg, err := s.message(channelID, messageID)
+	if err != nil {
+		return nil, err
+	}
+	return copyMessage(msg), nil
+}
+
+func (s *Session) message(channelID, messageID string) (*discordgo.Message, error) {
+	msg, ok := s.messages[messageID]
+	if !ok || msg.ChannelID != channelID {
+		return nil, NotFound("Message")
+	}
+	return msg, nil
+}
+
+// MessageReactions with emoji on message, at most limit users
+func (s *Session) MessageReactions(channelID, messageID, emojiID string, limit int) ([]*discordgo.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.record("MessageReactions", channelID, messageID, emojiID); err != nil {
+		return nil, err
+	}
+	if _, err := s.message(channelID, messageID); err != nil {
+		return nil, err
+	}
+	var users []*discordgo.User
+	for _, id := range s.reactions[messageID][emojiID] {
+		if len(users) == limit {
+			break
+		}
+		users = append(users, s.users[id])
+	}
+	return users, nil
+}
+
+// ChannelMessageSend content to channel as the bot
+func (s *Session) ChannelMessageSend(channelID, content string) (*discordgo.Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.record("ChannelMessageSend", channelID, content); err != nil {
+		return nil, err
+	}
+	if _, ok := s.channels[channelID]; !ok {
+		return nil, NotFound("Channel")
+	}
+	return copyMessage(s.post(channelID, s.me, content, nil)), nil
+}
+
+// ChannelMessageSendEmbed to channel as the bot
+func (s *Session) ChannelMessageSendEmbed(channelID string, embed *discordgo.MessageEmbed) (*discordgo.Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.record("ChannelMessageSendEmbed", channelID, embed.Title); err != nil {
+		return nil, err
+	}
+	if _, ok := s.channels[channelID]; !ok {
+		return nil, NotFound("Channel")
+	}
+	return copyMessage(s.post(channelID, s.me, "", embed)), nil
+}
+
+// ChannelMessageEditComplex replacing the content and embed of a message
+func (s *Session) ChannelMessageEditComplex(m *discordgo.MessageEdit) (*discordgo.Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.record("ChannelMessageEditComplex", m.Channel, m.ID); err != nil {
+		return nil, err
+	}
+	msg, err := s.message(m.Channel, m.ID)
+	if err != nil {
+		return nil, err
+	}
+	if m.Content != nil {
+		msg.Content = *m.Content
+	}
+	if m.Embed != nil {
+		msg.Embeds = []*discordgo.MessageEmbed{m.Embed}
+	}
+	return copyMessage(msg), nil
+}
+
+// ChannelMessageDelete from channel
+func (s *Session) ChannelMessageDelete(channelID, messageID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.record("ChannelMessageDelete", channelID, messageID); err != nil {
+		return err
+	}
+	msg, err := s.message(channelID, messageID)
+	if err != nil {
+		return err
+	}
+	delete(s.messages, messageID)
+	delete(s.reactions, messageID)
+	s.deleted = append(s.deleted, msg)
+	return nil
+}
+
+// MessageReactionAdd with emoji as the bot
+func (s *Session) MessageReactionAdd(channelID, messageID, emojiID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.record("MessageReactionAdd", channelID, messageID, emojiID); err != nil {
+		return err
+	}
+	if _, err := s.message(channelID, messageID); err != nil {
+		return err
+	}
+	s.react(messageID, emojiID, s.me.ID)
+	return nil
+}
+
+// MessageReactionRemove of user
+func (s *Session) MessageReactionRemove(channelID, messageID, emojiID, userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.record("MessageReactionRemove", channelID, messageID, emojiID, userID); err != nil {
+		return err
+	}
+	if _, err := s.message(channelID, messageID); err != nil {
+		return err
+	}
+	users := s.reactions[messageID][emojiID]
+	for i, u := range users {
+		if u == userID {
+			s.reactions[messageID][emojiID] = append(users[:i:i], users[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+// MessageReactionsRemoveAll from message
+func (s *Session) MessageReactionsRemoveAll(channelID, messageID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.record("MessageReactionsRemoveAll", channelID, messageID); err != nil {
+		return err
+	}
+	if _, err := s.message(channelID, messageID); err != nil {
+		return err
+	}
+	delete(s.reactions, messageID)
+	return nil
+}
+
+// GuildChannelCreate in guild
+func (s *Session) GuildChannelCreate(guildID, name, ctype string) (*discordgo.Channel, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.record("GuildChannelCreate", guildID, name, ctype); err != nil {
+		return nil, err
+	}
+	if _, ok := s.guilds[guildID]; !ok {
+		return nil, NotFound("Guild")
+	}
+	t := discordgo.ChannelTypeGuildText
+	if ctype == "voice" {
+		t = discordgo.ChannelTypeGuildVoice
+	}
+	c := *s.addChannel(guildID, name, t)
+	return &c, nil
+}
+
+// ChannelEditComplex updating the name, topic, position, parent and permissions of channel
+func (s *Session) ChannelEditComplex(channelID string, data *discordgo.ChannelEdit) (*discordgo.Channel, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.record("ChannelEditComplex", channelID, data.Name); err != nil {
+		return nil, err
+	}
+	c, ok := s.channels[channelID]
+	if !ok {
+		return nil, NotFound("Channel")
+	}
+	if data.Name != "" {
+		c.Name = data.Name
+	}
+	c.Topic = data.Topic
+	c.Position = data.Position
+	c.ParentID = data.ParentID
+	c.PermissionOverwrites = data.PermissionOverwrites
+	cp := *c
+	return &cp, nil
+}
+
+// ChannelDelete removing the channel from its guild
+func (s *Session) ChannelDelete(channelID string) (*discordgo.Channel, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.record("ChannelDelete", channelID); err != nil {
+		return nil, err
+	}
+	c, ok := s.channels[channelID]
+	if !ok {
+		return nil, NotFound("Channel")
+	}
+	delete(s.channels, channelID)
+	if guild, ok := s.guilds[c.GuildID]; ok {
+		for i, gc := range guild.Channels {
+			if gc.ID == channelID {
+				guild.Channels = append(guild.Channels[:i:i], guild.Channels[i+1:]...)
+				break
+			}
+		}
+	}
+	return c, nil
+}
+
+// UserChannelCreate opening the direct message channel with recipient
+func (s *Session) UserChannelCreate(recipientID string) (*discordgo.Channel, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.record("UserChannelCreate", recipientID); err != nil {
+		return nil, err
+	}
+	user, ok := s.users[recipientID]
+	if !ok {
+		return nil, NotFound("User")
+	}
+	for _, c := range s.channels {
+		if c.Type == discordgo.ChannelTypeDM && c.Recipients[0].ID == recipientID {
+			cp := *c
+			return &cp, nil
+		}
+	}
+	c := s.addChannel("", "", discordgo.ChannelTypeDM)
+	c.Recipients = []*discordgo.User{user}
+	cp := *c
+	return &cp, nil
+}
+
+// DirectMessages sent to user
+func (s *Session) DirectMessages(user *discordgo.User) []*discordgo.Message {
+	s.mu.Lock()
+	var channel string
+	for _, c := range s.channels {
+		if c.Type == discordgo.ChannelTypeDM && c.Recipients[0].ID == user.ID {
+			channel = c.ID
+		}
+	}
+	s.mu.Unlock()
+	if channel == "" {
+		return nil
+	}
+	return s.Messages(channel)
+}
+
+func copyMessage(msg *discordgo.Message) *discordgo.Message {
+	cp := *msg
+	cp.Embeds = nil
+	for _, e := range msg.Embeds {
+		embed := *e
+		embed.Fields = append([]*discordgo.MessageEmbedField(nil), e.Fields...)
+		cp.Embeds = append(cp.Embeds, &embed)
+	}
+	return &cp
+}
@@ -0,0 +1,66 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+	commands := r.NewCounter("commands_total", "Handled commands.", "command")
+	commands.Inc("vote")
+	commands.Add(2, "vote")
+	commands.Inc(`say "hi"`)
+	connected := r.NewGauge("connected", "Whether the gateway is connected.")
+	connected.Set(1)
+	r.NewGaugeFunc("open_votes", "Open votes.", []string{"guild"}, func() []Sample {
+		return []Sample{{Values: []string{"g"}, Value: 3}}
+	})
+	latency := r.NewHistogram("latency_seconds", "Latency.", []float64{0.1, 1}, "command")
+	latency.Observe(0.05, "vote")
+	latency.Observe(0.1, "vote")
+	latency.Observe(5, "vote")
+
+	var out bytes.Buffer
+	r.WriteTo(&out)
+	expected := `# HELP commands_total Handled commands.
+# TYPE commands_total counter
+commands_total{command="say \"hi\""} 1
+commands_total{command="vote"} 3
+# HELP connected Whether the gateway is connected.
+# TYPE connected gauge
+connected 1
+# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{command="vote",le="0.1"} 2
+latency_seconds_bucket{command="vote",le="1"} 2
+latency_seconds_bucket{command="vote",le="+Inf"} 3
+latency_seconds_sum{command="vote"} 5.15
+latency_seconds_count{command="vote"} 3
+# HELP open_votes Open votes.
+# TYPE open_votes gauge
+open_votes{guild="g"} 3
+`
+	if out.String() != expected {
+		t.Errorf("unexpected exposition:\n%s", out.String())
+	}
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if !strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain; version=0.0.4") || w.Body.String() != expected {
+		t.Errorf("unexpected response %q", w.Header().Get("Content-Type"))
+	}
+}
+
+func TestRegistryDuplicate(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounter("commands_total", "Handled commands.")
+	defer func() {
+		if recover() == nil {
+			t.Error("expected registering a metric twice to panic")
+		}
+	}()
+	r.NewGauge("commands_total", "Handled commands.")
+}
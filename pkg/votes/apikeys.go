@@ -0,0 +1,117 @@
+package votes
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/pkg/errors"
+)
+
+// apiKeyPrefix of all API keys, so leaked keys are easy to spot
+const apiKeyPrefix = "dem_"
+
+// ErrUnknownAPIKey is returned for keys which were never created or are revoked
+var ErrUnknownAPIKey = errors.New("unknown api key")
+
+// APIKey granting an integration access to the votes of a guild. Only the hash of the key is stored.
+type APIKey struct {
+	Guild   string
+	Name    string
+	Hash    string
+	Created time.Time
+}
+
+// hashAPIKey as stored
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// newAPIKey returning the secret key, which is only shown once
+func newAPIKey() (string, error) {
+	b := make([]byte, 24)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", errors.Wrap(err, "unable to generate api key")
+	}
+	return apiKeyPrefix + hex.EncodeToString(b), nil
+}
+
+// Authenticate an API key, returning ErrUnknownAPIKey if it is invalid
+func (v *VoteHandler) Authenticate(key string) (APIKey, error) {
+	if !strings.HasPrefix(key, apiKeyPrefix) {
+		return APIKey{}, ErrUnknownAPIKey
+	}
+	return v.store.GetAPIKey(hashAPIKey(key))
+}
+
+// APIKeyCommand Message Handler for creating, listing and revoking the API keys of the guild
+func (v *VoteHandler) APIKeyCommand(c *discordgo.Channel, s Session, m *discordgo.MessageCreate) error {
+	if m.Content == "reset_handler" {
+		return nil
+	}
+
+	usage := "Invalid apikey command. Please follow this schema: '!democracy apikey create [name]', '!democracy apikey list' or '!democracy apikey revoke [name]'"
+	args := strings.Fields(strings.TrimPrefix(m.Content, "apikey"))
+	if len(args) < 1 || args[0] == "list" && len(args) != 1 || args[0] != "list" && len(args) != 2 {
+		return userError("invalid apikey command", usage)
+	}
+	g, err := s.Guild(c.GuildID)
+	if err != nil {
+		return internalError("could not fetch guild", "unable to update api keys", err)
+	}
+	if g.OwnerID != m.Author.ID {
+		return permissionError("Only the server owner may manage API keys.")
+	}
+
+	switch args[0] {
+	case "list":
+		keys, err := v.db(s).ReadAPIKeys(c.GuildID)
+		if err != nil {
+			return internalError("unable to read api keys", "Failed to read API keys from DB. Please contact support.", err)
+		}
+		if len(keys) == 0 {
+			return v.reply(s, m, "There are no API keys.")
+		}
+		var names []string
+		for _, key := range keys {
+			names = append(names, fmt.Sprintf("%s (created %s)", key.Name, key.Created.UTC().Format("02-01-2006")))
+		}
+		return v.reply(s, m, "API keys: "+strings.Join(names, ", "))
+	case "create":
+		secret, err := newAPIKey()
+		if err != nil {
+			return internalError("unable to create api key", "", err)
+		}
+		err = v.db(s).InsertAPIKey(APIKey{Guild: c.GuildID, Name: args[1], Hash: hashAPIKey(secret), Created: v.clock.Now()})
+		if err != nil {
+			return internalError("unable to store api key", fmt.Sprintf("Failed to store API key '%s', is the name taken already?", args[1]), err)
+		}
+		// the key is a secret, it never shows up in the channel
+		dm, err := v.discord(s).UserChannelCreate(m.Author.ID)
+		if err == nil {
+			_, err = v.discord(s).ChannelMessageSend(dm.ID, fmt.Sprintf("API key '%s' for %s: `%s`\nIt is only shown once, pass it in the X-API-Key header.", args[1], g.Name, secret))
+		}
+		if err != nil {
+			v.db(s).DeleteAPIKey(c.GuildID, args[1])
+			return internalError("unable to send api key", "Failed to send you the API key, please allow direct messages from server members.", err)
+		}
+		return v.reply(s, m, fmt.Sprintf("API key '%s' created and sent to you.", args[1]))
+	case "revoke":
+		deleted, err := v.db(s).DeleteAPIKey(c.GuildID, args[1])
+		if err != nil {
+			return internalError("unable to revoke api key", "Failed to revoke API key in DB. Please contact support.", err)
+		}
+		if !deleted {
+			return userError("unknown api key", fmt.Sprintf("There is no API key '%s'.", args[1]))
+		}
+		return v.reply(s, m, fmt.Sprintf("API key '%s' revoked.", args[1]))
+	default:
+		return userError("invalid apikey command", usage)
+	}
+}
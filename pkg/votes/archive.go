@@ -0,0 +1,103 @@
+package votes
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"go.uber.org/zap"
+)
+
+// ArchiveCommand Message Handler for setting the channel closed votes are moved to
+func (v *VoteHandler) ArchiveCommand(c *discordgo.Channel, s Session, m *discordgo.MessageCreate) error {
+	if m.Content == "reset_handler" {
+		return nil
+	}
+
+	args := strings.Fields(strings.TrimPrefix(m.Content, "archive"))
+	if len(args) == 0 {
+		archive, err := v.db(s).GetArchive(c.GuildID)
+		if err != nil {
+			return internalError("unable to read archive", "Failed to read archive from DB. Please contact support.", err)
+		}
+		return v.reply(s, m, archiveString(archive))
+	}
+	if len(args) != 1 {
+		return userError("invalid archive command", "Invalid archive command. Please follow this schema: '!democracy archive [#channel|off]'")
+	}
+	g, err := s.Guild(c.GuildID)
+	if err != nil {
+		return internalError("could not fetch guild", "unable to update archive", err)
+	}
+	if g.OwnerID != m.Author.ID {
+		return permissionError("Only the server owner may change the archive.")
+	}
+
+	archive := ""
+	if args[0] != "off" {
+		var ok bool
+		archive, ok = textChannel(g, args[0])
+		if !ok {
+			return userError("invalid archive channel", "Please mention a text channel of this server or use 'off'.")
+		}
+	}
+	err = v.db(s).SetArchive(c.GuildID, archive)
+	if err != nil {
+		return internalError("unable to store archive", "Failed to store archive in DB. Please contact support.", err)
+	}
+	return v.reply(s, m, archiveString(archive))
+}
+
+// textChannel of g mentioned like <#id>, false if g has no such text channel
+func textChannel(g *discordgo.Guild, mention string) (string, bool) {
+	id := strings.TrimSuffix(strings.TrimPrefix(mention, "<#"), ">")
+	for _, ch := range g.Channels {
+		if ch.ID == id && ch.Type == discordgo.ChannelTypeGuildText {
+			return id, true
+		}
+	}
+	return "", false
+}
+
+// archiveString describing the archive channel
+func archiveString(archive string) string {
+	if archive == "" {
+		return "Closed votes stay in their channel."
+	}
+	return fmt.Sprintf("Closed votes are moved to <#%s>.", archive)
+}
+
+// archiveVote re-posting the closed vote with its final result to the archive channel of its guild
+// and deleting it from channel. Returns false if the vote stays where it is.
+func (v *VoteHandler) archiveVote(s Session, vote Vote, channel string) bool {
+	archive, err := v.store.GetArchive(vote.Guild)
+	if err != nil {
+		v.log.Error("unable to read archive", zap.String("guild", vote.Guild), zap.Error(err))
+		return false
+	}
+	if archive == "" || archive == channel {
+		return false
+	}
+	embed := v.embed(s, vote)
+	if embed == nil {
+		return false
+	}
+	// no ballot reactions, the vote is decided
+	msg, err := v.discord(s).ChannelMessageSendEmbed(archive, embed)
+	if err != nil {
+		v.log.Error("unable to archive vote", zap.String("guild", vote.Guild), zap.String("vote", vote.ID), zap.String("archive", archive), zap.Error(err))
+		return false
+	}
+	old := vote.CurrentID
+	vote.CurrentID = msg.ID
+	vote.Channel = archive
+	err = v.store.UpdateVote(vote.ID, vote)
+	if err != nil {
+		v.log.Error("unable to update archived vote", zap.String("guild", vote.Guild), zap.String("vote", vote.ID), zap.Error(err))
+		v.discord(s).Delete(archive, msg.ID)
+		return false
+	}
+	v.log.Info("vote archived", zap.String("guild", vote.Guild), zap.String("vote", vote.ID), zap.String("archive", archive), zap.String("message", msg.ID))
+	v.discord(s).Delete(channel, old)
+	return true
+}
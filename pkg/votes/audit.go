@@ -0,0 +1,170 @@
+package votes
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// auditBuffer of events waiting to be recorded in the audit log
+const auditBuffer = 1024
+
+// AuditEvent recording a change to the votes of a guild
+type AuditEvent struct {
+	ID    int64
+	Guild string
+	Kind  EventKind
+	// Vote and Number of the vote the event happened on
+	Vote   string
+	Number int
+	// User causing the event, empty for the bot itself
+	User    string
+	Created time.Time
+	// Detail like the ballot cast or the outcome of a closed vote
+	Detail string
+	// Key is the name of the API key acting for User, empty if the user acted on Discord
+	Key string
+}
+
+// auditEvent recording e in the audit log apart from the publisher, failures are only logged
+func (v *VoteHandler) auditEvent(e Event) {
+	event := AuditEvent{
+		Guild:   e.GuildID(),
+		Kind:    e.Kind(),
+		Created: e.At(),
+		Detail:  e.Detail(),
+		Key:     e.ActingKey(),
+	}
+	if ve, ok := e.(VoteEvent); ok {
+		event.Vote = ve.EventVote().ID
+		event.Number = ve.EventVote().Number
+	}
+	switch e := e.(type) {
+	case VoteCreated:
+		event.User = e.Vote.Author
+	case BallotCast:
+		event.User = e.User
+	case BallotChanged:
+		event.User = e.User
+	case BallotRetracted:
+		event.User = e.User
+	case VoteDeleted:
+		event.User = e.User
+	case ElectionPhaseChanged:
+		event.User = e.Proposal.Author
+	}
+	v.audit.push(auditRecord{store: v.store, event: event})
+}
+
+// auditRecord of an event to be inserted into store
+type auditRecord struct {
+	store Store
+	event AuditEvent
+	// done is closed once all records queued before were inserted
+	done chan struct{}
+}
+
+// auditWriter inserting audit events one after another, so handling a ballot
+// does not wait for its audit event to be written.
+type auditWriter struct {
+	log     *zap.Logger
+	records chan auditRecord
+
+	mu      sync.Mutex
+	closed  bool
+	stopped chan struct{}
+}
+
+func newAuditWriter(log *zap.Logger) *auditWriter {
+	a := &auditWriter{
+		log:     log,
+		records: make(chan auditRecord, auditBuffer),
+		stopped: make(chan struct{}),
+	}
+	go a.work()
+	return a
+}
+
+// push r to the queue, inserting it right away if the queue is full or closed
+// rather than losing the event
+func (a *auditWriter) push(r auditRecord) {
+	a.mu.Lock()
+	if !a.closed {
+		select {
+		case a.records <- r:
+			a.mu.Unlock()
+			return
+		default:
+			a.log.Warn("audit queue is full", zap.String("guild", r.event.Guild))
+		}
+	}
+	a.mu.Unlock()
+	a.insert(r)
+}
+
+// flush waiting until all events queued before were inserted
+func (a *auditWriter) flush() {
+	done := make(chan struct{})
+	a.mu.Lock()
+	if a.closed {
+		a.mu.Unlock()
+		return
+	}
+	a.records <- auditRecord{done: done}
+	a.mu.Unlock()
+	<-done
+}
+
+// close the queue after inserting all queued events
+func (a *auditWriter) close() {
+	a.mu.Lock()
+	if !a.closed {
+		a.closed = true
+		close(a.records)
+	}
+	a.mu.Unlock()
+	<-a.stopped
+}
+
+func (a *auditWriter) work() {
+	defer close(a.stopped)
+	for r := range a.records {
+		if r.done != nil {
+			close(r.done)
+			continue
+		}
+		a.insert(r)
+	}
+}
+
+func (a *auditWriter) insert(r auditRecord) {
+	_, err := r.store.InsertAuditEvent(r.event)
+	if err != nil {
+		a.log.Error("unable to record audit event", zap.String("guild", r.event.Guild), zap.String("vote", r.event.Vote), zap.String("kind", string(r.event.Kind)), zap.Error(err))
+	}
+}
+
+// auditOutcome of a closed vote like 'accepted 3:1'
+func auditOutcome(vote Vote) string {
+	result := "rejected"
+	if vote.Accepted() {
+		result = "accepted"
+	}
+	return fmt.Sprintf("%s %d:%d", result, vote.Pro, vote.Con)
+}
+
+// auditBallot value
+func auditBallot(pro bool) string {
+	if pro {
+		return "pro"
+	}
+	return "con"
+}
+
+// AuditEvents of guild newest first, only events before the given ID unless it is 0
+func (v *VoteHandler) AuditEvents(guild string, before int64, limit int) ([]AuditEvent, error) {
+	v.audit.flush()
+	return v.store.ReadAuditEvents(guild, before, limit)
+}
@@ -1,15 +1,23 @@
 package votes
 
 import (
+	"context"
 	"fmt"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/bwmarrin/discordgo"
+	"github.com/pkg/errors"
 	"go.uber.org/zap"
 )
 
-type msgFunc func(c *discordgo.Channel, s *discordgo.Session, m *discordgo.MessageCreate)
-type reactFunc func(c *discordgo.Channel, s *discordgo.Session, m *discordgo.MessageReactionAdd)
+// msgFunc handling a command, errors are replied to by the Bot
+type msgFunc func(c *discordgo.Channel, s Session, m *discordgo.MessageCreate) error
+
+// reactFunc handling a reaction, errors are logged by the Bot
+type reactFunc func(c *discordgo.Channel, s Session, m *discordgo.MessageReactionAdd) error
+type readyFunc func(s Session, event *discordgo.Ready)
 
 // Bot for creating and managing votes
 type Bot struct {
@@ -18,15 +26,42 @@ type Bot struct {
 	messageHandlers map[string]msgFunc
 	// message title/content maps function
 	reactionHandlers map[string]reactFunc
+	// called on every (re)connect
+	readyHandlers []readyFunc
+	queues        *actionQueues
+	// reporter of internal failures, if any
+	reporter Reporter
+	// connected is set while the gateway is connected
+	connected int32
+	// middleware wrapping all message and reaction handlers
+	middleware []Middleware
 }
 
 // New bot with logger
 func New(log *zap.Logger) *Bot {
-	return &Bot{
+	b := &Bot{
 		Log:              log,
 		messageHandlers:  make(map[string]msgFunc),
 		reactionHandlers: make(map[string]reactFunc),
+		queues:           newActionQueues(log),
 	}
+	b.Use(b.recoverPanics, scopeLogger, timeHandlers)
+	return b
+}
+
+// discord writes of s through the Bots queues
+func (b *Bot) discord(s Session) discordWriter {
+	return discordWriter{qs: b.queues, s: s}
+}
+
+// Flush waiting until all pending writes were sent to Discord
+func (b *Bot) Flush() {
+	b.queues.Flush()
+}
+
+// Close the Bot after sending all pending writes to Discord
+func (b *Bot) Close() {
+	b.queues.Close()
 }
 
 // AddMessageHandler to Bot
@@ -39,26 +74,78 @@ func (b *Bot) AddReactionHandler(title string, f reactFunc) {
 	b.reactionHandlers[title] = f
 }
 
+// AddReadyHandler to Bot
+func (b *Bot) AddReadyHandler(f readyFunc) {
+	b.readyHandlers = append(b.readyHandlers, f)
+}
+
 // Ready Event Handler
 func (b *Bot) Ready(s *discordgo.Session, event *discordgo.Ready) {
 	s.UpdateStatus(0, "democracy")
 	s.State.TrackChannels = true
 	s.State.MaxMessageCount = 100
+	b.HandleReady(NewSession(s), event)
+}
 
+// Connect Event Handler
+func (b *Bot) Connect(s *discordgo.Session, event *discordgo.Connect) {
+	b.setConnected(true)
+}
+
+// Disconnect Event Handler
+func (b *Bot) Disconnect(s *discordgo.Session, event *discordgo.Disconnect) {
+	b.Log.Warn("gateway disconnected")
+	b.setConnected(false)
+}
+
+// RateLimit Event Handler, called whenever Discord answered a request with 429
+func (b *Bot) RateLimit(s *discordgo.Session, event *discordgo.RateLimit) {
+	b.Log.Info("rate limited", zap.String("url", event.URL), zap.Duration("retryAfter", event.RetryAfter*time.Millisecond))
+	discordRateLimits.Inc()
+}
+
+func (b *Bot) setConnected(connected bool) {
+	var state int32
+	if connected {
+		state = 1
+	}
+	atomic.StoreInt32(&b.connected, state)
+	gatewayConnected.Set(float64(state))
+}
+
+// Connected returns an error unless the gateway is connected
+func (b *Bot) Connected() error {
+	if atomic.LoadInt32(&b.connected) == 0 {
+		return errors.New("discord gateway disconnected")
+	}
+	return nil
+}
+
+// HandleReady of s
+func (b *Bot) HandleReady(s Session, event *discordgo.Ready) {
+	b.setConnected(true)
 	/*for _, g := range event.Guilds {
 		b.ResetDemocracy(s, g)
 	}*/
 
+	for _, f := range b.readyHandlers {
+		f(s, event)
+	}
 }
 
 // MessageCreate Event Handler
 func (b *Bot) MessageCreate(s *discordgo.Session, m *discordgo.MessageCreate) {
-	if m.Author.ID == s.State.User.ID {
+	b.HandleMessage(NewSession(s), m)
+}
+
+// HandleMessage m received by s
+func (b *Bot) HandleMessage(s Session, m *discordgo.MessageCreate) {
+	if m.Author.ID == s.BotUser().ID {
 		return
 	}
 	if m.Content == "!democracy" {
-		s.ChannelMessageDelete(m.ChannelID, m.ID)
-		_, err := s.ChannelMessageSendEmbed(m.ChannelID, newInitEmbed())
+		b.discord(s).Delete(m.ChannelID, m.ID)
+		_, err := b.discord(s).ChannelMessageSendEmbed(m.ChannelID, newInitEmbed())
 		if err != nil {
 			b.Log.Error("unable to send embed", zap.Error(err))
 			return
@@ -70,35 +157,46 @@ func (b *Bot) MessageCreate(s *discordgo.Session, m *discordgo.MessageCreate) {
 	}
 	m.Content = strings.TrimPrefix(m.Content, "!democracy ")
 	ch := b.getChannel(s, m.ChannelID)
-	b.Log.Info("message event",
-		zap.String("user", m.Author.ID),
-		zap.String("message", m.Content),
-		zap.String("channel", m.ChannelID),
-	)
+	if ch == nil {
+		return
+	}
 	for k, v := range b.messageHandlers {
 		if strings.HasPrefix(m.Content, k) {
-			v(ch, s, m)
+			f := v
+			r := &Request{Ctx: context.Background(), Log: b.Log, Handler: k, Channel: ch, Session: s, Message: m}
+			err := b.chain(func(r *Request) error {
+				return f(r.Channel, requestSession{Session: r.Session, r: r}, r.Message)
+			})(r)
+			if err != nil {
+				b.commandFailed(r, err)
+			}
 		}
 	}
 }
 
 // ReactionAdd Event Handler
 func (b *Bot) ReactionAdd(s *discordgo.Session, m *discordgo.MessageReactionAdd) {
-	if m.UserID == s.State.User.ID {
+	b.HandleReaction(NewSession(s), m)
+}
+
+// HandleReaction m received by s
+func (b *Bot) HandleReaction(s Session, m *discordgo.MessageReactionAdd) {
+	if m.UserID == s.BotUser().ID {
 		return
 	}
 	ch := b.getChannel(s, m.ChannelID)
-	b.Log.Info("reaction event",
-		zap.String("user", m.UserID),
-		zap.String("message", m.MessageID),
-		zap.String("channel", m.ChannelID),
-		zap.String("emoji", m.Emoji.Name),
-	)
+	if ch == nil {
+		return
+	}
 	var title string
-	msg, err := s.ChannelMessage(m.ChannelID, m.MessageID)
+	// recent messages are tracked in the state, saving a request per reaction
+	msg, err := s.StateMessage(m.ChannelID, m.MessageID)
 	if err != nil {
-		b.Log.Error("unable to find message", zap.Error(err))
-		return
+		msg, err = s.ChannelMessage(m.ChannelID, m.MessageID)
+		if err != nil {
+			b.Log.Error("unable to find message", zap.Error(err))
+			return
+		}
 	}
 	if len(msg.Embeds) > 0 {
 		title = msg.Embeds[0].Title
@@ -108,65 +206,64 @@ func (b *Bot) ReactionAdd(s *discordgo.Session, m *discordgo.MessageReactionAdd)
 
 	for k, v := range b.reactionHandlers {
 		if strings.HasPrefix(title, k) {
-			v(ch, s, m)
+			f := v
+			r := &Request{Ctx: context.Background(), Log: b.Log, Handler: k, Channel: ch, Session: s, Reaction: m}
+			err := b.chain(func(r *Request) error {
+				return f(r.Channel, requestSession{Session: r.Session, r: r}, r.Reaction)
+			})(r)
+			if err != nil {
+				b.reactionFailed(r, err)
+			}
 		}
 	}
 }
 
 // ResetDemocracy for the provied guild
-func (b *Bot) ResetDemocracy(c *discordgo.Channel, s *discordgo.Session, m *discordgo.MessageCreate) {
+func (b *Bot) ResetDemocracy(c *discordgo.Channel, s Session, m *discordgo.MessageCreate) error {
 	if m.Content == "reset_handler" {
-		return
+		return nil
 	}
 	g, err := s.Guild(c.GuildID)
 	if err != nil {
-		b.Log.Error("could not fetch guild", zap.String("guild", c.GuildID), zap.Error(err))
-		return
+		return internalError("could not fetch guild", "", err)
 	}
-	errHandler := func(guild *discordgo.Guild, e string) {
-		owner, err := s.UserChannelCreate(guild.OwnerID)
-		if err != nil {
-			b.Log.Error("could not contact owner", zap.String("guild", guild.ID), zap.String("owner", owner.ID))
-			return
+	// the channel the reset was requested in is gone, so the owner is told directly
+	failed := func(op string, err error) error {
+		owner, dmErr := b.discord(s).UserChannelCreate(g.OwnerID)
+		if dmErr == nil {
+			_, dmErr = b.discord(s).ChannelMessageSend(owner.ID, fmt.Sprintf("democracy.bot failed to initialize on your server %s. Please contact support. Error: %s", g.Name, op))
 		}
-		_, err = s.ChannelMessageSend(owner.ID, fmt.Sprintf("democracy.bot failed to initialize on your server %s. Please contact support. Error: %s", guild.Name, e))
-		if err != nil {
-			b.Log.Error("could not contact owner", zap.String("guild", guild.ID), zap.String("owner", owner.ID))
-			return
+		if dmErr != nil {
+			b.logger(s).Error("could not contact owner", zap.String("guild", g.ID), zap.String("owner", g.OwnerID), zap.Error(dmErr))
 		}
+		return internalError(op, "", err)
 	}
 
-	b.Log.Info("readying guild", zap.String("guild", g.ID), zap.Int("chanCount", len(g.Channels)))
+	b.logger(s).Info("readying guild", zap.String("guild", g.ID), zap.Int("chanCount", len(g.Channels)))
 	if len(g.Channels) < 1 {
-		return
+		return nil
 	}
 	var oldChan *discordgo.Channel
 	for _, c := range g.Channels {
-		b.Log.Debug("looking for default channel", zap.String("guild", g.ID), zap.String("channelID", c.ID), zap.String("channel", c.Name))
+		b.logger(s).Debug("looking for default channel", zap.String("guild", g.ID), zap.String("channelID", c.ID), zap.String("channel", c.Name))
 		if c.Name == "democracy" {
-			b.Log.Debug("caching default channel", zap.String("guild", g.ID), zap.String("channelID", c.ID), zap.String("channel", c.Name))
+			b.logger(s).Debug("caching default channel", zap.String("guild", g.ID), zap.String("channelID", c.ID), zap.String("channel", c.Name))
 			oldChan = c
-			b.Log.Debug("resetting default channel", zap.String("guild", g.ID), zap.String("channelID", c.ID), zap.String("channel", c.Name))
-			_, err := s.ChannelDelete(c.ID)
+			b.logger(s).Debug("resetting default channel", zap.String("guild", g.ID), zap.String("channelID", c.ID), zap.String("channel", c.Name))
+			_, err := b.discord(s).ChannelDelete(c.ID)
 			if err != nil {
-				b.Log.Error("could not delete channel", zap.String("guild", g.ID), zap.String("cid", c.ID), zap.Error(err))
-				errHandler(g, "could not delete channel")
-				return
+				return failed("could not delete channel", err)
 			}
 		}
 	}
 	if oldChan == nil {
-		b.Log.Error("could not find default channel", zap.String("guild", g.ID))
-		errHandler(g, "could not find default channel")
-		return
+		return failed("could not find default channel", nil)
 	}
-	ch, err := s.GuildChannelCreate(g.ID, "democracy", "text")
+	ch, err := b.discord(s).GuildChannelCreate(g.ID, "democracy", "text")
 	if err != nil {
-		b.Log.Error("could not create channel", zap.String("guild", g.ID))
-		errHandler(g, "could not create channel")
-		return
+		return failed("could not create channel", err)
 	}
-	_, err = s.ChannelEditComplex(ch.ID, &discordgo.ChannelEdit{
+	_, err = b.discord(s).ChannelEditComplex(ch.ID, &discordgo.ChannelEdit{
 		Name:                 "democracy",
 		Topic:                "For the people, by the people",
 		Position:             oldChan.Position,
@@ -174,28 +271,28 @@ func (b *Bot) ResetDemocracy(c *discordgo.Channel, s *discordgo.Session, m *disc
 		PermissionOverwrites: oldChan.PermissionOverwrites,
 	})
 	if err != nil {
-		b.Log.Error("could not update channel", zap.String("guild", g.ID))
-		errHandler(g, "could not update channel")
-		return
+		return failed("could not update channel", err)
 	}
 
-	_, err = s.ChannelMessageSendEmbed(ch.ID, newInitEmbed())
+	_, err = b.discord(s).ChannelMessageSendEmbed(ch.ID, newInitEmbed())
 	if err != nil {
-		b.Log.Error("unable to send embed", zap.Error(err))
-		errHandler(g, "unable to send embed")
-		return
+		return failed("unable to send embed", err)
 	}
 
 	// Reload Handlers
-	for _, f := range b.messageHandlers {
+	for cmd, f := range b.messageHandlers {
 		m.Content = "reset_handler"
-		f(ch, s, m)
+		err = f(ch, s, m)
+		if err != nil {
+			b.handlerFailed(b.logger(s), ch, m.Author.ID, cmd, err, zap.String("reloaded", cmd))
+		}
 	}
+	return nil
 }
 
-func (b *Bot) getChannel(s *discordgo.Session, current string) (ch *discordgo.Channel) {
+func (b *Bot) getChannel(s Session, current string) (ch *discordgo.Channel) {
 
-	currentChannel, err := s.State.Channel(current)
+	currentChannel, err := s.StateChannel(current)
 	if err != nil {
 		b.Log.Error("could not find channel")
 		return nil
@@ -258,7 +355,72 @@ func newInitEmbed() *discordgo.MessageEmbed {
 			},
 			&discordgo.MessageEmbedField{
 				Name:   "Start Vote",
-				Value:  "!democracy vote [title]|[text]",
+				Value:  "!democracy vote [title]|[text]|[duration]|[tags]",
+				Inline: true,
+			},
+			&discordgo.MessageEmbedField{
+				Name:   "Discuss a Proposal before voting",
+				Value:  "!democracy discuss [title]|[text]",
+				Inline: true,
+			},
+			&discordgo.MessageEmbedField{
+				Name:   "Amend or open a Proposal (in its discussion channel)",
+				Value:  "!democracy amend [text]\n!democracy open [duration]",
+				Inline: true,
+			},
+			&discordgo.MessageEmbedField{
+				Name:   "Extend a running Vote",
+				Value:  "!democracy extend [vote number] [duration]",
+				Inline: true,
+			},
+			&discordgo.MessageEmbedField{
+				Name:   "Elect or distrust a Member",
+				Value:  "!democracy nominate [@user]|[role]|[term]|[duration]\n!democracy distrust [@user]|[reason]|[duration]",
+				Inline: false,
+			},
+			&discordgo.MessageEmbedField{
+				Name:   "Show a Vote",
+				Value:  "!democracy show [vote number]",
+				Inline: true,
+			},
+			&discordgo.MessageEmbedField{
+				Name:   "Browse past Votes",
+				Value:  "!democracy history [page] [open|closed|accepted|rejected|all] [author:@user] [from:YYYY-MM-DD] [to:YYYY-MM-DD] [tag:name]",
+				Inline: false,
+			},
+			&discordgo.MessageEmbedField{
+				Name:   "Archive closed Votes",
+				Value:  "!democracy archive [#channel|off]",
+				Inline: true,
+			},
+			&discordgo.MessageEmbedField{
+				Name:   "API Keys",
+				Value:  "!democracy apikey create|revoke [name]\n!democracy apikey list",
+				Inline: true,
+			},
+			&discordgo.MessageEmbedField{
+				Name:   "Webhooks",
+				Value:  "!democracy webhook add [url] [events|all]\n!democracy webhook list|failures\n!democracy webhook test|remove [id]",
+				Inline: true,
+			},
+			&discordgo.MessageEmbedField{
+				Name:   "Brigading Detection",
+				Value:  "!democracy brigading [channel #channel|off / quarantine on|off]\n!democracy brigading check|release|discard [vote number] [@user]",
+				Inline: false,
+			},
+			&discordgo.MessageEmbedField{
+				Name:   "Schedule Votes",
+				Value:  "!democracy schedule add [start]|[once/daily/weekly/monthly]|[duration]|[title]|[text]\n!democracy schedule list\n!democracy schedule cancel [id]",
+				Inline: false,
+			},
+			&discordgo.MessageEmbedField{
+				Name:   "Reminders",
+				Value:  "!democracy notify on|off\n!democracy reminders [at|role] [24h,1h|off / @role|none]",
+				Inline: true,
+			},
+			&discordgo.MessageEmbedField{
+				Name:   "Show or change Rules",
+				Value:  "!democracy rules [set] [rule] [value]",
 				Inline: true,
 			},
 			&discordgo.MessageEmbedField{
@@ -267,11 +429,6 @@ func newInitEmbed() *discordgo.MessageEmbed {
 				Inline: true,
 			},
 			//&discordgo.MessageEmbedField{
-			//	Name:   "Start Distrust Vote",
-			//	Value:  "!democracy distrust [reason]",
-			//	Inline: true,
-			//},
-			//&discordgo.MessageEmbedField{
 			//	Name:   "Suggest new Admin",
 			//	Value:  "To do so, text the bot in private with '!democracy admin [user]'.",
 			//	Inline: false,
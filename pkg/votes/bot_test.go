@@ -0,0 +1,668 @@
+package votes
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/pkg/errors"
+	"github.com/playnet-public/democracy.bot/pkg/discordtest"
+	"github.com/playnet-public/democracy.bot/pkg/metrics"
+	"go.uber.org/zap"
+)
+
+var _ Session = &discordtest.Session{}
+
+type testClock struct {
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time { return c.now }
+
+// testBot wired like cmd/democracy.bot against a fake Discord
+type testBot struct {
+	t       *testing.T
+	discord *discordtest.Session
+	bot     *Bot
+	votes   *VoteHandler
+	clock   *testClock
+
+	guild   *discordgo.Guild
+	channel *discordgo.Channel
+	users   map[string]*discordgo.User
+	alice   *discordgo.User
+	bob     *discordgo.User
+}
+
+// newTestBot for a guild owned by the first of members, alice, bob and carol by default
+func newTestBot(t *testing.T, members ...string) *testBot {
+	if len(members) == 0 {
+		members = []string{"alice", "bob", "carol"}
+	}
+	tb := &testBot{
+		t:       t,
+		discord: discordtest.NewSession(),
+		clock:   &testClock{now: time.Date(2018, 3, 1, 12, 0, 0, 0, time.UTC)},
+		users:   make(map[string]*discordgo.User),
+	}
+	owner := tb.discord.AddUser(members[0])
+	tb.users[owner.Username] = owner
+	tb.guild = tb.discord.AddGuild("guild", owner)
+	tb.discord.AddMember(tb.guild, owner)
+	for _, name := range members[1:] {
+		tb.join(name)
+	}
+	tb.alice = tb.users["alice"]
+	tb.bob = tb.users["bob"]
+	tb.channel = tb.discord.AddChannel(tb.guild, "democracy")
+
+	log := zap.NewNop()
+	tb.votes = NewVoteHandler(log)
+	tb.votes.SetStore(NewCachedStore(NewMemoryStore()))
+	tb.votes.SetClock(tb.clock)
+	tb.bot = New(log)
+	tb.bot.AddMessageHandler("reset", tb.bot.ResetDemocracy)
+	tb.votes.Register(tb.bot)
+	return tb
+}
+
+// join name to the guild as a new member
+func (tb *testBot) join(name string) *discordgo.User {
+	user := tb.discord.AddUser(name)
+	tb.users[name] = user
+	tb.discord.AddMember(tb.guild, user)
+	return user
+}
+
+// user by name
+func (tb *testBot) user(name string) *discordgo.User {
+	user, ok := tb.users[name]
+	if !ok {
+		tb.t.Fatalf("unknown member %q", name)
+	}
+	return user
+}
+
+func (tb *testBot) close() {
+	tb.votes.Close()
+	tb.bot.Close()
+}
+
+// say content in the democracy channel as user and wait for all writes
+func (tb *testBot) say(user *discordgo.User, content string) {
+	tb.sayIn(tb.channel, user, content)
+}
+
+// sayIn channel content as user and wait for all writes
+func (tb *testBot) sayIn(c *discordgo.Channel, user *discordgo.User, content string) {
+	tb.bot.HandleMessage(tb.discord, tb.discord.Message(c.ID, user, content))
+	tb.flush()
+	// follow the channel when it was reset
+	if c := tb.discord.ChannelByName(tb.guild, "democracy"); c != nil {
+		tb.channel = c
+	}
+}
+
+// react with emoji on message as user and wait for all writes
+func (tb *testBot) react(message, emoji string, user *discordgo.User) {
+	tb.bot.HandleReaction(tb.discord, tb.discord.React(tb.channel.ID, message, emoji, user))
+	tb.flush()
+}
+
+func (tb *testBot) flush() {
+	tb.votes.Flush()
+	tb.bot.Flush()
+}
+
+// embed titled with prefix in the democracy channel
+func (tb *testBot) embed(prefix string) (*discordgo.Message, *discordgo.MessageEmbed) {
+	if msg, embed := tb.findEmbed(prefix); msg != nil {
+		return msg, embed
+	}
+	tb.t.Fatalf("no embed %q in %s", prefix, tb.channel.Name)
+	return nil, nil
+}
+
+// findEmbed titled with prefix in the democracy channel, nil if there is none
+func (tb *testBot) findEmbed(prefix string) (*discordgo.Message, *discordgo.MessageEmbed) {
+	return tb.findEmbedIn(tb.channel, prefix)
+}
+
+// findEmbedIn channel c titled with prefix, nil if there is none
+func (tb *testBot) findEmbedIn(c *discordgo.Channel, prefix string) (*discordgo.Message, *discordgo.MessageEmbed) {
+	for _, msg := range tb.discord.Messages(c.ID) {
+		if len(msg.Embeds) > 0 && strings.HasPrefix(msg.Embeds[0].Title, prefix) {
+			return msg, msg.Embeds[0]
+		}
+	}
+	return nil, nil
+}
+
+func field(embed *discordgo.MessageEmbed, name string) string {
+	for _, f := range embed.Fields {
+		if f.Name == name {
+			return f.Value
+		}
+	}
+	return ""
+}
+
+func TestBotVoteLifecycle(t *testing.T) {
+	tb := newTestBot(t)
+	defer tb.close()
+
+	tb.say(tb.alice, "!democracy vote Coffee|Buy a coffee machine|1h")
+	msg, embed := tb.embed("[Vote] Coffee")
+	if embed.Description != "Buy a coffee machine" {
+		t.Errorf("unexpected description %q", embed.Description)
+	}
+	if got := tb.discord.Reactions(msg.ID, "✅"); len(got) != 1 || got[0] != tb.discord.BotUser().ID {
+		t.Errorf("expected the bot to add the pro reaction, got %v", got)
+	}
+	tb.embed("Vote created")
+
+	tb.react(msg.ID, "✅", tb.bob)
+	_, embed = tb.embed("[Vote] Coffee")
+	if got := field(embed, "Pro"); got != ":white_check_mark: [ 1 ]" {
+		t.Errorf("expected one pro ballot, got %q", got)
+	}
+	if got := tb.discord.Reactions(msg.ID, "✅"); len(got) != 1 {
+		t.Errorf("expected the ballot reaction to be removed, got %v", got)
+	}
+
+	// ballots after the vote expired are refused before the scheduler closes it
+	tb.clock.now = tb.clock.now.Add(2 * time.Hour)
+	tb.react(msg.ID, "❎", tb.user("carol"))
+	if vote, _ := tb.vote("Coffee"); vote.Con != 0 || vote.Closed {
+		t.Errorf("expected no late ballot on the open vote, got %d con, closed %v", vote.Con, vote.Closed)
+	}
+	if got := tb.discord.Reactions(msg.ID, "❎"); len(got) != 1 {
+		t.Errorf("expected the late reaction to be removed, got %v", got)
+	}
+
+	tb.votes.closeDueVotes(tb.discord, tb.clock.now)
+	tb.flush()
+	_, embed = tb.embed("[Vote] Coffee")
+	if got := field(embed, "Result"); got != "Accepted" {
+		t.Errorf("expected accepted vote, got %q", got)
+	}
+	if got := tb.discord.Reactions(msg.ID, "✅"); len(got) != 0 {
+		t.Errorf("expected reactions of a closed vote to be removed, got %v", got)
+	}
+
+	tb.react(msg.ID, "❎", tb.bob)
+	_, embed = tb.embed("[Vote] Coffee")
+	if got := field(embed, "Con"); got != ":negative_squared_cross_mark: [ 0 ]" {
+		t.Errorf("expected no ballot on a closed vote, got %q", got)
+	}
+}
+
+func TestBotUndoVote(t *testing.T) {
+	tb := newTestBot(t)
+	defer tb.close()
+
+	tb.say(tb.alice, "!democracy vote Coffee|Buy a coffee machine|1h")
+	vote, _ := tb.embed("[Vote] Coffee")
+	created, _ := tb.embed("Vote created")
+
+	tb.react(created.ID, "↩", tb.bob)
+	tb.embed("[Vote] Coffee")
+
+	tb.react(created.ID, "↩", tb.alice)
+	if _, err := tb.discord.ChannelMessage(tb.channel.ID, vote.ID); err == nil {
+		t.Error("expected the vote message to be deleted")
+	}
+	if _, err := tb.votes.store.GetVoteByID(tb.guild.ID, vote.ID); err == nil {
+		t.Error("expected the vote to be deleted from the store")
+	}
+	tb.embed("Vote deleted")
+}
+
+func TestBotReset(t *testing.T) {
+	tb := newTestBot(t)
+	defer tb.close()
+
+	tb.say(tb.alice, "!democracy vote Coffee|Buy a coffee machine|1h")
+	old := tb.channel
+
+	tb.say(tb.alice, "!democracy reset")
+	if tb.channel.ID == old.ID {
+		t.Fatalf("expected a new democracy channel, got %v", tb.channel)
+	}
+	if tb.channel.Topic != "For the people, by the people" {
+		t.Errorf("unexpected topic %q", tb.channel.Topic)
+	}
+	tb.embed("Info Board")
+	msg, _ := tb.embed("[Vote] Coffee")
+
+	tb.react(msg.ID, "❎", tb.bob)
+	_, embed := tb.embed("[Vote] Coffee")
+	if got := field(embed, "Con"); got != ":negative_squared_cross_mark: [ 1 ]" {
+		t.Errorf("expected ballots on the reposted vote, got %q", got)
+	}
+}
+
+func TestBotInvalidVote(t *testing.T) {
+	tb := newTestBot(t)
+	defer tb.close()
+
+	tb.say(tb.alice, "!democracy vote Coffee")
+	_, embed := tb.embed("Vote failed")
+	if !strings.Contains(embed.Description, "Invalid vote text") {
+		t.Errorf("unexpected error %q", embed.Description)
+	}
+	if got := len(tb.discord.Calls("MessageReactionAdd")); got != 0 {
+		t.Errorf("expected no vote to be posted, got %d reactions", got)
+	}
+}
+
+func TestBotVoteLimits(t *testing.T) {
+	tb := newTestBot(t)
+	defer tb.close()
+	for _, rule := range []string{"max_open_votes 2", "max_votes_per_day 3", "min_title_length 4", "min_description_length 10"} {
+		tb.say(tb.alice, "!democracy rules set "+rule)
+	}
+
+	// each failure is removed once checked, so the next one is found
+	rejected := func(content, rule string) {
+		tb.say(tb.bob, content)
+		msg, embed := tb.embed("Vote failed")
+		if !strings.Contains(embed.Description, rule) {
+			t.Errorf("%s: expected %s to be broken, got %q", content, rule, embed.Description)
+		}
+		tb.discord.ChannelMessageDelete(tb.channel.ID, msg.ID)
+	}
+	rejected("!democracy vote Tea|Buy a kettle for the office", "min_title_length")
+	rejected("!democracy vote Kettle|Buy one", "min_description_length")
+	tb.say(tb.bob, "!democracy vote Coffee|Buy a coffee machine")
+	rejected("!democracy vote coffee |Buy another coffee machine", "duplicate title")
+	tb.say(tb.bob, "!democracy vote Kettle|Buy a kettle for the office")
+	rejected("!democracy vote Snacks|Buy snacks for the office", "max_open_votes")
+
+	tb.say(tb.alice, "!democracy rules set max_open_votes 0")
+	tb.say(tb.bob, "!democracy vote Snacks|Buy snacks for the office")
+	rejected("!democracy vote Fruit|Buy fruit for the office", "max_votes_per_day")
+	tb.clock.now = tb.clock.now.Add(25 * time.Hour)
+	tb.say(tb.bob, "!democracy vote Fruit|Buy fruit for the office")
+	tb.embed("[Vote] Fruit")
+}
+
+func TestBotElections(t *testing.T) {
+	tb := newTestBot(t)
+	defer tb.close()
+	carol := tb.user("carol")
+	tb.say(tb.alice, "!democracy rules set distrust_cooldown 7")
+	rejected := func(content, reply string) {
+		tb.say(tb.bob, content)
+		msg, embed := tb.embed("Vote failed")
+		if !strings.Contains(embed.Description, reply) {
+			t.Errorf("%s: expected %q, got %q", content, reply, embed.Description)
+		}
+		tb.discord.ChannelMessageDelete(tb.channel.ID, msg.ID)
+	}
+	rejected("!democracy nominate <@42>|moderator|30d", "Only members")
+	rejected("!democracy distrust <@"+carol.ID+">|inactive", "holds no elected role")
+
+	tb.say(tb.bob, "!democracy nominate <@"+carol.ID+">|moderator|30d")
+	msg, _ := tb.embed("[Vote] Elect carol as moderator")
+	tb.react(msg.ID, "✅", tb.alice)
+	tb.react(msg.ID, "✅", tb.bob)
+	terms, _ := tb.votes.store.ReadTerms(tb.guild.ID, carol.ID)
+	if len(terms) != 1 || terms[0].Role != "moderator" || !terms[0].End.Equal(tb.clock.now.Add(30*day)) {
+		t.Fatalf("expected carol to be elected for 30 days, got %v", terms)
+	}
+
+	tb.say(tb.alice, "!democracy distrust <@"+carol.ID+">|inactive")
+	msg, _ = tb.embed("[Vote] Distrust carol as moderator")
+	tb.react(msg.ID, "❎", tb.bob)
+	tb.react(msg.ID, "❎", carol)
+	rejected("!democracy distrust <@"+carol.ID+">|still inactive", "distrust cooldown active")
+
+	tb.clock.now = tb.clock.now.Add(8 * day)
+	tb.say(tb.bob, "!democracy distrust <@"+carol.ID+">|still inactive")
+	// the rejected distrust vote is still shown with the same title
+	for _, m := range tb.discord.Messages(tb.channel.ID) {
+		if len(m.Embeds) > 0 && strings.HasPrefix(m.Embeds[0].Title, "[Vote] Distrust carol") {
+			msg = m
+		}
+	}
+	tb.react(msg.ID, "✅", tb.alice)
+	tb.react(msg.ID, "✅", tb.bob)
+	terms, _ = tb.votes.store.ReadTerms(tb.guild.ID, carol.ID)
+	if len(terms) != 1 || !terms[0].End.Equal(tb.clock.now) {
+		t.Errorf("expected the term of carol to end, got %v", terms)
+	}
+}
+
+func TestBotErrorReporting(t *testing.T) {
+	tb := newTestBot(t)
+	defer tb.close()
+	var reported []map[string]string
+	tb.bot.SetReporter(ReporterFunc(func(err error, tags map[string]string) {
+		reported = append(reported, tags)
+	}))
+	tb.bot.AddMessageHandler("broken", func(c *discordgo.Channel, s Session, m *discordgo.MessageCreate) error {
+		if m.Content == "reset_handler" {
+			return nil
+		}
+		return internalError("unable to break", "", errors.New("boom"))
+	})
+
+	// user and permission errors are replied but not reported
+	tb.say(tb.alice, "!democracy vote Coffee")
+	tb.say(tb.bob, "!democracy rules set repropose_cooldown 2d")
+	msg, embed := tb.embed("Vote failed")
+	if !strings.Contains(embed.Description, "Invalid vote text") {
+		t.Errorf("unexpected error %q", embed.Description)
+	}
+	if len(reported) != 0 {
+		t.Errorf("expected no reports, got %v", reported)
+	}
+	tb.discord.ChannelMessageDelete(tb.channel.ID, msg.ID)
+	if _, embed = tb.embed("Vote failed"); !strings.Contains(embed.Description, "Only the server owner") {
+		t.Errorf("unexpected error %q", embed.Description)
+	}
+
+	tb.say(tb.bob, "!democracy broken")
+	if len(reported) != 1 || reported[0]["handler"] != "broken" || reported[0]["user"] != tb.bob.ID {
+		t.Fatalf("expected one report of the broken handler, got %v", reported)
+	}
+	found := false
+	for _, msg := range tb.discord.Messages(tb.channel.ID) {
+		if len(msg.Embeds) > 0 && msg.Embeds[0].Title == "Vote failed" && strings.Contains(msg.Embeds[0].Description, "contact support") {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("expected the failure to be replied")
+	}
+	for _, msg := range tb.discord.Messages(tb.channel.ID) {
+		if strings.HasPrefix(msg.Content, "!democracy") {
+			t.Errorf("expected command %q to be removed", msg.Content)
+		}
+	}
+}
+
+func TestBotReconcile(t *testing.T) {
+	tb := newTestBot(t)
+	defer tb.close()
+
+	tb.say(tb.alice, "!democracy vote Coffee|Buy a coffee machine|1h")
+	msg, _ := tb.embed("[Vote] Coffee")
+	// reacted while the bot was offline, no event is received
+	tb.discord.React(tb.channel.ID, msg.ID, "❎", tb.bob)
+
+	tb.bot.HandleReady(tb.discord, &discordgo.Ready{})
+	tb.flush()
+	_, embed := tb.embed("[Vote] Coffee")
+	if got := field(embed, "Con"); got != ":negative_squared_cross_mark: [ 1 ]" {
+		t.Errorf("expected the missed ballot to be recorded, got %q", got)
+	}
+	if got := tb.discord.Reactions(msg.ID, "❎"); len(got) != 1 {
+		t.Errorf("expected the missed reaction to be removed, got %v", got)
+	}
+
+	tb.discord.ChannelMessageDelete(tb.channel.ID, msg.ID)
+	tb.votes.reconcile(tb.discord)
+	tb.flush()
+	reposted, embed := tb.embed("[Vote] Coffee")
+	if reposted.ID == msg.ID || field(embed, "Con") != ":negative_squared_cross_mark: [ 1 ]" {
+		t.Errorf("expected the deleted vote to be reposted with its tally, got %s %q", reposted.ID, field(embed, "Con"))
+	}
+}
+
+func TestBotArchive(t *testing.T) {
+	tb := newTestBot(t)
+	defer tb.close()
+	archive := tb.discord.AddChannel(tb.guild, "archive")
+
+	tb.say(tb.bob, "!democracy archive <#"+archive.ID+">")
+	if got := len(tb.discord.Messages(archive.ID)); got != 0 {
+		t.Fatalf("expected only the owner to set the archive, got %d messages", got)
+	}
+	tb.say(tb.alice, "!democracy archive <#"+archive.ID+">")
+
+	tb.say(tb.alice, "!democracy vote Coffee|Buy a coffee machine|1h|Kitchen, budget")
+	msg, embed := tb.embed("[Vote] Coffee")
+	if got := field(embed, "Tags"); got != "kitchen, budget" {
+		t.Errorf("unexpected tags %q", got)
+	}
+	tb.react(msg.ID, "✅", tb.bob)
+
+	tb.clock.now = tb.clock.now.Add(2 * time.Hour)
+	tb.votes.closeDueVotes(tb.discord, tb.clock.now)
+	tb.flush()
+	if msg, _ := tb.findEmbed("[Vote] Coffee"); msg != nil {
+		t.Error("expected the closed vote to leave the democracy channel")
+	}
+	archived := tb.discord.Messages(archive.ID)
+	if len(archived) != 1 || field(archived[0].Embeds[0], "Result") != "Accepted" {
+		t.Fatalf("expected the closed vote in the archive, got %v", archived)
+	}
+	if got := tb.discord.Reactions(archived[0].ID, "✅"); len(got) != 0 {
+		t.Errorf("expected no ballot reactions on the archived vote, got %v", got)
+	}
+	if vote, err := tb.votes.store.GetVoteByNumber(tb.guild.ID, 1); err != nil || vote.CurrentID != archived[0].ID {
+		t.Errorf("expected the vote to point to its archived message, got %+v (%v)", vote, err)
+	}
+
+	// reposting the votes of a reset channel leaves the archive alone
+	tb.say(tb.alice, "!democracy reset")
+	if msg, _ := tb.findEmbed("[Vote] Coffee"); msg != nil {
+		t.Error("expected the archived vote to not be reposted")
+	}
+}
+
+func TestBotReminderRetry(t *testing.T) {
+	tb := newTestBot(t)
+	defer tb.close()
+	tb.votes.queues.sleep = func(time.Duration) {}
+	tb.votes.reminders.sleep = func(time.Duration) {}
+	carol := tb.user("carol")
+
+	tb.say(carol, "!democracy notify on")
+	tb.say(tb.bob, "!democracy vote Slow|A slow vote|2d")
+	tb.clock.now = tb.clock.now.Add(47 * time.Hour)
+	tb.discord.Fail("UserChannelCreate", &discordgo.RESTError{
+		Response: &http.Response{StatusCode: http.StatusServiceUnavailable, Status: "503 Service Unavailable"},
+	})
+	tb.votes.tick(tb.discord, tb.clock.now)
+	tb.flush()
+	if n := len(tb.discord.DirectMessages(carol)); n != 0 {
+		t.Fatalf("expected no reminder while Discord is unavailable, got %d", n)
+	}
+
+	tb.discord.Fail("UserChannelCreate", nil)
+	tb.clock.now = tb.clock.now.Add(time.Minute)
+	tb.votes.tick(tb.discord, tb.clock.now)
+	tb.flush()
+	tb.votes.tick(tb.discord, tb.clock.now)
+	tb.flush()
+	if n := len(tb.discord.DirectMessages(carol)); n != 1 {
+		t.Errorf("expected the released reminder to be sent once, got %d", n)
+	}
+	lastCalls := 0
+	for _, msg := range tb.discord.Messages(tb.channel.ID) {
+		if strings.Contains(msg.Content, "Last call") {
+			lastCalls++
+		}
+	}
+	if lastCalls != 1 {
+		t.Errorf("expected one last call, got %d", lastCalls)
+	}
+}
+
+func TestBotHistory(t *testing.T) {
+	tb := newTestBot(t)
+	defer tb.close()
+
+	for i := 0; i < 12; i++ {
+		vote, err := tb.votes.store.InsertVote(Vote{
+			Guild: tb.guild.ID, Channel: tb.channel.ID, ID: string(rune('a' + i)), Title: "Vote", Author: tb.alice.ID,
+			Created: tb.clock.now, Expires: tb.clock.now,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		tb.votes.store.CloseVote(vote)
+	}
+
+	tb.say(tb.bob, "!democracy history")
+	msg, embed := tb.embed("Vote History")
+	if len(embed.Fields) != historyPageSize || embed.Fields[0].Name != "#12 Vote" {
+		t.Fatalf("expected the newest votes first, got %d fields", len(embed.Fields))
+	}
+	if embed.Footer.Text != "Page 1/2 · closed" {
+		t.Errorf("unexpected footer %q", embed.Footer.Text)
+	}
+
+	tb.react(msg.ID, "▶", tb.bob)
+	_, embed = tb.embed("Vote History")
+	if embed.Footer.Text != "Page 2/2 · closed" || len(embed.Fields) != 2 || embed.Fields[1].Name != "#1 Vote" {
+		t.Errorf("expected the second page, got %q with %d fields", embed.Footer.Text, len(embed.Fields))
+	}
+	if got := tb.discord.Reactions(msg.ID, "▶"); len(got) != 1 {
+		t.Errorf("expected the page reaction to be removed, got %v", got)
+	}
+
+	tb.say(tb.bob, "!democracy history open")
+	if got := len(tb.discord.Messages(tb.channel.ID)); got != 2 {
+		t.Fatalf("expected a second history, got %d messages", got)
+	}
+	last := tb.discord.Messages(tb.channel.ID)[1].Embeds[0]
+	if last.Description != "No votes found." || last.Footer.Text != "Page 1/1 · open" {
+		t.Errorf("unexpected empty history %q %q", last.Description, last.Footer.Text)
+	}
+}
+
+func TestBotWebhooks(t *testing.T) {
+	tb := newTestBot(t)
+	tb.votes.webhooks.sleep = func(time.Duration, <-chan struct{}) bool { return true }
+
+	type delivery struct {
+		header http.Header
+		body   []byte
+	}
+	received := make(chan delivery, 10)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		received <- delivery{r.Header, body}
+	}))
+	defer receiver.Close()
+	var attempts int32
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer broken.Close()
+
+	tb.say(tb.bob, "!democracy webhook add "+receiver.URL+" all")
+	tb.say(tb.alice, "!democracy webhook add ftp://example.com all")
+	// the receivers listen on the loopback address only allowed in tests
+	tb.say(tb.alice, "!democracy webhook add "+receiver.URL+" all")
+	refused := false
+	for _, msg := range tb.discord.Messages(tb.channel.ID) {
+		if len(msg.Embeds) > 0 && strings.Contains(msg.Embeds[0].Description, "private address 127.0.0.1") {
+			refused = true
+		}
+	}
+	if !refused {
+		t.Error("expected private addresses to be refused")
+	}
+	tb.votes.webhooks.allowed = func(ip net.IP) bool { return ip.IsLoopback() || publicAddress(ip) }
+	tb.say(tb.alice, "!democracy webhook add "+receiver.URL+" ballot_counted")
+	if hooks, _ := tb.votes.store.ReadWebhooks(tb.guild.ID); len(hooks) != 0 {
+		t.Fatalf("expected only valid webhooks of the owner, got %v", hooks)
+	}
+	tb.say(tb.alice, "!democracy webhook add "+receiver.URL+" vote_created,vote_closed")
+	tb.say(tb.alice, "!democracy webhook add "+broken.URL+" ballot_cast")
+	dms := tb.discord.DirectMessages(tb.alice)
+	secret := regexp.MustCompile("`([0-9a-f]{64})`")
+	if len(dms) != 2 || !secret.MatchString(dms[0].Content) {
+		t.Fatalf("expected the secrets by direct message, got %v", dms)
+	}
+
+	tb.say(tb.alice, "!democracy vote Coffee|Buy a coffee machine|1h")
+	var d delivery
+	select {
+	case d = <-received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the created vote to be delivered")
+	}
+	if got := d.header.Get(WebhookSignatureHeader); got != SignWebhook(secret.FindStringSubmatch(dms[0].Content)[1], d.body) {
+		t.Errorf("expected the payload to be signed with the secret, got %q", got)
+	}
+	var payload WebhookPayload
+	if err := json.Unmarshal(d.body, &payload); err != nil || payload.Event != EventVoteCreated || payload.Vote == nil || payload.Vote.Title != "Coffee" || payload.Vote.Outcome != "open" {
+		t.Errorf("unexpected payload %s (%v)", d.body, err)
+	}
+
+	tb.say(tb.alice, "!democracy webhook test 1")
+	select {
+	case d = <-received:
+		if d.header.Get("X-Democracy-Event") != string(webhookTestEvent) {
+			t.Errorf("expected a test delivery, got %s", d.body)
+		}
+	default:
+		t.Error("expected the test delivery to be sent before replying")
+	}
+
+	msg, _ := tb.embed("[Vote] Coffee")
+	tb.react(msg.ID, "✅", tb.bob)
+	tb.close()
+	if len(received) != 0 {
+		t.Errorf("expected ballots to not be delivered to webhooks of other events, got %d", len(received))
+	}
+	failures, _ := tb.votes.store.ReadWebhookFailures(tb.guild.ID, 0)
+	if len(failures) != 1 || failures[0].Webhook != 2 || failures[0].Attempts != webhookMaxAttempts || atomic.LoadInt32(&attempts) != webhookMaxAttempts {
+		t.Errorf("expected the failed delivery to be retried and recorded, got %+v", failures)
+	}
+}
+
+func TestBotMetrics(t *testing.T) {
+	tb := newTestBot(t)
+	defer tb.close()
+	registry := metrics.NewRegistry()
+	tb.votes.RegisterMetrics(registry)
+
+	tb.say(tb.alice, "!democracy vote Coffee|Buy a coffee machine|1h")
+	msg, _ := tb.embed("[Vote] Coffee")
+	tb.react(msg.ID, "✅", tb.bob)
+
+	var out bytes.Buffer
+	registry.WriteTo(&out)
+	if !strings.Contains(out.String(), `democracy_open_votes{guild="`+tb.guild.ID+`"} 1`) {
+		t.Errorf("expected the open vote, got\n%s", out.String())
+	}
+	out.Reset()
+	metrics.Default.WriteTo(&out)
+	for _, line := range []string{
+		`democracy_command_duration_seconds_count{command="vote"}`,
+		`democracy_ballots_total{guild="` + tb.guild.ID + `"}`,
+	} {
+		if !strings.Contains(out.String(), line) {
+			t.Errorf("expected %s in the metrics", line)
+		}
+	}
+
+	for query, expected := range map[string]string{
+		"select guild_id, coalesce(pro, 0) from votes where guild_id = $1": "select votes",
+		"INSERT INTO guild_api_keys(guild_id, name) VALUES($1,$2)":         "insert guild_api_keys",
+		"UPDATE votes SET pro = $3 WHERE guild_id = $1":                    "update votes",
+		"DELETE FROM vote_entries WHERE guild_id = $1":                     "delete vote_entries",
+		"CREATE TABLE IF NOT EXISTS votes (vote_id VARCHAR(50))":           "other",
+	} {
+		if got := statement(query); got != expected {
+			t.Errorf("expected statement %q for %q, got %q", expected, query, got)
+		}
+	}
+}
@@ -0,0 +1,684 @@
+package votes
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/playnet-public/democracy.bot/pkg/helpers"
+	"go.uber.org/zap"
+)
+
+// discordEpoch of snowflake IDs in milliseconds since the unix epoch
+const discordEpoch = 1420070400000
+
+// brigadeReportLimit of flagged ballots listed in a report
+const brigadeReportLimit = 20
+
+// BrigadeThresholds of the signals marking ballots as suspicious
+type BrigadeThresholds struct {
+	// NewMember joined the guild less than NewMember before their ballot
+	NewMember time.Duration
+	// NewAccount was created less than NewAccount before the ballot
+	NewAccount time.Duration
+	// FastBallot was cast less than FastBallot after joining the guild
+	FastBallot time.Duration
+	// Burst of at least BurstSize ballots of new members within BurstWindow
+	BurstSize   int
+	BurstWindow time.Duration
+	// Cluster of at least this many voters sharing an account creation day, avatar or name pattern
+	Cluster int
+	// Report a vote once at least this many of its ballots are flagged
+	Report int
+}
+
+// DefaultBrigadeThresholds catching raids of fresh accounts while leaving regular members alone
+var DefaultBrigadeThresholds = BrigadeThresholds{
+	NewMember:   7 * day,
+	NewAccount:  30 * day,
+	FastBallot:  time.Minute,
+	BurstSize:   5,
+	BurstWindow: 10 * time.Minute,
+	Cluster:     3,
+	Report:      3,
+}
+
+// SetBrigadeThresholds used to flag suspicious ballots
+func (v *VoteHandler) SetBrigadeThresholds(t BrigadeThresholds) {
+	v.brigade = t
+}
+
+// Signal of a suspicious ballot
+type Signal string
+
+// Signal values found by the brigading detection
+const (
+	SignalNewMember  Signal = "new member"
+	SignalFastBallot Signal = "voted right after joining"
+	SignalBurst      Signal = "part of a burst of new members"
+	SignalSameDay    Signal = "account created the same day as others"
+	SignalAvatar     Signal = "same avatar as others"
+	SignalName       Signal = "name similar to others"
+)
+
+// BrigadeSettings of a guild. Detection is off unless a report channel is set or quarantine is enabled.
+type BrigadeSettings struct {
+	Guild string
+	// Channel moderators are warned in, empty to only log suspicious votes
+	Channel string
+	// Quarantine flagged ballots of suspicious votes until they are reviewed
+	Quarantine bool
+}
+
+func (b BrigadeSettings) enabled() bool {
+	return b.Channel != "" || b.Quarantine
+}
+
+func (b BrigadeSettings) String() string {
+	if !b.enabled() {
+		return "Brigading detection is off."
+	}
+	report := "Suspicious votes are only logged."
+	if b.Channel != "" {
+		report = fmt.Sprintf("Suspicious votes are reported in <#%s>.", b.Channel)
+	}
+	if b.Quarantine {
+		return report + " Flagged ballots are quarantined until they are reviewed."
+	}
+	return report + " Flagged ballots are counted."
+}
+
+// QuarantinedBallot held back from the tally of its vote until a moderator reviews it.
+// Released ballots are counted again and never quarantined twice.
+type QuarantinedBallot struct {
+	Guild    string
+	Vote     string
+	User     string
+	Pro      bool
+	Signals  []Signal
+	Cast     time.Time
+	Released bool
+}
+
+// voter of a ballot with what is known about their account, unknown times are zero
+type voter struct {
+	User    *discordgo.User
+	Pro     bool
+	Joined  time.Time
+	Created time.Time
+	Cast    time.Time
+	// Quarantined ballots are held back pending review, Released ones were reviewed and counted
+	Quarantined bool
+	Released    bool
+}
+
+// flaggedVoter whose ballot shows the signals of brigading
+type flaggedVoter struct {
+	voter
+	Signals []Signal
+}
+
+// brigadeReport of the ballots of a vote
+type brigadeReport struct {
+	Vote    Vote
+	Ballots int
+	Flagged []flaggedVoter
+}
+
+// suspicious if enough ballots were flagged to report the vote
+func (r brigadeReport) suspicious(t BrigadeThresholds) bool {
+	return len(r.Flagged) > 0 && len(r.Flagged) >= t.Report
+}
+
+// analyzeBallots of voters on vote at now. A ballot is flagged if it shows two signals
+// or was cast right after joining, released ballots are never flagged.
+func analyzeBallots(vote Vote, voters []voter, t BrigadeThresholds, now time.Time) brigadeReport {
+	signals := make([][]Signal, len(voters))
+	// new members with a known ballot time, candidates for a burst
+	var fresh []int
+	days := make(map[string][]int)
+	avatars := make(map[string][]int)
+	names := make(map[string][]int)
+	for i, vt := range voters {
+		cast := vt.Cast
+		if cast.IsZero() {
+			cast = now
+		}
+		if !vt.Joined.IsZero() && cast.Sub(vt.Joined) < t.NewMember {
+			signals[i] = append(signals[i], SignalNewMember)
+			if !vt.Cast.IsZero() {
+				fresh = append(fresh, i)
+				if vt.Cast.Sub(vt.Joined) < t.FastBallot {
+					signals[i] = append(signals[i], SignalFastBallot)
+				}
+			}
+		}
+		if !vt.Created.IsZero() && cast.Sub(vt.Created) < t.NewAccount {
+			day := vt.Created.UTC().Format(historyDateFormat)
+			days[day] = append(days[day], i)
+		}
+		if vt.User.Avatar != "" {
+			avatars[vt.User.Avatar] = append(avatars[vt.User.Avatar], i)
+		}
+		if stem := nameStem(vt.User.Username); stem != "" {
+			names[stem] = append(names[stem], i)
+		}
+	}
+	for _, cluster := range []struct {
+		groups map[string][]int
+		signal Signal
+	}{{days, SignalSameDay}, {avatars, SignalAvatar}, {names, SignalName}} {
+		for _, group := range cluster.groups {
+			if t.Cluster < 1 || len(group) < t.Cluster {
+				continue
+			}
+			for _, i := range group {
+				signals[i] = append(signals[i], cluster.signal)
+			}
+		}
+	}
+
+	// a window sliding over the ballots of new members in the order they were cast
+	sort.Slice(fresh, func(a, b int) bool { return voters[fresh[a]].Cast.Before(voters[fresh[b]].Cast) })
+	burst := make([]bool, len(voters))
+	for start, end := 0, 0; end < len(fresh); end++ {
+		for voters[fresh[end]].Cast.Sub(voters[fresh[start]].Cast) > t.BurstWindow {
+			start++
+		}
+		if t.BurstSize > 0 && end-start+1 >= t.BurstSize {
+			for _, i := range fresh[start : end+1] {
+				burst[i] = true
+			}
+		}
+	}
+
+	report := brigadeReport{Vote: vote, Ballots: len(voters)}
+	for i, vt := range voters {
+		if burst[i] {
+			signals[i] = append(signals[i], SignalBurst)
+		}
+		if vt.Released || (len(signals[i]) < 2 && !hasSignal(signals[i], SignalFastBallot)) {
+			continue
+		}
+		report.Flagged = append(report.Flagged, flaggedVoter{voter: vt, Signals: signals[i]})
+	}
+	return report
+}
+
+func hasSignal(signals []Signal, signal Signal) bool {
+	for _, s := range signals {
+		if s == signal {
+			return true
+		}
+	}
+	return false
+}
+
+// nameStem of a username without trailing digits and separators like 'voter' of 'Voter_42',
+// empty if too little is left to compare
+func nameStem(name string) string {
+	stem := strings.TrimRight(strings.ToLower(name), "0123456789_.- ")
+	if len(stem) < 3 {
+		return ""
+	}
+	return stem
+}
+
+// accountCreated time of the Discord account with id, encoded in its snowflake
+func accountCreated(id string) time.Time {
+	n, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	ms := int64(n>>22) + discordEpoch
+	return time.Unix(ms/1000, ms%1000*int64(time.Millisecond)).UTC()
+}
+
+// brigadeCheckInterval between two checks of the ballots of a vote.
+// Ballots cast in between are checked together on the next tick of the scheduler.
+const brigadeCheckInterval = 30 * time.Second
+
+// ballotLog remembering when ballots were cast, when votes were checked and which votes were reported.
+// It is kept in memory only, ballots cast before the bot started have no known time.
+type ballotLog struct {
+	mu       sync.Mutex
+	cast     map[string]map[string]time.Time
+	reported map[string]int
+	checked  map[string]time.Time
+	// pending votes with ballots cast since their last check
+	pending map[string]Vote
+}
+
+func newBallotLog() *ballotLog {
+	return &ballotLog{
+		cast:     make(map[string]map[string]time.Time),
+		reported: make(map[string]int),
+		checked:  make(map[string]time.Time),
+		pending:  make(map[string]Vote),
+	}
+}
+
+// record the ballot of user on vote at, keeping the time of the first one
+func (l *ballotLog) record(vote Vote, user string, at time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	k := key(vote.Guild, vote.ID)
+	if l.cast[k] == nil {
+		l.cast[k] = make(map[string]time.Time)
+	}
+	if _, ok := l.cast[k][user]; !ok {
+		l.cast[k][user] = at
+	}
+}
+
+// castTimes of the ballots on vote by user
+func (l *ballotLog) castTimes(vote Vote) map[string]time.Time {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	times := make(map[string]time.Time)
+	for user, at := range l.cast[key(vote.Guild, vote.ID)] {
+		times[user] = at
+	}
+	return times
+}
+
+// report vote with flagged ballots, false if it was reported with as many before
+func (l *ballotLog) report(vote Vote, flagged int) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	k := key(vote.Guild, vote.ID)
+	if flagged <= l.reported[k] {
+		return false
+	}
+	l.reported[k] = flagged
+	return true
+}
+
+// due reports whether the ballots of vote may be checked at now, otherwise the vote is left pending
+func (l *ballotLog) due(vote Vote, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	k := key(vote.Guild, vote.ID)
+	if last, ok := l.checked[k]; ok && now.Sub(last) < brigadeCheckInterval {
+		l.pending[k] = vote
+		return false
+	}
+	l.checked[k] = now
+	delete(l.pending, k)
+	return true
+}
+
+// isPending reports whether ballots of vote are waiting for their check
+func (l *ballotLog) isPending(vote Vote) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, ok := l.pending[key(vote.Guild, vote.ID)]
+	return ok
+}
+
+// takeDue pending votes whose last check is at least brigadeCheckInterval before now
+func (l *ballotLog) takeDue(now time.Time) []Vote {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var votes []Vote
+	for k, vote := range l.pending {
+		if now.Sub(l.checked[k]) < brigadeCheckInterval {
+			continue
+		}
+		votes = append(votes, vote)
+		l.checked[k] = now
+		delete(l.pending, k)
+	}
+	return votes
+}
+
+// forget vote once it no longer takes ballots
+func (l *ballotLog) forget(vote Vote) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	k := key(vote.Guild, vote.ID)
+	delete(l.cast, k)
+	delete(l.reported, k)
+	delete(l.checked, k)
+	delete(l.pending, k)
+}
+
+// detectBrigading on the ballots of a vote, warning moderators and quarantining flagged ballots.
+// The ballots of a vote are checked at most once per brigadeCheckInterval, the vote is not closed
+// early while ballots wait for their check.
+func (v *VoteHandler) detectBrigading(e Event) {
+	var base eventBase
+	var vote Vote
+	var user string
+	switch e := e.(type) {
+	case BallotCast:
+		base, vote, user = e.eventBase, e.Vote, e.User
+	case BallotChanged:
+		base, vote, user = e.eventBase, e.Vote, e.User
+	case VoteClosed:
+		v.ballots.forget(e.Vote)
+		return
+	case VoteDeleted:
+		v.ballots.forget(e.Vote)
+		return
+	default:
+		return
+	}
+	v.ballots.record(vote, user, base.Time)
+	settings, err := v.store.GetBrigadeSettings(vote.Guild)
+	if err != nil {
+		v.log.Error("unable to read brigading settings", zap.String("guild", vote.Guild), zap.Error(err))
+		return
+	}
+	if !settings.enabled() || !v.ballots.due(vote, base.Time) {
+		return
+	}
+	v.checkBrigading(base.session, vote, settings)
+}
+
+// checkPendingBrigading of the votes whose ballots waited for their check at now, closing them if they are decided
+func (v *VoteHandler) checkPendingBrigading(s Session, now time.Time) {
+	for _, vote := range v.ballots.takeDue(now) {
+		settings, err := v.store.GetBrigadeSettings(vote.Guild)
+		if err != nil {
+			v.log.Error("unable to read brigading settings", zap.String("guild", vote.Guild), zap.Error(err))
+			continue
+		}
+		if settings.enabled() {
+			v.checkBrigading(s, vote, settings)
+		}
+		v.checkEarlyClose(s, vote)
+	}
+}
+
+// checkBrigading on the ballots of vote, quarantining and reporting them as configured by settings
+func (v *VoteHandler) checkBrigading(s Session, vote Vote, settings BrigadeSettings) {
+	report, err := v.checkBallots(s, vote)
+	if err != nil {
+		v.log.Error("unable to check ballots", zap.String("guild", vote.Guild), zap.String("vote", vote.ID), zap.Error(err))
+		return
+	}
+	if !report.suspicious(v.brigade) {
+		return
+	}
+	if settings.Quarantine {
+		report = v.quarantine(s, report)
+	}
+	if !v.ballots.report(vote, len(report.Flagged)) {
+		return
+	}
+	v.log.Warn("brigading suspected", zap.String("guild", vote.Guild), zap.String("vote", vote.ID), zap.Int("flagged", len(report.Flagged)), zap.Int("ballots", report.Ballots))
+	if settings.Channel == "" {
+		return
+	}
+	title := fmt.Sprintf("Brigading suspected on Vote #%d", vote.Number)
+	_, err = v.discord(s).ChannelMessageSendEmbed(settings.Channel, newBrigadeEmbed(title, report))
+	if err != nil {
+		v.log.Error("unable to report brigading", zap.String("guild", vote.Guild), zap.String("vote", vote.ID), zap.String("channel", settings.Channel), zap.Error(err))
+	}
+}
+
+// checkBallots of vote, including those in quarantine
+func (v *VoteHandler) checkBallots(s Session, vote Vote) (brigadeReport, error) {
+	voters, err := v.voters(s, vote)
+	if err != nil {
+		return brigadeReport{Vote: vote}, err
+	}
+	return analyzeBallots(vote, voters, v.brigade, v.clock.Now()), nil
+}
+
+// voters of vote with their membership, ordered by user
+func (v *VoteHandler) voters(s Session, vote Vote) ([]voter, error) {
+	ballots, err := v.store.ReadVoters(vote)
+	if err != nil {
+		return nil, err
+	}
+	quarantined, err := v.store.ReadQuarantinedBallots(vote.Guild, vote.ID)
+	if err != nil {
+		return nil, err
+	}
+	guild, err := s.StateGuild(vote.Guild)
+	if err != nil {
+		return nil, err
+	}
+	members := make(map[string]*discordgo.Member)
+	for _, member := range guild.Members {
+		if member.User != nil {
+			members[member.User.ID] = member
+		}
+	}
+	cast := v.ballots.castTimes(vote)
+	newVoter := func(user string, pro bool) voter {
+		vt := voter{User: &discordgo.User{ID: user}, Pro: pro, Created: accountCreated(user), Cast: cast[user]}
+		if member, ok := members[user]; ok {
+			vt.User = member.User
+			// an unparsable join time is unknown
+			vt.Joined, _ = time.Parse(time.RFC3339, member.JoinedAt)
+		}
+		return vt
+	}
+
+	var voters []voter
+	released := make(map[string]bool)
+	for _, ballot := range quarantined {
+		if ballot.Released {
+			released[ballot.User] = true
+			continue
+		}
+		vt := newVoter(ballot.User, ballot.Pro)
+		vt.Cast, vt.Quarantined = ballot.Cast, true
+		voters = append(voters, vt)
+	}
+	for user, pro := range ballots {
+		vt := newVoter(user, pro)
+		vt.Released = released[user]
+		voters = append(voters, vt)
+	}
+	sort.Slice(voters, func(i, j int) bool { return voters[i].User.ID < voters[j].User.ID })
+	return voters, nil
+}
+
+// quarantine the flagged ballots of report, holding them back from the tally until they are reviewed
+func (v *VoteHandler) quarantine(s Session, report brigadeReport) brigadeReport {
+	for i, flagged := range report.Flagged {
+		if flagged.Quarantined {
+			continue
+		}
+		ballot := QuarantinedBallot{
+			Guild:   report.Vote.Guild,
+			Vote:    report.Vote.ID,
+			User:    flagged.User.ID,
+			Pro:     flagged.Pro,
+			Signals: flagged.Signals,
+			Cast:    flagged.Cast,
+		}
+		if ballot.Cast.IsZero() {
+			ballot.Cast = v.clock.Now()
+		}
+		err := v.store.QuarantineBallot(ballot)
+		if err != nil {
+			v.log.Error("unable to quarantine ballot", zap.String("guild", ballot.Guild), zap.String("vote", ballot.Vote), zap.String("user", ballot.User), zap.Error(err))
+			continue
+		}
+		vote, err := v.RetractBallot(s, report.Vote, ballot.User)
+		if err != nil {
+			v.log.Error("unable to retract quarantined ballot", zap.String("guild", ballot.Guild), zap.String("vote", ballot.Vote), zap.String("user", ballot.User), zap.Error(err))
+			v.store.DeleteQuarantinedBallot(ballot)
+			continue
+		}
+		v.log.Info("ballot quarantined", zap.String("guild", ballot.Guild), zap.String("vote", ballot.Vote), zap.String("user", ballot.User))
+		report.Vote = vote
+		report.Flagged[i].Quarantined = true
+	}
+	return report
+}
+
+// holdQuarantined ballots of users whose earlier ballot on vote awaits review, updating it instead of the tally.
+// Ballots are counted right away while the guild does not quarantine them.
+// Returns false if the ballot is to be counted.
+func (v *VoteHandler) holdQuarantined(vote Vote, user string, pro bool) (bool, error) {
+	settings, err := v.store.GetBrigadeSettings(vote.Guild)
+	if err != nil || !settings.Quarantine {
+		return false, err
+	}
+	ballot, err := v.store.GetQuarantinedBallot(vote.Guild, vote.ID, user)
+	if err == ErrNotFound || (err == nil && ballot.Released) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	ballot.Pro = pro
+	return true, v.store.QuarantineBallot(ballot)
+}
+
+// BrigadingCommand Message Handler for configuring brigading detection and reviewing quarantined ballots
+func (v *VoteHandler) BrigadingCommand(c *discordgo.Channel, s Session, m *discordgo.MessageCreate) error {
+	if m.Content == "reset_handler" {
+		return nil
+	}
+
+	usage := "Invalid brigading command. Please follow this schema: '!democracy brigading', '!democracy brigading channel [#channel|off]', '!democracy brigading quarantine [on|off]', '!democracy brigading check [vote number]' or '!democracy brigading release|discard [vote number] [@user]'"
+	args := strings.Fields(strings.TrimPrefix(m.Content, "brigading"))
+	g, err := s.Guild(c.GuildID)
+	if err != nil {
+		return internalError("could not fetch guild", "unable to update brigading detection", err)
+	}
+	if g.OwnerID != m.Author.ID {
+		return permissionError("Only the server owner may manage brigading detection.")
+	}
+	settings, err := v.db(s).GetBrigadeSettings(c.GuildID)
+	if err != nil {
+		return internalError("unable to read brigading settings", "Failed to read brigading settings from DB. Please contact support.", err)
+	}
+
+	switch {
+	case len(args) == 0:
+		return v.reply(s, m, settings.String())
+	case args[0] == "channel" && len(args) == 2:
+		settings.Channel = ""
+		if args[1] != "off" {
+			channel, ok := textChannel(g, args[1])
+			if !ok {
+				return userError("invalid brigading channel", "Please mention a text channel of this server or use 'off'.")
+			}
+			settings.Channel = channel
+		}
+	case args[0] == "quarantine" && len(args) == 2 && (args[1] == "on" || args[1] == "off"):
+		settings.Quarantine = args[1] == "on"
+	case args[0] == "check" && len(args) == 2:
+		vote, err := v.findVote(c.GuildID, args[1])
+		if err != nil {
+			return lookupError("vote not found", fmt.Sprintf("Vote %s not found.", args[1]), err)
+		}
+		report, err := v.checkBallots(s, vote)
+		if err != nil {
+			return internalError("unable to check ballots", "", err).forVote(vote)
+		}
+		return v.replyEmbed(s, m, newBrigadeEmbed(fmt.Sprintf("Ballot check of Vote #%d", vote.Number), report))
+	case (args[0] == "release" || args[0] == "discard") && (len(args) == 2 || len(args) == 3):
+		return v.reviewBallots(s, m, c.GuildID, args)
+	default:
+		return userError("invalid brigading command", usage)
+	}
+
+	settings.Guild = c.GuildID
+	err = v.db(s).SetBrigadeSettings(settings)
+	if err != nil {
+		return internalError("unable to store brigading settings", "Failed to store brigading settings in DB. Please contact support.", err)
+	}
+	return v.reply(s, m, settings.String())
+}
+
+// reviewBallots quarantined on a vote, releasing them into its tally or discarding them.
+// args are the action, the vote and optionally the user whose ballot is reviewed.
+func (v *VoteHandler) reviewBallots(s Session, m *discordgo.MessageCreate, guild string, args []string) error {
+	vote, err := v.findVote(guild, args[1])
+	if err != nil {
+		return lookupError("vote not found", fmt.Sprintf("Vote %s not found.", args[1]), err)
+	}
+	release := args[0] == "release"
+	if release && vote.Closed {
+		return userErrorf("vote closed", "Vote #%d is closed, its quarantined ballots can only be discarded.", vote.Number).forVote(vote)
+	}
+	ballots, err := v.db(s).ReadQuarantinedBallots(guild, vote.ID)
+	if err != nil {
+		return internalError("unable to read quarantined ballots", "Failed to read quarantined ballots from DB. Please contact support.", err).forVote(vote)
+	}
+	user := ""
+	if len(args) == 3 {
+		user = strings.TrimSuffix(strings.TrimLeft(args[2], "<@!"), ">")
+	}
+
+	n := 0
+	for _, ballot := range ballots {
+		if ballot.Released || (user != "" && ballot.User != user) {
+			continue
+		}
+		if !release {
+			deleted, err := v.db(s).DeleteQuarantinedBallot(ballot)
+			if err != nil {
+				return internalError("unable to discard ballot", "", err).forVote(vote)
+			}
+			if deleted {
+				n++
+			}
+			continue
+		}
+		ballot.Released = true
+		err = v.db(s).QuarantineBallot(ballot)
+		if err != nil {
+			return internalError("unable to release ballot", "", err).forVote(vote)
+		}
+		vote, _, err = v.recordBallot(s, vote, ballot.User, ballot.Pro)
+		if err != nil {
+			return internalError("unable to count released ballot", "", err).forVote(vote)
+		}
+		n++
+	}
+	if n == 0 {
+		return userErrorf("no quarantined ballots", "Vote #%d has no quarantined ballots to %s.", vote.Number, args[0]).forVote(vote)
+	}
+	v.logger(s).Info("quarantined ballots reviewed", zap.String("guild", guild), zap.String("vote", vote.ID), zap.String("action", args[0]), zap.Int("ballots", n))
+	if release {
+		return v.reply(s, m, fmt.Sprintf("Released %d ballots into the tally of Vote #%d.", n, vote.Number))
+	}
+	return v.reply(s, m, fmt.Sprintf("Discarded %d ballots of Vote #%d.", n, vote.Number))
+}
+
+func newBrigadeEmbed(title string, report brigadeReport) *discordgo.MessageEmbed {
+	embed := helpers.NewEmbed().
+		SetTitle(title).
+		SetColor(0xf5a623).
+		SetDescription(fmt.Sprintf("%d of %d ballots on '%s' look suspicious.", len(report.Flagged), report.Ballots, report.Vote.Title))
+	for i, flagged := range report.Flagged {
+		if i == brigadeReportLimit {
+			embed.AddField("More", fmt.Sprintf("%d more ballots are flagged.", len(report.Flagged)-i), false)
+			break
+		}
+		ballot := "con"
+		if flagged.Pro {
+			ballot = "pro"
+		}
+		if flagged.Quarantined {
+			ballot += ", quarantined"
+		}
+		signals := make([]string, len(flagged.Signals))
+		for j, signal := range flagged.Signals {
+			signals[j] = string(signal)
+		}
+		name := flagged.User.ID
+		if flagged.User.Username != "" {
+			name = flagged.User.String()
+		}
+		embed.AddField(name, fmt.Sprintf("<@%s> (%s): %s", flagged.User.ID, ballot, strings.Join(signals, ", ")), false)
+	}
+	if len(report.Flagged) > 0 {
+		embed.AddField("Review", fmt.Sprintf("!democracy brigading release|discard %d [@user]", report.Vote.Number), false)
+	}
+	return embed.Truncate().MessageEmbed
+}
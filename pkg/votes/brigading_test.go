@@ -0,0 +1,116 @@
+package votes
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestAnalyzeBallots(t *testing.T) {
+	now := time.Date(2018, 3, 1, 12, 0, 0, 0, time.UTC)
+	old := now.Add(-365 * day)
+	voters := []voter{
+		{User: &discordgo.User{ID: "1", Username: "alice"}, Joined: old, Created: old, Cast: now},
+		{User: &discordgo.User{ID: "2", Username: "bob", Avatar: "a"}, Joined: old, Created: old},
+		// a new member voting in their own time
+		{User: &discordgo.User{ID: "3", Username: "carol"}, Joined: now.Add(-2 * day), Created: old, Cast: now},
+	}
+	for i, name := range []string{"raider_1", "Raider2", "raider.3", "raider4", "raider5"} {
+		voters = append(voters, voter{
+			User:    &discordgo.User{ID: string(rune('a' + i)), Username: name, Avatar: "b"},
+			Joined:  now.Add(-time.Hour),
+			Created: now.Add(-day),
+			Cast:    now.Add(-time.Hour + time.Duration(i)*time.Minute + 5*time.Minute),
+		})
+	}
+	voters[len(voters)-1].Released = true
+
+	report := analyzeBallots(Vote{Title: "Coffee"}, voters, DefaultBrigadeThresholds, now)
+	if report.Ballots != 8 || len(report.Flagged) != 4 {
+		t.Fatalf("expected 4 of 8 ballots flagged, got %d of %d", len(report.Flagged), report.Ballots)
+	}
+	if !report.suspicious(DefaultBrigadeThresholds) {
+		t.Error("expected the vote to be suspicious")
+	}
+	expected := []Signal{SignalNewMember, SignalSameDay, SignalAvatar, SignalName, SignalBurst}
+	if got := report.Flagged[0].Signals; len(got) != len(expected) {
+		t.Errorf("expected signals %v, got %v", expected, got)
+	}
+	for _, flagged := range report.Flagged {
+		if !strings.HasPrefix(strings.ToLower(flagged.User.Username), "raider") {
+			t.Errorf("unexpected flagged voter %s", flagged.User.Username)
+		}
+	}
+}
+
+func TestAccountCreated(t *testing.T) {
+	// the example snowflake of the Discord documentation
+	created := accountCreated("175928847299117063")
+	if expected := time.Date(2016, 4, 30, 11, 18, 25, 796000000, time.UTC); !created.Equal(expected) {
+		t.Errorf("expected %s, got %s", expected, created)
+	}
+}
+
+func TestBotBrigading(t *testing.T) {
+	tb := newTestBot(t)
+	defer tb.close()
+	mods := tb.discord.AddChannel(tb.guild, "mods")
+	tb.say(tb.alice, "!democracy brigading channel <#"+mods.ID+">")
+	tb.say(tb.alice, "!democracy brigading quarantine on")
+	tb.say(tb.bob, "!democracy vote Coffee|Buy a coffee machine|3d")
+	msg, _ := tb.embed("[Vote] Coffee")
+	tb.react(msg.ID, "❎", tb.bob)
+
+	// a raid of fresh accounts voting seconds after joining
+	var raiders []*discordgo.User
+	for _, name := range []string{"raider1", "raider2", "raider3", "raider4"} {
+		user := tb.discord.AddUserAt(name, tb.clock.now.Add(-day))
+		tb.discord.AddMemberAt(tb.guild, user, tb.clock.now.Add(-10*time.Second))
+		raiders = append(raiders, user)
+		tb.react(msg.ID, "✅", user)
+	}
+	// the raid is checked together on the next tick and does not close the vote before
+	vote, err := tb.votes.GetVote(tb.guild.ID, 1)
+	if err != nil || vote.Closed || len(tb.discord.Messages(mods.ID)) != 0 {
+		t.Fatalf("expected the raid to wait for its check, got closed %v and %d reports (%v)", vote.Closed, len(tb.discord.Messages(mods.ID)), err)
+	}
+	tb.clock.now = tb.clock.now.Add(time.Minute)
+	tb.votes.tick(tb.discord, tb.clock.now)
+	tb.flush()
+	reports := tb.discord.Messages(mods.ID)
+	if len(reports) != 1 || !strings.HasPrefix(reports[0].Embeds[0].Title, "Brigading suspected on Vote #1") {
+		t.Fatalf("expected a report in the mod channel, got %d messages", len(reports))
+	}
+	vote, err = tb.votes.GetVote(tb.guild.ID, 1)
+	if err != nil || vote.Closed || vote.Pro != 0 || vote.Con != 1 {
+		t.Fatalf("expected the raid to be quarantined, got %d:%d closed %v (%v)", vote.Pro, vote.Con, vote.Closed, err)
+	}
+
+	// a changed ballot stays in quarantine
+	tb.react(msg.ID, "❎", raiders[0])
+	if vote, _ = tb.votes.GetVote(tb.guild.ID, 1); vote.Con != 1 {
+		t.Errorf("expected the changed ballot to stay in quarantine, got %d:%d", vote.Pro, vote.Con)
+	}
+	// so does a ballot missed while offline
+	tb.discord.React(tb.channel.ID, msg.ID, "❎", raiders[1])
+	tb.bot.HandleReady(tb.discord, &discordgo.Ready{})
+	tb.flush()
+	if vote, _ = tb.votes.GetVote(tb.guild.ID, 1); vote.Con != 1 {
+		t.Errorf("expected the missed ballot to stay in quarantine, got %d:%d", vote.Pro, vote.Con)
+	}
+
+	tb.say(tb.alice, "!democracy brigading release 1 <@"+raiders[0].ID+">")
+	tb.say(tb.alice, "!democracy brigading discard 1")
+	if vote, _ = tb.votes.GetVote(tb.guild.ID, 1); vote.Pro != 0 || vote.Con != 2 {
+		t.Errorf("expected the released ballot to be counted, got %d:%d", vote.Pro, vote.Con)
+	}
+	ballots, _ := tb.votes.store.ReadQuarantinedBallots(tb.guild.ID, vote.ID)
+	if len(ballots) != 1 || !ballots[0].Released {
+		t.Errorf("expected only the released ballot to be left, got %v", ballots)
+	}
+	if len(tb.discord.Messages(mods.ID)) != 1 {
+		t.Error("expected the released ballot not to be reported again")
+	}
+}
@@ -0,0 +1,473 @@
+package votes
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// CacheStats counting the lookups answered by a cache
+type CacheStats struct {
+	Hits   uint64
+	Misses uint64
+}
+
+// HitRate of all lookups between 0 and 1
+func (c CacheStats) HitRate() float64 {
+	total := c.Hits + c.Misses
+	if total == 0 {
+		return 0
+	}
+	return float64(c.Hits) / float64(total)
+}
+
+// CachedStore keeping open votes and their tallies, and the brigading settings, quarantine state and webhooks
+// read on every ballot, in memory.
+// Writes go through to the wrapped Store, closed and deleted votes are evicted.
+type CachedStore struct {
+	Store
+
+	mu sync.Mutex
+	// votes by guild and original ID
+	votes map[string]Vote
+	// current maps guild and current ID to the key in votes
+	current map[string]string
+	// brigading settings by guild
+	brigading map[string]BrigadeSettings
+	// quarantined reports by the key in votes whether a vote has ballots awaiting review
+	quarantined map[string]bool
+	// webhooks by guild
+	webhooks map[string][]Webhook
+	// writes counts the changes to quarantined ballots and webhooks, so a read
+	// overlapping with one is not cached
+	writes uint64
+	// versions by the key in votes, changed whenever a ballot is written so that
+	// the tally of a slower write or load cannot replace a newer one
+	versions map[string]uint64
+	seq      uint64
+
+	hits   uint64
+	misses uint64
+}
+
+// NewCachedStore wrapping store
+func NewCachedStore(store Store) *CachedStore {
+	return &CachedStore{
+		Store:       store,
+		votes:       make(map[string]Vote),
+		current:     make(map[string]string),
+		brigading:   make(map[string]BrigadeSettings),
+		quarantined: make(map[string]bool),
+		webhooks:    make(map[string][]Webhook),
+		versions:    make(map[string]uint64),
+	}
+}
+
+// Stats of the vote cache
+func (c *CachedStore) Stats() CacheStats {
+	return CacheStats{
+		Hits:   atomic.LoadUint64(&c.hits),
+		Misses: atomic.LoadUint64(&c.misses),
+	}
+}
+
+func (c *CachedStore) lookup(k string) (Vote, bool) {
+	c.mu.Lock()
+	vote, ok := c.votes[k]
+	c.mu.Unlock()
+	if ok {
+		atomic.AddUint64(&c.hits, 1)
+	} else {
+		atomic.AddUint64(&c.misses, 1)
+	}
+	return vote, ok
+}
+
+// version of vote, read before its tally is
+func (c *CachedStore) version(vote Vote) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.versions[key(vote.Guild, vote.ID)]
+}
+
+// bump the version of vote, returning the new one
+func (c *CachedStore) bump(vote Vote) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.bumpLocked(key(vote.Guild, vote.ID))
+}
+
+func (c *CachedStore) bumpLocked(k string) uint64 {
+	c.seq++
+	c.versions[k] = c.seq
+	return c.seq
+}
+
+// put vote into the cache if it is still open and its version is unchanged,
+// otherwise another ballot was written meanwhile and the vote is evicted
+func (c *CachedStore) put(vote Vote, version uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	k := key(vote.Guild, vote.ID)
+	if vote.Closed || c.versions[k] != version {
+		c.evictLocked(k)
+		return
+	}
+	if old, ok := c.votes[k]; ok {
+		delete(c.current, key(old.Guild, old.CurrentID))
+	}
+	c.votes[k] = vote
+	c.current[key(vote.Guild, vote.CurrentID)] = k
+}
+
+// written ballot of vote, which started at version, caching the tally returned by the wrapped store
+func (c *CachedStore) written(vote Vote, version uint64, err error) {
+	if err == nil {
+		c.put(vote, version)
+	} else if err == ErrVoteClosed {
+		c.forget(vote)
+		return
+	}
+	c.bump(vote)
+}
+
+func (c *CachedStore) evict(vote Vote) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.evictLocked(key(vote.Guild, vote.ID))
+}
+
+func (c *CachedStore) evictLocked(k string) {
+	if old, ok := c.votes[k]; ok {
+		delete(c.current, key(old.Guild, old.CurrentID))
+	}
+	delete(c.votes, k)
+}
+
+// forget closed or deleted vote along with its version
+func (c *CachedStore) forget(vote Vote) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	k := key(vote.Guild, vote.ID)
+	c.evictLocked(k)
+	delete(c.versions, k)
+	delete(c.quarantined, k)
+}
+
+// load vote with its tally from the wrapped store into the cache
+func (c *CachedStore) load(vote Vote, err error) (Vote, error) {
+	if err != nil {
+		return vote, err
+	}
+	version := c.version(vote)
+	vote, err = c.Store.GetVoteCount(vote)
+	if err != nil {
+		return vote, err
+	}
+	c.put(vote, version)
+	return vote, nil
+}
+
+// GetVote by (current) ID
+func (c *CachedStore) GetVote(guild, id string) (Vote, error) {
+	c.mu.Lock()
+	k, ok := c.current[key(guild, id)]
+	c.mu.Unlock()
+	if ok {
+		if vote, ok := c.lookup(k); ok {
+			return vote, nil
+		}
+	} else {
+		atomic.AddUint64(&c.misses, 1)
+	}
+	return c.load(c.Store.GetVote(guild, id))
+}
+
+// GetVoteByID using the original vote ID
+func (c *CachedStore) GetVoteByID(guild, id string) (Vote, error) {
+	if vote, ok := c.lookup(key(guild, id)); ok {
+		return vote, nil
+	}
+	return c.load(c.Store.GetVoteByID(guild, id))
+}
+
+// GetVoteCount for vote
+func (c *CachedStore) GetVoteCount(vote Vote) (Vote, error) {
+	if cached, ok := c.lookup(key(vote.Guild, vote.ID)); ok {
+		vote.Pro = cached.Pro
+		vote.Con = cached.Con
+		return vote, nil
+	}
+	return c.Store.GetVoteCount(vote)
+}
+
+// RecordBallot through to the wrapped store, caching the updated tally unless another ballot was written meanwhile
+func (c *CachedStore) RecordBallot(vote Vote, author string, value bool) (Vote, BallotChange, error) {
+	version := c.bump(vote)
+	vote, change, err := c.Store.RecordBallot(vote, author, value)
+	c.written(vote, version, err)
+	return vote, change, err
+}
+
+// RetractBallot through to the wrapped store, caching the updated tally unless another ballot was written meanwhile
+func (c *CachedStore) RetractBallot(vote Vote, author string) (Vote, bool, error) {
+	version := c.bump(vote)
+	vote, retracted, err := c.Store.RetractBallot(vote, author)
+	c.written(vote, version, err)
+	return vote, retracted, err
+}
+
+// UpdateVote through to the wrapped store
+func (c *CachedStore) UpdateVote(id string, vote Vote) error {
+	c.evict(Vote{Guild: vote.Guild, ID: id})
+	return c.Store.UpdateVote(id, vote)
+}
+
+// CloseVote through to the wrapped store
+func (c *CachedStore) CloseVote(vote Vote) error {
+	c.forget(vote)
+	return c.Store.CloseVote(vote)
+}
+
+// UpdateVoteExpiry through to the wrapped store
+func (c *CachedStore) UpdateVoteExpiry(vote Vote) error {
+	c.evict(vote)
+	return c.Store.UpdateVoteExpiry(vote)
+}
+
+// DeleteVote through to the wrapped store
+func (c *CachedStore) DeleteVote(vote Vote) error {
+	c.forget(vote)
+	return c.Store.DeleteVote(vote)
+}
+
+// DeleteVoteEntries through to the wrapped store
+func (c *CachedStore) DeleteVoteEntries(vote Vote) error {
+	c.evict(vote)
+	return c.Store.DeleteVoteEntries(vote)
+}
+
+// GetBrigadeSettings of guild, read from the wrapped store once
+func (c *CachedStore) GetBrigadeSettings(guild string) (BrigadeSettings, error) {
+	c.mu.Lock()
+	settings, ok := c.brigading[guild]
+	c.mu.Unlock()
+	if ok {
+		return settings, nil
+	}
+	settings, err := c.Store.GetBrigadeSettings(guild)
+	if err != nil {
+		return settings, err
+	}
+	c.mu.Lock()
+	c.brigading[guild] = settings
+	c.mu.Unlock()
+	return settings, nil
+}
+
+// SetBrigadeSettings through to the wrapped store, caching them once stored
+func (c *CachedStore) SetBrigadeSettings(settings BrigadeSettings) error {
+	err := c.Store.SetBrigadeSettings(settings)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err != nil {
+		delete(c.brigading, settings.Guild)
+		return err
+	}
+	c.brigading[settings.Guild] = settings
+	return nil
+}
+
+// changed counts a change to quarantined ballots or webhooks, applying update to the cache
+func (c *CachedStore) changed(update func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.writes++
+	update()
+}
+
+// GetQuarantinedBallot of user on vote, answered without the wrapped store for votes without ballots awaiting review
+func (c *CachedStore) GetQuarantinedBallot(guild, vote, user string) (QuarantinedBallot, error) {
+	k := key(guild, vote)
+	c.mu.Lock()
+	quarantined, ok := c.quarantined[k]
+	writes := c.writes
+	c.mu.Unlock()
+	if ok && !quarantined {
+		return QuarantinedBallot{}, ErrNotFound
+	}
+	if ok {
+		return c.Store.GetQuarantinedBallot(guild, vote, user)
+	}
+	ballots, err := c.Store.ReadQuarantinedBallots(guild, vote)
+	if err != nil {
+		return QuarantinedBallot{}, err
+	}
+	quarantined = false
+	found := QuarantinedBallot{}
+	err = ErrNotFound
+	for _, ballot := range ballots {
+		quarantined = quarantined || !ballot.Released
+		if ballot.User == user {
+			found, err = ballot, nil
+		}
+	}
+	c.mu.Lock()
+	if c.writes == writes {
+		c.quarantined[k] = quarantined
+	}
+	c.mu.Unlock()
+	return found, err
+}
+
+// QuarantineBallot through to the wrapped store
+func (c *CachedStore) QuarantineBallot(ballot QuarantinedBallot) error {
+	err := c.Store.QuarantineBallot(ballot)
+	c.changed(func() {
+		k := key(ballot.Guild, ballot.Vote)
+		if err == nil && !ballot.Released {
+			c.quarantined[k] = true
+		} else {
+			delete(c.quarantined, k)
+		}
+	})
+	return err
+}
+
+// DeleteQuarantinedBallot through to the wrapped store
+func (c *CachedStore) DeleteQuarantinedBallot(ballot QuarantinedBallot) (bool, error) {
+	deleted, err := c.Store.DeleteQuarantinedBallot(ballot)
+	c.changed(func() { delete(c.quarantined, key(ballot.Guild, ballot.Vote)) })
+	return deleted, err
+}
+
+// ReadWebhooks of guild, read from the wrapped store once
+func (c *CachedStore) ReadWebhooks(guild string) ([]Webhook, error) {
+	c.mu.Lock()
+	hooks, ok := c.webhooks[guild]
+	writes := c.writes
+	c.mu.Unlock()
+	if ok {
+		return append([]Webhook{}, hooks...), nil
+	}
+	hooks, err := c.Store.ReadWebhooks(guild)
+	if err != nil {
+		return hooks, err
+	}
+	c.mu.Lock()
+	if c.writes == writes {
+		c.webhooks[guild] = append([]Webhook{}, hooks...)
+	}
+	c.mu.Unlock()
+	return hooks, nil
+}
+
+// InsertWebhook through to the wrapped store
+func (c *CachedStore) InsertWebhook(hook Webhook) (Webhook, error) {
+	guild := hook.Guild
+	hook, err := c.Store.InsertWebhook(hook)
+	c.changed(func() { delete(c.webhooks, guild) })
+	return hook, err
+}
+
+// DeleteWebhook through to the wrapped store
+func (c *CachedStore) DeleteWebhook(guild string, id int64) (bool, error) {
+	deleted, err := c.Store.DeleteWebhook(guild, id)
+	c.changed(func() { delete(c.webhooks, guild) })
+	return deleted, err
+}
+
+const (
+	// authorTTL after which cached authors are requested again, picking up changed names and avatars
+	authorTTL = time.Hour
+	// authorCacheSize limiting the number of cached authors
+	authorCacheSize = 1000
+)
+
+// cachedAuthor expiring after authorTTL
+type cachedAuthor struct {
+	user    *discordgo.User
+	expires time.Time
+}
+
+// authorCache keeping the users shown as vote authors for authorTTL
+type authorCache struct {
+	mu    sync.Mutex
+	users map[string]cachedAuthor
+	now   func() time.Time
+
+	hits   uint64
+	misses uint64
+}
+
+func newAuthorCache(now func() time.Time) *authorCache {
+	return &authorCache{
+		users: make(map[string]cachedAuthor),
+		now:   now,
+	}
+}
+
+// get user id from the cache or Discord
+func (a *authorCache) get(s Session, id string) (*discordgo.User, error) {
+	now := a.now()
+	a.mu.Lock()
+	cached, ok := a.users[id]
+	a.mu.Unlock()
+	if ok && now.Before(cached.expires) {
+		atomic.AddUint64(&a.hits, 1)
+		return cached.user, nil
+	}
+	atomic.AddUint64(&a.misses, 1)
+	user, err := s.User(id)
+	if err != nil {
+		return nil, err
+	}
+	a.mu.Lock()
+	a.put(id, cachedAuthor{user: user, expires: now.Add(authorTTL)}, now)
+	a.mu.Unlock()
+	return user, nil
+}
+
+// put author into the cache, dropping expired authors and the one expiring first once it is full
+func (a *authorCache) put(id string, author cachedAuthor, now time.Time) {
+	if _, ok := a.users[id]; !ok && len(a.users) >= authorCacheSize {
+		oldest := ""
+		for k, cached := range a.users {
+			if now.After(cached.expires) {
+				delete(a.users, k)
+			} else if oldest == "" || cached.expires.Before(a.users[oldest].expires) {
+				oldest = k
+			}
+		}
+		if len(a.users) >= authorCacheSize {
+			delete(a.users, oldest)
+		}
+	}
+	a.users[id] = author
+}
+
+func (a *authorCache) stats() CacheStats {
+	return CacheStats{
+		Hits:   atomic.LoadUint64(&a.hits),
+		Misses: atomic.LoadUint64(&a.misses),
+	}
+}
+
+// embed of vote using the cached author
+func (v *VoteHandler) embed(s Session, vote Vote) *discordgo.MessageEmbed {
+	author, err := v.authors.get(s, vote.Author)
+	if err != nil {
+		return nil
+	}
+	return newVoteEmbed(vote, author)
+}
+
+// CacheStats of the vote cache, if enabled, and the author cache
+func (v *VoteHandler) CacheStats() (votes CacheStats, authors CacheStats) {
+	if cached, ok := v.store.(*CachedStore); ok {
+		votes = cached.Stats()
+	}
+	return votes, v.authors.stats()
+}
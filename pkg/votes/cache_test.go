@@ -0,0 +1,186 @@
+package votes
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestCachedStore(t *testing.T) {
+	store := NewCachedStore(NewMemoryStore())
+	now := time.Date(2018, 3, 1, 12, 0, 0, 0, time.UTC)
+	store.InsertVote(Vote{Guild: "g", ID: "1", Created: now, Expires: now.Add(time.Hour)})
+
+	vote, err := store.GetVote("g", "1")
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	vote, _, err = store.RecordBallot(vote, "a", true)
+	if err != nil {
+		t.Fatalf("ballot: %v", err)
+	}
+	vote, err = store.GetVote("g", "1")
+	if err != nil || vote.Pro != 1 {
+		t.Errorf("expected cached tally 1, got %d (%v)", vote.Pro, err)
+	}
+	if stats := store.Stats(); stats.Hits != 1 || stats.Misses != 1 {
+		t.Errorf("expected 1 hit and 1 miss, got %+v", stats)
+	}
+
+	vote.CurrentID = "2"
+	store.UpdateVote(vote.ID, vote)
+	if stats := store.Stats(); stats.Misses != 1 {
+		t.Errorf("expected no further lookups, got %+v", stats)
+	}
+	vote, err = store.GetVote("g", "2")
+	if err != nil || vote.Pro != 1 {
+		t.Errorf("expected reloaded tally 1, got %d (%v)", vote.Pro, err)
+	}
+
+	store.CloseVote(vote)
+	if _, _, err := store.RecordBallot(vote, "b", true); err != ErrVoteClosed {
+		t.Errorf("expected closed vote, got %v", err)
+	}
+}
+
+// blockingStore holding RecordBallot of author "slow" after the ballot was stored until release is closed
+type blockingStore struct {
+	Store
+	stored  chan struct{}
+	release chan struct{}
+}
+
+func (b blockingStore) RecordBallot(vote Vote, author string, value bool) (Vote, BallotChange, error) {
+	vote, change, err := b.Store.RecordBallot(vote, author, value)
+	if author == "slow" {
+		close(b.stored)
+		<-b.release
+	}
+	return vote, change, err
+}
+
+func TestCachedStoreOverlappingBallots(t *testing.T) {
+	blocking := blockingStore{Store: NewMemoryStore(), stored: make(chan struct{}), release: make(chan struct{})}
+	store := NewCachedStore(blocking)
+	now := time.Date(2018, 3, 1, 12, 0, 0, 0, time.UTC)
+	store.InsertVote(Vote{Guild: "g", ID: "1", Created: now, Expires: now.Add(time.Hour)})
+	vote, _ := store.GetVote("g", "1")
+
+	done := make(chan Vote)
+	go func() {
+		slow, _, _ := store.RecordBallot(vote, "slow", true)
+		done <- slow
+	}()
+	<-blocking.stored
+	if fast, _, _ := store.RecordBallot(vote, "fast", true); fast.Pro != 2 {
+		t.Fatalf("expected tally 2, got %d", fast.Pro)
+	}
+	close(blocking.release)
+	if slow := <-done; slow.Pro != 1 {
+		t.Fatalf("expected the slow ballot to return tally 1, got %d", slow.Pro)
+	}
+
+	vote, err := store.GetVote("g", "1")
+	if err != nil || vote.Pro != 2 {
+		t.Errorf("expected tally 2 after the slower ballot, got %d (%v)", vote.Pro, err)
+	}
+}
+
+// countingStore counting the reads of quarantined ballots and webhooks
+type countingStore struct {
+	Store
+	quarantine int
+	webhooks   int
+}
+
+func (c *countingStore) GetQuarantinedBallot(guild, vote, user string) (QuarantinedBallot, error) {
+	c.quarantine++
+	return c.Store.GetQuarantinedBallot(guild, vote, user)
+}
+
+func (c *countingStore) ReadQuarantinedBallots(guild, vote string) ([]QuarantinedBallot, error) {
+	c.quarantine++
+	return c.Store.ReadQuarantinedBallots(guild, vote)
+}
+
+func (c *countingStore) ReadWebhooks(guild string) ([]Webhook, error) {
+	c.webhooks++
+	return c.Store.ReadWebhooks(guild)
+}
+
+func TestCachedStoreQuarantineAndWebhooks(t *testing.T) {
+	counting := &countingStore{Store: NewMemoryStore()}
+	store := NewCachedStore(counting)
+
+	for i := 0; i < 3; i++ {
+		if _, err := store.GetQuarantinedBallot("g", "1", fmt.Sprint(i)); err != ErrNotFound {
+			t.Fatalf("expected no quarantined ballot, got %v", err)
+		}
+		store.ReadWebhooks("g")
+	}
+	if counting.quarantine != 1 || counting.webhooks != 1 {
+		t.Errorf("expected a single read of the quarantine and the webhooks, got %d and %d", counting.quarantine, counting.webhooks)
+	}
+
+	store.QuarantineBallot(QuarantinedBallot{Guild: "g", Vote: "1", User: "a", Pro: true})
+	if ballot, err := store.GetQuarantinedBallot("g", "1", "a"); err != nil || !ballot.Pro {
+		t.Errorf("expected the quarantined ballot, got %+v (%v)", ballot, err)
+	}
+	store.InsertWebhook(Webhook{Guild: "g", URL: "https://example.com/hook"})
+	if hooks, _ := store.ReadWebhooks("g"); len(hooks) != 1 {
+		t.Errorf("expected the new webhook, got %v", hooks)
+	}
+}
+
+func TestBotQuarantineOff(t *testing.T) {
+	tb := newTestBot(t)
+	defer tb.close()
+	counting := &countingStore{Store: tb.votes.store}
+	tb.votes.SetStore(counting)
+
+	tb.say(tb.alice, "!democracy vote Coffee|Buy a coffee machine|1h")
+	vote, _ := tb.vote("Coffee")
+	tb.react(vote.CurrentID, "✅", tb.bob)
+	if vote, _ := tb.vote("Coffee"); vote.Pro != 1 || counting.quarantine != 0 {
+		t.Errorf("expected the ballot counted without a quarantine lookup, got %d:%d after %d lookups", vote.Pro, vote.Con, counting.quarantine)
+	}
+}
+
+// userSession answering User lookups with a user named after the call count
+type userSession struct {
+	Session
+	calls int
+}
+
+func (s *userSession) User(id string) (*discordgo.User, error) {
+	s.calls++
+	return &discordgo.User{ID: id, Username: fmt.Sprintf("%s-%d", id, s.calls)}, nil
+}
+
+func TestAuthorCache(t *testing.T) {
+	now := time.Date(2018, 3, 1, 12, 0, 0, 0, time.UTC)
+	authors := newAuthorCache(func() time.Time { return now })
+	s := &userSession{}
+
+	authors.get(s, "a")
+	if user, _ := authors.get(s, "a"); user.Username != "a-1" || s.calls != 1 {
+		t.Errorf("expected cached author a-1, got %s after %d calls", user.Username, s.calls)
+	}
+	now = now.Add(authorTTL)
+	if user, _ := authors.get(s, "a"); user.Username != "a-2" {
+		t.Errorf("expected expired author to be requested again, got %s", user.Username)
+	}
+
+	for i := 0; i < authorCacheSize+10; i++ {
+		now = now.Add(time.Second)
+		authors.get(s, fmt.Sprint(i))
+	}
+	if n := len(authors.users); n != authorCacheSize {
+		t.Errorf("expected %d cached authors, got %d", authorCacheSize, n)
+	}
+	if _, ok := authors.users["a"]; ok {
+		t.Error("expected the author expiring first to be dropped")
+	}
+}
@@ -3,8 +3,10 @@ package votes
 import (
 	"database/sql"
 	"fmt"
-
-	"github.com/lib/pq"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
 
 	// Using PostgreSQL
 	_ "github.com/lib/pq"
@@ -13,284 +15,1743 @@ import (
 	"go.uber.org/zap"
 )
 
-// InitDB for persisting votes
-func (v *VoteHandler) InitDB(host, name, user, password string) error {
-	v.log.Info("connecting db", zap.String("host", host), zap.String("db", name))
+// SQLStore persisting votes in a SQL database
+type SQLStore struct {
+	log *zap.Logger
+	db  *sql.DB
+	// driver is postgres or sqlite, queries are written for PostgreSQL and rebound for SQLite
+	driver string
+}
+
+// NewPostgresStore connecting to the PostgreSQL database name on host
+func NewPostgresStore(log *zap.Logger, host, name, user, password string) (*SQLStore, error) {
+	log.Info("connecting db", zap.String("host", host), zap.String("db", name))
 	db, err := sql.Open("postgres", fmt.Sprintf("postgres://%s:%s@%s/%s?sslmode=disable", user, password, host, name))
 	if err != nil {
-		v.log.Error("unable to open db", zap.String("host", host), zap.String("db", name), zap.String("user", user), zap.Error(err))
+		log.Error("unable to open db", zap.String("host", host), zap.String("db", name), zap.String("user", user), zap.Error(err))
+		return nil, err
+	}
+	log.Info("database connected", zap.String("host", host), zap.String("db", name))
+	return &SQLStore{
+		log:    log,
+		db:     db,
+		driver: "postgres",
+	}, nil
+}
+
+// InitDB for persisting votes in PostgreSQL, migrating its schema to the latest version
+func (v *VoteHandler) InitDB(host, name, user, password string) error {
+	store, err := NewPostgresStore(v.log, host, name, user, password)
+	if err != nil {
+		return err
+	}
+	err = store.Migrate()
+	if err != nil {
 		return err
 	}
-	v.db = db
-	v.log.Info("database connected", zap.String("host", host), zap.String("db", name))
+	v.store = store
 	return nil
 }
 
-// ReadVotes for guild
-func (v *VoteHandler) ReadVotes(guild string) ([]Vote, error) {
-	v.log.Info("fetching votes", zap.String("guild", guild))
-	vote := Vote{
-		Guild: guild,
+var placeholder = regexp.MustCompile(`\$(\d+)`)
+
+// rebind the PostgreSQL placeholders of query to the ones of the stores driver
+func (s *SQLStore) rebind(query string) string {
+	if s.driver == "sqlite" {
+		return placeholder.ReplaceAllString(query, "?$1")
+	}
+	return query
+}
+
+// bind args for the stores driver. SQLite compares times as text, so they are all stored in UTC.
+func (s *SQLStore) bind(args []interface{}) []interface{} {
+	if s.driver != "sqlite" {
+		return args
+	}
+	bound := make([]interface{}, len(args))
+	for i, arg := range args {
+		if t, ok := arg.(time.Time); ok {
+			arg = t.UTC()
+		}
+		bound[i] = arg
+	}
+	return bound
+}
+
+// forUpdate locking the selected rows until the transaction ends. SQLite only has a single writer anyway.
+func (s *SQLStore) forUpdate() string {
+	if s.driver == "sqlite" {
+		return ""
+	}
+	return " FOR UPDATE"
+}
+
+// prepare query timing its executions, the caller has to close the statement
+func (s *SQLStore) prepare(query string) (*timedStmt, error) {
+	stmt, err := s.db.Prepare(s.rebind(query))
+	if err != nil {
+		return nil, err
+	}
+	return &timedStmt{Stmt: stmt, s: s, statement: statement(query)}, nil
+}
+
+func (s *SQLStore) query(query string, args ...interface{}) (*sql.Rows, error) {
+	defer observeQuery(query, time.Now())
+	return s.db.Query(s.rebind(query), s.bind(args)...)
+}
+
+func (s *SQLStore) queryRow(query string, args ...interface{}) *sql.Row {
+	defer observeQuery(query, time.Now())
+	return s.db.QueryRow(s.rebind(query), s.bind(args)...)
+}
+
+func (s *SQLStore) exec(query string, args ...interface{}) (sql.Result, error) {
+	defer observeQuery(query, time.Now())
+	return s.db.Exec(s.rebind(query), s.bind(args)...)
+}
+
+// begin a transaction timing its statements
+func (s *SQLStore) begin() (*timedTx, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return &timedTx{Tx: tx, s: s}, nil
+}
+
+// Ping the database, checking that it is reachable
+func (s *SQLStore) Ping() error {
+	return s.db.Ping()
+}
+
+// observeQuery started at start
+func observeQuery(query string, start time.Time) {
+	dbQueryDuration.Observe(time.Since(start).Seconds(), statement(query))
+}
+
+// timedStmt observing the latency of its executions
+type timedStmt struct {
+	*sql.Stmt
+	s         *SQLStore
+	statement string
+}
+
+func (t *timedStmt) Exec(args ...interface{}) (sql.Result, error) {
+	defer func(start time.Time) {
+		dbQueryDuration.Observe(time.Since(start).Seconds(), t.statement)
+	}(time.Now())
+	return t.Stmt.Exec(t.s.bind(args)...)
+}
+
+// timedTx observing the latency of its statements
+type timedTx struct {
+	*sql.Tx
+	s *SQLStore
+}
+
+func (t *timedTx) Exec(query string, args ...interface{}) (sql.Result, error) {
+	defer observeQuery(query, time.Now())
+	return t.Tx.Exec(t.s.rebind(query), t.s.bind(args)...)
+}
+
+func (t *timedTx) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	defer observeQuery(query, time.Now())
+	return t.Tx.Query(t.s.rebind(query), t.s.bind(args)...)
+}
+
+func (t *timedTx) QueryRow(query string, args ...interface{}) *sql.Row {
+	defer observeQuery(query, time.Now())
+	return t.Tx.QueryRow(t.s.rebind(query), t.s.bind(args)...)
+}
+
+// ReadVotes of guild matching filter, ordered by number
+func (s *SQLStore) ReadVotes(guild string, filter VoteFilter) ([]Vote, error) {
+	s.log.Info("fetching votes", zap.String("guild", guild), zap.Stringer("filter", filter))
+	where, args := voteFilterWhere(guild, filter)
+	where += " order by number"
+	if filter.Newest {
+		where += " desc"
+	}
+	if filter.Limit > 0 {
+		where += fmt.Sprintf(" limit %d", filter.Limit)
+	} else if filter.Offset > 0 && s.driver == "sqlite" {
+		// SQLite only takes an offset after a limit, a negative one has no bound
+		where += " limit -1"
+	}
+	if filter.Offset > 0 {
+		where += fmt.Sprintf(" offset %d", filter.Offset)
+	}
+	return s.queryVotes(where, args...)
+}
+
+// CountVotes of guild matching filter
+func (s *SQLStore) CountVotes(guild string, filter VoteFilter) (int, error) {
+	s.log.Debug("counting votes", zap.String("guild", guild), zap.Stringer("filter", filter))
+	where, args := voteFilterWhere(guild, filter)
+	var count int
+	err := s.queryRow("select count(*) from votes "+where, args...).Scan(&count)
+	if err != nil {
+		s.log.Error("error counting votes", zap.String("guild", guild), zap.Error(err))
+		return 0, err
+	}
+	return count, nil
+}
+
+// voteFilterWhere clause selecting the votes of guild matching filter
+func voteFilterWhere(guild string, filter VoteFilter) (string, []interface{}) {
+	where := []string{"guild_id = $1"}
+	args := []interface{}{guild}
+	arg := func(cond string, value interface{}) {
+		args = append(args, value)
+		where = append(where, fmt.Sprintf(cond, len(args)))
 	}
+	switch filter.Status {
+	case StatusOpen:
+		where = append(where, "not coalesce(closed, false)")
+	case StatusClosed:
+		where = append(where, "coalesce(closed, false)")
+	case StatusAccepted:
+		where = append(where, "coalesce(closed, false)", "coalesce(pro, 0) > coalesce(con, 0)")
+	case StatusRejected:
+		where = append(where, "coalesce(closed, false)", "coalesce(pro, 0) <= coalesce(con, 0)")
+	}
+	if filter.Author != "" {
+		arg("author = $%d", filter.Author)
+	}
+	if !filter.After.IsZero() {
+		arg("created >= $%d", filter.After)
+	}
+	if !filter.Before.IsZero() {
+		arg("created < $%d", filter.Before)
+	}
+	if filter.Tag != "" {
+		arg("(',' || coalesce(tags, '') || ',') like $%d", "%,"+filter.Tag+",%")
+	}
+	return "where " + strings.Join(where, " and "), args
+}
+
+// GetVote by the ID of any message representing it
+func (s *SQLStore) GetVote(guild, message string) (Vote, error) {
+	s.log.Info("fetching vote", zap.String("guild", guild), zap.String("message", message))
+	return s.getVote("where guild_id = $1 and vote_id = (select vote_id from vote_messages where guild_id = $1 and message_id = $2)", guild, message)
+}
+
+// GetVoteByNumber within guild
+func (s *SQLStore) GetVoteByNumber(guild string, number int) (Vote, error) {
+	s.log.Info("fetching vote", zap.String("guild", guild), zap.Int("number", number))
+	return s.getVote("where guild_id = $1 and number = $2", guild, number)
+}
+
+// GetVoteByID using the original vote ID
+func (s *SQLStore) GetVoteByID(guild, id string) (Vote, error) {
+	s.log.Info("fetching vote", zap.String("guild", guild), zap.String("vote", id))
+	return s.getVote("where guild_id = $1 and vote_id = $2", guild, id)
+}
+
+// ReadDueVotes of all guilds which are still open but expired before now
+func (s *SQLStore) ReadDueVotes(now time.Time) ([]Vote, error) {
+	s.log.Debug("fetching due votes", zap.Time("now", now))
+	return s.queryVotes("where not coalesce(closed, false) and expiration <= $1", now)
+}
+
+// ReadOpenVotes of all guilds which did not expire before now
+func (s *SQLStore) ReadOpenVotes(now time.Time) ([]Vote, error) {
+	s.log.Debug("fetching open votes", zap.Time("now", now))
+	return s.queryVotes("where not coalesce(closed, false) and expiration > $1", now)
+}
 
+// ReadExtensions still open for vote
+func (s *SQLStore) ReadExtensions(vote Vote) ([]Vote, error) {
+	s.log.Info("fetching extensions", zap.String("guild", vote.Guild), zap.String("vote", vote.ID))
+	return s.queryVotes("where guild_id = $1 and extends_id = $2 and not coalesce(closed, false)", vote.Guild, vote.ID)
+}
+
+func (s *SQLStore) getVote(where string, args ...interface{}) (Vote, error) {
+	votes, err := s.queryVotes(where, args...)
+	if err != nil {
+		return Vote{}, err
+	}
+	if len(votes) == 0 {
+		return Vote{}, ErrNotFound
+	}
+	if len(votes) != 1 {
+		s.log.Info("received invalid vote count", zap.String("where", where), zap.Int("count", len(votes)))
+		return Vote{}, errors.New("invalid vote count")
+	}
+	return votes[0], nil
+}
+
+func (s *SQLStore) queryVotes(where string, args ...interface{}) ([]Vote, error) {
 	votes := []Vote{}
 
-	rows, err := v.db.Query("select vote_id, title, description, author, created, expiration from votes where guild_id = $1", guild)
+	rows, err := s.query(`select guild_id, vote_id, coalesce(current_id, vote_id), number, title, description, author, created, expiration,
+		coalesce(discussion_id, ''), coalesce(revision, 0), coalesce(channel_id, ''), coalesce(closed, false), coalesce(extends_id, ''), coalesce(extension, 0), coalesce(tags, ''),
+		coalesce(nominee_id, ''), coalesce(role, ''), coalesce(term, 0), coalesce(distrust_id, '')
+		from votes `+where, args...)
 	if err != nil {
-		v.log.Error("error querying rows", zap.String("guild", guild), zap.Error(err))
+		s.log.Error("error querying rows", zap.String("where", where), zap.Error(err))
 		return votes, err
 	}
 	defer rows.Close()
-	count := 0
 	for rows.Next() {
-		err := rows.Scan(&vote.ID, &vote.Title, &vote.Description, &vote.Author, &vote.Created, &vote.Expires)
+		var vote Vote
+		var extension, term int64
+		var tags string
+		err := rows.Scan(
+			&vote.Guild, &vote.ID, &vote.CurrentID, &vote.Number, &vote.Title, &vote.Description, &vote.Author, &vote.Created, &vote.Expires,
+			&vote.Discussion, &vote.Revision, &vote.Channel, &vote.Closed, &vote.Extends, &extension, &tags,
+			&vote.Nominee, &vote.Role, &term, &vote.Distrust,
+		)
 		if err != nil {
-			v.log.Error("could not scan row", zap.String("guild", guild), zap.Error(err))
+			s.log.Error("could not scan row", zap.String("where", where), zap.Error(err))
 			continue
 		}
+		vote.Extension = time.Duration(extension) * time.Second
+		vote.Term = time.Duration(term) * time.Second
+		vote.Tags = ParseTags(tags)
 		votes = append(votes, vote)
-		count = count + 1
 	}
-	v.log.Info("finished reading votes", zap.Int("count", count))
+	s.log.Info("finished reading votes", zap.Int("count", len(votes)))
 	err = rows.Err()
 	if err != nil {
-		v.log.Error("error reading rows", zap.String("guild", guild), zap.Error(err))
+		s.log.Error("error reading rows", zap.String("where", where), zap.Error(err))
 		return votes, err
 	}
 
 	return votes, nil
 }
 
-// GetVote by (current) ID
-func (v *VoteHandler) GetVote(guild, id string) (Vote, error) {
-	v.log.Info("fetching vote", zap.String("guild", guild), zap.String("vote", id))
-	vote := Vote{
-		Guild: guild,
+// InsertVote to guild, numbering it after the last vote of the guild
+func (s *SQLStore) InsertVote(vote Vote) (Vote, error) {
+	s.log.Info("inserting vote", zap.String("guild", vote.Guild), zap.String("vote", vote.ID), zap.String("author", vote.Author))
+	tx, err := s.begin()
+	if err != nil {
+		s.log.Error("error starting transaction", zap.String("guild", vote.Guild), zap.String("vote", vote.ID), zap.Error(err))
+		return vote, err
 	}
+	defer tx.Rollback()
 
-	votes := []Vote{}
-
-	rows, err := v.db.Query("select vote_id, title, description, author, created, expiration from votes where guild_id = $1 and current_id = $2", guild, id)
+	// the counter row serializes concurrent inserts and never hands out a number twice, even after deletes
+	err = tx.QueryRow(
+		`INSERT INTO vote_numbers(guild_id, last) VALUES($1, 1)
+		ON CONFLICT (guild_id) DO UPDATE SET last = vote_numbers.last + 1 RETURNING last`,
+		vote.Guild,
+	).Scan(&vote.Number)
 	if err != nil {
-		v.log.Error("error querying rows", zap.String("guild", guild), zap.String("vote", id), zap.Error(err))
+		s.log.Error("error numbering vote", zap.String("guild", vote.Guild), zap.String("vote", vote.ID), zap.Error(err))
 		return vote, err
 	}
-	defer rows.Close()
-	count := 0
-	for rows.Next() {
-		err := rows.Scan(&vote.ID, &vote.Title, &vote.Description, &vote.Author, &vote.Created, &vote.Expires)
-		if err != nil {
-			v.log.Error("could not scan row", zap.String("guild", guild), zap.String("vote", id), zap.Error(err))
-			continue
-		}
-		votes = append(votes, vote)
-		count = count + 1
+	_, err = tx.Exec(
+		`INSERT INTO votes(guild_id, vote_id, current_id, number, title, description, author, created, expiration, discussion_id, revision, channel_id, closed, extends_id, extension, tags, nominee_id, role, term, distrust_id, pro, con)
+		VALUES($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15,$16,$17,$18,$19,$20,0,0)`,
+		vote.Guild, vote.ID, vote.ID, vote.Number, vote.Title, vote.Description, vote.Author, vote.Created, vote.Expires,
+		vote.Discussion, vote.Revision, vote.Channel, vote.Closed, vote.Extends, int64(vote.Extension/time.Second), strings.Join(vote.Tags, ","),
+		vote.Nominee, vote.Role, int64(vote.Term/time.Second), vote.Distrust,
+	)
+	if err != nil {
+		s.log.Error("error executing insert", zap.String("guild", vote.Guild), zap.String("vote", vote.ID), zap.Error(err))
+		return vote, err
 	}
-	v.log.Info("finished reading votes", zap.Int("count", count))
-	if len(votes) != 1 {
-		v.log.Info("received invalid vote count", zap.String("guild", guild), zap.String("vote", id), zap.Int("count", len(votes)))
-		return vote, errors.New("invalid vote count")
+	err = s.addVoteMessage(tx, vote, vote.ID)
+	if err != nil {
+		return vote, err
 	}
-	err = rows.Err()
+	err = tx.Commit()
 	if err != nil {
-		v.log.Error("error reading rows", zap.String("guild", guild), zap.String("vote", id), zap.Error(err))
+		s.log.Error("error committing insert", zap.String("guild", vote.Guild), zap.String("vote", vote.ID), zap.Error(err))
 		return vote, err
 	}
+	vote.CurrentID = vote.ID
+	vote.Pro, vote.Con = 0, 0
+	s.log.Info("finished insert", zap.String("guild", vote.Guild), zap.String("vote", vote.ID), zap.Int("number", vote.Number), zap.String("author", vote.Author))
 
-	return votes[0], nil
+	return vote, nil
 }
 
-// InsertVote to guild
-func (v *VoteHandler) InsertVote(vote Vote) error {
-	v.log.Info("inserting vote", zap.String("guild", vote.Guild), zap.String("vote", vote.ID), zap.String("author", vote.Author))
-	query := "INSERT INTO votes(guild_id, vote_id, current_id, title, description, author, created, expiration) VALUES($1,$2,$3,$4,$5,$6,$7,$8)"
-	stmt, err := v.db.Prepare(query)
+// UpdateVote to guild
+func (s *SQLStore) UpdateVote(id string, vote Vote) error {
+	s.log.Info("updating vote", zap.String("guild", vote.Guild), zap.String("vote", vote.ID), zap.String("author", vote.Author))
+	tx, err := s.begin()
 	if err != nil {
-		v.log.Error("error preparing insert", zap.String("guild", vote.Guild), zap.String("vote", vote.ID), zap.Error(err), zap.String("query", query))
+		s.log.Error("error starting transaction", zap.String("guild", vote.Guild), zap.String("vote", id), zap.Error(err))
 		return err
 	}
-	res, err := stmt.Exec(vote.Guild, vote.ID, vote.ID, vote.Title, vote.Description, vote.Author, vote.Created, vote.Expires)
+	defer tx.Rollback()
+
+	res, err := tx.Exec("UPDATE votes SET current_id = $2, channel_id = $3 WHERE vote_id = $1 AND guild_id = $4", id, vote.CurrentID, vote.Channel, vote.Guild)
 	if err != nil {
-		v.log.Error("error executing insert", zap.String("guild", vote.Guild), zap.String("vote", vote.ID), zap.Error(err))
+		s.log.Error("error executing update", zap.String("guild", vote.Guild), zap.String("currentID", vote.CurrentID), zap.String("vote", vote.ID), zap.Error(err))
 		return err
 	}
 	rowCnt, err := res.RowsAffected()
 	if err != nil {
-		v.log.Error("error getting affected rows", zap.String("guild", vote.Guild), zap.String("vote", vote.ID), zap.Error(err))
+		s.log.Error("error getting affected rows", zap.String("guild", vote.Guild), zap.String("currentID", vote.CurrentID), zap.String("vote", vote.ID), zap.Error(err))
+		return err
+	}
+	vote.ID = id
+	err = s.addVoteMessage(tx, vote, vote.CurrentID)
+	if err != nil {
+		return err
+	}
+	err = tx.Commit()
+	if err != nil {
+		s.log.Error("error committing update", zap.String("guild", vote.Guild), zap.String("vote", id), zap.Error(err))
 		return err
 	}
-	v.log.Info("finished insert", zap.String("guild", vote.Guild), zap.String("vote", vote.ID), zap.String("author", vote.Author), zap.Int64("affected", rowCnt))
+	s.log.Info("finished update", zap.String("guild", vote.Guild), zap.String("vote", vote.ID), zap.String("author", vote.Author), zap.Int64("affected", rowCnt))
 
 	return nil
 }
 
-// UpdateVote to guild
-func (v *VoteHandler) UpdateVote(id string, vote Vote) error {
-	v.log.Info("updating vote", zap.String("guild", vote.Guild), zap.String("vote", vote.ID), zap.String("author", vote.Author))
-	query := "UPDATE votes SET current_id = $2 WHERE vote_id = $1"
-	stmt, err := v.db.Prepare(query)
+// AddVoteMessage recording another message representing vote
+func (s *SQLStore) AddVoteMessage(vote Vote, message string) error {
+	tx, err := s.begin()
 	if err != nil {
-		v.log.Error("error preparing update", zap.String("guild", vote.Guild), zap.String("currentID", vote.CurrentID), zap.String("vote", vote.ID), zap.Error(err), zap.String("query", query))
+		s.log.Error("error starting transaction", zap.String("guild", vote.Guild), zap.String("vote", vote.ID), zap.Error(err))
 		return err
 	}
-	res, err := stmt.Exec(id, vote.CurrentID)
+	defer tx.Rollback()
+	err = s.addVoteMessage(tx, vote, message)
 	if err != nil {
-		v.log.Error("error executing update", zap.String("guild", vote.Guild), zap.String("currentID", vote.CurrentID), zap.String("vote", vote.ID), zap.Error(err))
 		return err
 	}
-	rowCnt, err := res.RowsAffected()
+	return tx.Commit()
+}
+
+func (s *SQLStore) addVoteMessage(tx *timedTx, vote Vote, message string) error {
+	s.log.Info("adding vote message", zap.String("guild", vote.Guild), zap.String("vote", vote.ID), zap.String("message", message))
+	_, err := tx.Exec(
+		"INSERT INTO vote_messages(guild_id, message_id, vote_id) VALUES($1,$2,$3) ON CONFLICT (guild_id, message_id) DO NOTHING",
+		vote.Guild, message, vote.ID,
+	)
+	if err != nil {
+		s.log.Error("error adding vote message", zap.String("guild", vote.Guild), zap.String("vote", vote.ID), zap.String("message", message), zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// CloseVote storing its closing time. The tally is kept up to date by RecordBallot.
+func (s *SQLStore) CloseVote(vote Vote) error {
+	s.log.Info("closing vote", zap.String("guild", vote.Guild), zap.String("vote", vote.ID), zap.Int("pro", vote.Pro), zap.Int("con", vote.Con))
+	query := "UPDATE votes SET closed = true, expiration = $3 WHERE guild_id = $1 AND vote_id = $2"
+	stmt, err := s.prepare(query)
+	if err != nil {
+		s.log.Error("error preparing update", zap.String("guild", vote.Guild), zap.String("vote", vote.ID), zap.Error(err), zap.String("query", query))
+		return err
+	}
+	defer stmt.Close()
+	_, err = stmt.Exec(vote.Guild, vote.ID, vote.Expires)
 	if err != nil {
-		v.log.Error("error getting affected rows", zap.String("guild", vote.Guild), zap.String("currentID", vote.CurrentID), zap.String("vote", vote.ID), zap.Error(err))
+		s.log.Error("error executing update", zap.String("guild", vote.Guild), zap.String("vote", vote.ID), zap.Error(err))
 		return err
 	}
-	v.log.Info("finished update", zap.String("guild", vote.Guild), zap.String("vote", vote.ID), zap.String("author", vote.Author), zap.Int64("affected", rowCnt))
+	return nil
+}
 
+// UpdateVoteExpiry after an extension
+func (s *SQLStore) UpdateVoteExpiry(vote Vote) error {
+	s.log.Info("updating vote expiry", zap.String("guild", vote.Guild), zap.String("vote", vote.ID), zap.Time("expires", vote.Expires))
+	query := "UPDATE votes SET expiration = $3 WHERE guild_id = $1 AND vote_id = $2"
+	stmt, err := s.prepare(query)
+	if err != nil {
+		s.log.Error("error preparing update", zap.String("guild", vote.Guild), zap.String("vote", vote.ID), zap.Error(err), zap.String("query", query))
+		return err
+	}
+	defer stmt.Close()
+	_, err = stmt.Exec(vote.Guild, vote.ID, vote.Expires)
+	if err != nil {
+		s.log.Error("error executing update", zap.String("guild", vote.Guild), zap.String("vote", vote.ID), zap.Error(err))
+		return err
+	}
 	return nil
 }
 
-// DeleteVote from guild
-func (v *VoteHandler) DeleteVote(vote Vote) error {
-	v.log.Info("deleting vote", zap.String("guild", vote.Guild), zap.String("vote", vote.ID), zap.String("author", vote.Author))
-	query := "DELETE FROM votes WHERE vote_id = $1 and guild_id = $2"
-	stmt, err := v.db.Prepare(query)
+// DeleteVote from guild together with its messages and entries
+func (s *SQLStore) DeleteVote(vote Vote) error {
+	s.log.Info("deleting vote", zap.String("guild", vote.Guild), zap.String("vote", vote.ID), zap.String("author", vote.Author))
+	tx, err := s.begin()
 	if err != nil {
-		v.log.Error("error preparing delete", zap.String("guild", vote.Guild), zap.String("vote", vote.ID), zap.String("author", vote.Author), zap.Error(err), zap.String("query", query))
+		s.log.Error("error starting transaction", zap.String("guild", vote.Guild), zap.String("vote", vote.ID), zap.Error(err))
 		return err
 	}
-	res, err := stmt.Exec(vote.ID, vote.Guild)
+	defer tx.Rollback()
+
+	res, err := tx.Exec("DELETE FROM votes WHERE vote_id = $1 and guild_id = $2", vote.ID, vote.Guild)
 	if err != nil {
-		v.log.Error("error executing delete", zap.String("guild", vote.Guild), zap.String("vote", vote.ID), zap.String("author", vote.Author), zap.Error(err))
+		s.log.Error("error executing delete", zap.String("guild", vote.Guild), zap.String("vote", vote.ID), zap.String("author", vote.Author), zap.Error(err))
 		return err
 	}
 	rowCnt, err := res.RowsAffected()
 	if err != nil {
-		v.log.Error("error getting affected rows", zap.String("guild", vote.Guild), zap.String("vote", vote.ID), zap.String("author", vote.Author), zap.Error(err))
+		s.log.Error("error getting affected rows", zap.String("guild", vote.Guild), zap.String("vote", vote.ID), zap.String("author", vote.Author), zap.Error(err))
 		return err
 	}
-	v.log.Info("finished delete vote", zap.String("guild", vote.Guild), zap.String("vote", vote.ID), zap.String("author", vote.Author), zap.Int64("affected", rowCnt))
-
+	// the vote number stays taken, so #N never refers to another vote
+	_, err = tx.Exec("DELETE FROM vote_messages WHERE vote_id = $1 and guild_id = $2", vote.ID, vote.Guild)
+	if err != nil {
+		s.log.Error("error deleting vote messages", zap.String("guild", vote.Guild), zap.String("vote", vote.ID), zap.Error(err))
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM vote_entries WHERE vote_id = $1 and guild_id = $2", vote.ID, vote.Guild)
+	if err != nil {
+		s.log.Error("error deleting vote entries", zap.String("guild", vote.Guild), zap.String("vote", vote.ID), zap.Error(err))
+		return err
+	}
+	err = tx.Commit()
+	if err != nil {
+		s.log.Error("error committing delete", zap.String("guild", vote.Guild), zap.String("vote", vote.ID), zap.Error(err))
+		return err
+	}
+	s.log.Info("finished delete vote", zap.String("guild", vote.Guild), zap.String("vote", vote.ID), zap.String("author", vote.Author), zap.Int64("affected", rowCnt))
 	return nil
 }
 
 // DeleteVoteEntries from guild
-func (v *VoteHandler) DeleteVoteEntries(vote Vote) error {
-	v.log.Info("deleting vote entries", zap.String("guild", vote.Guild), zap.String("vote", vote.ID), zap.String("author", vote.Author))
+func (s *SQLStore) DeleteVoteEntries(vote Vote) error {
+	s.log.Info("deleting vote entries", zap.String("guild", vote.Guild), zap.String("vote", vote.ID), zap.String("author", vote.Author))
 	query := "DELETE FROM vote_entries WHERE vote_id = $1 and guild_id = $2"
-	stmt, err := v.db.Prepare(query)
+	stmt, err := s.prepare(query)
 	if err != nil {
-		v.log.Error("error preparing delete", zap.String("guild", vote.Guild), zap.String("vote", vote.ID), zap.String("author", vote.Author), zap.Error(err), zap.String("query", query))
+		s.log.Error("error preparing delete", zap.String("guild", vote.Guild), zap.String("vote", vote.ID), zap.String("author", vote.Author), zap.Error(err), zap.String("query", query))
 		return err
 	}
+	defer stmt.Close()
 	res, err := stmt.Exec(vote.ID, vote.Guild)
 	if err != nil {
-		v.log.Error("error executing delete", zap.String("guild", vote.Guild), zap.String("vote", vote.ID), zap.String("author", vote.Author), zap.Error(err))
+		s.log.Error("error executing delete", zap.String("guild", vote.Guild), zap.String("vote", vote.ID), zap.String("author", vote.Author), zap.Error(err))
 		return err
 	}
 	rowCnt, err := res.RowsAffected()
 	if err != nil {
-		v.log.Error("error getting affected rows", zap.String("guild", vote.Guild), zap.String("vote", vote.ID), zap.String("author", vote.Author), zap.Error(err))
+		s.log.Error("error getting affected rows", zap.String("guild", vote.Guild), zap.String("vote", vote.ID), zap.String("author", vote.Author), zap.Error(err))
 		return err
 	}
-	v.log.Info("finished delete entries", zap.String("guild", vote.Guild), zap.String("vote", vote.ID), zap.String("author", vote.Author), zap.Int64("affected", rowCnt))
+	s.log.Info("finished delete entries", zap.String("guild", vote.Guild), zap.String("vote", vote.ID), zap.String("author", vote.Author), zap.Int64("affected", rowCnt))
 
 	return nil
 }
 
-// GetVoteCount for vote
-func (v *VoteHandler) GetVoteCount(vote Vote) (Vote, error) {
-	v.log.Info("fetching vote entries", zap.String("guild", vote.Guild), zap.String("vote", vote.ID))
-	rows, err := v.db.Query("select author, vote from vote_entries where guild_id = $1 and vote_id = $2", vote.Guild, vote.ID)
+// GetVoteCount for vote from its stored counters
+func (s *SQLStore) GetVoteCount(vote Vote) (Vote, error) {
+	s.log.Info("fetching vote count", zap.String("guild", vote.Guild), zap.String("vote", vote.ID))
+	err := s.queryRow(
+		"select coalesce(pro, 0), coalesce(con, 0) from votes where guild_id = $1 and vote_id = $2",
+		vote.Guild, vote.ID,
+	).Scan(&vote.Pro, &vote.Con)
 	if err != nil {
-		v.log.Error("error querying rows", zap.String("guild", vote.Guild), zap.String("vote", vote.ID), zap.Error(err))
+		s.log.Error("error querying vote count", zap.String("guild", vote.Guild), zap.String("vote", vote.ID), zap.Error(err))
 		return vote, err
 	}
+	return vote, nil
+}
+
+// RecordBallot of author on vote, replacing a previous ballot, and return the vote with its updated tally.
+// The ballot and the counters on the vote are written in one transaction holding a lock on the vote.
+// Recording the same ballot again does not change anything and returns changed false.
+func (s *SQLStore) RecordBallot(vote Vote, author string, value bool) (Vote, BallotChange, error) {
+	s.log.Info("recording ballot", zap.String("guild", vote.Guild), zap.String("vote", vote.ID), zap.String("author", author), zap.Bool("value", value))
+	tx, err := s.begin()
+	if err != nil {
+		s.log.Error("error starting transaction", zap.String("guild", vote.Guild), zap.String("vote", vote.ID), zap.Error(err))
+		return vote, BallotUnchanged, err
+	}
+	defer tx.Rollback()
+
+	var closed bool
+	err = tx.QueryRow(
+		"select coalesce(closed, false), coalesce(pro, 0), coalesce(con, 0) from votes where guild_id = $1 and vote_id = $2"+s.forUpdate(),
+		vote.Guild, vote.ID,
+	).Scan(&closed, &vote.Pro, &vote.Con)
+	if err != nil {
+		s.log.Error("error locking vote", zap.String("guild", vote.Guild), zap.String("vote", vote.ID), zap.Error(err))
+		return vote, BallotUnchanged, err
+	}
+	if closed {
+		return vote, BallotUnchanged, ErrVoteClosed
+	}
+
+	var previous bool
+	change := BallotReplaced
+	err = tx.QueryRow(
+		"select vote from vote_entries where guild_id = $1 and vote_id = $2 and author = $3",
+		vote.Guild, vote.ID, author,
+	).Scan(&previous)
+	switch {
+	case err == sql.ErrNoRows:
+		change = BallotAdded
+	case err != nil:
+		s.log.Error("error querying ballot", zap.String("guild", vote.Guild), zap.String("vote", vote.ID), zap.String("author", author), zap.Error(err))
+		return vote, BallotUnchanged, err
+	case previous == value:
+		s.log.Info("ballot unchanged", zap.String("guild", vote.Guild), zap.String("vote", vote.ID), zap.String("author", author))
+		return vote, BallotUnchanged, nil
+	case previous:
+		vote.Pro = vote.Pro - 1
+	default:
+		vote.Con = vote.Con - 1
+	}
+	if value {
+		vote.Pro = vote.Pro + 1
+	} else {
+		vote.Con = vote.Con + 1
+	}
+
+	_, err = tx.Exec(
+		`INSERT INTO vote_entries(vote_id, guild_id, author, vote) VALUES($1,$2,$3,$4)
+		ON CONFLICT (vote_id, guild_id, author) DO UPDATE SET vote = $4`,
+		vote.ID, vote.Guild, author, value,
+	)
+	if err != nil {
+		s.log.Error("error executing upsert", zap.String("guild", vote.Guild), zap.String("vote", vote.ID), zap.String("author", author), zap.Error(err))
+		return vote, BallotUnchanged, err
+	}
+	_, err = tx.Exec("UPDATE votes SET pro = $3, con = $4 WHERE guild_id = $1 AND vote_id = $2", vote.Guild, vote.ID, vote.Pro, vote.Con)
+	if err != nil {
+		s.log.Error("error updating vote count", zap.String("guild", vote.Guild), zap.String("vote", vote.ID), zap.Error(err))
+		return vote, BallotUnchanged, err
+	}
+	err = tx.Commit()
+	if err != nil {
+		s.log.Error("error committing ballot", zap.String("guild", vote.Guild), zap.String("vote", vote.ID), zap.String("author", author), zap.Error(err))
+		return vote, BallotUnchanged, err
+	}
+	s.log.Info("finished recording ballot", zap.String("guild", vote.Guild), zap.String("vote", vote.ID), zap.Int("pro", vote.Pro), zap.Int("con", vote.Con))
+	return vote, change, nil
+}
+
+// GetRules for guild. Returns the default rules if none are stored.
+func (s *SQLStore) GetRules(guild string) (Rules, error) {
+	s.log.Info("fetching rules", zap.String("guild", guild))
+	rules := Rules{
+		Guild: guild,
+	}
+	var mandatoryBreak, distrustCooldown, reproposeCooldown int
+	var minDuration, maxDuration int64
+	err := s.queryRow(
+		`select max_consecutive_terms, max_total_terms, mandatory_break, distrust_cooldown, repropose_cooldown, min_duration, max_duration,
+			max_open_votes, max_votes_per_day, min_title_length, min_description_length, cosponsors, no_early_close from guild_rules where guild_id = $1`,
+		guild,
+	).Scan(
+		&rules.MaxConsecutiveTerms, &rules.MaxTotalTerms, &mandatoryBreak, &distrustCooldown, &reproposeCooldown, &minDuration, &maxDuration,
+		&rules.MaxOpenVotes, &rules.MaxVotesPerDay, &rules.MinTitleLength, &rules.MinDescriptionLength, &rules.Cosponsors, &rules.NoEarlyClose,
+	)
+	if err == sql.ErrNoRows {
+		s.log.Info("no rules found, using defaults", zap.String("guild", guild))
+		return rules, nil
+	}
+	if err != nil {
+		s.log.Error("error querying rules", zap.String("guild", guild), zap.Error(err))
+		return rules, err
+	}
+	rules.MandatoryBreak = time.Duration(mandatoryBreak) * day
+	rules.DistrustCooldown = time.Duration(distrustCooldown) * day
+	rules.ReproposeCooldown = time.Duration(reproposeCooldown) * day
+	rules.MinDuration = time.Duration(minDuration) * time.Second
+	rules.MaxDuration = time.Duration(maxDuration) * time.Second
+	return rules, nil
+}
+
+// SetRules for guild
+func (s *SQLStore) SetRules(rules Rules) error {
+	s.log.Info("storing rules", zap.String("guild", rules.Guild))
+	query := `INSERT INTO guild_rules(guild_id, max_consecutive_terms, max_total_terms, mandatory_break, distrust_cooldown, repropose_cooldown, min_duration, max_duration,
+			max_open_votes, max_votes_per_day, min_title_length, min_description_length, cosponsors, no_early_close) VALUES($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14)
+		ON CONFLICT (guild_id) DO UPDATE SET max_consecutive_terms = $2, max_total_terms = $3, mandatory_break = $4, distrust_cooldown = $5, repropose_cooldown = $6, min_duration = $7, max_duration = $8,
+			max_open_votes = $9, max_votes_per_day = $10, min_title_length = $11, min_description_length = $12, cosponsors = $13, no_early_close = $14`
+	stmt, err := s.prepare(query)
+	if err != nil {
+		s.log.Error("error preparing upsert", zap.String("guild", rules.Guild), zap.Error(err), zap.String("query", query))
+		return err
+	}
+	defer stmt.Close()
+	res, err := stmt.Exec(
+		rules.Guild,
+		rules.MaxConsecutiveTerms,
+		rules.MaxTotalTerms,
+		int(rules.MandatoryBreak/day),
+		int(rules.DistrustCooldown/day),
+		int(rules.ReproposeCooldown/day),
+		int64(rules.MinDuration/time.Second),
+		int64(rules.MaxDuration/time.Second),
+		rules.MaxOpenVotes,
+		rules.MaxVotesPerDay,
+		rules.MinTitleLength,
+		rules.MinDescriptionLength,
+		rules.Cosponsors,
+		rules.NoEarlyClose,
+	)
+	if err != nil {
+		s.log.Error("error executing upsert", zap.String("guild", rules.Guild), zap.Error(err))
+		return err
+	}
+	rowCnt, err := res.RowsAffected()
+	if err != nil {
+		s.log.Error("error getting affected rows", zap.String("guild", rules.Guild), zap.Error(err))
+		return err
+	}
+	s.log.Info("finished storing rules", zap.String("guild", rules.Guild), zap.Int64("affected", rowCnt))
+	return nil
+}
+
+// ReadTerms served by user in guild
+func (s *SQLStore) ReadTerms(guild, user string) ([]Term, error) {
+	s.log.Info("fetching terms", zap.String("guild", guild), zap.String("user", user))
+	term := Term{
+		Guild: guild,
+		User:  user,
+	}
+
+	terms := []Term{}
+
+	rows, err := s.query("select role, term_start, term_end from terms where guild_id = $1 and user_id = $2", guild, user)
+	if err != nil {
+		s.log.Error("error querying rows", zap.String("guild", guild), zap.String("user", user), zap.Error(err))
+		return terms, err
+	}
 	defer rows.Close()
-	count := 0
-	entry := struct {
-		author string
-		vote   bool
-	}{}
 	for rows.Next() {
-		err := rows.Scan(&entry.author, &entry.vote)
+		err := rows.Scan(&term.Role, &term.Start, &term.End)
 		if err != nil {
-			v.log.Error("could not scan row", zap.String("guild", vote.Guild), zap.String("vote", vote.ID), zap.Error(err))
+			s.log.Error("could not scan row", zap.String("guild", guild), zap.String("user", user), zap.Error(err))
 			continue
 		}
-		if entry.vote {
-			vote.Pro = vote.Pro + 1
-		} else {
-			vote.Con = vote.Con + 1
-		}
-		count = count + 1
+		terms = append(terms, term)
 	}
-	v.log.Info("finished reading votes", zap.Int("count", count))
+	s.log.Info("finished reading terms", zap.Int("count", len(terms)))
 	err = rows.Err()
 	if err != nil {
-		v.log.Error("error reading rows", zap.String("guild", vote.Guild), zap.String("vote", vote.ID), zap.Error(err))
-		return vote, err
+		s.log.Error("error reading rows", zap.String("guild", guild), zap.String("user", user), zap.Error(err))
+		return terms, err
 	}
 
-	return vote, nil
+	return terms, nil
 }
 
-// AddVoteEntry for user
-func (v *VoteHandler) AddVoteEntry(vote Vote, author string, value bool) error {
-	v.log.Info("adding vote entry", zap.String("guild", vote.Guild), zap.String("vote", vote.ID), zap.String("author", author))
-	query := "INSERT INTO vote_entries(vote_id, guild_id, author, vote) VALUES($1,$2,$3,$4)"
-	stmt, err := v.db.Prepare(query)
-	if err != nil {
-		v.log.Error("error preparing insert", zap.String("guild", vote.Guild), zap.String("vote", vote.ID), zap.Error(err), zap.String("query", query))
-		return err
-	}
-	res, err := stmt.Exec(vote.ID, vote.Guild, author, value)
-	var rowCnt int64
-	if err != nil {
-		pge, ok := err.(*pq.Error)
-		if ok {
-			if pge.Code.Name() != "unique_violation" {
-				v.log.Error("error executing insert", zap.String("guild", vote.Guild), zap.String("vote", vote.ID), zap.String("author", author), zap.Bool("value", value), zap.String("dbErrorName", pge.Code.Name()), zap.String("dbErrorClass", pge.Code.Class().Name()), zap.Error(err))
-				return err
-			}
-			err = v.UpdateVoteEntry(vote, author, value)
-			if err != nil {
-				v.log.Error("error executing insert", zap.String("guild", vote.Guild), zap.String("vote", vote.ID), zap.String("author", author), zap.Bool("value", value), zap.Error(err))
-				return err
-			}
-		} else {
-			v.log.Error("error executing insert", zap.String("guild", vote.Guild), zap.String("vote", vote.ID), zap.String("author", author), zap.Bool("value", value), zap.Error(err))
-			return err
-		}
-	} else {
-		rowCnt, err = res.RowsAffected()
-		if err != nil {
-			v.log.Error("error getting affected rows", zap.String("guild", vote.Guild), zap.String("vote", vote.ID), zap.Error(err))
-			return err
-		}
+// InsertTerm served by a member
+func (s *SQLStore) InsertTerm(term Term) error {
+	s.log.Info("inserting term", zap.String("guild", term.Guild), zap.String("user", term.User), zap.String("role", term.Role))
+	query := "INSERT INTO terms(guild_id, user_id, role, term_start, term_end) VALUES($1,$2,$3,$4,$5)"
+	stmt, err := s.prepare(query)
+	if err != nil {
+		s.log.Error("error preparing insert", zap.String("guild", term.Guild), zap.String("user", term.User), zap.Error(err), zap.String("query", query))
+		return err
+	}
+	defer stmt.Close()
+	res, err := stmt.Exec(term.Guild, term.User, term.Role, term.Start, term.End)
+	if err != nil {
+		s.log.Error("error executing insert", zap.String("guild", term.Guild), zap.String("user", term.User), zap.Error(err))
+		return err
+	}
+	rowCnt, err := res.RowsAffected()
+	if err != nil {
+		s.log.Error("error getting affected rows", zap.String("guild", term.Guild), zap.String("user", term.User), zap.Error(err))
+		return err
 	}
-	v.log.Info("finished entry insert", zap.String("guild", vote.Guild), zap.String("vote", vote.ID), zap.String("author", author), zap.Bool("value", value), zap.Int64("affected", rowCnt))
+	s.log.Info("finished insert", zap.String("guild", term.Guild), zap.String("user", term.User), zap.Int64("affected", rowCnt))
+
 	return nil
 }
 
-// UpdateVoteEntry for user
-func (v *VoteHandler) UpdateVoteEntry(vote Vote, author string, value bool) error {
-	v.log.Info("updating vote entry", zap.String("guild", vote.Guild), zap.String("vote", vote.ID), zap.String("author", author))
-	query := "UPDATE vote_entries SET vote = $3 WHERE vote_id = $1 AND author = $2"
-	stmt, err := v.db.Prepare(query)
+// EndTerms of user in guild still running at end
+func (s *SQLStore) EndTerms(guild, user string, end time.Time) error {
+	s.log.Info("ending terms", zap.String("guild", guild), zap.String("user", user), zap.Time("end", end))
+	query := "UPDATE terms SET term_end = $3 WHERE guild_id = $1 AND user_id = $2 AND term_end > $3"
+	stmt, err := s.prepare(query)
 	if err != nil {
-		v.log.Error("error preparing update", zap.String("guild", vote.Guild), zap.String("currentID", vote.CurrentID), zap.String("vote", vote.ID), zap.Error(err), zap.String("query", query))
+		s.log.Error("error preparing update", zap.String("guild", guild), zap.String("user", user), zap.Error(err), zap.String("query", query))
 		return err
 	}
-	res, err := stmt.Exec(vote.ID, author, value)
+	defer stmt.Close()
+	res, err := stmt.Exec(guild, user, end)
 	if err != nil {
-		v.log.Error("error executing update", zap.String("guild", vote.Guild), zap.String("currentID", vote.CurrentID), zap.String("vote", vote.ID), zap.Error(err))
+		s.log.Error("error executing update", zap.String("guild", guild), zap.String("user", user), zap.Error(err))
 		return err
 	}
 	rowCnt, err := res.RowsAffected()
 	if err != nil {
-		v.log.Error("error getting affected rows", zap.String("guild", vote.Guild), zap.String("currentID", vote.CurrentID), zap.String("vote", vote.ID), zap.Error(err))
+		s.log.Error("error getting affected rows", zap.String("guild", guild), zap.String("user", user), zap.Error(err))
+		return err
+	}
+	s.log.Info("finished ending terms", zap.String("guild", guild), zap.String("user", user), zap.Int64("affected", rowCnt))
+	return nil
+}
+
+// InsertProposal to guild
+func (s *SQLStore) InsertProposal(proposal Proposal) error {
+	s.log.Info("inserting proposal", zap.String("guild", proposal.Guild), zap.String("proposal", proposal.ID), zap.String("author", proposal.Author))
+	query := "INSERT INTO proposals(proposal_id, guild_id, message_id, title, description, author, revision, status, created) VALUES($1,$2,$3,$4,$5,$6,$7,$8,$9)"
+	stmt, err := s.prepare(query)
+	if err != nil {
+		s.log.Error("error preparing insert", zap.String("guild", proposal.Guild), zap.String("proposal", proposal.ID), zap.Error(err), zap.String("query", query))
+		return err
+	}
+	defer stmt.Close()
+	_, err = stmt.Exec(proposal.ID, proposal.Guild, proposal.MessageID, proposal.Title, proposal.Description, proposal.Author, proposal.Revision, proposal.Status, proposal.Created)
+	if err != nil {
+		s.log.Error("error executing insert", zap.String("guild", proposal.Guild), zap.String("proposal", proposal.ID), zap.Error(err))
 		return err
 	}
-	v.log.Info("finished update", zap.Int64("affected", rowCnt))
+	s.log.Info("finished insert", zap.String("guild", proposal.Guild), zap.String("proposal", proposal.ID))
+	return nil
+}
+
+// GetProposal by discussion channel ID
+func (s *SQLStore) GetProposal(guild, id string) (Proposal, error) {
+	s.log.Info("fetching proposal", zap.String("guild", guild), zap.String("proposal", id))
+	proposal := Proposal{
+		Guild: guild,
+		ID:    id,
+	}
+	err := s.queryRow(
+		"select message_id, title, description, author, revision, status, created from proposals where guild_id = $1 and proposal_id = $2",
+		guild, id,
+	).Scan(&proposal.MessageID, &proposal.Title, &proposal.Description, &proposal.Author, &proposal.Revision, &proposal.Status, &proposal.Created)
+	if err == sql.ErrNoRows {
+		return proposal, ErrNotFound
+	}
+	if err != nil {
+		s.log.Info("unable to read proposal", zap.String("guild", guild), zap.String("proposal", id), zap.Error(err))
+		return proposal, err
+	}
+	return proposal, nil
+}
+
+// UpdateProposal text, revision and status
+func (s *SQLStore) UpdateProposal(proposal Proposal) error {
+	s.log.Info("updating proposal", zap.String("guild", proposal.Guild), zap.String("proposal", proposal.ID), zap.Int("revision", proposal.Revision))
+	query := "UPDATE proposals SET description = $3, revision = $4, status = $5 WHERE guild_id = $1 AND proposal_id = $2"
+	stmt, err := s.prepare(query)
+	if err != nil {
+		s.log.Error("error preparing update", zap.String("guild", proposal.Guild), zap.String("proposal", proposal.ID), zap.Error(err), zap.String("query", query))
+		return err
+	}
+	defer stmt.Close()
+	_, err = stmt.Exec(proposal.Guild, proposal.ID, proposal.Description, proposal.Revision, proposal.Status)
+	if err != nil {
+		s.log.Error("error executing update", zap.String("guild", proposal.Guild), zap.String("proposal", proposal.ID), zap.Error(err))
+		return err
+	}
+	s.log.Info("finished update", zap.String("guild", proposal.Guild), zap.String("proposal", proposal.ID))
+	return nil
+}
 
+// InsertRevision of the current proposal text
+func (s *SQLStore) InsertRevision(proposal Proposal, created time.Time) error {
+	s.log.Info("inserting revision", zap.String("guild", proposal.Guild), zap.String("proposal", proposal.ID), zap.Int("revision", proposal.Revision))
+	query := "INSERT INTO proposal_revisions(proposal_id, revision, description, created) VALUES($1,$2,$3,$4)"
+	stmt, err := s.prepare(query)
+	if err != nil {
+		s.log.Error("error preparing insert", zap.String("guild", proposal.Guild), zap.String("proposal", proposal.ID), zap.Error(err), zap.String("query", query))
+		return err
+	}
+	defer stmt.Close()
+	_, err = stmt.Exec(proposal.ID, proposal.Revision, proposal.Description, created)
+	if err != nil {
+		s.log.Error("error executing insert", zap.String("guild", proposal.Guild), zap.String("proposal", proposal.ID), zap.Error(err))
+		return err
+	}
+	s.log.Info("finished insert", zap.String("guild", proposal.Guild), zap.String("proposal", proposal.ID), zap.Int("revision", proposal.Revision))
 	return nil
 }
+
+// InsertAmendment to proposal
+func (s *SQLStore) InsertAmendment(amendment Amendment) error {
+	s.log.Info("inserting amendment", zap.String("guild", amendment.Guild), zap.String("proposal", amendment.Proposal), zap.String("amendment", amendment.ID))
+	query := "INSERT INTO amendments(amendment_id, guild_id, proposal_id, author, description, status, vote_id) VALUES($1,$2,$3,$4,$5,$6,$7)"
+	stmt, err := s.prepare(query)
+	if err != nil {
+		s.log.Error("error preparing insert", zap.String("guild", amendment.Guild), zap.String("amendment", amendment.ID), zap.Error(err), zap.String("query", query))
+		return err
+	}
+	defer stmt.Close()
+	_, err = stmt.Exec(amendment.ID, amendment.Guild, amendment.Proposal, amendment.Author, amendment.Description, amendment.Status, amendment.Vote)
+	if err != nil {
+		s.log.Error("error executing insert", zap.String("guild", amendment.Guild), zap.String("amendment", amendment.ID), zap.Error(err))
+		return err
+	}
+	s.log.Info("finished insert", zap.String("guild", amendment.Guild), zap.String("amendment", amendment.ID))
+	return nil
+}
+
+// GetAmendment by message ID
+func (s *SQLStore) GetAmendment(guild, id string) (Amendment, error) {
+	s.log.Info("fetching amendment", zap.String("guild", guild), zap.String("amendment", id))
+	amendment := Amendment{
+		Guild: guild,
+		ID:    id,
+	}
+	err := s.queryRow(
+		"select proposal_id, author, description, status, coalesce(vote_id, '') from amendments where guild_id = $1 and amendment_id = $2",
+		guild, id,
+	).Scan(&amendment.Proposal, &amendment.Author, &amendment.Description, &amendment.Status, &amendment.Vote)
+	if err != nil {
+		s.log.Info("unable to read amendment", zap.String("guild", guild), zap.String("amendment", id), zap.Error(err))
+		return amendment, err
+	}
+	return amendment, nil
+}
+
+// ReadAmendments for proposal
+func (s *SQLStore) ReadAmendments(guild, proposal string) ([]Amendment, error) {
+	s.log.Info("fetching amendments", zap.String("guild", guild), zap.String("proposal", proposal))
+	amendment := Amendment{
+		Guild:    guild,
+		Proposal: proposal,
+	}
+
+	amendments := []Amendment{}
+
+	rows, err := s.query(
+		"select amendment_id, author, description, status, coalesce(vote_id, '') from amendments where guild_id = $1 and proposal_id = $2 order by amendment_id",
+		guild, proposal,
+	)
+	if err != nil {
+		s.log.Error("error querying rows", zap.String("guild", guild), zap.String("proposal", proposal), zap.Error(err))
+		return amendments, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		err := rows.Scan(&amendment.ID, &amendment.Author, &amendment.Description, &amendment.Status, &amendment.Vote)
+		if err != nil {
+			s.log.Error("could not scan row", zap.String("guild", guild), zap.String("proposal", proposal), zap.Error(err))
+			continue
+		}
+		amendments = append(amendments, amendment)
+	}
+	s.log.Info("finished reading amendments", zap.Int("count", len(amendments)))
+	err = rows.Err()
+	if err != nil {
+		s.log.Error("error reading rows", zap.String("guild", guild), zap.String("proposal", proposal), zap.Error(err))
+		return amendments, err
+	}
+
+	return amendments, nil
+}
+
+// UpdateAmendment status
+func (s *SQLStore) UpdateAmendment(amendment Amendment) error {
+	s.log.Info("updating amendment", zap.String("guild", amendment.Guild), zap.String("amendment", amendment.ID), zap.String("status", amendment.Status))
+	query := "UPDATE amendments SET status = $3, vote_id = $4 WHERE guild_id = $1 AND amendment_id = $2"
+	stmt, err := s.prepare(query)
+	if err != nil {
+		s.log.Error("error preparing update", zap.String("guild", amendment.Guild), zap.String("amendment", amendment.ID), zap.Error(err), zap.String("query", query))
+		return err
+	}
+	defer stmt.Close()
+	_, err = stmt.Exec(amendment.Guild, amendment.ID, amendment.Status, amendment.Vote)
+	if err != nil {
+		s.log.Error("error executing update", zap.String("guild", amendment.Guild), zap.String("amendment", amendment.ID), zap.Error(err))
+		return err
+	}
+	s.log.Info("finished update", zap.String("guild", amendment.Guild), zap.String("amendment", amendment.ID))
+	return nil
+}
+
+// InsertSchedule returning the schedule with its assigned ID
+func (s *SQLStore) InsertSchedule(schedule Schedule) (Schedule, error) {
+	s.log.Info("inserting schedule", zap.String("guild", schedule.Guild), zap.String("author", schedule.Author))
+	if schedule.Anchor.IsZero() {
+		schedule.Anchor = schedule.Starts
+	}
+	query := "INSERT INTO scheduled_votes(guild_id, author, title, description, starts, duration, recurrence, timezone, anchor) VALUES($1,$2,$3,$4,$5,$6,$7,$8,$9) RETURNING schedule_id"
+	err := s.queryRow(
+		query,
+		schedule.Guild,
+		schedule.Author,
+		schedule.Title,
+		schedule.Description,
+		schedule.Starts.UTC(),
+		int64(schedule.Duration/time.Second),
+		string(schedule.Recurrence),
+		schedule.Location.String(),
+		schedule.Anchor.UTC(),
+	).Scan(&schedule.ID)
+	if err != nil {
+		s.log.Error("error executing insert", zap.String("guild", schedule.Guild), zap.Error(err), zap.String("query", query))
+		return schedule, err
+	}
+	s.log.Info("finished insert", zap.String("guild", schedule.Guild), zap.Int64("schedule", schedule.ID))
+	return schedule, nil
+}
+
+// GetSchedule by ID
+func (s *SQLStore) GetSchedule(guild string, id int64) (Schedule, error) {
+	s.log.Info("fetching schedule", zap.String("guild", guild), zap.Int64("schedule", id))
+	schedules, err := s.querySchedules("where guild_id = $1 and schedule_id = $2", guild, id)
+	if err != nil {
+		return Schedule{}, err
+	}
+	if len(schedules) != 1 {
+		s.log.Info("received invalid schedule count", zap.String("guild", guild), zap.Int64("schedule", id), zap.Int("count", len(schedules)))
+		return Schedule{}, ErrNotFound
+	}
+	return schedules[0], nil
+}
+
+// ReadSchedules for guild
+func (s *SQLStore) ReadSchedules(guild string) ([]Schedule, error) {
+	s.log.Info("fetching schedules", zap.String("guild", guild))
+	return s.querySchedules("where guild_id = $1 order by starts", guild)
+}
+
+// ReadDueSchedules of all guilds starting before now
+func (s *SQLStore) ReadDueSchedules(now time.Time) ([]Schedule, error) {
+	s.log.Debug("fetching due schedules", zap.Time("now", now))
+	return s.querySchedules("where starts <= $1 order by starts", now.UTC())
+}
+
+func (s *SQLStore) querySchedules(where string, args ...interface{}) ([]Schedule, error) {
+	schedules := []Schedule{}
+	rows, err := s.query("select schedule_id, guild_id, author, title, description, starts, duration, recurrence, timezone, anchor from scheduled_votes "+where, args...)
+	if err != nil {
+		s.log.Error("error querying rows", zap.String("where", where), zap.Error(err))
+		return schedules, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var schedule Schedule
+		var duration int64
+		var recurrence, timezone string
+		err := rows.Scan(&schedule.ID, &schedule.Guild, &schedule.Author, &schedule.Title, &schedule.Description, &schedule.Starts, &duration, &recurrence, &timezone, &schedule.Anchor)
+		if err != nil {
+			s.log.Error("could not scan row", zap.String("where", where), zap.Error(err))
+			continue
+		}
+		schedule.Duration = time.Duration(duration) * time.Second
+		schedule.Recurrence = Recurrence(recurrence)
+		schedule.Location, err = time.LoadLocation(timezone)
+		if err != nil {
+			s.log.Error("unknown schedule timezone, falling back to UTC", zap.Int64("schedule", schedule.ID), zap.String("timezone", timezone), zap.Error(err))
+			schedule.Location = time.UTC
+		}
+		schedules = append(schedules, schedule)
+	}
+	err = rows.Err()
+	if err != nil {
+		s.log.Error("error reading rows", zap.String("where", where), zap.Error(err))
+		return schedules, err
+	}
+	return schedules, nil
+}
+
+// UpdateSchedule start of next occurrence
+func (s *SQLStore) UpdateSchedule(schedule Schedule) error {
+	s.log.Info("updating schedule", zap.String("guild", schedule.Guild), zap.Int64("schedule", schedule.ID), zap.Time("starts", schedule.Starts))
+	query := "UPDATE scheduled_votes SET starts = $3 WHERE guild_id = $1 AND schedule_id = $2"
+	stmt, err := s.prepare(query)
+	if err != nil {
+		s.log.Error("error preparing update", zap.String("guild", schedule.Guild), zap.Int64("schedule", schedule.ID), zap.Error(err), zap.String("query", query))
+		return err
+	}
+	defer stmt.Close()
+	_, err = stmt.Exec(schedule.Guild, schedule.ID, schedule.Starts.UTC())
+	if err != nil {
+		s.log.Error("error executing update", zap.String("guild", schedule.Guild), zap.Int64("schedule", schedule.ID), zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// DeleteSchedule from guild
+func (s *SQLStore) DeleteSchedule(schedule Schedule) error {
+	s.log.Info("deleting schedule", zap.String("guild", schedule.Guild), zap.Int64("schedule", schedule.ID))
+	query := "DELETE FROM scheduled_votes WHERE guild_id = $1 AND schedule_id = $2"
+	stmt, err := s.prepare(query)
+	if err != nil {
+		s.log.Error("error preparing delete", zap.String("guild", schedule.Guild), zap.Int64("schedule", schedule.ID), zap.Error(err), zap.String("query", query))
+		return err
+	}
+	defer stmt.Close()
+	_, err = stmt.Exec(schedule.Guild, schedule.ID)
+	if err != nil {
+		s.log.Error("error executing delete", zap.String("guild", schedule.Guild), zap.Int64("schedule", schedule.ID), zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// ReadVoters of vote
+func (s *SQLStore) ReadVoters(vote Vote) (map[string]bool, error) {
+	s.log.Info("fetching voters", zap.String("guild", vote.Guild), zap.String("vote", vote.ID))
+	voters := make(map[string]bool)
+	rows, err := s.query("select author from vote_entries where guild_id = $1 and vote_id = $2", vote.Guild, vote.ID)
+	if err != nil {
+		s.log.Error("error querying rows", zap.String("guild", vote.Guild), zap.String("vote", vote.ID), zap.Error(err))
+		return voters, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var author string
+		err := rows.Scan(&author)
+		if err != nil {
+			s.log.Error("could not scan row", zap.String("guild", vote.Guild), zap.String("vote", vote.ID), zap.Error(err))
+			continue
+		}
+		voters[author] = true
+	}
+	return voters, rows.Err()
+}
+
+// GetReminders for guild. Returns the default reminders if none are stored.
+func (s *SQLStore) GetReminders(guild string) (Reminders, error) {
+	s.log.Debug("fetching reminders", zap.String("guild", guild))
+	reminders := Reminders{
+		Guild:  guild,
+		Points: DefaultReminderPoints,
+	}
+	var points string
+	err := s.queryRow("select points, role_id from guild_reminders where guild_id = $1", guild).Scan(&points, &reminders.Role)
+	if err == sql.ErrNoRows {
+		return reminders, nil
+	}
+	if err != nil {
+		s.log.Error("error querying reminders", zap.String("guild", guild), zap.Error(err))
+		return reminders, err
+	}
+	reminders.Points = nil
+	for _, p := range strings.Fields(points) {
+		seconds, err := strconv.ParseInt(p, 10, 64)
+		if err != nil {
+			s.log.Error("invalid reminder point", zap.String("guild", guild), zap.String("point", p), zap.Error(err))
+			continue
+		}
+		reminders.Points = append(reminders.Points, time.Duration(seconds)*time.Second)
+	}
+	return reminders, nil
+}
+
+// SetReminders for guild
+func (s *SQLStore) SetReminders(reminders Reminders) error {
+	s.log.Info("storing reminders", zap.String("guild", reminders.Guild))
+	var points []string
+	for _, p := range reminders.Points {
+		points = append(points, strconv.FormatInt(int64(p/time.Second), 10))
+	}
+	query := `INSERT INTO guild_reminders(guild_id, points, role_id) VALUES($1,$2,$3)
+		ON CONFLICT (guild_id) DO UPDATE SET points = $2, role_id = $3`
+	stmt, err := s.prepare(query)
+	if err != nil {
+		s.log.Error("error preparing upsert", zap.String("guild", reminders.Guild), zap.Error(err), zap.String("query", query))
+		return err
+	}
+	defer stmt.Close()
+	_, err = stmt.Exec(reminders.Guild, strings.Join(points, " "), reminders.Role)
+	if err != nil {
+		s.log.Error("error executing upsert", zap.String("guild", reminders.Guild), zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// SetNotify opts user in or out of reminder DMs for guild
+func (s *SQLStore) SetNotify(guild, user string, enabled bool) error {
+	s.log.Info("storing notify", zap.String("guild", guild), zap.String("user", user), zap.Bool("enabled", enabled))
+	query := "DELETE FROM notify_subscriptions WHERE guild_id = $1 AND user_id = $2"
+	if enabled {
+		query = "INSERT INTO notify_subscriptions(guild_id, user_id) VALUES($1,$2) ON CONFLICT DO NOTHING"
+	}
+	stmt, err := s.prepare(query)
+	if err != nil {
+		s.log.Error("error preparing notify", zap.String("guild", guild), zap.String("user", user), zap.Error(err), zap.String("query", query))
+		return err
+	}
+	defer stmt.Close()
+	_, err = stmt.Exec(guild, user)
+	if err != nil {
+		s.log.Error("error executing notify", zap.String("guild", guild), zap.String("user", user), zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// ReadNotify subscribers of guild
+func (s *SQLStore) ReadNotify(guild string) ([]string, error) {
+	s.log.Debug("fetching notify subscribers", zap.String("guild", guild))
+	users := []string{}
+	rows, err := s.query("select user_id from notify_subscriptions where guild_id = $1", guild)
+	if err != nil {
+		s.log.Error("error querying rows", zap.String("guild", guild), zap.Error(err))
+		return users, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var user string
+		err := rows.Scan(&user)
+		if err != nil {
+			s.log.Error("could not scan row", zap.String("guild", guild), zap.Error(err))
+			continue
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+// ClaimReminder records the reminder at point for user (or the channel if empty).
+// Returns false if it was already recorded before.
+func (s *SQLStore) ClaimReminder(vote Vote, point time.Duration, user string) (bool, error) {
+	query := "INSERT INTO reminders_sent(guild_id, vote_id, point, user_id) VALUES($1,$2,$3,$4) ON CONFLICT DO NOTHING"
+	stmt, err := s.prepare(query)
+	if err != nil {
+		s.log.Error("error preparing insert", zap.String("guild", vote.Guild), zap.String("vote", vote.ID), zap.Error(err), zap.String("query", query))
+		return false, err
+	}
+	defer stmt.Close()
+	res, err := stmt.Exec(vote.Guild, vote.ID, int64(point/time.Second), user)
+	if err != nil {
+		s.log.Error("error executing insert", zap.String("guild", vote.Guild), zap.String("vote", vote.ID), zap.Error(err))
+		return false, err
+	}
+	rowCnt, err := res.RowsAffected()
+	if err != nil {
+		s.log.Error("error getting affected rows", zap.String("guild", vote.Guild), zap.String("vote", vote.ID), zap.Error(err))
+		return false, err
+	}
+	return rowCnt == 1, nil
+}
+
+// ReleaseReminder at point for user (or the channel if empty), which could not be sent
+func (s *SQLStore) ReleaseReminder(vote Vote, point time.Duration, user string) error {
+	_, err := s.exec("DELETE FROM reminders_sent WHERE guild_id = $1 AND vote_id = $2 AND point = $3 AND user_id = $4",
+		vote.Guild, vote.ID, int64(point/time.Second), user)
+	if err != nil {
+		s.log.Error("error executing delete", zap.String("guild", vote.Guild), zap.String("vote", vote.ID), zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// GetArchive channel of guild, empty if none is set
+func (s *SQLStore) GetArchive(guild string) (string, error) {
+	s.log.Debug("fetching archive", zap.String("guild", guild))
+	var channel string
+	err := s.queryRow("select channel_id from guild_archives where guild_id = $1", guild).Scan(&channel)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		s.log.Error("error querying archive", zap.String("guild", guild), zap.Error(err))
+		return "", err
+	}
+	return channel, nil
+}
+
+// SetArchive channel of guild, removing it if channel is empty
+func (s *SQLStore) SetArchive(guild, channel string) error {
+	s.log.Info("storing archive", zap.String("guild", guild), zap.String("channel", channel))
+	var err error
+	if channel == "" {
+		_, err = s.exec("DELETE FROM guild_archives WHERE guild_id = $1", guild)
+	} else {
+		_, err = s.exec(`INSERT INTO guild_archives(guild_id, channel_id) VALUES($1,$2)
+		ON CONFLICT (guild_id) DO UPDATE SET channel_id = $2`, guild, channel)
+	}
+	if err != nil {
+		s.log.Error("error storing archive", zap.String("guild", guild), zap.String("channel", channel), zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// RetractBallot of author on vote and return the vote with its updated tally, like RecordBallot.
+// Returns retracted false if author had no ballot on the vote.
+func (s *SQLStore) RetractBallot(vote Vote, author string) (Vote, bool, error) {
+	s.log.Info("retracting ballot", zap.String("guild", vote.Guild), zap.String("vote", vote.ID), zap.String("author", author))
+	tx, err := s.begin()
+	if err != nil {
+		s.log.Error("error starting transaction", zap.String("guild", vote.Guild), zap.String("vote", vote.ID), zap.Error(err))
+		return vote, false, err
+	}
+	defer tx.Rollback()
+
+	var closed bool
+	err = tx.QueryRow(
+		"select coalesce(closed, false), coalesce(pro, 0), coalesce(con, 0) from votes where guild_id = $1 and vote_id = $2"+s.forUpdate(),
+		vote.Guild, vote.ID,
+	).Scan(&closed, &vote.Pro, &vote.Con)
+	if err != nil {
+		s.log.Error("error locking vote", zap.String("guild", vote.Guild), zap.String("vote", vote.ID), zap.Error(err))
+		return vote, false, err
+	}
+	if closed {
+		return vote, false, ErrVoteClosed
+	}
+
+	var previous bool
+	err = tx.QueryRow(
+		"select vote from vote_entries where guild_id = $1 and vote_id = $2 and author = $3",
+		vote.Guild, vote.ID, author,
+	).Scan(&previous)
+	if err == sql.ErrNoRows {
+		return vote, false, nil
+	}
+	if err != nil {
+		s.log.Error("error querying ballot", zap.String("guild", vote.Guild), zap.String("vote", vote.ID), zap.String("author", author), zap.Error(err))
+		return vote, false, err
+	}
+	if previous {
+		vote.Pro = vote.Pro - 1
+	} else {
+		vote.Con = vote.Con - 1
+	}
+
+	_, err = tx.Exec("DELETE FROM vote_entries WHERE guild_id = $1 AND vote_id = $2 AND author = $3", vote.Guild, vote.ID, author)
+	if err != nil {
+		s.log.Error("error executing delete", zap.String("guild", vote.Guild), zap.String("vote", vote.ID), zap.String("author", author), zap.Error(err))
+		return vote, false, err
+	}
+	_, err = tx.Exec("UPDATE votes SET pro = $3, con = $4 WHERE guild_id = $1 AND vote_id = $2", vote.Guild, vote.ID, vote.Pro, vote.Con)
+	if err != nil {
+		s.log.Error("error updating vote count", zap.String("guild", vote.Guild), zap.String("vote", vote.ID), zap.Error(err))
+		return vote, false, err
+	}
+	err = tx.Commit()
+	if err != nil {
+		s.log.Error("error committing retraction", zap.String("guild", vote.Guild), zap.String("vote", vote.ID), zap.String("author", author), zap.Error(err))
+		return vote, false, err
+	}
+	s.log.Info("finished retracting ballot", zap.String("guild", vote.Guild), zap.String("vote", vote.ID), zap.Int("pro", vote.Pro), zap.Int("con", vote.Con))
+	return vote, true, nil
+}
+
+// InsertAuditEvent returning the event with its assigned ID
+func (s *SQLStore) InsertAuditEvent(event AuditEvent) (AuditEvent, error) {
+	s.log.Debug("inserting audit event", zap.String("guild", event.Guild), zap.String("kind", string(event.Kind)))
+	err := s.queryRow(
+		`INSERT INTO audit_events(guild_id, kind, vote_id, number, user_id, created, detail, api_key) VALUES($1,$2,$3,$4,$5,$6,$7,$8) RETURNING event_id`,
+		event.Guild, string(event.Kind), event.Vote, event.Number, event.User, event.Created, event.Detail, event.Key,
+	).Scan(&event.ID)
+	if err != nil {
+		s.log.Error("error inserting audit event", zap.String("guild", event.Guild), zap.String("kind", string(event.Kind)), zap.Error(err))
+		return event, err
+	}
+	return event, nil
+}
+
+// ReadAuditEvents of guild newest first, only events before the given ID unless it is 0
+func (s *SQLStore) ReadAuditEvents(guild string, before int64, limit int) ([]AuditEvent, error) {
+	s.log.Debug("fetching audit events", zap.String("guild", guild), zap.Int64("before", before), zap.Int("limit", limit))
+	query := "select event_id, guild_id, kind, vote_id, number, user_id, created, detail, api_key from audit_events where guild_id = $1"
+	args := []interface{}{guild}
+	if before > 0 {
+		query += " and event_id < $2"
+		args = append(args, before)
+	}
+	query += " order by event_id desc"
+	if limit > 0 {
+		query += fmt.Sprintf(" limit %d", limit)
+	}
+	rows, err := s.query(query, args...)
+	if err != nil {
+		s.log.Error("error querying audit events", zap.String("guild", guild), zap.Error(err))
+		return nil, err
+	}
+	defer rows.Close()
+	events := []AuditEvent{}
+	for rows.Next() {
+		var event AuditEvent
+		var kind string
+		err = rows.Scan(&event.ID, &event.Guild, &kind, &event.Vote, &event.Number, &event.User, &event.Created, &event.Detail, &event.Key)
+		if err != nil {
+			s.log.Error("could not scan row", zap.String("guild", guild), zap.Error(err))
+			continue
+		}
+		event.Kind = EventKind(kind)
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+// InsertAPIKey of a guild
+func (s *SQLStore) InsertAPIKey(key APIKey) error {
+	s.log.Info("inserting api key", zap.String("guild", key.Guild), zap.String("name", key.Name))
+	_, err := s.exec(
+		"INSERT INTO guild_api_keys(guild_id, name, key_hash, created) VALUES($1,$2,$3,$4)",
+		key.Guild, key.Name, key.Hash, key.Created,
+	)
+	if err != nil {
+		s.log.Error("error inserting api key", zap.String("guild", key.Guild), zap.String("name", key.Name), zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// GetAPIKey by its hash
+func (s *SQLStore) GetAPIKey(hash string) (APIKey, error) {
+	key := APIKey{Hash: hash}
+	err := s.queryRow("select guild_id, name, created from guild_api_keys where key_hash = $1", hash).Scan(&key.Guild, &key.Name, &key.Created)
+	if err == sql.ErrNoRows {
+		return key, ErrUnknownAPIKey
+	}
+	if err != nil {
+		s.log.Error("error querying api key", zap.Error(err))
+		return key, err
+	}
+	return key, nil
+}
+
+// ReadAPIKeys of guild
+func (s *SQLStore) ReadAPIKeys(guild string) ([]APIKey, error) {
+	rows, err := s.query("select name, key_hash, created from guild_api_keys where guild_id = $1 order by name", guild)
+	if err != nil {
+		s.log.Error("error querying api keys", zap.String("guild", guild), zap.Error(err))
+		return nil, err
+	}
+	defer rows.Close()
+	keys := []APIKey{}
+	for rows.Next() {
+		key := APIKey{Guild: guild}
+		err = rows.Scan(&key.Name, &key.Hash, &key.Created)
+		if err != nil {
+			s.log.Error("could not scan row", zap.String("guild", guild), zap.Error(err))
+			continue
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// DeleteAPIKey of guild by name
+func (s *SQLStore) DeleteAPIKey(guild, name string) (bool, error) {
+	s.log.Info("deleting api key", zap.String("guild", guild), zap.String("name", name))
+	res, err := s.exec("DELETE FROM guild_api_keys WHERE guild_id = $1 AND name = $2", guild, name)
+	if err != nil {
+		s.log.Error("error deleting api key", zap.String("guild", guild), zap.String("name", name), zap.Error(err))
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// InsertDraft waiting for cosponsors
+func (s *SQLStore) InsertDraft(draft Draft) error {
+	s.log.Info("inserting draft", zap.String("guild", draft.Guild), zap.String("draft", draft.ID))
+	_, err := s.exec(
+		`INSERT INTO vote_drafts(guild_id, draft_id, channel_id, author, title, description, duration, tags, created, nominee_id, role, term, distrust_id)
+		VALUES($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13)`,
+		draft.Guild, draft.ID, draft.Channel, draft.Author, draft.Title, draft.Description, int64(draft.Duration/time.Second), strings.Join(draft.Tags, ","), draft.Created,
+		draft.Nominee, draft.Role, int64(draft.Term/time.Second), draft.Distrust,
+	)
+	if err != nil {
+		s.log.Error("error inserting draft", zap.String("guild", draft.Guild), zap.String("draft", draft.ID), zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// GetDraft of guild by its message
+func (s *SQLStore) GetDraft(guild, id string) (Draft, error) {
+	s.log.Info("fetching draft", zap.String("guild", guild), zap.String("draft", id))
+	drafts, err := s.queryDrafts("where guild_id = $1 and draft_id = $2", guild, id)
+	if err != nil {
+		return Draft{}, err
+	}
+	if len(drafts) != 1 {
+		return Draft{}, ErrNotFound
+	}
+	return drafts[0], nil
+}
+
+// ReadDrafts of guild
+func (s *SQLStore) ReadDrafts(guild string) ([]Draft, error) {
+	s.log.Info("fetching drafts", zap.String("guild", guild))
+	return s.queryDrafts("where guild_id = $1", guild)
+}
+
+// ReadExpiredDrafts of all guilds created before before
+func (s *SQLStore) ReadExpiredDrafts(before time.Time) ([]Draft, error) {
+	s.log.Info("fetching expired drafts", zap.Time("before", before))
+	return s.queryDrafts("where created < $1", before)
+}
+
+func (s *SQLStore) queryDrafts(where string, args ...interface{}) ([]Draft, error) {
+	drafts := []Draft{}
+	rows, err := s.query(
+		`select guild_id, draft_id, channel_id, author, title, description, duration, tags, created,
+		coalesce(nominee_id, ''), coalesce(role, ''), coalesce(term, 0), coalesce(distrust_id, '') from vote_drafts `+where+" order by created",
+		args...,
+	)
+	if err != nil {
+		s.log.Error("error querying drafts", zap.Error(err))
+		return drafts, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var draft Draft
+		var duration, term int64
+		var tags string
+		err := rows.Scan(
+			&draft.Guild, &draft.ID, &draft.Channel, &draft.Author, &draft.Title, &draft.Description, &duration, &tags, &draft.Created,
+			&draft.Nominee, &draft.Role, &term, &draft.Distrust,
+		)
+		if err != nil {
+			s.log.Error("could not scan row", zap.Error(err))
+			continue
+		}
+		draft.Duration = time.Duration(duration) * time.Second
+		draft.Term = time.Duration(term) * time.Second
+		draft.Tags = ParseTags(tags)
+		drafts = append(drafts, draft)
+	}
+	err = rows.Err()
+	if err != nil {
+		s.log.Error("error reading rows", zap.Error(err))
+		return drafts, err
+	}
+	return drafts, nil
+}
+
+// DeleteDraft returning false if it was deleted before
+func (s *SQLStore) DeleteDraft(draft Draft) (bool, error) {
+	s.log.Info("deleting draft", zap.String("guild", draft.Guild), zap.String("draft", draft.ID))
+	res, err := s.exec("DELETE FROM vote_drafts WHERE guild_id = $1 AND draft_id = $2", draft.Guild, draft.ID)
+	if err != nil {
+		s.log.Error("error deleting draft", zap.String("guild", draft.Guild), zap.String("draft", draft.ID), zap.Error(err))
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// InsertWebhook returning the webhook with its assigned ID
+func (s *SQLStore) InsertWebhook(hook Webhook) (Webhook, error) {
+	s.log.Info("inserting webhook", zap.String("guild", hook.Guild), zap.String("url", hook.URL))
+	err := s.queryRow(
+		"INSERT INTO guild_webhooks(guild_id, url, events, secret, created) VALUES($1,$2,$3,$4,$5) RETURNING webhook_id",
+		hook.Guild, hook.URL, joinEvents(hook.Events), hook.Secret, hook.Created,
+	).Scan(&hook.ID)
+	if err != nil {
+		s.log.Error("error inserting webhook", zap.String("guild", hook.Guild), zap.String("url", hook.URL), zap.Error(err))
+		return hook, err
+	}
+	return hook, nil
+}
+
+// ReadWebhooks of guild
+func (s *SQLStore) ReadWebhooks(guild string) ([]Webhook, error) {
+	rows, err := s.query("select webhook_id, url, events, secret, created from guild_webhooks where guild_id = $1 order by webhook_id", guild)
+	if err != nil {
+		s.log.Error("error querying webhooks", zap.String("guild", guild), zap.Error(err))
+		return nil, err
+	}
+	defer rows.Close()
+	hooks := []Webhook{}
+	for rows.Next() {
+		hook := Webhook{Guild: guild}
+		var events string
+		err = rows.Scan(&hook.ID, &hook.URL, &events, &hook.Secret, &hook.Created)
+		if err != nil {
+			s.log.Error("could not scan row", zap.String("guild", guild), zap.Error(err))
+			continue
+		}
+		hook.Events = splitEvents(events)
+		hooks = append(hooks, hook)
+	}
+	return hooks, rows.Err()
+}
+
+// DeleteWebhook of guild by ID
+func (s *SQLStore) DeleteWebhook(guild string, id int64) (bool, error) {
+	s.log.Info("deleting webhook", zap.String("guild", guild), zap.Int64("webhook", id))
+	res, err := s.exec("DELETE FROM guild_webhooks WHERE guild_id = $1 AND webhook_id = $2", guild, id)
+	if err != nil {
+		s.log.Error("error deleting webhook", zap.String("guild", guild), zap.Int64("webhook", id), zap.Error(err))
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// InsertWebhookFailure of a delivery given up
+func (s *SQLStore) InsertWebhookFailure(failure WebhookFailure) error {
+	s.log.Info("inserting webhook failure", zap.String("guild", failure.Guild), zap.Int64("webhook", failure.Webhook))
+	_, err := s.exec(
+		"INSERT INTO webhook_failures(guild_id, webhook_id, url, event, payload, attempts, error, failed) VALUES($1,$2,$3,$4,$5,$6,$7,$8)",
+		failure.Guild, failure.Webhook, failure.URL, string(failure.Event), failure.Payload, failure.Attempts, failure.Error, failure.Failed,
+	)
+	if err != nil {
+		s.log.Error("error inserting webhook failure", zap.String("guild", failure.Guild), zap.Int64("webhook", failure.Webhook), zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// ReadWebhookFailures of guild newest first
+func (s *SQLStore) ReadWebhookFailures(guild string, limit int) ([]WebhookFailure, error) {
+	query := "select failure_id, webhook_id, url, event, payload, attempts, error, failed from webhook_failures where guild_id = $1 order by failure_id desc"
+	if limit > 0 {
+		query += fmt.Sprintf(" limit %d", limit)
+	}
+	rows, err := s.query(query, guild)
+	if err != nil {
+		s.log.Error("error querying webhook failures", zap.String("guild", guild), zap.Error(err))
+		return nil, err
+	}
+	defer rows.Close()
+	failures := []WebhookFailure{}
+	for rows.Next() {
+		failure := WebhookFailure{Guild: guild}
+		var event string
+		err = rows.Scan(&failure.ID, &failure.Webhook, &failure.URL, &event, &failure.Payload, &failure.Attempts, &failure.Error, &failure.Failed)
+		if err != nil {
+			s.log.Error("could not scan row", zap.String("guild", guild), zap.Error(err))
+			continue
+		}
+		failure.Event = EventKind(event)
+		failures = append(failures, failure)
+	}
+	return failures, rows.Err()
+}
+
+// joinEvents of a webhook into a comma separated column
+func joinEvents(events []EventKind) string {
+	var kinds []string
+	for _, k := range events {
+		kinds = append(kinds, string(k))
+	}
+	return strings.Join(kinds, ",")
+}
+
+// splitEvents of a webhook from its comma separated column
+func splitEvents(events string) []EventKind {
+	if events == "" {
+		return nil
+	}
+	var kinds []EventKind
+	for _, k := range strings.Split(events, ",") {
+		kinds = append(kinds, EventKind(k))
+	}
+	return kinds
+}
+
+// GetBrigadeSettings of guild, detection is off if none are stored
+func (s *SQLStore) GetBrigadeSettings(guild string) (BrigadeSettings, error) {
+	s.log.Debug("fetching brigading settings", zap.String("guild", guild))
+	settings := BrigadeSettings{Guild: guild}
+	err := s.queryRow("select channel_id, quarantine from guild_brigading where guild_id = $1", guild).Scan(&settings.Channel, &settings.Quarantine)
+	if err == sql.ErrNoRows {
+		return settings, nil
+	}
+	if err != nil {
+		s.log.Error("error querying brigading settings", zap.String("guild", guild), zap.Error(err))
+		return settings, err
+	}
+	return settings, nil
+}
+
+// SetBrigadeSettings of a guild
+func (s *SQLStore) SetBrigadeSettings(settings BrigadeSettings) error {
+	s.log.Info("storing brigading settings", zap.String("guild", settings.Guild), zap.String("channel", settings.Channel), zap.Bool("quarantine", settings.Quarantine))
+	_, err := s.exec(`INSERT INTO guild_brigading(guild_id, channel_id, quarantine) VALUES($1,$2,$3)
+		ON CONFLICT (guild_id) DO UPDATE SET channel_id = $2, quarantine = $3`, settings.Guild, settings.Channel, settings.Quarantine)
+	if err != nil {
+		s.log.Error("error storing brigading settings", zap.String("guild", settings.Guild), zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// QuarantineBallot replacing an earlier one of the same user on the vote
+func (s *SQLStore) QuarantineBallot(ballot QuarantinedBallot) error {
+	s.log.Info("quarantining ballot", zap.String("guild", ballot.Guild), zap.String("vote", ballot.Vote), zap.String("user", ballot.User), zap.Bool("released", ballot.Released))
+	_, err := s.exec(`INSERT INTO quarantined_ballots(guild_id, vote_id, user_id, vote, signals, cast_at, released) VALUES($1,$2,$3,$4,$5,$6,$7)
+		ON CONFLICT (guild_id, vote_id, user_id) DO UPDATE SET vote = $4, signals = $5, cast_at = $6, released = $7`,
+		ballot.Guild, ballot.Vote, ballot.User, ballot.Pro, joinSignals(ballot.Signals), ballot.Cast, ballot.Released,
+	)
+	if err != nil {
+		s.log.Error("error quarantining ballot", zap.String("guild", ballot.Guild), zap.String("vote", ballot.Vote), zap.String("user", ballot.User), zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// GetQuarantinedBallot of user on vote
+func (s *SQLStore) GetQuarantinedBallot(guild, vote, user string) (QuarantinedBallot, error) {
+	s.log.Debug("fetching quarantined ballot", zap.String("guild", guild), zap.String("vote", vote), zap.String("user", user))
+	ballots, err := s.queryQuarantinedBallots("where guild_id = $1 and vote_id = $2 and user_id = $3", guild, vote, user)
+	if err != nil {
+		return QuarantinedBallot{}, err
+	}
+	if len(ballots) != 1 {
+		return QuarantinedBallot{}, ErrNotFound
+	}
+	return ballots[0], nil
+}
+
+// ReadQuarantinedBallots of vote ordered by user
+func (s *SQLStore) ReadQuarantinedBallots(guild, vote string) ([]QuarantinedBallot, error) {
+	s.log.Info("fetching quarantined ballots", zap.String("guild", guild), zap.String("vote", vote))
+	return s.queryQuarantinedBallots("where guild_id = $1 and vote_id = $2", guild, vote)
+}
+
+func (s *SQLStore) queryQuarantinedBallots(where string, args ...interface{}) ([]QuarantinedBallot, error) {
+	ballots := []QuarantinedBallot{}
+	rows, err := s.query("select guild_id, vote_id, user_id, vote, signals, cast_at, released from quarantined_ballots "+where+" order by user_id", args...)
+	if err != nil {
+		s.log.Error("error querying quarantined ballots", zap.Error(err))
+		return ballots, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var ballot QuarantinedBallot
+		var signals string
+		err := rows.Scan(&ballot.Guild, &ballot.Vote, &ballot.User, &ballot.Pro, &signals, &ballot.Cast, &ballot.Released)
+		if err != nil {
+			s.log.Error("could not scan row", zap.Error(err))
+			continue
+		}
+		ballot.Signals = splitSignals(signals)
+		ballots = append(ballots, ballot)
+	}
+	err = rows.Err()
+	if err != nil {
+		s.log.Error("error reading rows", zap.Error(err))
+		return ballots, err
+	}
+	return ballots, nil
+}
+
+// DeleteQuarantinedBallot returning false if it was deleted before
+func (s *SQLStore) DeleteQuarantinedBallot(ballot QuarantinedBallot) (bool, error) {
+	s.log.Info("deleting quarantined ballot", zap.String("guild", ballot.Guild), zap.String("vote", ballot.Vote), zap.String("user", ballot.User))
+	res, err := s.exec("DELETE FROM quarantined_ballots WHERE guild_id = $1 AND vote_id = $2 AND user_id = $3", ballot.Guild, ballot.Vote, ballot.User)
+	if err != nil {
+		s.log.Error("error deleting quarantined ballot", zap.String("guild", ballot.Guild), zap.String("vote", ballot.Vote), zap.String("user", ballot.User), zap.Error(err))
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// joinSignals of a quarantined ballot into a comma separated column
+func joinSignals(signals []Signal) string {
+	var s []string
+	for _, signal := range signals {
+		s = append(s, string(signal))
+	}
+	return strings.Join(s, ",")
+}
+
+// splitSignals of a quarantined ballot from its comma separated column
+func splitSignals(signals string) []Signal {
+	if signals == "" {
+		return nil
+	}
+	var s []Signal
+	for _, signal := range strings.Split(signals, ",") {
+		s = append(s, Signal(signal))
+	}
+	return s
+}
@@ -0,0 +1,205 @@
+package votes
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// testPostgres connects to the database at TEST_DB_HOST and migrates it from scratch, dropping all data.
+// TEST_DB_NAME, TEST_DB_USER and TEST_DB_PASSWORD default like the flags of the bot.
+// Tests run against PostgreSQL only if TEST_DB_HOST is set, the database should be a throwaway one.
+// done closes the store.
+func testPostgres(t *testing.T) (store *SQLStore, done func()) {
+	host := os.Getenv("TEST_DB_HOST")
+	if host == "" {
+		t.Skip("TEST_DB_HOST not set")
+	}
+	env := func(name, fallback string) string {
+		if value := os.Getenv(name); value != "" {
+			return value
+		}
+		return fallback
+	}
+	store, err := NewPostgresStore(zap.NewNop(), host, env("TEST_DB_NAME", "db"), env("TEST_DB_USER", "db"), env("TEST_DB_PASSWORD", "dev"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range migrations {
+		err = store.MigrateDown()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = store.Migrate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store, func() { store.db.Close() }
+}
+
+// testSQLite creates a migrated SQLite database in a temporary directory, removed by done.
+// Tests run against SQLite only if it is compiled in with the sqlite build tag.
+func testSQLite(t *testing.T) (store *SQLStore, done func()) {
+	if !sqliteAvailable() {
+		t.Skip("sqlite support not compiled in, test with '-tags sqlite'")
+	}
+	dir, err := ioutil.TempDir("", "democracy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err = NewSQLiteStore(zap.NewNop(), filepath.Join(dir, "votes.db"))
+	if err == nil {
+		err = store.Migrate()
+	}
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return store, func() {
+		store.db.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+// testSQLStores running test against SQLite and PostgreSQL, as far as they are available
+func testSQLStores(t *testing.T, test func(t *testing.T, store *SQLStore)) {
+	for _, backend := range []struct {
+		name string
+		open func(t *testing.T) (*SQLStore, func())
+	}{
+		{"sqlite", testSQLite},
+		{"postgres", testPostgres},
+	} {
+		open := backend.open
+		t.Run(backend.name, func(t *testing.T) {
+			store, done := open(t)
+			defer done()
+			test(t, store)
+		})
+	}
+}
+
+func TestMigrationVersions(t *testing.T) {
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			t.Errorf("expected migration %q to be version %d, got %d", m.Name, i+1, m.Version)
+		}
+		if strings.TrimSpace(m.Up) == "" || strings.TrimSpace(m.Down) == "" {
+			t.Errorf("expected migration %d to have up and down statements", m.Version)
+		}
+	}
+}
+
+func TestSQLStoreMigrations(t *testing.T) {
+	testSQLStores(t, func(t *testing.T, store *SQLStore) {
+
+		// every migration is reverted and applied again twice, so a down that leaves anything behind fails the next up
+		for round := 0; round < 2; round++ {
+			for range migrations {
+				err := store.MigrateDown()
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			states, err := store.MigrationStatus()
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, state := range states {
+				if !state.Applied.IsZero() {
+					t.Fatalf("expected migration %d to be reverted", state.Version)
+				}
+			}
+			err = store.Migrate()
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		states, err := store.MigrationStatus()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, state := range states {
+			if state.Applied.IsZero() {
+				t.Errorf("expected migration %d to be applied", state.Version)
+			}
+		}
+
+		// votes keep the time of day and texts longer than 50 characters
+		now := time.Date(2018, 3, 1, 12, 34, 56, 0, time.UTC)
+		title := strings.Repeat("t", 100)
+		vote, err := store.InsertVote(Vote{Guild: "migrations", ID: "1", Title: title, Description: strings.Repeat("d", 500), Author: "a", Created: now, Expires: now.Add(time.Hour)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer store.DeleteVote(vote)
+		got, err := store.GetVoteByID(vote.Guild, vote.ID)
+		if err != nil || got.Title != title || !got.Created.Equal(now) || !got.Expires.Equal(now.Add(time.Hour)) {
+			t.Errorf("expected the stored vote to be unchanged, got %q %s %s (%v)", got.Title, got.Created, got.Expires, err)
+		}
+	})
+}
+
+func TestSQLStoreConcurrentBallots(t *testing.T) {
+	testSQLStores(t, func(t *testing.T, store *SQLStore) {
+
+		now := time.Now().UTC().Truncate(time.Second)
+		guild := fmt.Sprintf("test-%d", now.UnixNano())
+		vote, err := store.InsertVote(Vote{Guild: guild, ID: guild, Title: "t", Author: "a", Created: now, Expires: now.Add(time.Hour)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer store.DeleteVote(vote)
+
+		// every member changes their ballot, the even ones retract it in the end
+		const members = 20
+		var wg sync.WaitGroup
+		errs := make(chan error, 3*members)
+		for i := 0; i < members; i++ {
+			wg.Add(1)
+			go func(author string, retract bool) {
+				defer wg.Done()
+				_, _, err := store.RecordBallot(vote, author, true)
+				errs <- err
+				_, _, err = store.RecordBallot(vote, author, false)
+				errs <- err
+				if retract {
+					_, _, err = store.RetractBallot(vote, author)
+					errs <- err
+				}
+			}(fmt.Sprintf("member-%d", i), i%2 == 0)
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		vote, err = store.GetVoteCount(vote)
+		if err != nil || vote.Pro != 0 || vote.Con != members/2 {
+			t.Errorf("expected 0:%d, got %d:%d (%v)", members/2, vote.Pro, vote.Con, err)
+		}
+		voters, err := store.ReadVoters(vote)
+		if err != nil || len(voters) != members/2 {
+			t.Errorf("expected %d stored ballots, got %d (%v)", members/2, len(voters), err)
+		}
+
+		err = store.DeleteVote(vote)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if voters, err := store.ReadVoters(vote); err != nil || len(voters) != 0 {
+			t.Errorf("expected the ballots to be deleted with the vote, got %d (%v)", len(voters), err)
+		}
+	})
+}
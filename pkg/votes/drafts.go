@@ -0,0 +1,206 @@
+package votes
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/pkg/errors"
+	"github.com/playnet-public/democracy.bot/pkg/helpers"
+	"go.uber.org/zap"
+)
+
+// DraftDuration a draft waits for cosponsors before it is dropped
+const DraftDuration = 3 * day
+
+// cosponsorEmoji members react with to cosponsor a draft
+const cosponsorEmoji = "👍"
+
+// ErrDrafted is returned by Propose if the guild requires cosponsors, the vote was posted as a draft
+var ErrDrafted = errors.New("vote posted as draft, waiting for cosponsors")
+
+// Draft of a vote waiting for the cosponsors required by the rules of its guild.
+// The ID of a draft is the ID of its message.
+type Draft struct {
+	Guild       string
+	ID          string
+	Channel     string
+	Author      string
+	Title       string
+	Description string
+	Duration    time.Duration
+	Tags        []string
+	Created     time.Time
+	// Nominee, Role, Term and Distrust of the election the draft opens, see Vote
+	Nominee  string
+	Role     string
+	Term     time.Duration
+	Distrust string
+}
+
+// vote opened from the draft at now
+func (d Draft) vote(now time.Time) Vote {
+	return Vote{
+		Guild:       d.Guild,
+		Title:       d.Title,
+		Description: d.Description,
+		Author:      d.Author,
+		Created:     now,
+		Expires:     now.Add(d.Duration),
+		Tags:        d.Tags,
+		Nominee:     d.Nominee,
+		Role:        d.Role,
+		Term:        d.Term,
+		Distrust:    d.Distrust,
+	}
+}
+
+// postDraft of vote to channel, opening it once cosponsors members reacted
+func (v *VoteHandler) postDraft(s Session, channel string, vote Vote, cosponsors int) (Draft, error) {
+	draft := Draft{
+		Guild:       vote.Guild,
+		Channel:     channel,
+		Author:      vote.Author,
+		Title:       vote.Title,
+		Description: vote.Description,
+		Duration:    vote.Expires.Sub(vote.Created),
+		Tags:        vote.Tags,
+		Created:     vote.Created,
+		Nominee:     vote.Nominee,
+		Role:        vote.Role,
+		Term:        vote.Term,
+		Distrust:    vote.Distrust,
+	}
+	author, err := v.authors.get(s, vote.Author)
+	if err != nil {
+		return draft, errors.Wrap(err, "could not fetch author")
+	}
+	msg, err := v.discord(s).ChannelMessageSendEmbed(channel, newDraftEmbed(draft, author, 0, cosponsors))
+	if err != nil {
+		return draft, errors.Wrap(err, "unable to send embed")
+	}
+	err = v.discord(s).MessageReactionAdd(channel, msg.ID, cosponsorEmoji)
+	if err != nil {
+		v.discord(s).Delete(channel, msg.ID)
+		return draft, errors.Wrap(err, "unable to add emoji")
+	}
+	draft.ID = msg.ID
+	err = v.store.InsertDraft(draft)
+	if err != nil {
+		v.discord(s).Delete(channel, msg.ID)
+		return draft, errors.Wrap(err, "unable to store draft")
+	}
+	v.log.Info("vote drafted", zap.String("guild", draft.Guild), zap.String("draft", draft.ID), zap.String("author", draft.Author))
+	return draft, nil
+}
+
+// Cosponsor Reaction Handler opening a draft once enough members reacted
+func (v *VoteHandler) Cosponsor(c *discordgo.Channel, s Session, m *discordgo.MessageReactionAdd) error {
+	if m.Emoji.Name != cosponsorEmoji {
+		return nil
+	}
+	draft, err := v.db(s).GetDraft(c.GuildID, m.MessageID)
+	if err != nil {
+		return lookupError("unable to fetch draft", "", err)
+	}
+	if m.UserID == draft.Author {
+		v.discord(s).RemoveReaction(m.ChannelID, m.MessageID, m.Emoji.Name, m.UserID)
+		return userError("authors may not cosponsor their own draft", "")
+	}
+	rules, err := v.db(s).GetRules(draft.Guild)
+	if err != nil {
+		return internalError("unable to read rules", "", err)
+	}
+	users, err := s.MessageReactions(draft.Channel, draft.ID, cosponsorEmoji, reconcileReactionLimit)
+	if err != nil {
+		return internalError("unable to read cosponsors", "", err)
+	}
+	n := countCosponsors(users, draft.Author, s.BotUser().ID)
+	if n < rules.Cosponsors {
+		author, err := v.authors.get(s, draft.Author)
+		if err != nil {
+			return internalError("could not fetch author", "", err)
+		}
+		v.discord(s).Edit(draft.Channel, draft.ID, newDraftEmbed(draft, author, n, rules.Cosponsors))
+		return nil
+	}
+	return v.openDraft(s, c.ID, draft)
+}
+
+// countCosponsors among the users who reacted, ignoring the author and the bot
+func countCosponsors(users []*discordgo.User, author, bot string) int {
+	n := 0
+	for _, user := range users {
+		if user.ID != author && user.ID != bot && !user.Bot {
+			n++
+		}
+	}
+	return n
+}
+
+// openDraft as a live vote in channel, unless it was opened or dropped in the meantime
+func (v *VoteHandler) openDraft(s Session, channel string, draft Draft) error {
+	deleted, err := v.store.DeleteDraft(draft)
+	if err != nil {
+		return internalError("unable to delete draft", "", err)
+	}
+	if !deleted {
+		return nil
+	}
+	// the draft no longer counts, but other votes may have been opened while it waited
+	vote := draft.vote(v.clock.Now())
+	err = v.checkVoteCreation(vote.Guild, vote.Author, vote.Title, vote.Description)
+	if err != nil {
+		v.discord(s).Delete(draft.Channel, draft.ID)
+		// reactions are not replied to, so the author is told in the channel
+		_, sendErr := v.discord(s).ChannelMessageSend(draft.Channel, fmt.Sprintf("<@%s> the draft '%s' was dropped instead of opened: %s", draft.Author, draft.Title, err))
+		if sendErr != nil {
+			return internalError("unable to report dropped draft", "", sendErr)
+		}
+		return userError("draft rejected by rules", "")
+	}
+	vote, err = v.openVote(s, channel, vote)
+	if err != nil {
+		return internalError("unable to open draft", "", err)
+	}
+	v.discord(s).Delete(draft.Channel, draft.ID)
+	v.log.Info("draft opened", zap.String("guild", draft.Guild), zap.String("draft", draft.ID), zap.String("vote", vote.ID))
+	return nil
+}
+
+// expireDrafts waiting for cosponsors for longer than DraftDuration
+func (v *VoteHandler) expireDrafts(s Session, now time.Time) {
+	drafts, err := v.store.ReadExpiredDrafts(now.Add(-DraftDuration))
+	if err != nil {
+		v.log.Error("unable to read expired drafts", zap.Error(err))
+		return
+	}
+	for _, draft := range drafts {
+		deleted, err := v.store.DeleteDraft(draft)
+		if err != nil {
+			v.log.Error("unable to delete draft", zap.String("guild", draft.Guild), zap.String("draft", draft.ID), zap.Error(err))
+			continue
+		}
+		if deleted {
+			v.log.Info("draft expired", zap.String("guild", draft.Guild), zap.String("draft", draft.ID))
+			v.discord(s).Delete(draft.Channel, draft.ID)
+		}
+	}
+}
+
+func newDraftEmbed(draft Draft, author *discordgo.User, cosponsors, needed int) *discordgo.MessageEmbed {
+	embed := helpers.NewEmbed().
+		SetTitle(fmt.Sprintf("[Draft] %s", draft.Title)).
+		SetAuthor(author.Username, author.AvatarURL("100x100")).
+		SetColor(0x9b9b9b).
+		SetDescription(draft.Description).
+		SetTimestamp(draft.Created).
+		AddField("Duration", draft.Duration.String(), true).
+		AddField("Cosponsors", fmt.Sprintf(":thumbsup: [ %d / %d ]", cosponsors, needed), true).
+		AddField("Cosponsor", fmt.Sprintf("Press :thumbsup: to support this draft. It opens as a vote once %d members support it and is dropped after %d days.", needed, int(DraftDuration/day)), false)
+	if len(draft.Tags) > 0 {
+		embed.AddField("Tags", strings.Join(draft.Tags, ", "), false)
+	}
+	return embed.MessageEmbed
+}
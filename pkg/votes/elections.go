@@ -0,0 +1,198 @@
+package votes
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Nominate Message Handler starting a vote electing a member into a role for a term
+func (v *VoteHandler) Nominate(c *discordgo.Channel, s Session, m *discordgo.MessageCreate) error {
+	if m.Content == "reset_handler" {
+		return nil
+	}
+
+	args := strings.Split(strings.TrimPrefix(m.Content, "nominate "), "|")
+	if len(args) < 3 {
+		return userError("invalid nomination", "Invalid nomination. Please follow this schema: '!democracy nominate [@user]|[role]|[term]|[duration]'")
+	}
+	role := strings.TrimSpace(args[1])
+	if role == "" {
+		return userError("invalid nomination", "Invalid nomination. Please name the role to elect for.")
+	}
+	term, err := ParseDuration(strings.TrimSpace(args[2]))
+	if err != nil {
+		return userError("invalid term", err.Error())
+	}
+	nominee, err := guildMember(s, c.GuildID, args[0])
+	if err != nil {
+		return lookupError("nominee not found", "Only members of this server can be nominated.", err)
+	}
+	rules, err := v.db(s).GetRules(c.GuildID)
+	if err != nil {
+		return internalError("unable to read rules", "Failed to read rules from DB. Please contact support.", err)
+	}
+	terms, err := v.db(s).ReadTerms(c.GuildID, nominee.ID)
+	if err != nil {
+		return internalError("unable to read terms", "Failed to read terms from DB. Please contact support.", err)
+	}
+	err = rules.CheckTerms(terms, v.clock.Now())
+	if err != nil {
+		return userError("nomination rejected by rules", err.Error())
+	}
+
+	vote := Vote{
+		Title:       fmt.Sprintf("Elect %s as %s", nominee.Username, role),
+		Description: fmt.Sprintf("Elect <@%s> as %s for %s?", nominee.ID, role, term),
+		Nominee:     nominee.ID,
+		Role:        role,
+		Term:        term,
+	}
+	return v.openElection(c, s, m, vote, args[3:])
+}
+
+// Distrust Message Handler starting a vote on ending the running terms of a member
+func (v *VoteHandler) Distrust(c *discordgo.Channel, s Session, m *discordgo.MessageCreate) error {
+	if m.Content == "reset_handler" {
+		return nil
+	}
+
+	args := strings.Split(strings.TrimPrefix(m.Content, "distrust "), "|")
+	if len(args) < 2 {
+		return userError("invalid distrust vote", "Invalid distrust vote. Please follow this schema: '!democracy distrust [@user]|[reason]|[duration]'")
+	}
+	target, err := guildMember(s, c.GuildID, args[0])
+	if err != nil {
+		return lookupError("member not found", "Only members of this server can be distrusted.", err)
+	}
+	now := v.clock.Now()
+	terms, err := v.db(s).ReadTerms(c.GuildID, target.ID)
+	if err != nil {
+		return internalError("unable to read terms", "Failed to read terms from DB. Please contact support.", err)
+	}
+	var roles []string
+	for _, term := range terms {
+		if term.End.After(now) {
+			roles = append(roles, term.Role)
+		}
+	}
+	if len(roles) == 0 {
+		return userErrorf("no running term", "<@%s> holds no elected role.", target.ID)
+	}
+	err = v.checkDistrust(c.GuildID, target.ID)
+	if err != nil {
+		return userError("distrust vote rejected by rules", err.Error())
+	}
+
+	vote := Vote{
+		Title:       fmt.Sprintf("Distrust %s as %s", target.Username, strings.Join(roles, ", ")),
+		Description: fmt.Sprintf("End the terms of <@%s> as %s? Reason: %s", target.ID, strings.Join(roles, ", "), strings.TrimSpace(args[1])),
+		Distrust:    target.ID,
+	}
+	return v.openElection(c, s, m, vote, args[2:])
+}
+
+// checkDistrust of target against the cooldown after failed distrust votes against them
+func (v *VoteHandler) checkDistrust(guild, target string) error {
+	rules, err := v.store.GetRules(guild)
+	if err != nil {
+		return err
+	}
+	if rules.DistrustCooldown <= 0 {
+		return nil
+	}
+	rejected, err := v.store.ReadVotes(guild, VoteFilter{Status: StatusRejected})
+	if err != nil {
+		return err
+	}
+	var failed []Vote
+	for _, vote := range rejected {
+		if vote.Distrust == target {
+			failed = append(failed, vote)
+		}
+	}
+	return rules.CheckDistrust(failed, v.clock.Now())
+}
+
+// openElection vote in the channel of m, created like any other vote.
+// args optionally holds the duration of the vote.
+func (v *VoteHandler) openElection(c *discordgo.Channel, s Session, m *discordgo.MessageCreate, vote Vote, args []string) error {
+	rules, err := v.db(s).GetRules(c.GuildID)
+	if err != nil {
+		return internalError("unable to read rules", "Failed to read rules from DB. Please contact support.", err)
+	}
+	duration := ""
+	if len(args) > 0 {
+		duration = args[0]
+	}
+	d, err := rules.VoteDuration(duration)
+	if err != nil {
+		return userError("invalid duration", err.Error())
+	}
+
+	now := v.clock.Now()
+	vote.Guild = c.GuildID
+	vote.Author = m.Author.ID
+	vote.Created = now
+	vote.Expires = now.Add(d)
+	vote, err = v.createVote(s, c.ID, vote)
+	if err == ErrDrafted {
+		v.discord(s).Delete(m.ChannelID, m.ID)
+		return nil
+	}
+	if err != nil {
+		return createVoteError(err)
+	}
+	return v.replyVote(s, m, vote)
+}
+
+// elect the nominee of an accepted vote, recording their term from now on
+func (v *VoteHandler) elect(s Session, vote Vote, now time.Time) error {
+	err := v.store.InsertTerm(Term{
+		Guild: vote.Guild,
+		User:  vote.Nominee,
+		Role:  vote.Role,
+		Start: now,
+		End:   now.Add(vote.Term),
+	})
+	if err != nil {
+		return err
+	}
+	v.events.Publish(ActionExecuted{
+		voteEvent: v.voteEvent(s, vote),
+		Action:    "elect",
+		Summary:   fmt.Sprintf("elected <@%s> as %s until %s", vote.Nominee, vote.Role, now.Add(vote.Term).UTC().Format("02-01-2006 - 15:04:05")),
+	})
+	return nil
+}
+
+// endTerms of the member distrusted by an accepted vote
+func (v *VoteHandler) endTerms(s Session, vote Vote, now time.Time) error {
+	err := v.store.EndTerms(vote.Guild, vote.Distrust, now)
+	if err != nil {
+		return err
+	}
+	v.events.Publish(ActionExecuted{
+		voteEvent: v.voteEvent(s, vote),
+		Action:    "distrust",
+		Summary:   fmt.Sprintf("ended the terms of <@%s>", vote.Distrust),
+	})
+	return nil
+}
+
+// guildMember mentioned like <@42> or named by ID, excluding bots
+func guildMember(s Session, guild, mention string) (*discordgo.User, error) {
+	id := strings.TrimSuffix(strings.TrimLeft(strings.TrimSpace(mention), "<@!"), ">")
+	g, err := s.StateGuild(guild)
+	if err != nil {
+		return nil, err
+	}
+	for _, member := range g.Members {
+		if member.User != nil && member.User.ID == id && !member.User.Bot {
+			return member.User, nil
+		}
+	}
+	return nil, ErrNotFound
+}
@@ -2,7 +2,7 @@ package votes
 
 import (
 	"fmt"
-	"time"
+	"strings"
 
 	"github.com/playnet-public/democracy.bot/pkg/helpers"
 
@@ -10,26 +10,46 @@ import (
 	"github.com/pkg/errors"
 )
 
-func newVoteEmbed(title, description string, author *discordgo.User) *discordgo.MessageEmbed {
-
+func newVoteEmbed(vote Vote, author *discordgo.User) *discordgo.MessageEmbed {
 	embed := helpers.NewEmbed().
-		SetTitle(fmt.Sprintf("[Vote] %s", title)).
+		SetTitle(fmt.Sprintf("[Vote] %s", vote.Title)).
 		SetAuthor(author.Username, author.AvatarURL("100x100")).
 		SetColor(0x587987).
-		SetDescription(description).
-		SetTimestamp(time.Now()).
+		SetDescription(vote.Description).
+		SetTimestamp(vote.Created).
 		AddField(
 			"Due Date",
-			fmt.Sprintf("%s", time.Now().AddDate(0, 0, 3).UTC().Format("02-01-2006 - 15:04:05")),
+			fmt.Sprintf("%s", vote.Expires.UTC().Format("02-01-2006 - 15:04:05")),
 			false,
 		).
-		AddField("Pro", ":white_check_mark:", true).
-		AddField("Con", ":negative_squared_cross_mark:", true)
+		AddField("Pro", fmt.Sprintf(":white_check_mark: [ %d ]", vote.Pro), true).
+		AddField("Con", fmt.Sprintf(":negative_squared_cross_mark: [ %d ]", vote.Con), true)
+	// votes are only numbered once they are stored
+	if vote.Number > 0 {
+		embed.SetFooter(fmt.Sprintf("Vote #%d", vote.Number))
+	}
+	if vote.Discussion != "" {
+		embed.AddField("Discussion", fmt.Sprintf("<#%s>", vote.Discussion), true).
+			AddField("Revision", fmt.Sprintf("%d", vote.Revision), true)
+	}
+	if len(vote.Tags) > 0 {
+		embed.AddField("Tags", strings.Join(vote.Tags, ", "), false)
+	}
+	if vote.Extends != "" {
+		embed.AddField("Extension", fmt.Sprintf("+%s for vote %s", vote.Extension, vote.Extends), false)
+	}
+	if vote.Closed {
+		result := "Rejected"
+		if vote.Accepted() {
+			result = "Accepted"
+		}
+		embed.AddField("Result", result, false)
+	}
 	return embed.MessageEmbed
 }
 
-func newVoteSuccessEmbed(s *discordgo.Session, c string, desc string, author *discordgo.User) error {
-	feedbackEmbed, err := s.ChannelMessageSendEmbed(c, &discordgo.MessageEmbed{
+func newVoteSuccessEmbed(d discordWriter, c string, desc string, author *discordgo.User) (*discordgo.Message, error) {
+	feedbackEmbed, err := d.ChannelMessageSendEmbed(c, &discordgo.MessageEmbed{
 		Title:       "Vote created",
 		Description: desc,
 		Author: &discordgo.MessageEmbedAuthor{
@@ -49,17 +69,20 @@ func newVoteSuccessEmbed(s *discordgo.Session, c string, desc string, author *di
 			},
 		},
 	})
-	err = s.MessageReactionAdd(c, feedbackEmbed.ID, "↩")
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to send embed")
+	}
+	err = d.MessageReactionAdd(c, feedbackEmbed.ID, "↩")
 	if err != nil {
 		err = errors.Wrap(err, "unable to add emoji")
-		s.ChannelMessageDelete(c, feedbackEmbed.ID)
-		return err
+		d.Delete(c, feedbackEmbed.ID)
+		return nil, err
 	}
-	return nil
+	return feedbackEmbed, nil
 }
 
-func newVoteFailedEmbed(s *discordgo.Session, c string, err string, author *discordgo.User) error {
-	_, e := s.ChannelMessageSendEmbed(c, &discordgo.MessageEmbed{
+func newVoteFailedEmbed(d discordWriter, c string, err string, author *discordgo.User) error {
+	_, e := d.ChannelMessageSendEmbed(c, &discordgo.MessageEmbed{
 		Title: "Vote failed",
 		Author: &discordgo.MessageEmbedAuthor{
 			Name:    author.ID,
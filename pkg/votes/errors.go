@@ -0,0 +1,198 @@
+package votes
+
+import (
+	"fmt"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// supportReply shown for internal failures without a more specific reply
+const supportReply = "Something went wrong. Please contact support."
+
+// ErrorClass of a handler error, deciding how it is logged and reported
+type ErrorClass int
+
+const (
+	// ClassInternal failures of Discord or the store, logged as errors and reported to Sentry
+	ClassInternal ErrorClass = iota
+	// ClassUser errors like invalid commands or votes rejected by the rules
+	ClassUser
+	// ClassPermission errors of members not allowed to do what they tried
+	ClassPermission
+)
+
+func (c ErrorClass) String() string {
+	switch c {
+	case ClassUser:
+		return "user"
+	case ClassPermission:
+		return "permission"
+	}
+	return "internal"
+}
+
+// Error returned by handlers, carrying the reply shown to the member and the context of the failure
+type Error struct {
+	Class ErrorClass
+	// Op that failed, like "unable to store vote"
+	Op string
+	// Reply shown to the member
+	Reply string
+	// Err causing the failure, if any
+	Err error
+	// Vote the failure happened to, if any
+	Vote string
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return e.Op + ": " + e.Err.Error()
+	}
+	return e.Op
+}
+
+// Cause of e, so errors.Cause finds the original error
+func (e *Error) Cause() error {
+	return e.Err
+}
+
+// rateLimited reports whether e rejected a request by the RateLimit middleware
+func (e *Error) rateLimited() bool {
+	return e.Op == opUserRateLimited || e.Op == opGuildRateLimited
+}
+
+// forVote the failure happened to
+func (e *Error) forVote(vote Vote) *Error {
+	e.Vote = vote.ID
+	return e
+}
+
+// userError of op replying reply
+func userError(op, reply string) *Error {
+	return &Error{Class: ClassUser, Op: op, Reply: reply}
+}
+
+// userErrorf of op replying the formatted reply
+func userErrorf(op, format string, args ...interface{}) *Error {
+	return userError(op, fmt.Sprintf(format, args...))
+}
+
+// permissionError replying reply
+func permissionError(reply string) *Error {
+	return &Error{Class: ClassPermission, Op: "permission denied", Reply: reply}
+}
+
+// internalError of op caused by err, replying reply or asking to contact support if it is empty
+func internalError(op, reply string, err error) *Error {
+	if reply == "" {
+		reply = supportReply
+	}
+	return &Error{Class: ClassInternal, Op: op, Reply: reply, Err: err}
+}
+
+// lookupError of op failing with err, a user error replying reply if nothing was found and an internal failure otherwise
+func lookupError(op, reply string, err error) *Error {
+	if errors.Cause(err) == ErrNotFound {
+		return userError(op, reply)
+	}
+	return internalError(op, "", err)
+}
+
+// asError finding the Error among the causes of err, all other errors are internal failures
+func asError(err error) *Error {
+	for cause := err; cause != nil; {
+		if e, ok := cause.(*Error); ok {
+			return e
+		}
+		causer, ok := cause.(interface {
+			Cause() error
+		})
+		if !ok {
+			break
+		}
+		cause = causer.Cause()
+	}
+	return internalError("unexpected error", "", err)
+}
+
+// Reporter of internal failures to an error tracker like Sentry
+type Reporter interface {
+	Report(err error, tags map[string]string)
+}
+
+// ReporterFunc reporting internal failures by calling itself
+type ReporterFunc func(err error, tags map[string]string)
+
+// Report err with tags
+func (f ReporterFunc) Report(err error, tags map[string]string) {
+	f(err, tags)
+}
+
+// SetReporter of internal failures, without one they are only logged
+func (b *Bot) SetReporter(r Reporter) {
+	b.reporter = r
+}
+
+// commandFailed replying the failure of the handler of the command r to its author and removing the command
+func (b *Bot) commandFailed(r *Request, err error) {
+	m := r.Message
+	e := b.handlerFailed(r.Log, r.Channel, m.Author.ID, r.Handler, err, zap.String("msg", m.Content))
+	if sendErr := newVoteFailedEmbed(b.discord(r.Session), m.ChannelID, e.Reply, m.Author); sendErr != nil {
+		r.Log.Error("unable to reply failure", zap.String("channel", m.ChannelID), zap.Error(sendErr))
+	}
+	b.discord(r.Session).Delete(m.ChannelID, m.ID)
+}
+
+// reactionFailed logging the failure of the handler of the reaction r, reactions are not replied to.
+// Rate limited reactions are removed and their member is told in a direct message to react again.
+// Rate limited ballots stay in place instead, the reconciler records them on its next pass.
+func (b *Bot) reactionFailed(r *Request, err error) {
+	m := r.Reaction
+	e := b.handlerFailed(r.Log, r.Channel, m.UserID, r.Handler, err, zap.String("message", m.MessageID), zap.String("emoji", m.Emoji.Name))
+	if !e.rateLimited() || r.ballot() {
+		return
+	}
+	b.discord(r.Session).RemoveReaction(m.ChannelID, m.MessageID, m.Emoji.Name, m.UserID)
+	dm, dmErr := b.discord(r.Session).UserChannelCreate(m.UserID)
+	if dmErr == nil {
+		_, dmErr = b.discord(r.Session).ChannelMessageSend(dm.ID, e.Reply+" Your reaction was removed, please react again.")
+	}
+	if dmErr != nil {
+		r.Log.Error("unable to tell rate limited member", zap.Error(dmErr))
+	}
+}
+
+// handlerFailed logging err of handler to the logger of its request by its class, reporting internal failures with their context
+func (b *Bot) handlerFailed(log *zap.Logger, c *discordgo.Channel, user, handler string, err error, extra ...zapcore.Field) *Error {
+	e := asError(err)
+	guild := ""
+	if c != nil {
+		guild = c.GuildID
+	}
+	// log is scoped to the guild, user and handler of the request already
+	fields := append([]zapcore.Field{
+		zap.String("class", e.Class.String()),
+		zap.String("vote", e.Vote),
+		zap.Error(err),
+	}, extra...)
+	switch e.Class {
+	case ClassUser:
+		log.Info(e.Op, fields...)
+	case ClassPermission:
+		log.Warn(e.Op, fields...)
+	default:
+		log.Error(e.Op, fields...)
+		if b.reporter != nil {
+			b.reporter.Report(err, map[string]string{
+				"guild":   guild,
+				"user":    user,
+				"handler": handler,
+				"vote":    e.Vote,
+			})
+		}
+	}
+	return e
+}
@@ -0,0 +1,360 @@
+package votes
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// EventKind of a governance event
+type EventKind string
+
+// EventKind values of the events published by the VoteHandler
+const (
+	EventVoteCreated          EventKind = "vote_created"
+	EventBallotCast           EventKind = "ballot_cast"
+	EventBallotChanged        EventKind = "ballot_changed"
+	EventBallotRetracted      EventKind = "ballot_retracted"
+	EventVoteClosed           EventKind = "vote_closed"
+	EventVoteDeleted          EventKind = "vote_deleted"
+	EventActionExecuted       EventKind = "action_executed"
+	EventElectionPhaseChanged EventKind = "election_phase_changed"
+)
+
+// Event published on the EventBus. The concrete types are the exported structs of this file.
+type Event interface {
+	Kind() EventKind
+	GuildID() string
+	// At is the time the event happened
+	At() time.Time
+	// Detail summarizing the event like 'pro' for a ballot or 'accepted 3:1' for a closed vote
+	Detail() string
+	// ActingKey is the name of the API key the event was caused through, empty for Discord
+	ActingKey() string
+}
+
+// VoteEvent happening to a vote, carrying the vote with its tally after the event
+type VoteEvent interface {
+	Event
+	EventVote() Vote
+}
+
+// eventBase of all events
+type eventBase struct {
+	Guild string
+	Time  time.Time
+	// Key of the API key acting for the user of the event, empty for Discord
+	Key string
+	// session the event happened on, for subscribers writing to Discord
+	session Session
+}
+
+func (e eventBase) GuildID() string   { return e.Guild }
+func (e eventBase) At() time.Time     { return e.Time }
+func (e eventBase) ActingKey() string { return e.Key }
+
+// voteEvent of all VoteEvents
+type voteEvent struct {
+	eventBase
+	Vote Vote
+}
+
+func (e voteEvent) EventVote() Vote { return e.Vote }
+
+// VoteCreated after a vote was posted and stored
+type VoteCreated struct{ voteEvent }
+
+// Kind of the event
+func (VoteCreated) Kind() EventKind { return EventVoteCreated }
+
+// Detail of the event
+func (VoteCreated) Detail() string { return "" }
+
+// BallotCast by a user without a previous ballot on the vote
+type BallotCast struct {
+	voteEvent
+	User string
+	Pro  bool
+}
+
+// Kind of the event
+func (BallotCast) Kind() EventKind { return EventBallotCast }
+
+// Detail of the event
+func (e BallotCast) Detail() string { return auditBallot(e.Pro) }
+
+// BallotChanged by a user replacing their previous ballot
+type BallotChanged struct {
+	voteEvent
+	User string
+	Pro  bool
+}
+
+// Kind of the event
+func (BallotChanged) Kind() EventKind { return EventBallotChanged }
+
+// Detail of the event
+func (e BallotChanged) Detail() string { return auditBallot(e.Pro) }
+
+// BallotRetracted by a user
+type BallotRetracted struct {
+	voteEvent
+	User string
+}
+
+// Kind of the event
+func (BallotRetracted) Kind() EventKind { return EventBallotRetracted }
+
+// Detail of the event
+func (BallotRetracted) Detail() string { return "" }
+
+// VoteClosed with its final tally
+type VoteClosed struct{ voteEvent }
+
+// Kind of the event
+func (VoteClosed) Kind() EventKind { return EventVoteClosed }
+
+// Detail of the event
+func (e VoteClosed) Detail() string { return auditOutcome(e.Vote) }
+
+// VoteDeleted by its author
+type VoteDeleted struct {
+	voteEvent
+	User string
+}
+
+// Kind of the event
+func (VoteDeleted) Kind() EventKind { return EventVoteDeleted }
+
+// Detail of the event
+func (VoteDeleted) Detail() string { return "" }
+
+// ActionExecuted as outcome of an accepted vote, like extending another vote or applying an amendment
+type ActionExecuted struct {
+	voteEvent
+	// Action like extend or amend
+	Action  string
+	Summary string
+}
+
+// Kind of the event
+func (ActionExecuted) Kind() EventKind { return EventActionExecuted }
+
+// Detail of the event
+func (e ActionExecuted) Detail() string { return e.Summary }
+
+// ElectionPhaseChanged when a proposal moves from discussion to voting
+type ElectionPhaseChanged struct {
+	eventBase
+	Proposal Proposal
+	Phase    string
+}
+
+// Kind of the event
+func (ElectionPhaseChanged) Kind() EventKind { return EventElectionPhaseChanged }
+
+// Detail of the event
+func (e ElectionPhaseChanged) Detail() string {
+	return fmt.Sprintf("%s: %s", e.Proposal.Title, e.Phase)
+}
+
+// EventHandler subscribed to the EventBus
+type EventHandler func(e Event)
+
+// namedHandler for logging failures of the subscriber
+type namedHandler struct {
+	name string
+	h    EventHandler
+}
+
+// asyncSubscriber running its handler on its own goroutine
+type asyncSubscriber struct {
+	// dropped is accessed atomically and first for its 64 bit alignment
+	dropped uint64
+	namedHandler
+	c chan Event
+	// onDrop is called with the events dropped while c is full, if set
+	onDrop EventHandler
+}
+
+// EventBus fanning out the events of all guilds to their subscribers.
+// Synchronous subscribers run in order of subscription before Publish returns,
+// asynchronous subscribers and Subscriptions never block the publisher.
+type EventBus struct {
+	log *zap.Logger
+
+	mu     sync.Mutex
+	inline []namedHandler
+	queued []*asyncSubscriber
+	subs   map[*Subscription]struct{}
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// Subscription to the events of a guild. Events are dropped rather than blocking the bot
+// while its buffer is full.
+type Subscription struct {
+	// dropped is accessed atomically and first for its 64 bit alignment
+	dropped uint64
+	// C receives the events until the subscription is closed
+	C     <-chan Event
+	c     chan Event
+	guild string
+	bus   *EventBus
+}
+
+// NewEventBus without subscribers
+func NewEventBus(log *zap.Logger) *EventBus {
+	return &EventBus{
+		log:  log,
+		subs: make(map[*Subscription]struct{}),
+	}
+}
+
+// SubscribeSync h to all events. It runs on the publishing goroutine, so it sees events
+// in order and may rely on the subscribers before it.
+func (b *EventBus) SubscribeSync(name string, h EventHandler) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.inline = append(b.inline, namedHandler{name: name, h: h})
+}
+
+// SubscribeAsync h to all events, running it on its own goroutine with a queue of buffer events
+func (b *EventBus) SubscribeAsync(name string, buffer int, h EventHandler) {
+	b.subscribeAsync(name, buffer, h, nil)
+}
+
+// subscribeAsync h like SubscribeAsync, calling onDrop on the publishing goroutine with every event
+// dropped while the queue is full
+func (b *EventBus) subscribeAsync(name string, buffer int, h, onDrop EventHandler) {
+	sub := &asyncSubscriber{namedHandler: namedHandler{name: name, h: h}, c: make(chan Event, buffer), onDrop: onDrop}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return
+	}
+	b.queued = append(b.queued, sub)
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		for e := range sub.c {
+			b.call(sub.namedHandler, e)
+		}
+	}()
+}
+
+// Subscribe to the events of guild, buffering up to buffer events
+func (b *EventBus) Subscribe(guild string, buffer int) *Subscription {
+	c := make(chan Event, buffer)
+	sub := &Subscription{C: c, c: c, guild: guild, bus: b}
+	b.mu.Lock()
+	b.subs[sub] = struct{}{}
+	b.mu.Unlock()
+	return sub
+}
+
+// Publish e to all subscribers. Asynchronous subscribers and Subscriptions receive it first,
+// so they see events published by synchronous subscribers in order as well.
+func (b *EventBus) Publish(e Event) {
+	var dropped []namedHandler
+	b.mu.Lock()
+	if !b.closed {
+		for _, sub := range b.queued {
+			select {
+			case sub.c <- e:
+			default:
+				if atomic.AddUint64(&sub.dropped, 1) == 1 {
+					b.log.Warn("dropping events of slow subscriber", zap.String("subscriber", sub.name))
+				}
+				if sub.onDrop != nil {
+					dropped = append(dropped, namedHandler{name: sub.name, h: sub.onDrop})
+				}
+			}
+		}
+		for sub := range b.subs {
+			if sub.guild != e.GuildID() {
+				continue
+			}
+			select {
+			case sub.c <- e:
+			default:
+				if atomic.AddUint64(&sub.dropped, 1) == 1 {
+					b.log.Warn("dropping events of slow subscription", zap.String("guild", e.GuildID()))
+				}
+			}
+		}
+	}
+	handlers := make([]namedHandler, len(b.inline))
+	copy(handlers, b.inline)
+	b.mu.Unlock()
+	// handlers may publish further events, so they run without holding the lock
+	for _, h := range dropped {
+		b.call(h, e)
+	}
+	for _, h := range handlers {
+		b.call(h, e)
+	}
+}
+
+// call h with e, a panicking subscriber must not take down the publisher
+func (b *EventBus) call(h namedHandler, e Event) {
+	defer func() {
+		if r := recover(); r != nil {
+			b.log.Error("event subscriber panicked", zap.String("subscriber", h.name), zap.String("event", string(e.Kind())), zap.Any("panic", r))
+		}
+	}()
+	h.h(e)
+}
+
+// Close the bus, waiting for the asynchronous subscribers to handle their queued events
+func (b *EventBus) Close() {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return
+	}
+	b.closed = true
+	for _, sub := range b.queued {
+		close(sub.c)
+	}
+	b.mu.Unlock()
+	b.wg.Wait()
+}
+
+// Close the subscription, closing C
+func (s *Subscription) Close() {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+	if _, ok := s.bus.subs[s]; !ok {
+		return
+	}
+	delete(s.bus.subs, s)
+	close(s.c)
+}
+
+// Dropped events of the subscription
+func (s *Subscription) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// Events published by the VoteHandler
+func (v *VoteHandler) Events() *EventBus {
+	return v.events
+}
+
+// voteEvent on vote happening now
+func (v *VoteHandler) voteEvent(s Session, vote Vote) voteEvent {
+	return voteEvent{eventBase: v.eventBase(s, vote.Guild), Vote: vote}
+}
+
+// eventBase in guild happening now
+func (v *VoteHandler) eventBase(s Session, guild string) eventBase {
+	base := eventBase{Guild: guild, Time: v.clock.Now(), session: s}
+	if ks, ok := s.(apiKeySession); ok {
+		base.Key = ks.key
+	}
+	return base
+}
@@ -0,0 +1,79 @@
+package votes
+
+import (
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestEventBusSubscription(t *testing.T) {
+	bus := NewEventBus(zap.NewNop())
+	sub := bus.Subscribe("g", 1)
+	other := bus.Subscribe("other", 1)
+	defer other.Close()
+
+	bus.Publish(VoteCreated{voteEvent{eventBase: eventBase{Guild: "g"}}})
+	bus.Publish(VoteClosed{voteEvent{eventBase: eventBase{Guild: "g"}}})
+	if e := <-sub.C; e.Kind() != EventVoteCreated {
+		t.Errorf("expected the first event, got %s", e.Kind())
+	}
+	if sub.Dropped() != 1 {
+		t.Errorf("expected the event beyond the buffer to be dropped, got %d", sub.Dropped())
+	}
+	if len(other.C) != 0 {
+		t.Error("expected events of other guilds to be filtered")
+	}
+
+	sub.Close()
+	sub.Close()
+	if _, ok := <-sub.C; ok {
+		t.Error("expected closed subscription to close its channel")
+	}
+	bus.Publish(VoteClosed{voteEvent{eventBase: eventBase{Guild: "g"}}})
+}
+
+func TestEventBusSubscribers(t *testing.T) {
+	bus := NewEventBus(zap.NewNop())
+	var inline []EventKind
+	bus.SubscribeSync("panics", func(e Event) { panic("broken subscriber") })
+	bus.SubscribeSync("closes", func(e Event) {
+		inline = append(inline, e.Kind())
+		// a ballot deciding the vote closes it from within the subscriber
+		if e.Kind() == EventBallotCast {
+			bus.Publish(VoteClosed{voteEvent{eventBase: eventBase{Guild: "g"}}})
+		}
+	})
+	var queued []EventKind
+	bus.SubscribeAsync("records", 10, func(e Event) { queued = append(queued, e.Kind()) })
+
+	bus.Publish(BallotCast{voteEvent: voteEvent{eventBase: eventBase{Guild: "g"}}, Pro: true})
+	if len(inline) != 2 || inline[0] != EventBallotCast || inline[1] != EventVoteClosed {
+		t.Errorf("expected synchronous subscribers to see both events despite the panic, got %v", inline)
+	}
+	bus.Close()
+	if len(queued) != 2 || queued[0] != EventBallotCast || queued[1] != EventVoteClosed {
+		t.Errorf("expected asynchronous subscribers to see the events in order, got %v", queued)
+	}
+	bus.Publish(BallotCast{voteEvent: voteEvent{eventBase: eventBase{Guild: "g"}}})
+}
+
+func TestEventBusDropped(t *testing.T) {
+	bus := NewEventBus(zap.NewNop())
+	block := make(chan struct{})
+	var dropped []EventKind
+	bus.subscribeAsync("blocked", 1, func(e Event) { <-block }, func(e Event) { dropped = append(dropped, e.Kind()) })
+
+	// the first event is taken by the blocked subscriber, the second fills its queue
+	bus.Publish(VoteCreated{voteEvent{eventBase: eventBase{Guild: "g"}}})
+	for len(bus.queued[0].c) != 0 {
+		time.Sleep(time.Millisecond)
+	}
+	bus.Publish(BallotCast{voteEvent: voteEvent{eventBase: eventBase{Guild: "g"}}})
+	bus.Publish(VoteClosed{voteEvent{eventBase: eventBase{Guild: "g"}}})
+	if len(dropped) != 1 || dropped[0] != EventVoteClosed {
+		t.Errorf("expected the event beyond the queue to be handed to onDrop, got %v", dropped)
+	}
+	close(block)
+	bus.Close()
+}
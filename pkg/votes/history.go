@@ -0,0 +1,320 @@
+package votes
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/pkg/errors"
+	"github.com/playnet-public/democracy.bot/pkg/helpers"
+)
+
+// historyPageSize of votes shown on one page of the history
+const historyPageSize = 10
+
+// historyDateFormat of the from and to filters
+const historyDateFormat = "2006-01-02"
+
+// VoteStatus to filter votes by
+type VoteStatus string
+
+// VoteStatus values, an empty status matches all votes
+const (
+	StatusAll      VoteStatus = ""
+	StatusOpen     VoteStatus = "open"
+	StatusClosed   VoteStatus = "closed"
+	StatusAccepted VoteStatus = "accepted"
+	StatusRejected VoteStatus = "rejected"
+)
+
+// VoteFilter selecting votes of a guild
+type VoteFilter struct {
+	Status VoteStatus
+	Author string
+	// After and Before bound the creation time, zero values are unbounded. Before is exclusive.
+	After  time.Time
+	Before time.Time
+	Tag    string
+	// Newest votes first instead of the oldest
+	Newest bool
+	// Offset and Limit for paging, a zero Limit returns all votes
+	Offset int
+	Limit  int
+}
+
+// ParseVoteFilter from arguments like accepted, author:@user, from:2018-03-01, to:2018-03-31 or tag:budget
+func ParseVoteFilter(args []string) (VoteFilter, error) {
+	var f VoteFilter
+	for _, arg := range args {
+		name, value := "status", arg
+		if i := strings.Index(arg, ":"); i > 0 {
+			name, value = arg[:i], arg[i+1:]
+		}
+		switch name {
+		case "status":
+			status := VoteStatus(strings.ToLower(value))
+			switch status {
+			case StatusOpen, StatusClosed, StatusAccepted, StatusRejected:
+				f.Status = status
+			case "all":
+				f.Status = StatusAll
+			default:
+				return f, errors.Errorf("unknown status '%s', use open, closed, accepted, rejected or all", value)
+			}
+		case "author":
+			f.Author = strings.TrimSuffix(strings.TrimLeft(value, "<@!"), ">")
+		case "from":
+			t, err := time.Parse(historyDateFormat, value)
+			if err != nil {
+				return f, errors.Errorf("invalid date '%s', use YYYY-MM-DD", value)
+			}
+			f.After = t
+		case "to":
+			t, err := time.Parse(historyDateFormat, value)
+			if err != nil {
+				return f, errors.Errorf("invalid date '%s', use YYYY-MM-DD", value)
+			}
+			f.Before = t.Add(day)
+		case "tag":
+			f.Tag = normalizeTag(value)
+		default:
+			return f, errors.Errorf("unknown filter '%s'", name)
+		}
+	}
+	return f, nil
+}
+
+// String of the filter as parsed by ParseVoteFilter
+func (f VoteFilter) String() string {
+	var args []string
+	if f.Status == StatusAll {
+		args = append(args, "all")
+	} else {
+		args = append(args, string(f.Status))
+	}
+	if f.Author != "" {
+		args = append(args, "author:"+f.Author)
+	}
+	if !f.After.IsZero() {
+		args = append(args, "from:"+f.After.Format(historyDateFormat))
+	}
+	if !f.Before.IsZero() {
+		args = append(args, "to:"+f.Before.Add(-day).Format(historyDateFormat))
+	}
+	if f.Tag != "" {
+		args = append(args, "tag:"+f.Tag)
+	}
+	return strings.Join(args, " ")
+}
+
+// match reports whether vote passes the filter, ignoring paging
+func (f VoteFilter) match(vote Vote) bool {
+	switch f.Status {
+	case StatusOpen:
+		if vote.Closed {
+			return false
+		}
+	case StatusClosed:
+		if !vote.Closed {
+			return false
+		}
+	case StatusAccepted:
+		if !vote.Closed || !vote.Accepted() {
+			return false
+		}
+	case StatusRejected:
+		if !vote.Closed || vote.Accepted() {
+			return false
+		}
+	}
+	if f.Author != "" && vote.Author != f.Author {
+		return false
+	}
+	if !f.After.IsZero() && vote.Created.Before(f.After) {
+		return false
+	}
+	if !f.Before.IsZero() && !vote.Created.Before(f.Before) {
+		return false
+	}
+	if f.Tag != "" {
+		for _, tag := range vote.Tags {
+			if tag == f.Tag {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// normalizeTag to lower case without separators
+func normalizeTag(tag string) string {
+	return strings.ToLower(strings.Trim(strings.TrimSpace(tag), "#,"))
+}
+
+// ParseTags from a comma separated list, lower cased
+func ParseTags(value string) []string {
+	var tags []string
+	for _, t := range strings.Split(value, ",") {
+		t = normalizeTag(t)
+		if t != "" {
+			tags = append(tags, t)
+		}
+	}
+	return tags
+}
+
+// History Message Handler listing past votes page by page
+func (v *VoteHandler) History(c *discordgo.Channel, s Session, m *discordgo.MessageCreate) error {
+	if m.Content == "reset_handler" {
+		return nil
+	}
+
+	args := strings.Fields(strings.TrimPrefix(m.Content, "history"))
+	page := 1
+	if len(args) > 0 {
+		if n, err := strconv.Atoi(args[0]); err == nil {
+			page = n
+			args = args[1:]
+		}
+	}
+	filter := VoteFilter{Status: StatusClosed}
+	if len(args) > 0 {
+		var err error
+		filter, err = ParseVoteFilter(args)
+		if err != nil {
+			return userError("invalid history filter", err.Error())
+		}
+	}
+
+	embed, pages, err := v.historyEmbed(c.GuildID, filter, page)
+	if err != nil {
+		return internalError("unable to read history", "Failed to read votes from DB. Please contact support.", err)
+	}
+	v.discord(s).Delete(m.ChannelID, m.ID)
+	msg, err := v.discord(s).ChannelMessageSendEmbed(m.ChannelID, embed)
+	if err != nil {
+		return internalError("unable to send history", "", err)
+	}
+	if pages < 2 {
+		return nil
+	}
+	for _, emoji := range []string{"◀", "▶"} {
+		err = v.discord(s).MessageReactionAdd(m.ChannelID, msg.ID, emoji)
+		if err != nil {
+			return internalError("unable to add emoji", "", err)
+		}
+	}
+	return nil
+}
+
+// HistoryReact Handler turning the pages of a history embed
+func (v *VoteHandler) HistoryReact(c *discordgo.Channel, s Session, m *discordgo.MessageReactionAdd) error {
+	var delta int
+	switch m.Emoji.Name {
+	case "◀":
+		delta = -1
+	case "▶":
+		delta = 1
+	default:
+		return nil
+	}
+	v.discord(s).RemoveReaction(m.ChannelID, m.MessageID, m.Emoji.Name, m.UserID)
+
+	msg, err := s.ChannelMessage(m.ChannelID, m.MessageID)
+	if err != nil {
+		return internalError("unable to find history embed", "", err)
+	}
+	if len(msg.Embeds) < 1 || msg.Embeds[0].Footer == nil {
+		return userError("not a history embed", "")
+	}
+	// the footer keeps the page and filter, so paging survives restarts
+	page, filter, err := parseHistoryFooter(msg.Embeds[0].Footer.Text)
+	if err != nil {
+		return userError("invalid history footer", err.Error())
+	}
+	embed, pages, err := v.historyEmbed(c.GuildID, filter, page+delta)
+	if err != nil {
+		return internalError("unable to read history", "", err)
+	}
+	if page+delta < 1 || page+delta > pages {
+		return nil
+	}
+	v.discord(s).Edit(m.ChannelID, m.MessageID, embed)
+	return nil
+}
+
+// historyEmbed showing page of the votes matching filter, the last page if page is beyond it
+func (v *VoteHandler) historyEmbed(guild string, filter VoteFilter, page int) (*discordgo.MessageEmbed, int, error) {
+	count, err := v.store.CountVotes(guild, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+	pages := (count + historyPageSize - 1) / historyPageSize
+	if pages < 1 {
+		pages = 1
+	}
+	if page > pages {
+		page = pages
+	}
+	if page < 1 {
+		page = 1
+	}
+	filter.Newest = true
+	filter.Offset = (page - 1) * historyPageSize
+	filter.Limit = historyPageSize
+	votes, err := v.store.ReadVotes(guild, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	embed := helpers.NewEmbed().
+		SetTitle("Vote History").
+		SetColor(0x587987).
+		SetFooter(fmt.Sprintf("Page %d/%d · %s", page, pages, filter))
+	if len(votes) == 0 {
+		embed.SetDescription("No votes found.")
+	}
+	for _, vote := range votes {
+		vote, err = v.store.GetVoteCount(vote)
+		if err != nil {
+			return nil, 0, err
+		}
+		embed.AddField(fmt.Sprintf("#%d %s", vote.Number, vote.Title), historyLine(vote), false)
+	}
+	return embed.MessageEmbed, pages, nil
+}
+
+// historyLine summarizing the outcome of vote
+func historyLine(vote Vote) string {
+	result := "Open"
+	switch {
+	case vote.Closed && vote.Accepted():
+		result = "Accepted"
+	case vote.Closed:
+		result = "Rejected"
+	}
+	line := fmt.Sprintf("%s %d:%d · by <@%s> · %s", result, vote.Pro, vote.Con, vote.Author, vote.Expires.UTC().Format("02-01-2006"))
+	if len(vote.Tags) > 0 {
+		line += " · " + strings.Join(vote.Tags, ", ")
+	}
+	return line
+}
+
+// parseHistoryFooter like 'Page 2/5 · accepted tag:budget'
+func parseHistoryFooter(footer string) (int, VoteFilter, error) {
+	parts := strings.SplitN(footer, " · ", 2)
+	var page, pages int
+	_, err := fmt.Sscanf(parts[0], "Page %d/%d", &page, &pages)
+	if err != nil {
+		return 0, VoteFilter{}, err
+	}
+	var args []string
+	if len(parts) > 1 {
+		args = strings.Fields(parts[1])
+	}
+	filter, err := ParseVoteFilter(args)
+	return page, filter, err
+}
@@ -0,0 +1,214 @@
+package votes
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// ExtensionVoteDuration is how long members may vote on extending a vote
+const ExtensionVoteDuration = day
+
+// closeVote ending the vote with its current tally
+func (v *VoteHandler) closeVote(s Session, vote Vote) error {
+	now := v.clock.Now()
+	vote.Closed = true
+	if vote.Expires.After(now) {
+		vote.Expires = now
+	}
+	err := v.store.CloseVote(vote)
+	if err != nil {
+		return err
+	}
+	v.events.Publish(VoteClosed{v.voteEvent(s, vote)})
+
+	if !vote.Accepted() {
+		return nil
+	}
+	switch {
+	case vote.Extends != "":
+		return v.extend(s, vote)
+	case vote.Nominee != "":
+		return v.elect(s, vote, now)
+	case vote.Distrust != "":
+		return v.endTerms(s, vote, now)
+	}
+	return nil
+}
+
+// extend the vote an accepted extension vote was opened for
+func (v *VoteHandler) extend(s Session, vote Vote) error {
+	target, err := v.store.GetVoteByID(vote.Guild, vote.Extends)
+	if err != nil {
+		return errors.Wrap(err, "unable to fetch extended vote")
+	}
+	if target.Closed {
+		return nil
+	}
+	target.Expires = target.Expires.Add(vote.Extension)
+	err = v.store.UpdateVoteExpiry(target)
+	if err != nil {
+		return err
+	}
+	v.events.Publish(ActionExecuted{
+		voteEvent: v.voteEvent(s, vote),
+		Action:    "extend",
+		Summary:   fmt.Sprintf("extended #%d by %s", target.Number, vote.Extension),
+	})
+	target, err = v.store.GetVoteCount(target)
+	if err != nil {
+		return err
+	}
+	channel, err := v.voteChannel(s, target)
+	if err != nil {
+		return err
+	}
+	v.discord(s).Edit(channel, target.CurrentID, v.embed(s, target))
+	return nil
+}
+
+// checkEarlyClose closing the vote if the electorate can no longer change its outcome
+func (v *VoteHandler) checkEarlyClose(s Session, vote Vote) {
+	rules, err := v.store.GetRules(vote.Guild)
+	if err != nil {
+		v.log.Error("unable to read rules", zap.String("guild", vote.Guild), zap.Error(err))
+		return
+	}
+	if rules.NoEarlyClose {
+		return
+	}
+	guild, err := s.StateGuild(vote.Guild)
+	if err != nil {
+		v.log.Debug("unable to determine electorate", zap.String("guild", vote.Guild), zap.Error(err))
+		return
+	}
+	// the tally of the event may be outdated by ballots quarantined in the meantime
+	vote, err = v.store.GetVoteCount(vote)
+	if err != nil {
+		v.log.Error("unable to count ballots", zap.String("guild", vote.Guild), zap.String("vote", vote.ID), zap.Error(err))
+		return
+	}
+	if !vote.Decided(electorate(guild)) {
+		return
+	}
+	err = v.closeVote(s, vote)
+	if err != nil {
+		v.log.Error("unable to close decided vote", zap.String("guild", vote.Guild), zap.String("vote", vote.ID), zap.Error(err))
+	}
+}
+
+// electorate of guild, every member but bots like democracy.bot itself.
+// Members missing from the state are counted, so a vote is never closed too early.
+func electorate(guild *discordgo.Guild) int {
+	n := guild.MemberCount
+	for _, member := range guild.Members {
+		if member.User != nil && member.User.Bot {
+			n--
+		}
+	}
+	return n
+}
+
+// closeDueVotes that expired before now. Votes with a pending extension stay open until it is decided.
+func (v *VoteHandler) closeDueVotes(s Session, now time.Time) {
+	votes, err := v.store.ReadDueVotes(now)
+	if err != nil {
+		v.log.Error("unable to read due votes", zap.Error(err))
+		return
+	}
+	for _, vote := range votes {
+		extensions, err := v.store.ReadExtensions(vote)
+		if err != nil {
+			v.log.Error("unable to read extensions", zap.String("guild", vote.Guild), zap.String("vote", vote.ID), zap.Error(err))
+			continue
+		}
+		if len(extensions) > 0 {
+			continue
+		}
+		vote, err = v.store.GetVoteCount(vote)
+		if err != nil {
+			v.log.Error("unable to get vote entries", zap.String("guild", vote.Guild), zap.String("vote", vote.ID), zap.Error(err))
+			continue
+		}
+		observeLag(vote.Expires, now)
+		err = v.closeVote(s, vote)
+		if err != nil {
+			v.log.Error("unable to close vote", zap.String("guild", vote.Guild), zap.String("vote", vote.ID), zap.Error(err))
+		}
+	}
+}
+
+// voteChannel the vote was posted to. Votes created before channels were stored live in the democracy channel.
+func (v *VoteHandler) voteChannel(s Session, vote Vote) (string, error) {
+	if vote.Channel != "" {
+		return vote.Channel, nil
+	}
+	c, err := democracyChannel(s, vote.Guild)
+	if err != nil {
+		return "", err
+	}
+	return c.ID, nil
+}
+
+// Extend Message Handler starting a short vote on extending a running vote
+func (v *VoteHandler) Extend(c *discordgo.Channel, s Session, m *discordgo.MessageCreate) error {
+	if m.Content == "reset_handler" {
+		return nil
+	}
+
+	args := strings.Fields(strings.TrimPrefix(m.Content, "extend"))
+	if len(args) != 2 {
+		return userError("invalid extension", "Invalid extension. Please follow this schema: '!democracy extend [vote number] [duration]'")
+	}
+	target, err := v.findVote(c.GuildID, args[0])
+	if err != nil {
+		return lookupError("vote not found", fmt.Sprintf("Vote %s not found.", args[0]), err)
+	}
+	if target.Closed || target.Extends != "" {
+		return userError("vote not extendable", "Only running votes can be extended.")
+	}
+	extensions, err := v.db(s).ReadExtensions(target)
+	if err != nil {
+		return internalError("unable to read extensions", "Failed to read votes from DB. Please contact support.", err)
+	}
+	if len(extensions) > 0 {
+		return userError("extension pending", "There already is a pending extension for this vote.")
+	}
+	extension, err := ParseDuration(args[1])
+	if err != nil {
+		return userError("invalid duration", err.Error())
+	}
+	rules, err := v.db(s).GetRules(c.GuildID)
+	if err != nil {
+		return internalError("unable to read rules", "Failed to read rules from DB. Please contact support.", err)
+	}
+	err = rules.CheckDuration(target.Expires.Add(extension).Sub(target.Created))
+	if err != nil {
+		return userError("extension rejected by rules", err.Error())
+	}
+
+	now := v.clock.Now()
+	vote := Vote{
+		Guild:       target.Guild,
+		Title:       fmt.Sprintf("Extend %s", target.Title),
+		Description: fmt.Sprintf("Extend the vote '%s' by %s?", target.Title, extension),
+		Author:      m.Author.ID,
+		Created:     now,
+		Expires:     now.Add(ExtensionVoteDuration),
+		Extends:     target.ID,
+		Extension:   extension,
+	}
+	channel, err := v.voteChannel(s, target)
+	if err != nil {
+		return internalError("unable to find vote channel", "unable to extend vote", err)
+	}
+	vote, err = v.openVote(s, channel, vote)
+	if err != nil {
+		return internalError("unable to open extension vote", "Failed to open extension vote. Please contact support.", err)
+	}
+	return v.replyVote(s, m, vote)
+}
@@ -0,0 +1,899 @@
+package votes
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// MemoryStore keeping all state in memory. It is not persisted and meant for tests and trying out the bot.
+type MemoryStore struct {
+	mu sync.Mutex
+
+	votes      map[string]Vote
+	numbers    map[string]int
+	messages   map[string]string
+	entries    map[string]map[string]bool
+	rules      map[string]Rules
+	terms      []Term
+	proposals  map[string]Proposal
+	revisions  map[string][]Proposal
+	amendments map[string]Amendment
+	drafts     map[string]Draft
+	schedules  map[int64]Schedule
+	scheduleID int64
+	reminders  map[string]Reminders
+	notify     map[string]map[string]bool
+	claimed    map[string]bool
+	archives   map[string]string
+	events     []AuditEvent
+	apiKeys    map[string]APIKey
+	webhooks   []Webhook
+	webhookID  int64
+	failures   []WebhookFailure
+	brigades   map[string]BrigadeSettings
+	quarantine map[string]QuarantinedBallot
+}
+
+// NewMemoryStore without any data
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		votes:      make(map[string]Vote),
+		numbers:    make(map[string]int),
+		messages:   make(map[string]string),
+		entries:    make(map[string]map[string]bool),
+		rules:      make(map[string]Rules),
+		proposals:  make(map[string]Proposal),
+		revisions:  make(map[string][]Proposal),
+		amendments: make(map[string]Amendment),
+		drafts:     make(map[string]Draft),
+		schedules:  make(map[int64]Schedule),
+		reminders:  make(map[string]Reminders),
+		notify:     make(map[string]map[string]bool),
+		claimed:    make(map[string]bool),
+		archives:   make(map[string]string),
+		apiKeys:    make(map[string]APIKey),
+		brigades:   make(map[string]BrigadeSettings),
+		quarantine: make(map[string]QuarantinedBallot),
+	}
+}
+
+func key(parts ...string) string {
+	k := ""
+	for _, p := range parts {
+		k = k + p + "/"
+	}
+	return k
+}
+
+// filterVotes matching f sorted by creation
+func (m *MemoryStore) filterVotes(f func(Vote) bool) []Vote {
+	votes := []Vote{}
+	for _, vote := range m.votes {
+		if f(vote) {
+			votes = append(votes, vote)
+		}
+	}
+	sort.Slice(votes, func(i, j int) bool { return votes[i].Created.Before(votes[j].Created) })
+	return votes
+}
+
+func (m *MemoryStore) getVote(f func(Vote) bool) (Vote, error) {
+	votes := m.filterVotes(f)
+	if len(votes) == 0 {
+		return Vote{}, ErrNotFound
+	}
+	if len(votes) != 1 {
+		return Vote{}, errors.New("invalid vote count")
+	}
+	return votes[0], nil
+}
+
+// ReadVotes of guild matching filter, ordered by number
+func (m *MemoryStore) ReadVotes(guild string, filter VoteFilter) ([]Vote, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	votes := m.filterVotes(func(v Vote) bool { return v.Guild == guild && filter.match(m.tally(v)) })
+	sort.SliceStable(votes, func(i, j int) bool {
+		if filter.Newest {
+			return votes[i].Number > votes[j].Number
+		}
+		return votes[i].Number < votes[j].Number
+	})
+	if filter.Offset >= len(votes) {
+		return []Vote{}, nil
+	}
+	votes = votes[filter.Offset:]
+	if filter.Limit > 0 && filter.Limit < len(votes) {
+		votes = votes[:filter.Limit]
+	}
+	return votes, nil
+}
+
+// CountVotes of guild matching filter
+func (m *MemoryStore) CountVotes(guild string, filter VoteFilter) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.filterVotes(func(v Vote) bool { return v.Guild == guild && filter.match(m.tally(v)) })), nil
+}
+
+// GetVote by the ID of any message representing it
+func (m *MemoryStore) GetVote(guild, message string) (Vote, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	id, ok := m.messages[key(guild, message)]
+	if !ok {
+		return Vote{}, ErrNotFound
+	}
+	return m.getVote(func(v Vote) bool { return v.Guild == guild && v.ID == id })
+}
+
+// GetVoteByNumber within guild
+func (m *MemoryStore) GetVoteByNumber(guild string, number int) (Vote, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.getVote(func(v Vote) bool { return v.Guild == guild && v.Number == number })
+}
+
+// GetVoteByID using the original vote ID
+func (m *MemoryStore) GetVoteByID(guild, id string) (Vote, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.getVote(func(v Vote) bool { return v.Guild == guild && v.ID == id })
+}
+
+// ReadDueVotes of all guilds which are still open but expired before now
+func (m *MemoryStore) ReadDueVotes(now time.Time) ([]Vote, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.filterVotes(func(v Vote) bool { return !v.Closed && !v.Expires.After(now) }), nil
+}
+
+// ReadOpenVotes of all guilds which did not expire before now
+func (m *MemoryStore) ReadOpenVotes(now time.Time) ([]Vote, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.filterVotes(func(v Vote) bool { return !v.Closed && v.Expires.After(now) }), nil
+}
+
+// ReadExtensions still open for vote
+func (m *MemoryStore) ReadExtensions(vote Vote) ([]Vote, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.filterVotes(func(v Vote) bool { return v.Guild == vote.Guild && v.Extends == vote.ID && !v.Closed }), nil
+}
+
+// InsertVote to guild, numbering it after the last vote of the guild
+func (m *MemoryStore) InsertVote(vote Vote) (Vote, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.votes[vote.ID]; ok {
+		return vote, errors.Errorf("vote %s already exists", vote.ID)
+	}
+	m.numbers[vote.Guild]++
+	vote.Number = m.numbers[vote.Guild]
+	vote.CurrentID = vote.ID
+	vote.Pro, vote.Con = 0, 0
+	vote.Tags = append([]string(nil), vote.Tags...)
+	m.votes[vote.ID] = vote
+	m.messages[key(vote.Guild, vote.ID)] = vote.ID
+	return vote, nil
+}
+
+// UpdateVote to guild
+func (m *MemoryStore) UpdateVote(id string, vote Vote) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stored, ok := m.votes[id]
+	if !ok || stored.Guild != vote.Guild {
+		return nil
+	}
+	stored.CurrentID = vote.CurrentID
+	stored.Channel = vote.Channel
+	m.votes[id] = stored
+	m.messages[key(stored.Guild, stored.CurrentID)] = id
+	return nil
+}
+
+// AddVoteMessage recording another message representing vote
+func (m *MemoryStore) AddVoteMessage(vote Vote, message string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.messages[key(vote.Guild, message)]; !ok {
+		m.messages[key(vote.Guild, message)] = vote.ID
+	}
+	return nil
+}
+
+// CloseVote storing its closing time
+func (m *MemoryStore) CloseVote(vote Vote) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stored, ok := m.votes[vote.ID]
+	if !ok || stored.Guild != vote.Guild {
+		return nil
+	}
+	stored.Closed = true
+	stored.Expires = vote.Expires
+	m.votes[vote.ID] = stored
+	return nil
+}
+
+// UpdateVoteExpiry after an extension
+func (m *MemoryStore) UpdateVoteExpiry(vote Vote) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stored, ok := m.votes[vote.ID]
+	if !ok || stored.Guild != vote.Guild {
+		return nil
+	}
+	stored.Expires = vote.Expires
+	m.votes[vote.ID] = stored
+	return nil
+}
+
+// DeleteVote from guild together with its messages and entries
+func (m *MemoryStore) DeleteVote(vote Vote) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if stored, ok := m.votes[vote.ID]; ok && stored.Guild == vote.Guild {
+		delete(m.votes, vote.ID)
+		for k, id := range m.messages {
+			if id == vote.ID {
+				delete(m.messages, k)
+			}
+		}
+		delete(m.entries, key(vote.Guild, vote.ID))
+	}
+	return nil
+}
+
+// GetVoteCount for vote
+func (m *MemoryStore) GetVoteCount(vote Vote) (Vote, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.tally(vote), nil
+}
+
+// tally of the ballots on vote
+func (m *MemoryStore) tally(vote Vote) Vote {
+	vote.Pro, vote.Con = 0, 0
+	for _, value := range m.entries[key(vote.Guild, vote.ID)] {
+		if value {
+			vote.Pro = vote.Pro + 1
+		} else {
+			vote.Con = vote.Con + 1
+		}
+	}
+	return vote
+}
+
+// ReadVoters of vote
+func (m *MemoryStore) ReadVoters(vote Vote) (map[string]bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	voters := make(map[string]bool)
+	for author := range m.entries[key(vote.Guild, vote.ID)] {
+		voters[author] = true
+	}
+	return voters, nil
+}
+
+// RecordBallot of author on vote, replacing a previous ballot
+func (m *MemoryStore) RecordBallot(vote Vote, author string, value bool) (Vote, BallotChange, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stored, ok := m.votes[vote.ID]
+	if !ok || stored.Guild != vote.Guild {
+		return vote, BallotUnchanged, errors.New("invalid vote count")
+	}
+	if stored.Closed {
+		return vote, BallotUnchanged, ErrVoteClosed
+	}
+	k := key(vote.Guild, vote.ID)
+	if m.entries[k] == nil {
+		m.entries[k] = make(map[string]bool)
+	}
+	previous, voted := m.entries[k][author]
+	m.entries[k][author] = value
+	switch {
+	case !voted:
+		return m.tally(vote), BallotAdded, nil
+	case previous != value:
+		return m.tally(vote), BallotReplaced, nil
+	}
+	return m.tally(vote), BallotUnchanged, nil
+}
+
+// DeleteVoteEntries from guild
+func (m *MemoryStore) DeleteVoteEntries(vote Vote) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.entries, key(vote.Guild, vote.ID))
+	return nil
+}
+
+// GetRules for guild. Returns the default rules if none are stored.
+func (m *MemoryStore) GetRules(guild string) (Rules, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	rules, ok := m.rules[guild]
+	if !ok {
+		return Rules{Guild: guild}, nil
+	}
+	return rules, nil
+}
+
+// SetRules for guild
+func (m *MemoryStore) SetRules(rules Rules) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.rules[rules.Guild] = rules
+	return nil
+}
+
+// ReadTerms served by user in guild
+func (m *MemoryStore) ReadTerms(guild, user string) ([]Term, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	terms := []Term{}
+	for _, term := range m.terms {
+		if term.Guild == guild && term.User == user {
+			terms = append(terms, term)
+		}
+	}
+	return terms, nil
+}
+
+// InsertTerm served by a member
+func (m *MemoryStore) InsertTerm(term Term) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.terms = append(m.terms, term)
+	return nil
+}
+
+// EndTerms of user in guild still running at end
+func (m *MemoryStore) EndTerms(guild, user string, end time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, term := range m.terms {
+		if term.Guild == guild && term.User == user && term.End.After(end) {
+			m.terms[i].End = end
+		}
+	}
+	return nil
+}
+
+// InsertProposal to guild
+func (m *MemoryStore) InsertProposal(proposal Proposal) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.proposals[proposal.ID]; ok {
+		return errors.Errorf("proposal %s already exists", proposal.ID)
+	}
+	m.proposals[proposal.ID] = proposal
+	return nil
+}
+
+// GetProposal by discussion channel ID
+func (m *MemoryStore) GetProposal(guild, id string) (Proposal, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	proposal, ok := m.proposals[id]
+	if !ok || proposal.Guild != guild {
+		return Proposal{Guild: guild, ID: id}, ErrNotFound
+	}
+	return proposal, nil
+}
+
+// UpdateProposal text, revision and status
+func (m *MemoryStore) UpdateProposal(proposal Proposal) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stored, ok := m.proposals[proposal.ID]
+	if !ok || stored.Guild != proposal.Guild {
+		return nil
+	}
+	stored.Description = proposal.Description
+	stored.Revision = proposal.Revision
+	stored.Status = proposal.Status
+	m.proposals[proposal.ID] = stored
+	return nil
+}
+
+// InsertRevision of the current proposal text
+func (m *MemoryStore) InsertRevision(proposal Proposal, created time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, revision := range m.revisions[proposal.ID] {
+		if revision.Revision == proposal.Revision {
+			return errors.Errorf("revision %d of proposal %s already exists", proposal.Revision, proposal.ID)
+		}
+	}
+	proposal.Created = created
+	m.revisions[proposal.ID] = append(m.revisions[proposal.ID], proposal)
+	return nil
+}
+
+// InsertAmendment to proposal
+func (m *MemoryStore) InsertAmendment(amendment Amendment) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.amendments[amendment.ID]; ok {
+		return errors.Errorf("amendment %s already exists", amendment.ID)
+	}
+	m.amendments[amendment.ID] = amendment
+	return nil
+}
+
+// GetAmendment by message ID
+func (m *MemoryStore) GetAmendment(guild, id string) (Amendment, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	amendment, ok := m.amendments[id]
+	if !ok || amendment.Guild != guild {
+		return Amendment{Guild: guild, ID: id}, errors.New("amendment not found")
+	}
+	return amendment, nil
+}
+
+// ReadAmendments for proposal
+func (m *MemoryStore) ReadAmendments(guild, proposal string) ([]Amendment, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	amendments := []Amendment{}
+	for _, amendment := range m.amendments {
+		if amendment.Guild == guild && amendment.Proposal == proposal {
+			amendments = append(amendments, amendment)
+		}
+	}
+	sort.Slice(amendments, func(i, j int) bool { return amendments[i].ID < amendments[j].ID })
+	return amendments, nil
+}
+
+// UpdateAmendment status
+func (m *MemoryStore) UpdateAmendment(amendment Amendment) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stored, ok := m.amendments[amendment.ID]
+	if !ok || stored.Guild != amendment.Guild {
+		return nil
+	}
+	stored.Status = amendment.Status
+	stored.Vote = amendment.Vote
+	m.amendments[amendment.ID] = stored
+	return nil
+}
+
+// InsertSchedule returning the schedule with its assigned ID
+func (m *MemoryStore) InsertSchedule(schedule Schedule) (Schedule, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.scheduleID = m.scheduleID + 1
+	schedule.ID = m.scheduleID
+	if schedule.Anchor.IsZero() {
+		schedule.Anchor = schedule.Starts
+	}
+	m.schedules[schedule.ID] = schedule
+	return schedule, nil
+}
+
+// GetSchedule by ID
+func (m *MemoryStore) GetSchedule(guild string, id int64) (Schedule, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	schedule, ok := m.schedules[id]
+	if !ok || schedule.Guild != guild {
+		return Schedule{}, ErrNotFound
+	}
+	return schedule, nil
+}
+
+func (m *MemoryStore) filterSchedules(f func(Schedule) bool) []Schedule {
+	schedules := []Schedule{}
+	for _, schedule := range m.schedules {
+		if f(schedule) {
+			schedules = append(schedules, schedule)
+		}
+	}
+	sort.Slice(schedules, func(i, j int) bool { return schedules[i].Starts.Before(schedules[j].Starts) })
+	return schedules
+}
+
+// ReadSchedules for guild
+func (m *MemoryStore) ReadSchedules(guild string) ([]Schedule, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.filterSchedules(func(s Schedule) bool { return s.Guild == guild }), nil
+}
+
+// ReadDueSchedules of all guilds starting before now
+func (m *MemoryStore) ReadDueSchedules(now time.Time) ([]Schedule, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.filterSchedules(func(s Schedule) bool { return !s.Starts.After(now) }), nil
+}
+
+// UpdateSchedule start of next occurrence
+func (m *MemoryStore) UpdateSchedule(schedule Schedule) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stored, ok := m.schedules[schedule.ID]
+	if !ok || stored.Guild != schedule.Guild {
+		return nil
+	}
+	stored.Starts = schedule.Starts
+	m.schedules[schedule.ID] = stored
+	return nil
+}
+
+// DeleteSchedule from guild
+func (m *MemoryStore) DeleteSchedule(schedule Schedule) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if stored, ok := m.schedules[schedule.ID]; ok && stored.Guild == schedule.Guild {
+		delete(m.schedules, schedule.ID)
+	}
+	return nil
+}
+
+// GetReminders for guild. Returns the default reminders if none are stored.
+func (m *MemoryStore) GetReminders(guild string) (Reminders, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	reminders, ok := m.reminders[guild]
+	if !ok {
+		return Reminders{Guild: guild, Points: DefaultReminderPoints}, nil
+	}
+	return reminders, nil
+}
+
+// SetReminders for guild
+func (m *MemoryStore) SetReminders(reminders Reminders) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.reminders[reminders.Guild] = reminders
+	return nil
+}
+
+// SetNotify opts user in or out of reminder DMs for guild
+func (m *MemoryStore) SetNotify(guild, user string, enabled bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.notify[guild] == nil {
+		m.notify[guild] = make(map[string]bool)
+	}
+	if enabled {
+		m.notify[guild][user] = true
+	} else {
+		delete(m.notify[guild], user)
+	}
+	return nil
+}
+
+// ReadNotify subscribers of guild
+func (m *MemoryStore) ReadNotify(guild string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	users := []string{}
+	for user := range m.notify[guild] {
+		users = append(users, user)
+	}
+	sort.Strings(users)
+	return users, nil
+}
+
+// ClaimReminder records the reminder at point for user (or the channel if empty).
+// Returns false if it was already recorded before.
+func (m *MemoryStore) ClaimReminder(vote Vote, point time.Duration, user string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	k := key(vote.Guild, vote.ID, point.String(), user)
+	if m.claimed[k] {
+		return false, nil
+	}
+	m.claimed[k] = true
+	return true, nil
+}
+
+// ReleaseReminder at point for user (or the channel if empty), which could not be sent
+func (m *MemoryStore) ReleaseReminder(vote Vote, point time.Duration, user string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.claimed, key(vote.Guild, vote.ID, point.String(), user))
+	return nil
+}
+
+// GetArchive channel of guild, empty if none is set
+func (m *MemoryStore) GetArchive(guild string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.archives[guild], nil
+}
+
+// SetArchive channel of guild, removing it if channel is empty
+func (m *MemoryStore) SetArchive(guild, channel string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if channel == "" {
+		delete(m.archives, guild)
+		return nil
+	}
+	m.archives[guild] = channel
+	return nil
+}
+
+// RetractBallot of author on vote
+func (m *MemoryStore) RetractBallot(vote Vote, author string) (Vote, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stored, ok := m.votes[vote.ID]
+	if !ok || stored.Guild != vote.Guild {
+		return vote, false, errors.New("invalid vote count")
+	}
+	if stored.Closed {
+		return vote, false, ErrVoteClosed
+	}
+	k := key(vote.Guild, vote.ID)
+	_, voted := m.entries[k][author]
+	delete(m.entries[k], author)
+	return m.tally(vote), voted, nil
+}
+
+// InsertAuditEvent returning the event with its assigned ID
+func (m *MemoryStore) InsertAuditEvent(event AuditEvent) (AuditEvent, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	event.ID = int64(len(m.events) + 1)
+	m.events = append(m.events, event)
+	return event, nil
+}
+
+// ReadAuditEvents of guild newest first, only events before the given ID unless it is 0
+func (m *MemoryStore) ReadAuditEvents(guild string, before int64, limit int) ([]AuditEvent, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	events := []AuditEvent{}
+	for i := len(m.events) - 1; i >= 0 && (limit <= 0 || len(events) < limit); i-- {
+		event := m.events[i]
+		if event.Guild == guild && (before <= 0 || event.ID < before) {
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+// InsertAPIKey of a guild
+func (m *MemoryStore) InsertAPIKey(key APIKey) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, k := range m.apiKeys {
+		if k.Guild == key.Guild && k.Name == key.Name {
+			return errors.Errorf("api key %s already exists", key.Name)
+		}
+	}
+	m.apiKeys[key.Hash] = key
+	return nil
+}
+
+// GetAPIKey by its hash
+func (m *MemoryStore) GetAPIKey(hash string) (APIKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key, ok := m.apiKeys[hash]
+	if !ok {
+		return key, ErrUnknownAPIKey
+	}
+	return key, nil
+}
+
+// ReadAPIKeys of guild
+func (m *MemoryStore) ReadAPIKeys(guild string) ([]APIKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	keys := []APIKey{}
+	for _, key := range m.apiKeys {
+		if key.Guild == guild {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Name < keys[j].Name })
+	return keys, nil
+}
+
+// DeleteAPIKey of guild by name
+func (m *MemoryStore) DeleteAPIKey(guild, name string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for hash, key := range m.apiKeys {
+		if key.Guild == guild && key.Name == name {
+			delete(m.apiKeys, hash)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// InsertDraft waiting for cosponsors
+func (m *MemoryStore) InsertDraft(draft Draft) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.drafts[key(draft.Guild, draft.ID)] = draft
+	return nil
+}
+
+// GetDraft of guild by its message
+func (m *MemoryStore) GetDraft(guild, id string) (Draft, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	draft, ok := m.drafts[key(guild, id)]
+	if !ok {
+		return draft, ErrNotFound
+	}
+	return draft, nil
+}
+
+// ReadDrafts of guild
+func (m *MemoryStore) ReadDrafts(guild string) ([]Draft, error) {
+	return m.filterDrafts(func(d Draft) bool { return d.Guild == guild }), nil
+}
+
+// ReadExpiredDrafts created before before
+func (m *MemoryStore) ReadExpiredDrafts(before time.Time) ([]Draft, error) {
+	return m.filterDrafts(func(d Draft) bool { return d.Created.Before(before) }), nil
+}
+
+func (m *MemoryStore) filterDrafts(f func(Draft) bool) []Draft {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	drafts := []Draft{}
+	for _, draft := range m.drafts {
+		if f(draft) {
+			drafts = append(drafts, draft)
+		}
+	}
+	sort.Slice(drafts, func(i, j int) bool { return drafts[i].Created.Before(drafts[j].Created) })
+	return drafts
+}
+
+// DeleteDraft returning false if it was deleted before
+func (m *MemoryStore) DeleteDraft(draft Draft) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	k := key(draft.Guild, draft.ID)
+	if _, ok := m.drafts[k]; !ok {
+		return false, nil
+	}
+	delete(m.drafts, k)
+	return true, nil
+}
+
+// InsertWebhook returning the webhook with its assigned ID
+func (m *MemoryStore) InsertWebhook(hook Webhook) (Webhook, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.webhookID++
+	hook.ID = m.webhookID
+	m.webhooks = append(m.webhooks, hook)
+	return hook, nil
+}
+
+// ReadWebhooks of guild
+func (m *MemoryStore) ReadWebhooks(guild string) ([]Webhook, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	hooks := []Webhook{}
+	for _, hook := range m.webhooks {
+		if hook.Guild == guild {
+			hooks = append(hooks, hook)
+		}
+	}
+	return hooks, nil
+}
+
+// DeleteWebhook of guild by ID
+func (m *MemoryStore) DeleteWebhook(guild string, id int64) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, hook := range m.webhooks {
+		if hook.Guild == guild && hook.ID == id {
+			m.webhooks = append(m.webhooks[:i], m.webhooks[i+1:]...)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// InsertWebhookFailure of a delivery given up
+func (m *MemoryStore) InsertWebhookFailure(failure WebhookFailure) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	failure.ID = int64(len(m.failures) + 1)
+	m.failures = append(m.failures, failure)
+	return nil
+}
+
+// ReadWebhookFailures of guild newest first
+func (m *MemoryStore) ReadWebhookFailures(guild string, limit int) ([]WebhookFailure, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	failures := []WebhookFailure{}
+	for i := len(m.failures) - 1; i >= 0 && (limit <= 0 || len(failures) < limit); i-- {
+		if m.failures[i].Guild == guild {
+			failures = append(failures, m.failures[i])
+		}
+	}
+	return failures, nil
+}
+
+// Ping the store, which is always reachable
+func (m *MemoryStore) Ping() error {
+	return nil
+}
+
+// GetBrigadeSettings of guild, detection is off if none are stored
+func (m *MemoryStore) GetBrigadeSettings(guild string) (BrigadeSettings, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	settings, ok := m.brigades[guild]
+	if !ok {
+		return BrigadeSettings{Guild: guild}, nil
+	}
+	return settings, nil
+}
+
+// SetBrigadeSettings of a guild
+func (m *MemoryStore) SetBrigadeSettings(settings BrigadeSettings) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.brigades[settings.Guild] = settings
+	return nil
+}
+
+// QuarantineBallot replacing an earlier one of the same user on the vote
+func (m *MemoryStore) QuarantineBallot(ballot QuarantinedBallot) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.quarantine[key(ballot.Guild, ballot.Vote, ballot.User)] = ballot
+	return nil
+}
+
+// GetQuarantinedBallot of user on vote
+func (m *MemoryStore) GetQuarantinedBallot(guild, vote, user string) (QuarantinedBallot, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ballot, ok := m.quarantine[key(guild, vote, user)]
+	if !ok {
+		return ballot, ErrNotFound
+	}
+	return ballot, nil
+}
+
+// ReadQuarantinedBallots of vote ordered by user
+func (m *MemoryStore) ReadQuarantinedBallots(guild, vote string) ([]QuarantinedBallot, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ballots := []QuarantinedBallot{}
+	for _, ballot := range m.quarantine {
+		if ballot.Guild == guild && ballot.Vote == vote {
+			ballots = append(ballots, ballot)
+		}
+	}
+	sort.Slice(ballots, func(i, j int) bool { return ballots[i].User < ballots[j].User })
+	return ballots, nil
+}
+
+// DeleteQuarantinedBallot returning false if it was deleted before
+func (m *MemoryStore) DeleteQuarantinedBallot(ballot QuarantinedBallot) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	k := key(ballot.Guild, ballot.Vote, ballot.User)
+	if _, ok := m.quarantine[k]; !ok {
+		return false, nil
+	}
+	delete(m.quarantine, k)
+	return true, nil
+}
@@ -0,0 +1,141 @@
+package votes
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/playnet-public/democracy.bot/pkg/metrics"
+	"go.uber.org/zap"
+)
+
+var (
+	gatewayConnected = metrics.NewGauge(
+		"democracy_gateway_connected",
+		"Whether the Discord gateway is connected.",
+	)
+	commandDuration = metrics.NewHistogram(
+		"democracy_command_duration_seconds",
+		"Time spent handling chat commands.",
+		metrics.DefaultBuckets, "command",
+	)
+	reactionDuration = metrics.NewHistogram(
+		"democracy_reaction_duration_seconds",
+		"Time spent handling reactions by handler.",
+		metrics.DefaultBuckets, "handler",
+	)
+	rateLimited = metrics.NewCounter(
+		"democracy_rate_limited_total",
+		"Commands and reactions rejected by the rate limits of users or guilds.",
+		"kind", "scope",
+	)
+	discordErrors = metrics.NewCounter(
+		"democracy_discord_errors_total",
+		"Failed attempts of Discord writes by action and HTTP status, network errors have status 0.",
+		"action", "status",
+	)
+	discordRateLimits = metrics.NewCounter(
+		"democracy_discord_rate_limits_total",
+		"Discord API requests rejected with 429 Too Many Requests.",
+	)
+	dbQueryDuration = metrics.NewHistogram(
+		"democracy_db_query_duration_seconds",
+		"Time spent executing SQL statements by statement.",
+		metrics.DefaultBuckets, "statement",
+	)
+	ballotsTotal = metrics.NewCounter(
+		"democracy_ballots_total",
+		"Ballots cast or changed by guild.",
+		"guild",
+	)
+	schedulerLag = metrics.NewHistogram(
+		"democracy_scheduler_lag_seconds",
+		"Delay between votes or schedules being due and the scheduler handling them.",
+		[]float64{1, 5, 15, 30, 60, 120, 300, 900, 3600},
+	)
+)
+
+// RegisterMetrics of v depending on its store with r
+func (v *VoteHandler) RegisterMetrics(r *metrics.Registry) {
+	r.NewGaugeFunc("democracy_open_votes", "Open votes by guild.", []string{"guild"}, func() []metrics.Sample {
+		open, err := v.store.ReadOpenVotes(v.clock.Now())
+		if err != nil {
+			v.log.Error("unable to read open votes", zap.Error(err))
+			return nil
+		}
+		count := make(map[string]int)
+		for _, vote := range open {
+			count[vote.Guild]++
+		}
+		var samples []metrics.Sample
+		for guild, n := range count {
+			samples = append(samples, metrics.Sample{Values: []string{guild}, Value: float64(n)})
+		}
+		return samples
+	})
+}
+
+// countBallot of every ballot cast or changed
+func (v *VoteHandler) countBallot(e Event) {
+	switch e.(type) {
+	case BallotCast, BallotChanged:
+		ballotsTotal.Inc(e.GuildID())
+	}
+}
+
+// observeHandler of kind command or reaction started at start
+func observeHandler(kind, handler string, start time.Time) {
+	if kind == "command" {
+		commandDuration.Observe(time.Since(start).Seconds(), handler)
+		return
+	}
+	reactionDuration.Observe(time.Since(start).Seconds(), handler)
+}
+
+// observeDiscordError of a failed attempt of action
+func observeDiscordError(action string, err error) {
+	status := 0
+	if restErr, ok := err.(*discordgo.RESTError); ok && restErr.Response != nil {
+		status = restErr.Response.StatusCode
+	}
+	if status == http.StatusTooManyRequests {
+		discordRateLimits.Inc()
+	}
+	discordErrors.Inc(action, strconv.Itoa(status))
+}
+
+// observeLag of work due at due and handled at now
+func observeLag(due, now time.Time) {
+	if lag := now.Sub(due); lag > 0 {
+		schedulerLag.Observe(lag.Seconds())
+	}
+}
+
+// statement naming query by its verb and table, keeping the metric labels few
+func statement(query string) string {
+	words := strings.Fields(strings.ToLower(query))
+	if len(words) < 2 {
+		return "other"
+	}
+	verb := words[0]
+	switch verb {
+	case "update":
+		return verb + " " + words[1]
+	case "select", "insert", "delete":
+		for i, word := range words[:len(words)-1] {
+			if word != "from" && word != "into" {
+				continue
+			}
+			table := words[i+1]
+			if end := strings.IndexAny(table, "(,"); end >= 0 {
+				table = table[:end]
+			}
+			if table != "" {
+				return verb + " " + table
+			}
+		}
+	}
+	return "other"
+}
@@ -0,0 +1,397 @@
+package votes
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// Request of a command or reaction passed through the middleware of the Bot
+type Request struct {
+	// Ctx of the request, cancelled once its deadline passed
+	Ctx context.Context
+	// Log scoped to the guild, channel and user of the request
+	Log *zap.Logger
+	// Handler the request is routed to, the command or the reaction title prefix
+	Handler string
+	// Channel of the democracy channel of the guild
+	Channel *discordgo.Channel
+	Session Session
+	// Message of commands, nil for reactions
+	Message *discordgo.MessageCreate
+	// Reaction of reactions, nil for commands
+	Reaction *discordgo.MessageReactionAdd
+}
+
+// Guild of the request
+func (r *Request) Guild() string {
+	if r.Channel == nil {
+		return ""
+	}
+	return r.Channel.GuildID
+}
+
+// User sending the command or reaction
+func (r *Request) User() string {
+	if r.Message != nil {
+		return r.Message.Author.ID
+	}
+	return r.Reaction.UserID
+}
+
+// ChannelID the command or reaction was sent in
+func (r *Request) ChannelID() string {
+	if r.Message != nil {
+		return r.Message.ChannelID
+	}
+	return r.Reaction.ChannelID
+}
+
+// Kind of the request, command or reaction
+func (r *Request) Kind() string {
+	if r.Message != nil {
+		return "command"
+	}
+	return "reaction"
+}
+
+// ballot reports whether r is a reaction casting a ballot
+func (r *Request) ballot() bool {
+	return r.Reaction != nil && (r.Reaction.Emoji.Name == "✅" || r.Reaction.Emoji.Name == "❎")
+}
+
+// requestSession passed to the handler of r, so the handler reaches the scoped logger and the context of r
+type requestSession struct {
+	Session
+	r *Request
+}
+
+// requestOf the handler s was passed to, nil outside of handlers
+func requestOf(s Session) *Request {
+	if rs, ok := s.(requestSession); ok {
+		return rs.r
+	}
+	return nil
+}
+
+// requestLog of the request s was passed for, log outside of handlers
+func requestLog(s Session, log *zap.Logger) *zap.Logger {
+	if r := requestOf(s); r != nil && r.Log != nil {
+		return r.Log
+	}
+	return log
+}
+
+// logger of the VoteHandler, scoped to the request s was passed for
+func (v *VoteHandler) logger(s Session) *zap.Logger {
+	return requestLog(s, v.log)
+}
+
+// logger of the Bot, scoped to the request s was passed for
+func (b *Bot) logger(s Session) *zap.Logger {
+	return requestLog(s, b.Log)
+}
+
+// db of the VoteHandler, failing lookups once the context of the request s was passed for is done
+func (v *VoteHandler) db(s Session) Store {
+	if r := requestOf(s); r != nil && r.Ctx != nil {
+		return deadlineStore{Store: v.store, ctx: r.Ctx}
+	}
+	return v.store
+}
+
+// Handler of a Request
+type Handler func(r *Request) error
+
+// Middleware wrapping a Handler, like logging or rate limiting
+type Middleware func(next Handler) Handler
+
+// Use middleware for all message and reaction handlers, the first one is the outermost
+func (b *Bot) Use(middleware ...Middleware) {
+	b.middleware = append(b.middleware, middleware...)
+}
+
+// chain of the middleware of b around h
+func (b *Bot) chain(h Handler) Handler {
+	for i := len(b.middleware) - 1; i >= 0; i-- {
+		h = b.middleware[i](h)
+	}
+	return h
+}
+
+// recoverPanics of handlers, turning them into internal failures replied and reported like any other
+func (b *Bot) recoverPanics(next Handler) Handler {
+	return func(r *Request) (err error) {
+		defer func() {
+			p := recover()
+			if p == nil {
+				return
+			}
+			r.Log.Error("handler panicked", zap.String("panic", fmt.Sprint(p)), zap.Stack("stack"))
+			err = internalError("handler panicked", "", errors.Errorf("panic: %v", p))
+		}()
+		return next(r)
+	}
+}
+
+// scopeLogger of requests to their guild, channel, user and handler, the logger handlers log to
+func scopeLogger(next Handler) Handler {
+	return func(r *Request) error {
+		r.Log = r.Log.With(
+			zap.String("guild", r.Guild()),
+			zap.String("channel", r.ChannelID()),
+			zap.String("user", r.User()),
+			zap.String("handler", r.Handler),
+		)
+		if r.Message != nil {
+			r.Log.Info("message event", zap.String("message", r.Message.Content))
+		} else {
+			r.Log.Info("reaction event", zap.String("message", r.Reaction.MessageID), zap.String("emoji", r.Reaction.Emoji.Name))
+		}
+		return next(r)
+	}
+}
+
+// timeHandlers observing the duration of every request
+func timeHandlers(next Handler) Handler {
+	return func(r *Request) error {
+		start := time.Now()
+		err := next(r)
+		observeHandler(r.Kind(), r.Handler, start)
+		return err
+	}
+}
+
+// Timeout of requests, Discord and store lookups of handlers fail once it passed
+func Timeout(d time.Duration) Middleware {
+	return func(next Handler) Handler {
+		return func(r *Request) error {
+			ctx, cancel := context.WithTimeout(r.Ctx, d)
+			defer cancel()
+			r.Ctx = ctx
+			r.Session = deadlineSession{Session: r.Session, ctx: ctx}
+			err := next(r)
+			if ctx.Err() == context.DeadlineExceeded {
+				r.Log.Warn("handler exceeded its deadline", zap.Duration("timeout", d))
+			}
+			return err
+		}
+	}
+}
+
+// deadlineSession failing the lookups of a handler once its context is done.
+// Writes are queued and still sent, so a handler never stops half way through a write.
+type deadlineSession struct {
+	Session
+	ctx context.Context
+}
+
+func (s deadlineSession) User(userID string) (*discordgo.User, error) {
+	if err := s.ctx.Err(); err != nil {
+		return nil, err
+	}
+	return s.Session.User(userID)
+}
+
+func (s deadlineSession) Guild(guildID string) (*discordgo.Guild, error) {
+	if err := s.ctx.Err(); err != nil {
+		return nil, err
+	}
+	return s.Session.Guild(guildID)
+}
+
+func (s deadlineSession) ChannelMessage(channelID, messageID string) (*discordgo.Message, error) {
+	if err := s.ctx.Err(); err != nil {
+		return nil, err
+	}
+	return s.Session.ChannelMessage(channelID, messageID)
+}
+
+func (s deadlineSession) MessageReactions(channelID, messageID, emojiID string, limit int) ([]*discordgo.User, error) {
+	if err := s.ctx.Err(); err != nil {
+		return nil, err
+	}
+	return s.Session.MessageReactions(channelID, messageID, emojiID, limit)
+}
+
+// deadlineStore failing the lookups of a handler once its context is done.
+// Like with deadlineSession, writes are still stored.
+type deadlineStore struct {
+	Store
+	ctx context.Context
+}
+
+func (s deadlineStore) ReadVotes(guild string, filter VoteFilter) ([]Vote, error) {
+	if err := s.ctx.Err(); err != nil {
+		return nil, err
+	}
+	return s.Store.ReadVotes(guild, filter)
+}
+
+func (s deadlineStore) CountVotes(guild string, filter VoteFilter) (int, error) {
+	if err := s.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return s.Store.CountVotes(guild, filter)
+}
+
+func (s deadlineStore) GetVote(guild, message string) (Vote, error) {
+	if err := s.ctx.Err(); err != nil {
+		return Vote{}, err
+	}
+	return s.Store.GetVote(guild, message)
+}
+
+func (s deadlineStore) GetVoteByID(guild, id string) (Vote, error) {
+	if err := s.ctx.Err(); err != nil {
+		return Vote{}, err
+	}
+	return s.Store.GetVoteByID(guild, id)
+}
+
+func (s deadlineStore) GetVoteByNumber(guild string, number int) (Vote, error) {
+	if err := s.ctx.Err(); err != nil {
+		return Vote{}, err
+	}
+	return s.Store.GetVoteByNumber(guild, number)
+}
+
+func (s deadlineStore) GetVoteCount(vote Vote) (Vote, error) {
+	if err := s.ctx.Err(); err != nil {
+		return vote, err
+	}
+	return s.Store.GetVoteCount(vote)
+}
+
+func (s deadlineStore) ReadVoters(vote Vote) (map[string]bool, error) {
+	if err := s.ctx.Err(); err != nil {
+		return nil, err
+	}
+	return s.Store.ReadVoters(vote)
+}
+
+func (s deadlineStore) GetRules(guild string) (Rules, error) {
+	if err := s.ctx.Err(); err != nil {
+		return Rules{}, err
+	}
+	return s.Store.GetRules(guild)
+}
+
+func (s deadlineStore) ReadTerms(guild, user string) ([]Term, error) {
+	if err := s.ctx.Err(); err != nil {
+		return nil, err
+	}
+	return s.Store.ReadTerms(guild, user)
+}
+
+// RateLimits of requests, a limit with a Burst of zero is disabled
+type RateLimits struct {
+	// User limiting each member of a guild
+	User Limit
+	// Guild limiting all members of a guild together
+	Guild Limit
+}
+
+// Limit of a token bucket, allowing Burst requests at once refilled at Rate per second
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// DefaultRateLimits generous enough for voting on a handful of votes at once
+var DefaultRateLimits = RateLimits{
+	User:  Limit{Rate: 1, Burst: 10},
+	Guild: Limit{Rate: 10, Burst: 60},
+}
+
+// ops of the errors of rate limited requests
+const (
+	opUserRateLimited  = "user rate limited"
+	opGuildRateLimited = "guild rate limited"
+)
+
+// RateLimit requests by user and guild, commands and reactions are limited separately.
+// Ballots are only limited by user, so busy votes do not turn away the ballots of their voters.
+func RateLimit(limits RateLimits) Middleware {
+	return rateLimit(limits, time.Now)
+}
+
+func rateLimit(limits RateLimits, now func() time.Time) Middleware {
+	users := newBuckets(limits.User, now)
+	guilds := newBuckets(limits.Guild, now)
+	return func(next Handler) Handler {
+		return func(r *Request) error {
+			key := r.Kind() + "/" + r.Guild()
+			if !users.take(key + "/" + r.User()) {
+				rateLimited.Inc(r.Kind(), "user")
+				return userErrorf(opUserRateLimited, "<@%s> is sending commands too fast, please wait a moment.", r.User())
+			}
+			if !r.ballot() && !guilds.take(key) {
+				rateLimited.Inc(r.Kind(), "guild")
+				return userError(opGuildRateLimited, "This server is sending too many commands, please wait a moment.")
+			}
+			return next(r)
+		}
+	}
+}
+
+// buckets of a Limit by key
+type buckets struct {
+	limit Limit
+	now   func() time.Time
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newBuckets(limit Limit, now func() time.Time) *buckets {
+	return &buckets{limit: limit, now: now, buckets: make(map[string]*bucket)}
+}
+
+// take a token from the bucket of key, false if it is empty
+func (b *buckets) take(key string) bool {
+	if b.limit.Burst < 1 {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := b.now()
+	bk, ok := b.buckets[key]
+	if !ok {
+		bk = &bucket{tokens: float64(b.limit.Burst), last: now}
+		b.buckets[key] = bk
+	}
+	bk.tokens += now.Sub(bk.last).Seconds() * b.limit.Rate
+	if bk.tokens > float64(b.limit.Burst) {
+		bk.tokens = float64(b.limit.Burst)
+	}
+	bk.last = now
+	if bk.tokens < 1 {
+		return false
+	}
+	bk.tokens--
+	b.sweep(now)
+	return true
+}
+
+// sweep buckets refilled completely by now, keeping only those of active members. The caller holds b.mu.
+func (b *buckets) sweep(now time.Time) {
+	if len(b.buckets) < 1024 {
+		return
+	}
+	for key, bk := range b.buckets {
+		if bk.tokens+now.Sub(bk.last).Seconds()*b.limit.Rate >= float64(b.limit.Burst) {
+			delete(b.buckets, key)
+		}
+	}
+}
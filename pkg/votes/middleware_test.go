@@ -0,0 +1,160 @@
+package votes
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"go.uber.org/zap"
+)
+
+func TestBotRecoversPanics(t *testing.T) {
+	tb := newTestBot(t)
+	defer tb.close()
+	var reported []map[string]string
+	tb.bot.SetReporter(ReporterFunc(func(err error, tags map[string]string) {
+		reported = append(reported, tags)
+	}))
+	tb.bot.AddMessageHandler("panic", func(c *discordgo.Channel, s Session, m *discordgo.MessageCreate) error {
+		if m.Content == "reset_handler" {
+			return nil
+		}
+		var vote *Vote
+		return userError(vote.Title, "")
+	})
+
+	tb.say(tb.alice, "!democracy panic")
+	if _, embed := tb.embed("Vote failed"); !strings.Contains(embed.Description, "contact support") {
+		t.Errorf("unexpected error %q", embed.Description)
+	}
+	if len(reported) != 1 || reported[0]["handler"] != "panic" {
+		t.Errorf("expected the panic to be reported, got %v", reported)
+	}
+
+	// the bot keeps handling commands
+	tb.say(tb.alice, "!democracy vote Coffee|Buy a coffee machine|3d")
+	tb.embed("[Vote] Coffee")
+}
+
+func TestRateLimit(t *testing.T) {
+	now := time.Date(2018, 3, 1, 12, 0, 0, 0, time.UTC)
+	limit := rateLimit(RateLimits{
+		User:  Limit{Rate: 1, Burst: 2},
+		Guild: Limit{Rate: 10, Burst: 3},
+	}, func() time.Time { return now })
+	handled := 0
+	h := limit(func(r *Request) error {
+		handled++
+		return nil
+	})
+	request := func(user string) error {
+		return h(&Request{
+			Ctx:     context.Background(),
+			Log:     zap.NewNop(),
+			Channel: &discordgo.Channel{GuildID: "guild"},
+			Message: &discordgo.MessageCreate{Message: &discordgo.Message{Author: &discordgo.User{ID: user}}},
+		})
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := request("alice"); err != nil {
+			t.Fatalf("request %d of alice limited: %v", i, err)
+		}
+	}
+	err := request("alice")
+	if err == nil || asError(err).Class != ClassUser {
+		t.Errorf("expected alice to be limited, got %v", err)
+	}
+	if err := request("bob"); err != nil {
+		t.Errorf("bob limited by alice: %v", err)
+	}
+	if err := request("carol"); err == nil {
+		t.Error("expected the guild to be limited")
+	}
+	ballot := h(&Request{
+		Ctx:      context.Background(),
+		Log:      zap.NewNop(),
+		Channel:  &discordgo.Channel{GuildID: "guild"},
+		Reaction: &discordgo.MessageReactionAdd{MessageReaction: &discordgo.MessageReaction{UserID: "dave", Emoji: discordgo.Emoji{Name: "✅"}}},
+	})
+	if ballot != nil {
+		t.Errorf("expected ballots to pass the guild limit, got %v", ballot)
+	}
+
+	now = now.Add(time.Second)
+	if err := request("alice"); err != nil {
+		t.Errorf("expected alice to be allowed after a second, got %v", err)
+	}
+	if handled != 5 {
+		t.Errorf("expected 5 handled requests, got %d", handled)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	tb := newTestBot(t)
+	defer tb.close()
+
+	var lookupErr, storeErr error
+	h := Timeout(0)(func(r *Request) error {
+		_, lookupErr = r.Session.Guild(r.Guild())
+		_, storeErr = tb.votes.db(requestSession{Session: r.Session, r: r}).GetRules(r.Guild())
+		return nil
+	})
+	h(&Request{
+		Ctx:     context.Background(),
+		Log:     zap.NewNop(),
+		Channel: tb.channel,
+		Session: tb.discord,
+		Message: tb.discord.Message(tb.channel.ID, tb.alice, "!democracy rules"),
+	})
+	if lookupErr != context.DeadlineExceeded {
+		t.Errorf("expected lookups to fail after the deadline, got %v", lookupErr)
+	}
+	if storeErr != context.DeadlineExceeded {
+		t.Errorf("expected store lookups to fail after the deadline, got %v", storeErr)
+	}
+}
+
+func TestBotRateLimitedReaction(t *testing.T) {
+	tb := newTestBot(t)
+	defer tb.close()
+	tb.bot.Use(rateLimit(RateLimits{User: Limit{Rate: 0.001, Burst: 1}}, func() time.Time { return tb.clock.now }))
+	carol := tb.user("carol")
+
+	tb.say(tb.bob, "!democracy vote Coffee|Buy a coffee machine|3d")
+	msg, _ := tb.embed("[Vote] Coffee")
+	created, _ := tb.embed("Vote created")
+	tb.react(msg.ID, "✅", carol)
+	tb.react(msg.ID, "❎", carol)
+
+	// the rate limited ballot stays until the reconciler records it
+	found := false
+	for _, user := range tb.discord.Reactions(msg.ID, "❎") {
+		found = found || user == carol.ID
+	}
+	if !found {
+		t.Error("expected the rate limited ballot to stay")
+	}
+	if dms := tb.discord.DirectMessages(carol); len(dms) != 0 {
+		t.Errorf("expected no direct message for a rate limited ballot, got %v", dms)
+	}
+	tb.votes.reconcile(tb.discord)
+	tb.flush()
+	if vote, _ := tb.vote("Coffee"); vote.Pro != 0 || vote.Con != 1 {
+		t.Errorf("expected the reconciler to record the rate limited ballot, got %d:%d", vote.Pro, vote.Con)
+	}
+
+	// other rate limited reactions are removed and their member is told to react again
+	tb.react(created.ID, "↩", carol)
+	for _, user := range tb.discord.Reactions(created.ID, "↩") {
+		if user == carol.ID {
+			t.Error("expected the rate limited reaction to be removed")
+		}
+	}
+	dms := tb.discord.DirectMessages(carol)
+	if len(dms) != 1 || !strings.Contains(dms[0].Content, "too fast") {
+		t.Errorf("expected carol to be told about the rate limit, got %v", dms)
+	}
+}
@@ -0,0 +1,613 @@
+package votes
+
+import (
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// migrationLock is the PostgreSQL advisory lock key held while migrating,
+// so concurrently starting instances do not migrate the schema twice
+const migrationLock = 4206130200
+
+// Migration of the schema from Version-1 to Version
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+	// SQLiteUp and SQLiteDown replace Up and Down for SQLite where it lacks a statement,
+	// otherwise the PostgreSQL types are translated by sqliteDialect
+	SQLiteUp   string
+	SQLiteDown string
+}
+
+// sqliteDialect translating the PostgreSQL types and clauses of the migrations which SQLite spells differently
+var sqliteDialect = strings.NewReplacer(
+	"BIGSERIAL PRIMARY KEY", "INTEGER PRIMARY KEY AUTOINCREMENT",
+	"SERIAL PRIMARY KEY", "INTEGER PRIMARY KEY AUTOINCREMENT",
+	"ADD COLUMN IF NOT EXISTS", "ADD COLUMN",
+	"DROP COLUMN IF EXISTS", "DROP COLUMN",
+)
+
+// up statements of m for driver
+func (m Migration) up(driver string) string {
+	if driver != "sqlite" {
+		return m.Up
+	}
+	if m.SQLiteUp != "" {
+		return m.SQLiteUp
+	}
+	return sqliteDialect.Replace(m.Up)
+}
+
+// down statements of m for driver
+func (m Migration) down(driver string) string {
+	if driver != "sqlite" {
+		return m.Down
+	}
+	if m.SQLiteDown != "" {
+		return m.SQLiteDown
+	}
+	return sqliteDialect.Replace(m.Down)
+}
+
+// MigrationState of a known migration
+type MigrationState struct {
+	Migration
+	// Applied is zero if the migration is pending
+	Applied time.Time
+}
+
+// migrations in the order they are applied. Never change a released migration, add a new one instead.
+var migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "initial schema",
+		// idempotent like migrations 2 to 7, so databases set up with the former db.sql script can be adopted
+		Up: `
+CREATE TABLE IF NOT EXISTS votes (
+    vote_id         VARCHAR(50) PRIMARY KEY,
+    guild_id        VARCHAR(50),
+    current_id      VARCHAR(50),
+    title           VARCHAR(50),
+    description     VARCHAR(50),
+    author          VARCHAR(30),
+    created         DATE,
+    expiration      DATE,
+    pro             INTEGER,
+    con             INTEGER
+);
+CREATE TABLE IF NOT EXISTS vote_entries (
+    vote_id         VARCHAR(50) NOT NULL,
+    guild_id        VARCHAR(50) NOT NULL,
+    author          VARCHAR(50) NOT NULL,
+    vote            BOOLEAN,
+    primary key (vote_id, guild_id, author)
+);`,
+		Down: `
+DROP TABLE IF EXISTS vote_entries;
+DROP TABLE IF EXISTS votes;`,
+	},
+	{
+		Version: 2,
+		Name:    "vote times and texts",
+		// db.sql created the vote times as DATE, dropping the time of day, and capped the texts at 50 characters
+		Up: `
+ALTER TABLE votes
+    ALTER COLUMN created TYPE TIMESTAMP,
+    ALTER COLUMN expiration TYPE TIMESTAMP,
+    ALTER COLUMN title TYPE VARCHAR(256),
+    ALTER COLUMN description TYPE TEXT,
+    ALTER COLUMN author TYPE VARCHAR(50);`,
+		Down: `
+ALTER TABLE votes
+    ALTER COLUMN created TYPE DATE,
+    ALTER COLUMN expiration TYPE DATE,
+    ALTER COLUMN title TYPE VARCHAR(50) USING left(title, 50),
+    ALTER COLUMN description TYPE VARCHAR(50) USING left(description, 50),
+    ALTER COLUMN author TYPE VARCHAR(30) USING left(author, 30);`,
+		SQLiteUp:   `-- SQLite keeps the time of day and does not limit the length of texts`,
+		SQLiteDown: `-- SQLite keeps the time of day and does not limit the length of texts`,
+	},
+	{
+		Version: 3,
+		Name:    "term limits",
+		Up: `
+CREATE TABLE IF NOT EXISTS guild_rules (
+    guild_id                VARCHAR(50) PRIMARY KEY,
+    max_consecutive_terms   INTEGER NOT NULL DEFAULT 0,
+    max_total_terms         INTEGER NOT NULL DEFAULT 0,
+    mandatory_break         INTEGER NOT NULL DEFAULT 0,
+    distrust_cooldown       INTEGER NOT NULL DEFAULT 0,
+    repropose_cooldown      INTEGER NOT NULL DEFAULT 0
+);
+CREATE TABLE IF NOT EXISTS terms (
+    guild_id        VARCHAR(50) NOT NULL,
+    user_id         VARCHAR(50) NOT NULL,
+    role            VARCHAR(50) NOT NULL,
+    term_start      TIMESTAMP NOT NULL,
+    term_end        TIMESTAMP NOT NULL
+);`,
+		Down: `
+DROP TABLE IF EXISTS terms;
+DROP TABLE IF EXISTS guild_rules;`,
+	},
+	{
+		Version: 4,
+		Name:    "proposals and amendments",
+		Up: `
+ALTER TABLE votes ADD COLUMN IF NOT EXISTS discussion_id VARCHAR(50);
+ALTER TABLE votes ADD COLUMN IF NOT EXISTS revision INTEGER DEFAULT 0;
+CREATE TABLE IF NOT EXISTS proposals (
+    proposal_id     VARCHAR(50) PRIMARY KEY,
+    guild_id        VARCHAR(50) NOT NULL,
+    message_id      VARCHAR(50),
+    title           VARCHAR(256),
+    description     TEXT,
+    author          VARCHAR(50),
+    revision        INTEGER NOT NULL DEFAULT 1,
+    status          VARCHAR(20) NOT NULL,
+    created         TIMESTAMP
+);
+CREATE TABLE IF NOT EXISTS proposal_revisions (
+    proposal_id     VARCHAR(50) NOT NULL,
+    revision        INTEGER NOT NULL,
+    description     TEXT,
+    created         TIMESTAMP,
+    primary key (proposal_id, revision)
+);
+CREATE TABLE IF NOT EXISTS amendments (
+    amendment_id    VARCHAR(50) PRIMARY KEY,
+    guild_id        VARCHAR(50) NOT NULL,
+    proposal_id     VARCHAR(50) NOT NULL,
+    author          VARCHAR(50),
+    description     TEXT,
+    status          VARCHAR(20) NOT NULL,
+    vote_id         VARCHAR(50)
+);`,
+		Down: `
+DROP TABLE IF EXISTS amendments;
+DROP TABLE IF EXISTS proposal_revisions;
+DROP TABLE IF EXISTS proposals;
+ALTER TABLE votes DROP COLUMN IF EXISTS revision;
+ALTER TABLE votes DROP COLUMN IF EXISTS discussion_id;`,
+	},
+	{
+		Version: 5,
+		Name:    "scheduled votes",
+		Up: `
+CREATE TABLE IF NOT EXISTS scheduled_votes (
+    schedule_id     SERIAL PRIMARY KEY,
+    guild_id        VARCHAR(50) NOT NULL,
+    author          VARCHAR(50) NOT NULL,
+    title           VARCHAR(256),
+    description     TEXT,
+    starts          TIMESTAMP NOT NULL,
+    duration        BIGINT NOT NULL,
+    recurrence      VARCHAR(20) NOT NULL,
+    timezone        VARCHAR(64) NOT NULL
+);`,
+		Down: `
+DROP TABLE IF EXISTS scheduled_votes;`,
+	},
+	{
+		Version: 6,
+		Name:    "vote durations and extensions",
+		Up: `
+ALTER TABLE votes ADD COLUMN IF NOT EXISTS channel_id VARCHAR(50);
+ALTER TABLE votes ADD COLUMN IF NOT EXISTS closed BOOLEAN DEFAULT false;
+ALTER TABLE votes ADD COLUMN IF NOT EXISTS extends_id VARCHAR(50);
+ALTER TABLE votes ADD COLUMN IF NOT EXISTS extension BIGINT DEFAULT 0;
+ALTER TABLE guild_rules ADD COLUMN IF NOT EXISTS min_duration BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE guild_rules ADD COLUMN IF NOT EXISTS max_duration BIGINT NOT NULL DEFAULT 0;`,
+		Down: `
+ALTER TABLE guild_rules DROP COLUMN IF EXISTS max_duration;
+ALTER TABLE guild_rules DROP COLUMN IF EXISTS min_duration;
+ALTER TABLE votes DROP COLUMN IF EXISTS extension;
+ALTER TABLE votes DROP COLUMN IF EXISTS extends_id;
+ALTER TABLE votes DROP COLUMN IF EXISTS closed;
+ALTER TABLE votes DROP COLUMN IF EXISTS channel_id;`,
+	},
+	{
+		Version: 7,
+		Name:    "reminders",
+		Up: `
+CREATE TABLE IF NOT EXISTS guild_reminders (
+    guild_id        VARCHAR(50) PRIMARY KEY,
+    points          VARCHAR(256) NOT NULL,
+    role_id         VARCHAR(50) NOT NULL DEFAULT ''
+);
+CREATE TABLE IF NOT EXISTS notify_subscriptions (
+    guild_id        VARCHAR(50) NOT NULL,
+    user_id         VARCHAR(50) NOT NULL,
+    primary key (guild_id, user_id)
+);
+CREATE TABLE IF NOT EXISTS reminders_sent (
+    guild_id        VARCHAR(50) NOT NULL,
+    vote_id         VARCHAR(50) NOT NULL,
+    point           BIGINT NOT NULL,
+    user_id         VARCHAR(50) NOT NULL,
+    primary key (guild_id, vote_id, point, user_id)
+);`,
+		Down: `
+DROP TABLE IF EXISTS reminders_sent;
+DROP TABLE IF EXISTS notify_subscriptions;
+DROP TABLE IF EXISTS guild_reminders;`,
+	},
+	{
+		Version: 8,
+		Name:    "stored vote counters",
+		Up: `
+UPDATE votes SET
+    pro = (SELECT count(*) FROM vote_entries e WHERE e.guild_id = votes.guild_id AND e.vote_id = votes.vote_id AND e.vote),
+    con = (SELECT count(*) FROM vote_entries e WHERE e.guild_id = votes.guild_id AND e.vote_id = votes.vote_id AND NOT e.vote);
+ALTER TABLE votes
+    ALTER COLUMN pro SET DEFAULT 0,
+    ALTER COLUMN pro SET NOT NULL,
+    ALTER COLUMN con SET DEFAULT 0,
+    ALTER COLUMN con SET NOT NULL;`,
+		Down: `
+ALTER TABLE votes
+    ALTER COLUMN pro DROP NOT NULL,
+    ALTER COLUMN pro DROP DEFAULT,
+    ALTER COLUMN con DROP NOT NULL,
+    ALTER COLUMN con DROP DEFAULT;`,
+		// SQLite can not change the constraints of a column, the counters are always written with the vote
+		SQLiteUp: `
+UPDATE votes SET
+    pro = (SELECT count(*) FROM vote_entries e WHERE e.guild_id = votes.guild_id AND e.vote_id = votes.vote_id AND e.vote),
+    con = (SELECT count(*) FROM vote_entries e WHERE e.guild_id = votes.guild_id AND e.vote_id = votes.vote_id AND NOT e.vote);`,
+		SQLiteDown: `-- SQLite can not change the constraints of a column`,
+	},
+	{
+		Version: 9,
+		Name:    "vote numbers and messages",
+		Up: `
+ALTER TABLE votes ADD COLUMN number INTEGER;
+UPDATE votes SET number = n.number FROM (
+    SELECT vote_id, row_number() OVER (PARTITION BY guild_id ORDER BY created, vote_id) AS number FROM votes
+) n WHERE votes.vote_id = n.vote_id;
+ALTER TABLE votes ALTER COLUMN number SET NOT NULL;
+CREATE UNIQUE INDEX votes_guild_number ON votes (guild_id, number);
+CREATE TABLE vote_numbers (
+    guild_id        VARCHAR(50) PRIMARY KEY,
+    last            INTEGER NOT NULL
+);
+INSERT INTO vote_numbers SELECT guild_id, max(number) FROM votes GROUP BY guild_id;
+CREATE TABLE vote_messages (
+    guild_id        VARCHAR(50) NOT NULL,
+    message_id      VARCHAR(50) NOT NULL,
+    vote_id         VARCHAR(50) NOT NULL,
+    primary key (guild_id, message_id)
+);
+INSERT INTO vote_messages SELECT guild_id, vote_id, vote_id FROM votes;
+INSERT INTO vote_messages SELECT guild_id, current_id, vote_id FROM votes WHERE current_id IS NOT NULL AND current_id <> vote_id;`,
+		Down: `
+DROP TABLE vote_messages;
+DROP TABLE vote_numbers;
+DROP INDEX votes_guild_number;
+ALTER TABLE votes DROP COLUMN number;`,
+		// like Up, without making number NOT NULL, which SQLite can not add to a column
+		SQLiteUp: `
+ALTER TABLE votes ADD COLUMN number INTEGER;
+UPDATE votes SET number = n.number FROM (
+    SELECT vote_id, row_number() OVER (PARTITION BY guild_id ORDER BY created, vote_id) AS number FROM votes
+) n WHERE votes.vote_id = n.vote_id;
+CREATE UNIQUE INDEX votes_guild_number ON votes (guild_id, number);
+CREATE TABLE vote_numbers (
+    guild_id        VARCHAR(50) PRIMARY KEY,
+    last            INTEGER NOT NULL
+);
+INSERT INTO vote_numbers SELECT guild_id, max(number) FROM votes GROUP BY guild_id;
+CREATE TABLE vote_messages (
+    guild_id        VARCHAR(50) NOT NULL,
+    message_id      VARCHAR(50) NOT NULL,
+    vote_id         VARCHAR(50) NOT NULL,
+    primary key (guild_id, message_id)
+);
+INSERT INTO vote_messages SELECT guild_id, vote_id, vote_id FROM votes;
+INSERT INTO vote_messages SELECT guild_id, current_id, vote_id FROM votes WHERE current_id IS NOT NULL AND current_id <> vote_id;`,
+	},
+	{
+		Version: 10,
+		Name:    "vote tags and archives",
+		Up: `
+ALTER TABLE votes ADD COLUMN tags TEXT NOT NULL DEFAULT '';
+CREATE TABLE guild_archives (
+    guild_id        VARCHAR(50) PRIMARY KEY,
+    channel_id      VARCHAR(50) NOT NULL
+);`,
+		Down: `
+DROP TABLE guild_archives;
+ALTER TABLE votes DROP COLUMN tags;`,
+	},
+	{
+		Version: 11,
+		Name:    "audit events and api keys",
+		Up: `
+CREATE TABLE audit_events (
+    event_id        BIGSERIAL PRIMARY KEY,
+    guild_id        VARCHAR(50) NOT NULL,
+    kind            VARCHAR(30) NOT NULL,
+    vote_id         VARCHAR(50) NOT NULL DEFAULT '',
+    number          INTEGER NOT NULL DEFAULT 0,
+    user_id         VARCHAR(50) NOT NULL DEFAULT '',
+    created         TIMESTAMP NOT NULL,
+    detail          TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX audit_events_guild ON audit_events (guild_id, event_id);
+CREATE TABLE guild_api_keys (
+    guild_id        VARCHAR(50) NOT NULL,
+    name            VARCHAR(50) NOT NULL,
+    key_hash        VARCHAR(64) NOT NULL UNIQUE,
+    created         TIMESTAMP NOT NULL,
+    primary key (guild_id, name)
+);`,
+		Down: `
+DROP TABLE guild_api_keys;
+DROP TABLE audit_events;`,
+	},
+	{
+		Version: 12,
+		Name:    "webhooks",
+		Up: `
+CREATE TABLE guild_webhooks (
+    webhook_id      BIGSERIAL PRIMARY KEY,
+    guild_id        VARCHAR(50) NOT NULL,
+    url             TEXT NOT NULL,
+    events          TEXT NOT NULL DEFAULT '',
+    secret          VARCHAR(64) NOT NULL,
+    created         TIMESTAMP NOT NULL
+);
+CREATE INDEX guild_webhooks_guild ON guild_webhooks (guild_id);
+CREATE TABLE webhook_failures (
+    failure_id      BIGSERIAL PRIMARY KEY,
+    guild_id        VARCHAR(50) NOT NULL,
+    webhook_id      BIGINT NOT NULL,
+    url             TEXT NOT NULL,
+    event           VARCHAR(30) NOT NULL,
+    payload         TEXT NOT NULL,
+    attempts        INTEGER NOT NULL,
+    error           TEXT NOT NULL,
+    failed          TIMESTAMP NOT NULL
+);
+CREATE INDEX webhook_failures_guild ON webhook_failures (guild_id, failure_id);`,
+		Down: `
+DROP TABLE webhook_failures;
+DROP TABLE guild_webhooks;`,
+	},
+	{
+		Version: 13,
+		Name:    "anti-spam rules and drafts",
+		Up: `
+ALTER TABLE guild_rules ADD COLUMN max_open_votes INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE guild_rules ADD COLUMN max_votes_per_day INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE guild_rules ADD COLUMN min_title_length INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE guild_rules ADD COLUMN min_description_length INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE guild_rules ADD COLUMN cosponsors INTEGER NOT NULL DEFAULT 0;
+CREATE TABLE vote_drafts (
+    guild_id        VARCHAR(50) NOT NULL,
+    draft_id        VARCHAR(50) NOT NULL,
+    channel_id      VARCHAR(50) NOT NULL,
+    author          VARCHAR(50) NOT NULL,
+    title           TEXT NOT NULL,
+    description     TEXT NOT NULL,
+    duration        BIGINT NOT NULL,
+    tags            TEXT NOT NULL DEFAULT '',
+    created         TIMESTAMP NOT NULL,
+    primary key (guild_id, draft_id)
+);
+CREATE INDEX vote_drafts_created ON vote_drafts (created);`,
+		Down: `
+DROP TABLE vote_drafts;
+ALTER TABLE guild_rules DROP COLUMN cosponsors;
+ALTER TABLE guild_rules DROP COLUMN min_description_length;
+ALTER TABLE guild_rules DROP COLUMN min_title_length;
+ALTER TABLE guild_rules DROP COLUMN max_votes_per_day;
+ALTER TABLE guild_rules DROP COLUMN max_open_votes;`,
+	},
+	{
+		Version: 14,
+		Name:    "brigading detection",
+		Up: `
+CREATE TABLE guild_brigading (
+    guild_id        VARCHAR(50) PRIMARY KEY,
+    channel_id      VARCHAR(50) NOT NULL DEFAULT '',
+    quarantine      BOOLEAN NOT NULL DEFAULT false
+);
+CREATE TABLE quarantined_ballots (
+    guild_id        VARCHAR(50) NOT NULL,
+    vote_id         VARCHAR(50) NOT NULL,
+    user_id         VARCHAR(50) NOT NULL,
+    vote            BOOLEAN NOT NULL,
+    signals         TEXT NOT NULL DEFAULT '',
+    cast_at         TIMESTAMP NOT NULL,
+    released        BOOLEAN NOT NULL DEFAULT false,
+    primary key (guild_id, vote_id, user_id)
+);`,
+		Down: `
+DROP TABLE quarantined_ballots;
+DROP TABLE guild_brigading;`,
+	},
+	{
+		Version: 15,
+		Name:    "api key of audit events",
+		Up: `
+ALTER TABLE audit_events ADD COLUMN api_key VARCHAR(50) NOT NULL DEFAULT '';`,
+		Down: `
+ALTER TABLE audit_events DROP COLUMN api_key;`,
+	},
+	{
+		Version: 16,
+		Name:    "elections",
+		Up: `
+ALTER TABLE votes ADD COLUMN nominee_id VARCHAR(50);
+ALTER TABLE votes ADD COLUMN role VARCHAR(100);
+ALTER TABLE votes ADD COLUMN term BIGINT DEFAULT 0;
+ALTER TABLE votes ADD COLUMN distrust_id VARCHAR(50);`,
+		Down: `
+ALTER TABLE votes DROP COLUMN distrust_id;
+ALTER TABLE votes DROP COLUMN term;
+ALTER TABLE votes DROP COLUMN role;
+ALTER TABLE votes DROP COLUMN nominee_id;`,
+	},
+	{
+		Version: 17,
+		Name:    "drafted elections",
+		Up: `
+ALTER TABLE vote_drafts ADD COLUMN nominee_id VARCHAR(50);
+ALTER TABLE vote_drafts ADD COLUMN role VARCHAR(100);
+ALTER TABLE vote_drafts ADD COLUMN term BIGINT DEFAULT 0;
+ALTER TABLE vote_drafts ADD COLUMN distrust_id VARCHAR(50);`,
+		Down: `
+ALTER TABLE vote_drafts DROP COLUMN distrust_id;
+ALTER TABLE vote_drafts DROP COLUMN term;
+ALTER TABLE vote_drafts DROP COLUMN role;
+ALTER TABLE vote_drafts DROP COLUMN nominee_id;`,
+	},
+	{
+		Version: 18,
+		Name:    "schedule anchors",
+		Up: `
+ALTER TABLE scheduled_votes ADD COLUMN anchor TIMESTAMP;
+UPDATE scheduled_votes SET anchor = starts;
+ALTER TABLE scheduled_votes ALTER COLUMN anchor SET NOT NULL;`,
+		Down: `
+ALTER TABLE scheduled_votes DROP COLUMN anchor;`,
+		// like Up, without making anchor NOT NULL, which SQLite can not add to a column
+		SQLiteUp: `
+ALTER TABLE scheduled_votes ADD COLUMN anchor TIMESTAMP;
+UPDATE scheduled_votes SET anchor = starts;`,
+	},
+	{
+		Version: 19,
+		Name:    "early close rule",
+		Up: `
+ALTER TABLE guild_rules ADD COLUMN no_early_close BOOLEAN NOT NULL DEFAULT false;`,
+		Down: `
+ALTER TABLE guild_rules DROP COLUMN no_early_close;`,
+	},
+}
+
+// Migrate applying all pending migrations
+func (s *SQLStore) Migrate() error {
+	return s.migrate(func(tx *timedTx, applied map[int]time.Time) error {
+		for _, m := range migrations {
+			if _, ok := applied[m.Version]; ok {
+				continue
+			}
+			s.log.Info("applying migration", zap.Int("version", m.Version), zap.String("name", m.Name))
+			_, err := tx.Exec(m.up(s.driver))
+			if err != nil {
+				s.log.Error("error applying migration", zap.Int("version", m.Version), zap.Error(err))
+				return errors.Wrapf(err, "unable to apply migration %d", m.Version)
+			}
+			_, err = tx.Exec("INSERT INTO schema_migrations(version, name) VALUES($1,$2)", m.Version, m.Name)
+			if err != nil {
+				s.log.Error("error recording migration", zap.Int("version", m.Version), zap.Error(err))
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// MigrateDown reverting the latest applied migration
+func (s *SQLStore) MigrateDown() error {
+	return s.migrate(func(tx *timedTx, applied map[int]time.Time) error {
+		for i := len(migrations) - 1; i >= 0; i-- {
+			m := migrations[i]
+			if _, ok := applied[m.Version]; !ok {
+				continue
+			}
+			s.log.Info("reverting migration", zap.Int("version", m.Version), zap.String("name", m.Name))
+			_, err := tx.Exec(m.down(s.driver))
+			if err != nil {
+				s.log.Error("error reverting migration", zap.Int("version", m.Version), zap.Error(err))
+				return errors.Wrapf(err, "unable to revert migration %d", m.Version)
+			}
+			_, err = tx.Exec("DELETE FROM schema_migrations WHERE version = $1", m.Version)
+			if err != nil {
+				s.log.Error("error recording migration", zap.Int("version", m.Version), zap.Error(err))
+				return err
+			}
+			return nil
+		}
+		s.log.Info("no migration to revert")
+		return nil
+	})
+}
+
+// MigrationStatus of all known migrations
+func (s *SQLStore) MigrationStatus() ([]MigrationState, error) {
+	var states []MigrationState
+	err := s.migrate(func(tx *timedTx, applied map[int]time.Time) error {
+		for _, m := range migrations {
+			states = append(states, MigrationState{Migration: m, Applied: applied[m.Version]})
+		}
+		return nil
+	})
+	return states, err
+}
+
+// migrate running f within a transaction holding the migration lock.
+// SQLite transactions hold the write lock of the database from their start, so they need no other lock.
+func (s *SQLStore) migrate(f func(tx *timedTx, applied map[int]time.Time) error) error {
+	tx, err := s.begin()
+	if err != nil {
+		s.log.Error("error starting migration", zap.Error(err))
+		return err
+	}
+	defer tx.Rollback()
+
+	if s.driver == "postgres" {
+		// released when the transaction ends
+		_, err = tx.Exec("SELECT pg_advisory_xact_lock($1)", migrationLock)
+		if err != nil {
+			s.log.Error("error locking migrations", zap.Error(err))
+			return err
+		}
+	}
+	_, err = tx.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version     INTEGER PRIMARY KEY,
+		name        VARCHAR(256) NOT NULL,
+		applied     TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+	)`)
+	if err != nil {
+		s.log.Error("error creating migrations table", zap.Error(err))
+		return err
+	}
+
+	applied := make(map[int]time.Time)
+	rows, err := tx.Query("select version, applied from schema_migrations")
+	if err != nil {
+		s.log.Error("error querying migrations", zap.Error(err))
+		return err
+	}
+	for rows.Next() {
+		var version int
+		var at time.Time
+		err = rows.Scan(&version, &at)
+		if err != nil {
+			rows.Close()
+			s.log.Error("could not scan row", zap.Error(err))
+			return err
+		}
+		applied[version] = at
+	}
+	rows.Close()
+	err = rows.Err()
+	if err != nil {
+		s.log.Error("error reading rows", zap.Error(err))
+		return err
+	}
+
+	err = f(tx, applied)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,444 @@
+package votes
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/playnet-public/democracy.bot/pkg/helpers"
+	"go.uber.org/zap"
+)
+
+// Proposal states
+const (
+	ProposalDiscussion = "discussion"
+	ProposalVoting     = "voting"
+)
+
+// Amendment states
+const (
+	AmendmentPending  = "pending"
+	AmendmentAccepted = "accepted"
+	AmendmentRejected = "rejected"
+	AmendmentSubVote  = "subvote"
+)
+
+// Proposal in discussion before it is opened for voting.
+// The ID of a proposal is the ID of its discussion channel.
+type Proposal struct {
+	Guild       string
+	ID          string
+	MessageID   string
+	Title       string
+	Description string
+	Author      string
+	Revision    int
+	Status      string
+	Created     time.Time
+}
+
+// Amendment to the text of a proposal.
+// The ID of an amendment is the ID of its message in the discussion channel.
+type Amendment struct {
+	Guild       string
+	ID          string
+	Proposal    string
+	Author      string
+	Description string
+	Status      string
+	// Vote deciding the amendment if it went to a sub-vote
+	Vote string
+}
+
+// Discuss Message Handler creating a proposal and its discussion channel
+func (v *VoteHandler) Discuss(c *discordgo.Channel, s Session, m *discordgo.MessageCreate) error {
+	if m.Content == "reset_handler" {
+		return nil
+	}
+
+	m.Content = strings.TrimPrefix(m.Content, "discuss ")
+	text := strings.Split(m.Content, "|")
+	if len(text) < 2 {
+		return userError("invalid proposal", "Invalid proposal text. Please follow this schema: '!democracy discuss [title]|[text]'")
+	}
+
+	err := v.checkVoteCreation(c.GuildID, m.Author.ID, text[0], text[1])
+	if err != nil {
+		return userError("proposal rejected by rules", err.Error())
+	}
+
+	discussion, err := v.discord(s).GuildChannelCreate(c.GuildID, discussionChannelName(text[0]), "text")
+	if err != nil {
+		return internalError("unable to create discussion channel", "unable to create discussion channel", err)
+	}
+	_, err = v.discord(s).ChannelEditComplex(discussion.ID, &discordgo.ChannelEdit{
+		Name:     discussion.Name,
+		Topic:    fmt.Sprintf("Discussion of '%s'. Propose changes with '!democracy amend [text]'.", text[0]),
+		ParentID: c.ParentID,
+	})
+	if err != nil {
+		v.discord(s).ChannelDelete(discussion.ID)
+		return internalError("unable to update discussion channel", "unable to update discussion channel", err)
+	}
+
+	proposal := Proposal{
+		Guild:       c.GuildID,
+		ID:          discussion.ID,
+		Title:       text[0],
+		Description: text[1],
+		Author:      m.Author.ID,
+		Revision:    1,
+		Status:      ProposalDiscussion,
+		Created:     v.clock.Now(),
+	}
+	announcement, err := v.discord(s).ChannelMessageSendEmbed(c.ID, newProposalEmbed(proposal, m.Author))
+	if err != nil {
+		v.discord(s).ChannelDelete(discussion.ID)
+		return internalError("unable to send embed", "unable to send embed", err)
+	}
+	proposal.MessageID = announcement.ID
+
+	err = v.db(s).InsertProposal(proposal)
+	if err != nil {
+		v.discord(s).ChannelDelete(discussion.ID)
+		v.discord(s).Delete(c.ID, announcement.ID)
+		return internalError("unable to store proposal", "Failed to store proposal in DB. Please contact support.", err)
+	}
+	err = v.db(s).InsertRevision(proposal, v.clock.Now())
+	if err != nil {
+		return internalError("unable to store revision", "Failed to store proposal in DB. Please contact support.", err)
+	}
+
+	_, err = v.discord(s).ChannelMessageSendEmbed(discussion.ID, newProposalEmbed(proposal, m.Author))
+	if err != nil {
+		v.logger(s).Error("unable to send embed", zap.String("guild", c.GuildID), zap.String("proposal", proposal.ID), zap.Error(err))
+	}
+	v.events.Publish(ElectionPhaseChanged{eventBase: v.eventBase(s, proposal.Guild), Proposal: proposal, Phase: ProposalDiscussion})
+	return v.reply(s, m, fmt.Sprintf("Discussion started in <#%s>", discussion.ID))
+}
+
+// Amend Message Handler proposing a new text for the proposal discussed in the current channel
+func (v *VoteHandler) Amend(c *discordgo.Channel, s Session, m *discordgo.MessageCreate) error {
+	if m.Content == "reset_handler" {
+		return nil
+	}
+
+	text := strings.TrimSpace(strings.TrimPrefix(m.Content, "amend"))
+	if text == "" {
+		return userError("invalid amendment", "Invalid amendment. Please follow this schema: '!democracy amend [text]'")
+	}
+	proposal, err := v.db(s).GetProposal(c.GuildID, m.ChannelID)
+	if err != nil {
+		return lookupError("proposal not found", "Amendments can only be made in the discussion channel of a proposal.", err)
+	}
+	if proposal.Status != ProposalDiscussion {
+		return userError("proposal frozen", "The proposal is already open for voting and can not be amended.")
+	}
+
+	amendment := Amendment{
+		Guild:       proposal.Guild,
+		Proposal:    proposal.ID,
+		Author:      m.Author.ID,
+		Description: text,
+		Status:      AmendmentPending,
+	}
+	msg, err := v.discord(s).ChannelMessageSendEmbed(m.ChannelID, newAmendmentEmbed(proposal, amendment, m.Author))
+	if err != nil {
+		return internalError("unable to send embed", "unable to send embed", err)
+	}
+	amendment.ID = msg.ID
+	for _, emoji := range []string{"✅", "❎"} {
+		err = v.discord(s).MessageReactionAdd(m.ChannelID, msg.ID, emoji)
+		if err != nil {
+			v.discord(s).Delete(m.ChannelID, msg.ID)
+			return internalError("unable to add emoji", fmt.Sprintf("unable to add emoji %s", emoji), err)
+		}
+	}
+	err = v.db(s).InsertAmendment(amendment)
+	if err != nil {
+		v.discord(s).Delete(m.ChannelID, msg.ID)
+		return internalError("unable to store amendment", "Failed to store amendment in DB. Please contact support.", err)
+	}
+	return v.reply(s, m, fmt.Sprintf("Amendment proposed. <@%s> may accept it or send it to a sub-vote.", proposal.Author))
+}
+
+// ReactAmendment Handler for the proposal author accepting (✅) an amendment or sending it to a sub-vote (❎)
+func (v *VoteHandler) ReactAmendment(c *discordgo.Channel, s Session, m *discordgo.MessageReactionAdd) error {
+	if m.Emoji.Name != "✅" && m.Emoji.Name != "❎" {
+		return nil
+	}
+	amendment, err := v.db(s).GetAmendment(c.GuildID, m.MessageID)
+	if err != nil {
+		return internalError("unable to fetch amendment from db", "", err)
+	}
+	proposal, err := v.db(s).GetProposal(amendment.Guild, amendment.Proposal)
+	if err != nil {
+		return internalError("unable to fetch proposal from db", "", err)
+	}
+	if proposal.Author != m.UserID || proposal.Status != ProposalDiscussion || amendment.Status != AmendmentPending {
+		v.logger(s).Info("ignoring amendment reaction", zap.String("guild", amendment.Guild), zap.String("amendment", amendment.ID), zap.String("user", m.UserID))
+		return nil
+	}
+
+	if m.Emoji.Name == "✅" {
+		err = v.applyAmendment(s, &proposal, &amendment)
+		if err != nil {
+			return internalError("unable to apply amendment", "", err)
+		}
+	} else {
+		duration, err := v.voteDuration(amendment.Guild, "")
+		if err != nil {
+			return internalError("unable to determine sub-vote duration", "", err)
+		}
+		now := v.clock.Now()
+		vote := Vote{
+			Guild:       amendment.Guild,
+			Title:       fmt.Sprintf("Amendment to %s", proposal.Title),
+			Description: amendment.Description,
+			Author:      amendment.Author,
+			Created:     now,
+			Expires:     now.Add(duration),
+			Discussion:  proposal.ID,
+			Revision:    proposal.Revision,
+		}
+		vote, err = v.openVote(s, m.ChannelID, vote)
+		if err != nil {
+			return internalError("unable to open sub-vote", "", err)
+		}
+		amendment.Status = AmendmentSubVote
+		amendment.Vote = vote.ID
+		err = v.db(s).UpdateAmendment(amendment)
+		if err != nil {
+			return internalError("unable to update amendment", "", err).forVote(vote)
+		}
+	}
+	v.updateAmendmentEmbed(s, m.ChannelID, proposal, amendment)
+	v.discord(s).RemoveAllReactions(m.ChannelID, m.MessageID)
+	return nil
+}
+
+// OpenProposal Message Handler freezing the proposal text and opening the vote
+func (v *VoteHandler) OpenProposal(c *discordgo.Channel, s Session, m *discordgo.MessageCreate) error {
+	if m.Content == "reset_handler" {
+		return nil
+	}
+
+	proposal, err := v.db(s).GetProposal(c.GuildID, m.ChannelID)
+	if err != nil {
+		return lookupError("proposal not found", "Votes can only be opened in the discussion channel of a proposal.", err)
+	}
+	if proposal.Author != m.Author.ID {
+		return permissionError("Only the author may open the vote on a proposal.")
+	}
+	if proposal.Status != ProposalDiscussion {
+		return userError("proposal frozen", "The proposal is already open for voting.")
+	}
+	duration, err := v.voteDuration(proposal.Guild, strings.TrimSpace(strings.TrimPrefix(m.Content, "open")))
+	if err != nil {
+		return userError("invalid duration", err.Error())
+	}
+
+	amendments, err := v.db(s).ReadAmendments(proposal.Guild, proposal.ID)
+	if err != nil {
+		return internalError("unable to read amendments", "Failed to read amendments from DB. Please contact support.", err)
+	}
+	for _, amendment := range amendments {
+		err = v.resolveAmendment(s, &proposal, &amendment)
+		if err != nil {
+			return internalError("unable to resolve amendment", "Failed to resolve amendments. Please contact support.", err)
+		}
+	}
+
+	now := v.clock.Now()
+	vote := Vote{
+		Guild:       proposal.Guild,
+		Title:       proposal.Title,
+		Description: proposal.Description,
+		Author:      proposal.Author,
+		Created:     now,
+		Expires:     now.Add(duration),
+		Discussion:  proposal.ID,
+		Revision:    proposal.Revision,
+	}
+	vote, err = v.openVote(s, c.ID, vote)
+	if err != nil {
+		return internalError("unable to open vote", "Failed to open vote. Please contact support.", err)
+	}
+
+	proposal.Status = ProposalVoting
+	err = v.db(s).UpdateProposal(proposal)
+	if err != nil {
+		return internalError("unable to update proposal", "Failed to store proposal in DB. Please contact support.", err).forVote(vote)
+	}
+	v.events.Publish(ElectionPhaseChanged{eventBase: v.eventBase(s, proposal.Guild), Proposal: proposal, Phase: ProposalVoting})
+	v.updateProposalEmbed(s, proposal)
+	return v.reply(s, m, fmt.Sprintf("Voting opened on revision %d", proposal.Revision))
+}
+
+// resolveAmendment that is still open when the proposal is frozen.
+// Sub-votes are closed like any other vote and decided by simple majority, undecided amendments are rejected.
+func (v *VoteHandler) resolveAmendment(s Session, proposal *Proposal, amendment *Amendment) error {
+	switch amendment.Status {
+	case AmendmentPending:
+		amendment.Status = AmendmentRejected
+		err := v.store.UpdateAmendment(*amendment)
+		if err != nil {
+			return err
+		}
+	case AmendmentSubVote:
+		vote, err := v.store.GetVote(amendment.Guild, amendment.Vote)
+		if err != nil {
+			return err
+		}
+		vote, err = v.store.GetVoteCount(vote)
+		if err != nil {
+			return err
+		}
+		if !vote.Closed {
+			err = v.closeVote(s, vote)
+			if err != nil {
+				return err
+			}
+		}
+		if vote.Pro > vote.Con {
+			err = v.applyAmendment(s, proposal, amendment)
+			if err == nil {
+				v.events.Publish(ActionExecuted{
+					voteEvent: v.voteEvent(s, vote),
+					Action:    "amend",
+					Summary:   fmt.Sprintf("amended '%s' to revision %d", proposal.Title, proposal.Revision),
+				})
+			}
+		} else {
+			amendment.Status = AmendmentRejected
+			err = v.store.UpdateAmendment(*amendment)
+		}
+		if err != nil {
+			return err
+		}
+	default:
+		return nil
+	}
+	v.updateAmendmentEmbed(s, proposal.ID, *proposal, *amendment)
+	return nil
+}
+
+// applyAmendment to proposal as new revision
+func (v *VoteHandler) applyAmendment(s Session, proposal *Proposal, amendment *Amendment) error {
+	proposal.Description = amendment.Description
+	proposal.Revision = proposal.Revision + 1
+	err := v.store.InsertRevision(*proposal, v.clock.Now())
+	if err != nil {
+		return err
+	}
+	err = v.store.UpdateProposal(*proposal)
+	if err != nil {
+		return err
+	}
+	amendment.Status = AmendmentAccepted
+	err = v.store.UpdateAmendment(*amendment)
+	if err != nil {
+		return err
+	}
+	v.updateProposalEmbed(s, *proposal)
+	return nil
+}
+
+// updateProposalEmbed announcing the proposal in the democracy channel
+func (v *VoteHandler) updateProposalEmbed(s Session, proposal Proposal) {
+	c, err := democracyChannel(s, proposal.Guild)
+	if err != nil {
+		v.log.Error("could not fetch democracy channel", zap.String("guild", proposal.Guild), zap.Error(err))
+		return
+	}
+	author, err := v.authors.get(s, proposal.Author)
+	if err != nil {
+		v.log.Error("could not fetch author", zap.String("guild", proposal.Guild), zap.String("author", proposal.Author), zap.Error(err))
+		return
+	}
+	v.discord(s).Edit(c.ID, proposal.MessageID, newProposalEmbed(proposal, author))
+}
+
+// updateAmendmentEmbed in the discussion channel
+func (v *VoteHandler) updateAmendmentEmbed(s Session, channel string, proposal Proposal, amendment Amendment) {
+	author, err := v.authors.get(s, amendment.Author)
+	if err != nil {
+		v.log.Error("could not fetch author", zap.String("guild", amendment.Guild), zap.String("author", amendment.Author), zap.Error(err))
+		return
+	}
+	v.discord(s).Edit(channel, amendment.ID, newAmendmentEmbed(proposal, amendment, author))
+}
+
+// reply the plain text success message of a command and remove the command
+func (v *VoteHandler) reply(s Session, m *discordgo.MessageCreate, text string) error {
+	v.logger(s).Info("command success", zap.String("msg", m.Content))
+	_, err := v.discord(s).ChannelMessageSend(m.ChannelID, text)
+	if err != nil {
+		return internalError("failed to send callback", "", err)
+	}
+	v.discord(s).Delete(m.ChannelID, m.ID)
+	return nil
+}
+
+// replyEmbed of a command and remove the command
+func (v *VoteHandler) replyEmbed(s Session, m *discordgo.MessageCreate, embed *discordgo.MessageEmbed) error {
+	_, err := v.discord(s).ChannelMessageSendEmbed(m.ChannelID, embed)
+	if err != nil {
+		return internalError("unable to send embed", "", err)
+	}
+	v.discord(s).Delete(m.ChannelID, m.ID)
+	return nil
+}
+
+// discussionChannelName derived from the proposal title
+func discussionChannelName(title string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r + 'a' - 'A'
+		}
+		return '-'
+	}, strings.TrimSpace(title))
+	name = strings.Trim(name, "-")
+	if len(name) > 80 {
+		name = name[:80]
+	}
+	return "proposal-" + name
+}
+
+func newProposalEmbed(proposal Proposal, author *discordgo.User) *discordgo.MessageEmbed {
+	status := "Discussion"
+	if proposal.Status == ProposalVoting {
+		status = "Voting"
+	}
+	return helpers.NewEmbed().
+		SetTitle(fmt.Sprintf("[Proposal] %s", proposal.Title)).
+		SetAuthor(author.Username, author.AvatarURL("100x100")).
+		SetColor(0x587987).
+		SetDescription(proposal.Description).
+		SetTimestamp(proposal.Created).
+		AddField("Discussion", fmt.Sprintf("<#%s>", proposal.ID), true).
+		AddField("Revision", fmt.Sprintf("%d", proposal.Revision), true).
+		AddField("Status", status, true).
+		MessageEmbed
+}
+
+func newAmendmentEmbed(proposal Proposal, amendment Amendment, author *discordgo.User) *discordgo.MessageEmbed {
+	status := map[string]string{
+		AmendmentPending:  "Waiting for the author: ✅ accept, ❎ send to sub-vote",
+		AmendmentAccepted: "Accepted",
+		AmendmentRejected: "Rejected",
+		AmendmentSubVote:  "Decided by sub-vote when voting opens",
+	}[amendment.Status]
+	return helpers.NewEmbed().
+		SetTitle(fmt.Sprintf("[Amendment] %s", proposal.Title)).
+		SetAuthor(author.Username, author.AvatarURL("100x100")).
+		SetColor(0x587987).
+		SetDescription(amendment.Description).
+		AddField("Based on Revision", fmt.Sprintf("%d", proposal.Revision), true).
+		AddField("Status", status, true).
+		MessageEmbed
+}
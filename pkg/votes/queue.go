@@ -0,0 +1,454 @@
+package votes
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+const (
+	// queueMaxAttempts of a Discord action before it is dropped
+	queueMaxAttempts = 5
+	// queueBackoff before retrying a failed action, doubled on every attempt
+	queueBackoff = 500 * time.Millisecond
+	// queueMaxBackoff between two attempts
+	queueMaxBackoff = 30 * time.Second
+)
+
+// errQueueClosed is returned for actions pushed after the queue was closed
+var errQueueClosed = errors.New("action queue closed")
+
+// action on Discord executed by the actionQueue
+type action struct {
+	name string
+	// key of a coalescable edit, empty for all other actions
+	key   string
+	embed *discordgo.MessageEmbed
+	run   func(a *action) error
+	// done receives the result of synchronous actions
+	done chan error
+}
+
+// actionQueue executing all Discord writes of the VoteHandler in order.
+// Pending edits of the same message are coalesced into one, transient failures
+// and rate limits are retried with backoff.
+type actionQueue struct {
+	log *zap.Logger
+
+	mu      sync.Mutex
+	actions []*action
+	// edits pending for a message by key
+	edits  map[string]*action
+	notify chan struct{}
+	closed bool
+	// stopped is closed after the worker returned
+	stopped chan struct{}
+
+	sleep func(time.Duration)
+}
+
+func newActionQueue(log *zap.Logger) *actionQueue {
+	q := &actionQueue{
+		log:     log,
+		edits:   make(map[string]*action),
+		notify:  make(chan struct{}, 1),
+		stopped: make(chan struct{}),
+		sleep:   time.Sleep,
+	}
+	go q.work()
+	return q
+}
+
+// push a to the end of the queue
+func (q *actionQueue) push(a *action) {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		q.log.Error("discarding action on closed queue", zap.String("action", a.name))
+		if a.done != nil {
+			a.done <- errQueueClosed
+		}
+		return
+	}
+	q.actions = append(q.actions, a)
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+	q.mu.Unlock()
+}
+
+// Edit the embed of message, replacing a still pending edit of the same message
+func (q *actionQueue) Edit(s Session, channel, message string, embed *discordgo.MessageEmbed) {
+	if embed == nil {
+		return
+	}
+	k := key(channel, message)
+	q.mu.Lock()
+	if pending, ok := q.edits[k]; ok {
+		pending.embed = embed
+		q.mu.Unlock()
+		return
+	}
+	a := &action{
+		name:  "edit",
+		key:   k,
+		embed: embed,
+		run: func(a *action) error {
+			q.mu.Lock()
+			// later edits queue a new action once this one started
+			if q.edits[a.key] == a {
+				delete(q.edits, a.key)
+			}
+			edit := discordgo.NewMessageEdit(channel, message)
+			edit.Embed = a.embed
+			q.mu.Unlock()
+			_, err := s.ChannelMessageEditComplex(edit)
+			return err
+		},
+	}
+	q.edits[k] = a
+	q.mu.Unlock()
+	q.push(a)
+}
+
+// RemoveReaction of user from message
+func (q *actionQueue) RemoveReaction(s Session, channel, message, emoji, user string) {
+	q.push(&action{
+		name: "remove reaction",
+		run: func(*action) error {
+			return s.MessageReactionRemove(channel, message, emoji, user)
+		},
+	})
+}
+
+// RemoveAllReactions from message
+func (q *actionQueue) RemoveAllReactions(s Session, channel, message string) {
+	q.push(&action{
+		name: "remove all reactions",
+		run: func(*action) error {
+			return s.MessageReactionsRemoveAll(channel, message)
+		},
+	})
+}
+
+// Delete message without waiting, failures are logged by the queue
+func (q *actionQueue) Delete(s Session, channel, message string) {
+	q.push(&action{
+		name: "delete",
+		run: func(*action) error {
+			return s.ChannelMessageDelete(channel, message)
+		},
+	})
+}
+
+// Do f in order with all other actions and wait for its result.
+// Used for writes whose result is needed, like sending a message.
+func (q *actionQueue) Do(name string, f func() error) error {
+	a := &action{
+		name: name,
+		run:  func(*action) error { return f() },
+		done: make(chan error, 1),
+	}
+	q.push(a)
+	return <-a.done
+}
+
+// Flush waiting until all actions pushed before were executed
+func (q *actionQueue) Flush() {
+	q.Do("flush", func() error { return nil })
+}
+
+// Close the queue after executing all pending actions
+func (q *actionQueue) Close() {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return
+	}
+	q.closed = true
+	close(q.notify)
+	q.mu.Unlock()
+	<-q.stopped
+}
+
+func (q *actionQueue) work() {
+	defer close(q.stopped)
+	for {
+		q.mu.Lock()
+		if len(q.actions) == 0 {
+			q.mu.Unlock()
+			if _, ok := <-q.notify; !ok {
+				// drain what was queued before closing
+				q.mu.Lock()
+				empty := len(q.actions) == 0
+				q.mu.Unlock()
+				if empty {
+					return
+				}
+			}
+			continue
+		}
+		a := q.actions[0]
+		q.actions = q.actions[1:]
+		q.mu.Unlock()
+
+		err := q.execute(a)
+		if a.done != nil {
+			a.done <- err
+		}
+	}
+}
+
+// execute a, retrying rate limited and transient failures
+func (q *actionQueue) execute(a *action) error {
+	backoff := queueBackoff
+	for attempt := 1; ; attempt++ {
+		err := a.run(a)
+		if err == nil {
+			return nil
+		}
+		observeDiscordError(a.name, err)
+		wait, retry := retryAfter(err, backoff)
+		if !retry || attempt >= queueMaxAttempts {
+			q.log.Error("discord action failed", zap.String("action", a.name), zap.Int("attempt", attempt), zap.Error(err))
+			return err
+		}
+		q.log.Info("retrying discord action", zap.String("action", a.name), zap.Int("attempt", attempt), zap.Duration("wait", wait), zap.Error(err))
+		q.sleep(wait)
+		backoff = backoff * 2
+		if backoff > queueMaxBackoff {
+			backoff = queueMaxBackoff
+		}
+	}
+}
+
+// retryAfter returns how long to wait before retrying after err and whether it is worth retrying at all
+func retryAfter(err error, backoff time.Duration) (time.Duration, bool) {
+	if restErr, ok := err.(*discordgo.RESTError); ok && restErr.Response != nil {
+		switch {
+		case restErr.Response.StatusCode == http.StatusTooManyRequests:
+			var rl discordgo.TooManyRequests
+			if json.Unmarshal(restErr.ResponseBody, &rl) == nil && rl.RetryAfter > 0 {
+				return rl.RetryAfter * time.Millisecond, true
+			}
+			return backoff, true
+		case restErr.Response.StatusCode >= http.StatusInternalServerError:
+			return backoff, true
+		}
+		return 0, false
+	}
+	if _, ok := err.(net.Error); ok {
+		return backoff, true
+	}
+	// discordgo gives up on repeated bad gateways with a plain error
+	if strings.HasPrefix(err.Error(), "Exceeded Max retries") {
+		return backoff, true
+	}
+	return 0, false
+}
+
+// actionQueues keeping one actionQueue per guild, so the retries and rate limits
+// of one guild do not hold up the writes to all others.
+// Direct messages and channels missing from the state share the queue of the empty guild.
+type actionQueues struct {
+	log *zap.Logger
+
+	mu     sync.Mutex
+	queues map[string]*actionQueue
+	closed bool
+
+	sleep func(time.Duration)
+}
+
+func newActionQueues(log *zap.Logger) *actionQueues {
+	return &actionQueues{
+		log:    log,
+		queues: make(map[string]*actionQueue),
+		sleep:  time.Sleep,
+	}
+}
+
+// guild returning the queue of guild, starting it on first use
+func (qs *actionQueues) guild(guild string) *actionQueue {
+	qs.mu.Lock()
+	defer qs.mu.Unlock()
+	q, ok := qs.queues[guild]
+	if ok {
+		return q
+	}
+	q = newActionQueue(qs.log.With(zap.String("guild", guild)))
+	q.sleep = qs.sleep
+	if qs.closed {
+		// discards all actions
+		q.Close()
+	}
+	qs.queues[guild] = q
+	return q
+}
+
+// channel returning the queue of the guild of channel
+func (qs *actionQueues) channel(s Session, channel string) *actionQueue {
+	c, err := s.StateChannel(channel)
+	if err != nil {
+		return qs.guild("")
+	}
+	return qs.guild(c.GuildID)
+}
+
+func (qs *actionQueues) all() []*actionQueue {
+	qs.mu.Lock()
+	defer qs.mu.Unlock()
+	queues := make([]*actionQueue, 0, len(qs.queues))
+	for _, q := range qs.queues {
+		queues = append(queues, q)
+	}
+	return queues
+}
+
+// Flush waiting until all actions pushed to any queue before were executed
+func (qs *actionQueues) Flush() {
+	for _, q := range qs.all() {
+		q.Flush()
+	}
+}
+
+// Close all queues after executing their pending actions
+func (qs *actionQueues) Close() {
+	qs.mu.Lock()
+	qs.closed = true
+	qs.mu.Unlock()
+	for _, q := range qs.all() {
+		q.Close()
+	}
+}
+
+// discordWriter sending all writes of one session through the actionQueue of their guild.
+// Edits, cleanup deletes and reaction removals are queued without waiting for them,
+// all other writes wait for their result.
+type discordWriter struct {
+	qs *actionQueues
+	s  Session
+}
+
+// q of the guild of channel
+func (d discordWriter) q(channel string) *actionQueue {
+	return d.qs.channel(d.s, channel)
+}
+
+// Edit the embed of message
+func (d discordWriter) Edit(channel, message string, embed *discordgo.MessageEmbed) {
+	d.q(channel).Edit(d.s, channel, message, embed)
+}
+
+// RemoveReaction of user from message
+func (d discordWriter) RemoveReaction(channel, message, emoji, user string) {
+	d.q(channel).RemoveReaction(d.s, channel, message, emoji, user)
+}
+
+// RemoveAllReactions from message
+func (d discordWriter) RemoveAllReactions(channel, message string) {
+	d.q(channel).RemoveAllReactions(d.s, channel, message)
+}
+
+// Delete message from channel without waiting, for cleanups whose failure changes nothing
+func (d discordWriter) Delete(channel, message string) {
+	d.q(channel).Delete(d.s, channel, message)
+}
+
+// ChannelMessageSend content to channel
+func (d discordWriter) ChannelMessageSend(channel, content string) (msg *discordgo.Message, err error) {
+	err = d.q(channel).Do("send", func() error {
+		msg, err = d.s.ChannelMessageSend(channel, content)
+		return err
+	})
+	return msg, err
+}
+
+// ChannelMessageSendEmbed to channel
+func (d discordWriter) ChannelMessageSendEmbed(channel string, embed *discordgo.MessageEmbed) (msg *discordgo.Message, err error) {
+	err = d.q(channel).Do("send embed", func() error {
+		msg, err = d.s.ChannelMessageSendEmbed(channel, embed)
+		return err
+	})
+	return msg, err
+}
+
+// ChannelMessageDelete from channel
+func (d discordWriter) ChannelMessageDelete(channel, message string) error {
+	return d.q(channel).Do("delete", func() error {
+		return d.s.ChannelMessageDelete(channel, message)
+	})
+}
+
+// MessageReactionAdd to message
+func (d discordWriter) MessageReactionAdd(channel, message, emoji string) error {
+	return d.q(channel).Do("add reaction", func() error {
+		return d.s.MessageReactionAdd(channel, message, emoji)
+	})
+}
+
+// GuildChannelCreate in guild
+func (d discordWriter) GuildChannelCreate(guild, name, ctype string) (ch *discordgo.Channel, err error) {
+	err = d.qs.guild(guild).Do("create channel", func() error {
+		ch, err = d.s.GuildChannelCreate(guild, name, ctype)
+		return err
+	})
+	return ch, err
+}
+
+// ChannelEditComplex of channel
+func (d discordWriter) ChannelEditComplex(channel string, data *discordgo.ChannelEdit) (ch *discordgo.Channel, err error) {
+	err = d.q(channel).Do("edit channel", func() error {
+		ch, err = d.s.ChannelEditComplex(channel, data)
+		return err
+	})
+	return ch, err
+}
+
+// ChannelDelete of channel
+func (d discordWriter) ChannelDelete(channel string) (ch *discordgo.Channel, err error) {
+	err = d.q(channel).Do("delete channel", func() error {
+		ch, err = d.s.ChannelDelete(channel)
+		return err
+	})
+	return ch, err
+}
+
+// UserChannelCreate opening a direct message channel with user
+func (d discordWriter) UserChannelCreate(user string) (ch *discordgo.Channel, err error) {
+	err = d.qs.guild("").Do("create dm", func() error {
+		ch, err = d.s.UserChannelCreate(user)
+		return err
+	})
+	return ch, err
+}
+
+// discord writes of s through the VoteHandlers queues
+func (v *VoteHandler) discord(s Session) discordWriter {
+	return discordWriter{qs: v.queues, s: s}
+}
+
+// Flush waiting until all pending writes and reminders were sent to Discord and the audit events were recorded
+func (v *VoteHandler) Flush() {
+	v.audit.flush()
+	v.reminders.flush()
+	v.queues.Flush()
+}
+
+// Close the VoteHandler after sending all pending writes to Discord
+func (v *VoteHandler) Close() {
+	// asynchronous subscribers may still queue writes
+	v.events.Close()
+	v.audit.close()
+	v.webhooks.Close()
+	v.reminders.close()
+	v.queues.Close()
+}
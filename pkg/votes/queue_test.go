@@ -0,0 +1,82 @@
+package votes
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/playnet-public/democracy.bot/pkg/discordtest"
+	"go.uber.org/zap"
+)
+
+func TestActionQueueRetry(t *testing.T) {
+	q := newActionQueue(zap.NewNop())
+	var waits []time.Duration
+	q.sleep = func(d time.Duration) { waits = append(waits, d) }
+
+	limited := &discordgo.RESTError{
+		Response:     &http.Response{StatusCode: http.StatusTooManyRequests},
+		ResponseBody: []byte(`{"retry_after": 1200}`),
+	}
+	calls := 0
+	err := q.Do("test", func() error {
+		calls++
+		if calls == 1 {
+			return limited
+		}
+		return nil
+	})
+	if err != nil || calls != 2 {
+		t.Errorf("expected success on second attempt, got %v after %d calls", err, calls)
+	}
+	if len(waits) != 1 || waits[0] != 1200*time.Millisecond {
+		t.Errorf("expected to wait retry_after, got %v", waits)
+	}
+
+	calls = 0
+	err = q.Do("test", func() error {
+		calls++
+		return errors.New("bad request")
+	})
+	if err == nil || calls != 1 {
+		t.Errorf("expected permanent failure without retry, got %v after %d calls", err, calls)
+	}
+	q.Close()
+
+	if err := q.Do("test", func() error { return nil }); err != errQueueClosed {
+		t.Errorf("expected closed queue, got %v", err)
+	}
+}
+
+func TestActionQueuesPerGuild(t *testing.T) {
+	s := discordtest.NewSession()
+	owner := s.AddUser("alice")
+	slow := s.AddChannel(s.AddGuild("slow", owner), "democracy")
+	fast := s.AddChannel(s.AddGuild("fast", owner), "democracy")
+	qs := newActionQueues(zap.NewNop())
+	defer qs.Close()
+	d := discordWriter{qs: qs, s: s}
+
+	release := make(chan struct{})
+	go d.q(slow.ID).Do("blocked", func() error {
+		<-release
+		return nil
+	})
+	sent := make(chan error)
+	go func() {
+		_, err := d.ChannelMessageSend(fast.ID, "hello")
+		sent <- err
+	}()
+	select {
+	case err := <-sent:
+		if err != nil {
+			t.Errorf("expected message to be sent, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("expected the write of another guild not to wait for the blocked one")
+	}
+	close(release)
+	qs.Flush()
+}
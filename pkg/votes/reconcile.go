@@ -0,0 +1,168 @@
+package votes
+
+import (
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"go.uber.org/zap"
+)
+
+// reconcileReactionLimit of ballots read per emoji and pass. Recorded reactions are removed,
+// so ballots beyond the limit are picked up by the next pass.
+const reconcileReactionLimit = 100
+
+// Reconcile Ready Handler bringing all open votes in line with Discord after the bot was offline
+func (v *VoteHandler) Reconcile(s Session, event *discordgo.Ready) {
+	v.reconcile(s)
+}
+
+// RunReconciler checking all open votes every interval until stop is closed
+func (v *VoteHandler) RunReconciler(s Session, interval time.Duration, stop <-chan struct{}) {
+	v.log.Info("starting reconciler", zap.Duration("interval", interval))
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			v.log.Info("stopping reconciler")
+			return
+		case <-ticker.C:
+			v.reconcile(s)
+		}
+	}
+}
+
+// reconcile all open votes, skipping the pass if another one is still running
+func (v *VoteHandler) reconcile(s Session) {
+	if !atomic.CompareAndSwapInt32(&v.reconciling, 0, 1) {
+		v.log.Info("reconciliation already running")
+		return
+	}
+	defer atomic.StoreInt32(&v.reconciling, 0)
+
+	votes, err := v.store.ReadOpenVotes(v.clock.Now())
+	if err != nil {
+		v.log.Error("unable to read open votes", zap.Error(err))
+		return
+	}
+	v.log.Info("reconciling open votes", zap.Int("count", len(votes)))
+	for _, vote := range votes {
+		err = v.reconcileVote(s, vote)
+		if err != nil {
+			v.log.Error("unable to reconcile vote", zap.String("guild", vote.Guild), zap.String("vote", vote.ID), zap.Error(err))
+		}
+	}
+}
+
+// reconcileVote recording ballots cast while the bot was offline,
+// re-posting the vote if its message is gone and fixing an outdated embed
+func (v *VoteHandler) reconcileVote(s Session, vote Vote) error {
+	channel, err := v.voteChannel(s, vote)
+	if err != nil {
+		return err
+	}
+	msg, err := s.ChannelMessage(channel, vote.CurrentID)
+	if isNotFound(err) {
+		return v.repostVote(s, channel, vote)
+	}
+	if err != nil {
+		return err
+	}
+
+	vote, err = v.store.GetVoteCount(vote)
+	if err != nil {
+		return err
+	}
+	// reactions on an expired vote may have been added after it expired
+	emojis := []string{"✅", "❎"}
+	if !v.acceptsBallots(vote) {
+		emojis = nil
+	}
+	for _, emoji := range emojis {
+		users, err := s.MessageReactions(channel, msg.ID, emoji, reconcileReactionLimit)
+		if err != nil {
+			return err
+		}
+		for _, user := range users {
+			if user.ID == s.BotUser().ID {
+				continue
+			}
+			// missed ballots of voters in quarantine stay there
+			held, err := v.holdQuarantined(vote, user.ID, emoji == "✅")
+			if err != nil {
+				return err
+			}
+			var changed bool
+			if !held {
+				vote, changed, err = v.recordBallot(s, vote, user.ID, emoji == "✅")
+			}
+			if err == ErrVoteClosed {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if changed {
+				v.log.Info("recorded missed ballot", zap.String("guild", vote.Guild), zap.String("vote", vote.ID), zap.String("user", user.ID))
+			}
+			v.discord(s).RemoveReaction(channel, msg.ID, emoji, user.ID)
+		}
+	}
+
+	embed := v.embed(s, vote)
+	if embed != nil && !embedMatches(msg, embed) {
+		v.log.Info("updating outdated vote embed", zap.String("guild", vote.Guild), zap.String("vote", vote.ID))
+		v.discord(s).Edit(channel, msg.ID, embed)
+	}
+	v.checkEarlyClose(s, vote)
+	return nil
+}
+
+// repostVote whose message was deleted, keeping its ballots
+func (v *VoteHandler) repostVote(s Session, channel string, vote Vote) error {
+	vote, err := v.store.GetVoteCount(vote)
+	if err != nil {
+		return err
+	}
+	msg, err := postVote(v.discord(s), channel, v.embed(s, vote))
+	if err != nil {
+		return err
+	}
+	v.log.Info("re-posted missing vote", zap.String("guild", vote.Guild), zap.String("vote", vote.ID), zap.String("message", msg.ID))
+	vote.CurrentID = msg.ID
+	vote.Channel = channel
+	err = v.store.UpdateVote(vote.ID, vote)
+	if err != nil {
+		v.discord(s).Delete(channel, msg.ID)
+		return err
+	}
+	return nil
+}
+
+// embedMatches reports whether msg shows the content of embed
+func embedMatches(msg *discordgo.Message, embed *discordgo.MessageEmbed) bool {
+	if len(msg.Embeds) < 1 {
+		return false
+	}
+	shown := msg.Embeds[0]
+	if shown.Title != embed.Title || shown.Description != embed.Description || len(shown.Fields) != len(embed.Fields) {
+		return false
+	}
+	if (shown.Footer == nil) != (embed.Footer == nil) || shown.Footer != nil && shown.Footer.Text != embed.Footer.Text {
+		return false
+	}
+	for i, field := range embed.Fields {
+		if shown.Fields[i].Name != field.Name || shown.Fields[i].Value != field.Value {
+			return false
+		}
+	}
+	return true
+}
+
+// isNotFound reports whether err is a Discord 404, like for a deleted message
+func isNotFound(err error) bool {
+	restErr, ok := err.(*discordgo.RESTError)
+	return ok && restErr.Response != nil && restErr.Response.StatusCode == http.StatusNotFound
+}
@@ -0,0 +1,352 @@
+package votes
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+const (
+	// reminderInterval between direct messages to stay clear of Discords rate limits
+	reminderInterval = 500 * time.Millisecond
+	// reminderBuffer of direct messages waiting to be sent, further ones are retried on the next tick
+	reminderBuffer = 1024
+)
+
+// DefaultReminderPoints before a vote expires if a guild did not configure any
+var DefaultReminderPoints = []time.Duration{day, time.Hour}
+
+// Reminders configured for a guild
+type Reminders struct {
+	Guild string
+	// Points before a vote expires at which reminders are sent
+	Points []time.Duration
+	// Role mentioned in the last-call post, none if empty
+	Role string
+}
+
+// ParseReminderPoints from a comma separated list like 24h,1h
+func ParseReminderPoints(value string) ([]time.Duration, error) {
+	var points []time.Duration
+	if value == "off" {
+		return points, nil
+	}
+	for _, p := range strings.Split(value, ",") {
+		d, err := ParseDuration(p)
+		if err != nil {
+			return nil, err
+		}
+		points = append(points, d)
+	}
+	return points, nil
+}
+
+// due returns the reminder points which passed for a vote expiring at expires,
+// the one closest to expiry last
+func (r Reminders) due(expires, now time.Time) []time.Duration {
+	var due []time.Duration
+	for _, p := range r.Points {
+		if !now.Before(expires.Add(-p)) && now.Before(expires) {
+			due = append(due, p)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool { return due[i] > due[j] })
+	return due
+}
+
+// Notify Message Handler for opting in and out of reminder DMs
+func (v *VoteHandler) Notify(c *discordgo.Channel, s Session, m *discordgo.MessageCreate) error {
+	if m.Content == "reset_handler" {
+		return nil
+	}
+
+	var on bool
+	var reply string
+	switch strings.TrimSpace(strings.TrimPrefix(m.Content, "notify")) {
+	case "on":
+		on = true
+		reply = fmt.Sprintf("<@%s> will receive reminders for votes they did not vote on yet.", m.Author.ID)
+	case "off":
+		reply = fmt.Sprintf("<@%s> will no longer receive reminders.", m.Author.ID)
+	default:
+		return userError("invalid notify command", "Invalid notify command. Please follow this schema: '!democracy notify on|off'")
+	}
+	err := v.db(s).SetNotify(c.GuildID, m.Author.ID, on)
+	if err != nil {
+		return internalError("unable to store notification setting", "Failed to store notification setting in DB. Please contact support.", err)
+	}
+	return v.reply(s, m, reply)
+}
+
+// ReminderCommand Message Handler for configuring the guilds reminders
+func (v *VoteHandler) ReminderCommand(c *discordgo.Channel, s Session, m *discordgo.MessageCreate) error {
+	if m.Content == "reset_handler" {
+		return nil
+	}
+
+	reminders, err := v.db(s).GetReminders(c.GuildID)
+	if err != nil {
+		return internalError("unable to read reminders", "Failed to read reminders from DB. Please contact support.", err)
+	}
+	args := strings.Fields(strings.TrimPrefix(m.Content, "reminders"))
+	if len(args) == 0 {
+		return v.reply(s, m, reminders.String())
+	}
+	if len(args) != 2 {
+		return userError("invalid reminders command", "Invalid reminders command. Please follow this schema: '!democracy reminders [at|role] [24h,1h|off / @role|none]'")
+	}
+	g, err := s.Guild(c.GuildID)
+	if err != nil {
+		return internalError("could not fetch guild", "unable to update reminders", err)
+	}
+	if g.OwnerID != m.Author.ID {
+		return permissionError("Only the server owner may change reminders.")
+	}
+	switch args[0] {
+	case "at":
+		reminders.Points, err = ParseReminderPoints(args[1])
+		if err != nil {
+			return userError("invalid reminder points", err.Error())
+		}
+	case "role":
+		reminders.Role = ""
+		if args[1] != "none" {
+			if len(m.MentionRoles) != 1 {
+				return userError("invalid reminder role", "Please mention exactly one role or use 'none'.")
+			}
+			reminders.Role = m.MentionRoles[0]
+		}
+	default:
+		return userError("invalid reminders command", "Invalid reminders command. Please follow this schema: '!democracy reminders [at|role] [24h,1h|off / @role|none]'")
+	}
+	err = v.db(s).SetReminders(reminders)
+	if err != nil {
+		return internalError("unable to store reminders", "Failed to store reminders in DB. Please contact support.", err)
+	}
+	return v.reply(s, m, reminders.String())
+}
+
+// String describing the configured reminders
+func (r Reminders) String() string {
+	if len(r.Points) == 0 {
+		return "Reminders are disabled."
+	}
+	var points []string
+	for _, p := range r.Points {
+		points = append(points, p.String())
+	}
+	role := "no role"
+	if r.Role != "" {
+		role = fmt.Sprintf("<@&%s>", r.Role)
+	}
+	return fmt.Sprintf("Reminders are sent %s before a vote closes, mentioning %s.", strings.Join(points, ", "), role)
+}
+
+// sendReminders for all open votes which passed one of their guilds reminder points.
+// Every reminder is claimed before it is sent, so it is never repeated after a restart,
+// and released again if sending failed, so it is retried on the next tick.
+// If several points passed while the bot was offline only the latest one is sent.
+func (v *VoteHandler) sendReminders(s Session, now time.Time) {
+	votes, err := v.store.ReadOpenVotes(now)
+	if err != nil {
+		v.log.Error("unable to read open votes", zap.Error(err))
+		return
+	}
+	guilds := make(map[string]Reminders)
+	for _, vote := range votes {
+		reminders, ok := guilds[vote.Guild]
+		if !ok {
+			reminders, err = v.store.GetReminders(vote.Guild)
+			if err != nil {
+				v.log.Error("unable to read reminders", zap.String("guild", vote.Guild), zap.Error(err))
+				continue
+			}
+			guilds[vote.Guild] = reminders
+		}
+		due := reminders.due(vote.Expires, now)
+		for i, point := range due {
+			if i == len(due)-1 {
+				err = v.remind(s, vote, reminders, point)
+				if err != nil {
+					v.log.Error("unable to send reminders", zap.String("guild", vote.Guild), zap.String("vote", vote.ID), zap.Error(err))
+				}
+				break
+			}
+			// earlier points are skipped
+			_, err = v.store.ClaimReminder(vote, point, "")
+			if err != nil {
+				v.log.Error("unable to record reminder", zap.String("guild", vote.Guild), zap.String("vote", vote.ID), zap.Error(err))
+				break
+			}
+		}
+	}
+}
+
+// remind the channel and queue the direct messages to all opted in members who did not vote yet.
+// Direct messages are claimed on every tick, so those released after a failure are queued again.
+func (v *VoteHandler) remind(s Session, vote Vote, reminders Reminders, point time.Duration) error {
+	claimed, err := v.store.ClaimReminder(vote, point, "")
+	if err != nil {
+		return err
+	}
+	channel, err := v.voteChannel(s, vote)
+	if err != nil {
+		return v.releaseReminder(vote, point, "", err)
+	}
+	if claimed {
+		mention := ""
+		if reminders.Role != "" {
+			mention = fmt.Sprintf("<@&%s> ", reminders.Role)
+		}
+		_, err = v.discord(s).ChannelMessageSend(channel, fmt.Sprintf(
+			"%s**Last call:** the vote '%s' closes in %s. React with ✅ or ❎ to have your say.",
+			mention, vote.Title, vote.Expires.Sub(v.clock.Now())/time.Minute*time.Minute,
+		))
+		if err != nil {
+			return v.releaseReminder(vote, point, "", errors.Wrap(err, "unable to send last call"))
+		}
+	}
+
+	subscribers, err := v.store.ReadNotify(vote.Guild)
+	if err != nil {
+		return err
+	}
+	voters, err := v.store.ReadVoters(vote)
+	if err != nil {
+		return err
+	}
+	for _, user := range subscribers {
+		if voters[user] {
+			continue
+		}
+		claimed, err := v.store.ClaimReminder(vote, point, user)
+		if err != nil {
+			return err
+		}
+		if claimed {
+			v.reminders.push(reminderDM{s: s, vote: vote, channel: channel, point: point, user: user})
+		}
+	}
+	return nil
+}
+
+// releaseReminder of user after sending it failed with err, returning err
+func (v *VoteHandler) releaseReminder(vote Vote, point time.Duration, user string, err error) error {
+	releaseErr := v.store.ReleaseReminder(vote, point, user)
+	if releaseErr != nil {
+		v.log.Error("unable to release reminder", zap.String("guild", vote.Guild), zap.String("vote", vote.ID), zap.String("user", user), zap.Error(releaseErr))
+	}
+	return err
+}
+
+// reminderDM to a member who did not vote on vote yet
+type reminderDM struct {
+	s       Session
+	vote    Vote
+	channel string
+	point   time.Duration
+	user    string
+	// done is closed once all direct messages queued before were sent
+	done chan struct{}
+}
+
+// reminderSender sending the reminder DMs one after another, apart from the scheduler.
+// Reminders which could not be sent for a transient reason are released to be retried.
+type reminderSender struct {
+	v   *VoteHandler
+	dms chan reminderDM
+
+	mu      sync.Mutex
+	closed  bool
+	stopped chan struct{}
+
+	sleep func(time.Duration)
+}
+
+func newReminderSender(v *VoteHandler) *reminderSender {
+	r := &reminderSender{
+		v:       v,
+		dms:     make(chan reminderDM, reminderBuffer),
+		stopped: make(chan struct{}),
+		sleep:   time.Sleep,
+	}
+	go r.work()
+	return r
+}
+
+// push dm to the queue, releasing it if the queue is full or closed
+func (r *reminderSender) push(dm reminderDM) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.closed {
+		select {
+		case r.dms <- dm:
+			return
+		default:
+		}
+	}
+	r.v.log.Warn("reminder queue is full", zap.String("guild", dm.vote.Guild), zap.String("user", dm.user))
+	r.v.releaseReminder(dm.vote, dm.point, dm.user, nil)
+}
+
+// flush waiting until all direct messages queued before were sent
+func (r *reminderSender) flush() {
+	done := make(chan struct{})
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return
+	}
+	r.dms <- reminderDM{done: done}
+	r.mu.Unlock()
+	<-done
+}
+
+// close the queue after sending all queued direct messages
+func (r *reminderSender) close() {
+	r.mu.Lock()
+	if !r.closed {
+		r.closed = true
+		close(r.dms)
+	}
+	r.mu.Unlock()
+	<-r.stopped
+}
+
+func (r *reminderSender) work() {
+	defer close(r.stopped)
+	for dm := range r.dms {
+		if dm.done != nil {
+			close(dm.done)
+			continue
+		}
+		r.sleep(reminderInterval)
+		err := r.send(dm)
+		if err == nil {
+			continue
+		}
+		r.v.log.Error("unable to send reminder", zap.String("guild", dm.vote.Guild), zap.String("user", dm.user), zap.Error(err))
+		if _, retry := retryAfter(errors.Cause(err), 0); retry {
+			r.v.releaseReminder(dm.vote, dm.point, dm.user, err)
+		}
+	}
+}
+
+// send dm to its member
+func (r *reminderSender) send(dm reminderDM) error {
+	ch, err := r.v.discord(dm.s).UserChannelCreate(dm.user)
+	if err != nil {
+		return errors.Wrap(err, "unable to open dm")
+	}
+	_, err = r.v.discord(dm.s).ChannelMessageSend(ch.ID, fmt.Sprintf(
+		"Reminder: you did not vote on '%s' yet. It closes %s UTC: https://discordapp.com/channels/%s/%s/%s",
+		dm.vote.Title, dm.vote.Expires.UTC().Format("02-01-2006 - 15:04"), dm.vote.Guild, dm.channel, dm.vote.CurrentID,
+	))
+	return errors.Wrap(err, "unable to send dm")
+}
@@ -0,0 +1,449 @@
+package votes
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/pkg/errors"
+	"github.com/playnet-public/democracy.bot/pkg/helpers"
+)
+
+const day = 24 * time.Hour
+
+// Rules limiting how long and how often members may hold elected roles,
+// how often failed votes may be repeated and how many votes members may create.
+// A zero value disables the rule.
+type Rules struct {
+	Guild string
+	// MaxConsecutiveTerms a member may serve without taking a break
+	MaxConsecutiveTerms int
+	// MaxTotalTerms a member may serve at all
+	MaxTotalTerms int
+	// MandatoryBreak after serving MaxConsecutiveTerms
+	MandatoryBreak time.Duration
+	// DistrustCooldown before a failed distrust vote may be repeated
+	DistrustCooldown time.Duration
+	// ReproposeCooldown before an author may re-propose a rejected vote
+	ReproposeCooldown time.Duration
+	// MinDuration a vote has to run
+	MinDuration time.Duration
+	// MaxDuration a vote may run including extensions
+	MaxDuration time.Duration
+	// MaxOpenVotes an author may have running or waiting for cosponsors at once
+	MaxOpenVotes int
+	// MaxVotesPerDay a member may create within 24 hours
+	MaxVotesPerDay int
+	// MinTitleLength of votes in characters
+	MinTitleLength int
+	// MinDescriptionLength of votes in characters
+	MinDescriptionLength int
+	// Cosponsors needed before a draft becomes a live vote
+	Cosponsors int
+	// NoEarlyClose keeps votes open until they expire, even once their outcome is decided
+	NoEarlyClose bool
+}
+
+// Term served by a member in an elected role
+type Term struct {
+	Guild string
+	User  string
+	Role  string
+	Start time.Time
+	End   time.Time
+}
+
+// rule names as used by the rules command
+var ruleNames = []string{
+	"max_consecutive_terms",
+	"max_total_terms",
+	"mandatory_break",
+	"distrust_cooldown",
+	"repropose_cooldown",
+	"min_duration",
+	"max_duration",
+	"max_open_votes",
+	"max_votes_per_day",
+	"min_title_length",
+	"min_description_length",
+	"cosponsors",
+	"early_close",
+}
+
+// Set the rule with the given name from a user provided value.
+// Terms are plain numbers, cooldowns and breaks are given in days,
+// vote durations like 12h or 3d and early_close as 1 or 0.
+func (r *Rules) Set(name, value string) error {
+	if name == "min_duration" || name == "max_duration" {
+		var d time.Duration
+		if value != "0" {
+			var err error
+			d, err = ParseDuration(value)
+			if err != nil {
+				return err
+			}
+		}
+		if name == "min_duration" {
+			r.MinDuration = d
+		} else {
+			r.MaxDuration = d
+		}
+		return nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return errors.Errorf("invalid value '%s' for %s, expected a positive number", value, name)
+	}
+	switch name {
+	case "max_consecutive_terms":
+		r.MaxConsecutiveTerms = n
+	case "max_total_terms":
+		r.MaxTotalTerms = n
+	case "mandatory_break":
+		r.MandatoryBreak = time.Duration(n) * day
+	case "distrust_cooldown":
+		r.DistrustCooldown = time.Duration(n) * day
+	case "repropose_cooldown":
+		r.ReproposeCooldown = time.Duration(n) * day
+	case "max_open_votes":
+		r.MaxOpenVotes = n
+	case "max_votes_per_day":
+		r.MaxVotesPerDay = n
+	case "min_title_length":
+		r.MinTitleLength = n
+	case "min_description_length":
+		r.MinDescriptionLength = n
+	case "cosponsors":
+		r.Cosponsors = n
+	case "early_close":
+		if n > 1 {
+			return errors.Errorf("invalid value '%s' for %s, expected 1 or 0", value, name)
+		}
+		r.NoEarlyClose = n == 0
+	default:
+		return errors.Errorf("unknown rule '%s', expected one of %s", name, strings.Join(ruleNames, ", "))
+	}
+	return nil
+}
+
+// CheckTerms returns an error describing the violated rule if the member
+// with the given terms may not be nominated at time now.
+func (r Rules) CheckTerms(terms []Term, now time.Time) error {
+	if r.MaxTotalTerms > 0 && len(terms) >= r.MaxTotalTerms {
+		return errors.Errorf("term limit reached: members may serve at most %d terms in total", r.MaxTotalTerms)
+	}
+	if r.MaxConsecutiveTerms < 1 || len(terms) < r.MaxConsecutiveTerms {
+		return nil
+	}
+
+	// newest term first
+	sorted := make([]Term, len(terms))
+	copy(sorted, terms)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Start.After(sorted[j].Start) })
+
+	// terms directly following each other count as consecutive
+	consecutive := 1
+	for i := 1; i < len(sorted); i++ {
+		if sorted[i-1].Start.Sub(sorted[i].End) > day {
+			break
+		}
+		consecutive = consecutive + 1
+	}
+	if consecutive < r.MaxConsecutiveTerms {
+		return nil
+	}
+	if r.MandatoryBreak > 0 {
+		if until := sorted[0].End.Add(r.MandatoryBreak); now.Before(until) {
+			return errors.Errorf(
+				"term limit reached: after %d consecutive terms a break is required until %s",
+				r.MaxConsecutiveTerms,
+				until.UTC().Format("02-01-2006 - 15:04:05"),
+			)
+		}
+		return nil
+	}
+	if now.Sub(sorted[0].End) > day {
+		return nil
+	}
+	return errors.Errorf("term limit reached: members may serve at most %d consecutive terms", r.MaxConsecutiveTerms)
+}
+
+// CheckDuration of a vote against the duration limits
+func (r Rules) CheckDuration(d time.Duration) error {
+	if r.MinDuration > 0 && d < r.MinDuration {
+		return errors.Errorf("votes have to run at least %s", r.MinDuration)
+	}
+	if r.MaxDuration > 0 && d > r.MaxDuration {
+		return errors.Errorf("votes may run at most %s", r.MaxDuration)
+	}
+	return nil
+}
+
+// CheckText of a vote against the minimum lengths of its title and description
+func (r Rules) CheckText(title, description string) error {
+	if n := len([]rune(strings.TrimSpace(title))); r.MinTitleLength > 0 && n < r.MinTitleLength {
+		return errors.Errorf("min_title_length: titles need at least %d characters, '%s' has %d", r.MinTitleLength, title, n)
+	}
+	if n := len([]rune(strings.TrimSpace(description))); r.MinDescriptionLength > 0 && n < r.MinDescriptionLength {
+		return errors.Errorf("min_description_length: descriptions need at least %d characters, this one has %d", r.MinDescriptionLength, n)
+	}
+	return nil
+}
+
+// CheckAuthor returns an error if an author with open votes or drafts,
+// having created today votes within the last 24 hours, may not create another one.
+func (r Rules) CheckAuthor(open, today int) error {
+	if r.MaxOpenVotes > 0 && open >= r.MaxOpenVotes {
+		return errors.Errorf("max_open_votes: members may have at most %d open votes or drafts at once", r.MaxOpenVotes)
+	}
+	if r.MaxVotesPerDay > 0 && today >= r.MaxVotesPerDay {
+		return errors.Errorf("max_votes_per_day: members may create at most %d votes within 24 hours", r.MaxVotesPerDay)
+	}
+	return nil
+}
+
+// checkDuplicate returns an error if title matches one of the open votes or drafts
+func checkDuplicate(title string, open []string) error {
+	for _, other := range open {
+		if strings.EqualFold(strings.TrimSpace(other), strings.TrimSpace(title)) {
+			return errors.Errorf("duplicate title: there already is an open vote or draft titled '%s'", other)
+		}
+	}
+	return nil
+}
+
+// VoteDuration parsed from value and checked against the duration limits.
+// An empty value returns the DefaultDuration clamped to the limits.
+func (r Rules) VoteDuration(value string) (time.Duration, error) {
+	if strings.TrimSpace(value) == "" {
+		d := DefaultDuration
+		if r.MaxDuration > 0 && d > r.MaxDuration {
+			d = r.MaxDuration
+		}
+		if r.MinDuration > 0 && d < r.MinDuration {
+			d = r.MinDuration
+		}
+		return d, nil
+	}
+	d, err := ParseDuration(value)
+	if err != nil {
+		return 0, err
+	}
+	return d, r.CheckDuration(d)
+}
+
+// CheckRepropose returns an error if the proposed title matches one of the
+// authors votes rejected within the repropose cooldown.
+func (r Rules) CheckRepropose(title string, rejected []Vote, now time.Time) error {
+	if r.ReproposeCooldown <= 0 {
+		return nil
+	}
+	return checkCooldown("repropose", title, rejected, r.ReproposeCooldown, now)
+}
+
+// CheckDistrust returns an error if a distrust vote failed within the distrust cooldown.
+func (r Rules) CheckDistrust(failed []Vote, now time.Time) error {
+	if r.DistrustCooldown <= 0 {
+		return nil
+	}
+	return checkCooldown("distrust", "", failed, r.DistrustCooldown, now)
+}
+
+func checkCooldown(kind, title string, votes []Vote, cooldown time.Duration, now time.Time) error {
+	for _, vote := range votes {
+		if title != "" && !strings.EqualFold(strings.TrimSpace(vote.Title), strings.TrimSpace(title)) {
+			continue
+		}
+		if until := vote.Expires.Add(cooldown); now.Before(until) {
+			return errors.Errorf(
+				"%s cooldown active: '%s' was rejected, a new vote is possible after %s",
+				kind,
+				vote.Title,
+				until.UTC().Format("02-01-2006 - 15:04:05"),
+			)
+		}
+	}
+	return nil
+}
+
+// CheckNomination of user for an elected role against the guilds term limits
+func (v *VoteHandler) CheckNomination(guild, user string) error {
+	rules, err := v.store.GetRules(guild)
+	if err != nil {
+		return err
+	}
+	terms, err := v.store.ReadTerms(guild, user)
+	if err != nil {
+		return err
+	}
+	return rules.CheckTerms(terms, v.clock.Now())
+}
+
+// checkVoteCreation of a vote by author against the guilds cooldown and anti-spam rules
+func (v *VoteHandler) checkVoteCreation(guild, author, title, description string) error {
+	rules, err := v.store.GetRules(guild)
+	if err != nil {
+		return err
+	}
+	err = rules.CheckText(title, description)
+	if err != nil {
+		return err
+	}
+	now := v.clock.Now()
+	open, err := v.store.ReadVotes(guild, VoteFilter{Status: StatusOpen})
+	if err != nil {
+		return err
+	}
+	drafts, err := v.store.ReadDrafts(guild)
+	if err != nil {
+		return err
+	}
+	var titles []string
+	var authorOpen, authorToday int
+	for _, vote := range open {
+		titles = append(titles, vote.Title)
+		if vote.Author == author {
+			authorOpen++
+		}
+	}
+	for _, draft := range drafts {
+		titles = append(titles, draft.Title)
+		if draft.Author == author {
+			authorOpen++
+			if now.Sub(draft.Created) < day {
+				authorToday++
+			}
+		}
+	}
+	err = checkDuplicate(title, titles)
+	if err != nil {
+		return err
+	}
+	if rules.MaxOpenVotes <= 0 && rules.MaxVotesPerDay <= 0 && rules.ReproposeCooldown <= 0 {
+		return nil
+	}
+
+	votes, err := v.store.ReadVotes(guild, VoteFilter{Author: author})
+	if err != nil {
+		return err
+	}
+	var rejected []Vote
+	for _, vote := range votes {
+		if now.Sub(vote.Created) < day {
+			authorToday++
+		}
+		if rules.ReproposeCooldown <= 0 {
+			continue
+		}
+		vote, err := v.store.GetVoteCount(vote)
+		if err != nil {
+			return err
+		}
+		if vote.Rejected(now) {
+			rejected = append(rejected, vote)
+		}
+	}
+	err = rules.CheckAuthor(authorOpen, authorToday)
+	if err != nil {
+		return err
+	}
+	return rules.CheckRepropose(title, rejected, now)
+}
+
+// voteDuration parsed from value within the guilds duration limits
+func (v *VoteHandler) voteDuration(guild, value string) (time.Duration, error) {
+	rules, err := v.store.GetRules(guild)
+	if err != nil {
+		return 0, err
+	}
+	return rules.VoteDuration(value)
+}
+
+// Rules Message Handler for showing and changing the guilds rules
+func (v *VoteHandler) Rules(c *discordgo.Channel, s Session, m *discordgo.MessageCreate) error {
+	if m.Content == "reset_handler" {
+		return nil
+	}
+
+	rules, err := v.db(s).GetRules(c.GuildID)
+	if err != nil {
+		return internalError("unable to read rules", "Failed to read rules from DB. Please contact support.", err)
+	}
+
+	args := strings.Fields(strings.TrimPrefix(m.Content, "rules"))
+	if len(args) == 0 {
+		return v.replyEmbed(s, m, rules.Embed())
+	}
+	if len(args) != 3 || args[0] != "set" {
+		return userError("invalid rules command", "Invalid rules command. Please follow this schema: '!democracy rules set [rule] [value]'")
+	}
+	g, err := s.Guild(c.GuildID)
+	if err != nil {
+		return internalError("could not fetch guild", "", err)
+	}
+	if g.OwnerID != m.Author.ID {
+		return permissionError("Only the server owner may change rules.")
+	}
+	err = rules.Set(args[1], args[2])
+	if err != nil {
+		return userError("invalid rule", err.Error())
+	}
+	err = v.db(s).SetRules(rules)
+	if err != nil {
+		return internalError("unable to store rules", "Failed to store rules in DB. Please contact support.", err)
+	}
+	return v.replyEmbed(s, m, rules.Embed())
+}
+
+// Embed from Rules
+func (r Rules) Embed() *discordgo.MessageEmbed {
+	limit := func(n int) string {
+		if n < 1 {
+			return "unlimited"
+		}
+		return fmt.Sprintf("%d", n)
+	}
+	duration := func(d time.Duration) string {
+		if d <= 0 {
+			return "none"
+		}
+		return d.String()
+	}
+	days := func(d time.Duration) string {
+		if d <= 0 {
+			return "none"
+		}
+		return fmt.Sprintf("%d days", int(d/day))
+	}
+	minimum := func(n int) string {
+		if n < 1 {
+			return "none"
+		}
+		return fmt.Sprintf("%d", n)
+	}
+	earlyClose := "on"
+	if r.NoEarlyClose {
+		earlyClose = "off"
+	}
+	return helpers.NewEmbed().
+		SetTitle("Rules").
+		SetColor(0x587987).
+		SetDescription("Change a rule with '!democracy rules set [rule] [value]'. Cooldowns and breaks are given in days, vote durations like 12h or 3d. 0 disables a rule.").
+		AddField("max_consecutive_terms", limit(r.MaxConsecutiveTerms), true).
+		AddField("max_total_terms", limit(r.MaxTotalTerms), true).
+		AddField("mandatory_break", days(r.MandatoryBreak), true).
+		AddField("distrust_cooldown", days(r.DistrustCooldown), true).
+		AddField("repropose_cooldown", days(r.ReproposeCooldown), true).
+		AddField("min_duration", duration(r.MinDuration), true).
+		AddField("max_duration", duration(r.MaxDuration), true).
+		AddField("max_open_votes", limit(r.MaxOpenVotes), true).
+		AddField("max_votes_per_day", limit(r.MaxVotesPerDay), true).
+		AddField("min_title_length", minimum(r.MinTitleLength), true).
+		AddField("min_description_length", minimum(r.MinDescriptionLength), true).
+		AddField("cosponsors", minimum(r.Cosponsors), true).
+		AddField("early_close", earlyClose, true).
+		MessageEmbed
+}
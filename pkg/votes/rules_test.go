@@ -0,0 +1,84 @@
+package votes
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckTerms(t *testing.T) {
+	now := time.Date(2018, 3, 1, 12, 0, 0, 0, time.UTC)
+	term := func(start, end time.Time) Term {
+		return Term{Role: "moderator", Start: start, End: end}
+	}
+	// two consecutive terms, the last one ended a week ago
+	consecutive := []Term{
+		term(now.Add(-67*day), now.Add(-37*day)),
+		term(now.Add(-37*day), now.Add(-7*day)),
+	}
+	tests := []struct {
+		name  string
+		rules Rules
+		terms []Term
+		err   string
+	}{
+		{"no rules", Rules{}, consecutive, ""},
+		{"total limit", Rules{MaxTotalTerms: 2}, consecutive, "at most 2 terms in total"},
+		{"below total limit", Rules{MaxTotalTerms: 3}, consecutive, ""},
+		{"break over", Rules{MaxConsecutiveTerms: 2, MandatoryBreak: 5 * day}, consecutive, ""},
+		{"break running", Rules{MaxConsecutiveTerms: 2, MandatoryBreak: 10 * day}, consecutive, "break is required until 04-03-2018"},
+		{"not consecutive", Rules{MaxConsecutiveTerms: 2, MandatoryBreak: 10 * day}, []Term{
+			term(now.Add(-97*day), now.Add(-67*day)),
+			term(now.Add(-37*day), now.Add(-7*day)),
+		}, ""},
+		{"serving", Rules{MaxConsecutiveTerms: 2}, []Term{
+			term(now.Add(-40*day), now.Add(-10*day)),
+			term(now.Add(-10*day), now.Add(20*day)),
+		}, "at most 2 consecutive terms"},
+	}
+	for _, test := range tests {
+		err := test.rules.CheckTerms(test.terms, now)
+		if test.err == "" && err != nil {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+		}
+		if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+			t.Errorf("%s: expected error %q, got %v", test.name, test.err, err)
+		}
+	}
+}
+
+func TestCheckRepropose(t *testing.T) {
+	now := time.Date(2018, 3, 1, 12, 0, 0, 0, time.UTC)
+	rejected := []Vote{{Title: "Coffee", Expires: now.Add(-2 * day)}}
+	rules := Rules{ReproposeCooldown: 3 * day}
+	if err := rules.CheckRepropose(" coffee", rejected, now); err == nil || !strings.Contains(err.Error(), "repropose cooldown") {
+		t.Errorf("expected the cooldown to be active, got %v", err)
+	}
+	if err := rules.CheckRepropose("Tea", rejected, now); err != nil {
+		t.Errorf("expected other titles to pass, got %v", err)
+	}
+	if err := rules.CheckRepropose("Coffee", rejected, now.Add(day)); err != nil {
+		t.Errorf("expected the cooldown to be over, got %v", err)
+	}
+	if err := (Rules{}).CheckRepropose("Coffee", rejected, now); err != nil {
+		t.Errorf("expected no cooldown without the rule, got %v", err)
+	}
+}
+
+func TestCheckDistrust(t *testing.T) {
+	now := time.Date(2018, 3, 1, 12, 0, 0, 0, time.UTC)
+	failed := []Vote{{Title: "Distrust carol as moderator", Expires: now.Add(-2 * day)}}
+	rules := Rules{DistrustCooldown: 7 * day}
+	if err := rules.CheckDistrust(failed, now); err == nil || !strings.Contains(err.Error(), "distrust cooldown") {
+		t.Errorf("expected the cooldown to be active, got %v", err)
+	}
+	if err := rules.CheckDistrust(failed, now.Add(5*day)); err != nil {
+		t.Errorf("expected the cooldown to be over, got %v", err)
+	}
+	if err := rules.CheckDistrust(nil, now); err != nil {
+		t.Errorf("expected no failed votes to pass, got %v", err)
+	}
+	if err := (Rules{}).CheckDistrust(failed, now); err != nil {
+		t.Errorf("expected no cooldown without the rule, got %v", err)
+	}
+}
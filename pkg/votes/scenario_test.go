@@ -0,0 +1,231 @@
+package votes
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// scenario replayed against a testBot, read from testdata/scenarios
+type scenario struct {
+	Name string `json:"name"`
+	// Members of the guild, the first one owns it
+	Members []string `json:"members"`
+	Steps   []step   `json:"steps"`
+}
+
+// step of a scenario, exactly one action or expectation is set
+type step struct {
+	// Join a new member to the guild
+	Join string `json:"join"`
+	// Bot joins a bot account to the guild
+	Bot string `json:"bot"`
+	// As is the member performing Say or React
+	As  string `json:"as"`
+	Say string `json:"say"`
+	// React with an emoji on the latest embed whose title starts with On
+	React string `json:"react"`
+	On    string `json:"on"`
+	// In is the channel of Say and React, the democracy channel if empty
+	In string `json:"in"`
+	// Offline reactions are not received by the bot
+	Offline bool `json:"offline"`
+	// Advance the clock by a duration like 90m or 3d and run the scheduler
+	Advance string `json:"advance"`
+	// Reconnect the bot, running all ready handlers
+	Reconnect bool `json:"reconnect"`
+
+	Expect *expectation `json:"expect"`
+}
+
+// expectation on the state of the guild after the steps before
+type expectation struct {
+	// Embed whose title starts with the value, with Fields containing the given values
+	Embed  string            `json:"embed"`
+	Fields map[string]string `json:"fields"`
+	// In is the channel of Embed, the democracy channel if empty
+	In string `json:"in"`
+	// Missing expects no such embed
+	Missing bool `json:"missing"`
+
+	// Vote stored with the given title
+	Vote     string `json:"vote"`
+	Pro      *int   `json:"pro"`
+	Con      *int   `json:"con"`
+	Closed   *bool  `json:"closed"`
+	Accepted *bool  `json:"accepted"`
+
+	// DM sent to the member, containing Contains
+	DM       string `json:"dm"`
+	Contains string `json:"contains"`
+}
+
+func TestScenarios(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("testdata", "scenarios", "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("no scenarios found")
+	}
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var sc scenario
+		err = json.Unmarshal(data, &sc)
+		if err != nil {
+			t.Fatalf("%s: %v", file, err)
+		}
+		t.Run(strings.TrimSuffix(filepath.Base(file), ".json"), func(t *testing.T) {
+			runScenario(t, sc)
+		})
+	}
+}
+
+func runScenario(t *testing.T, sc scenario) {
+	tb := newTestBot(t, sc.Members...)
+	defer tb.close()
+	for i, st := range sc.Steps {
+		switch {
+		case st.Join != "":
+			tb.join(st.Join)
+		case st.Bot != "":
+			tb.join(st.Bot).Bot = true
+		case st.Say != "":
+			tb.sayIn(tb.scenarioChannel(st.In), tb.user(st.As), st.Say)
+		case st.React != "":
+			on := st.On
+			if on == "" {
+				on = "[Vote]"
+			}
+			c := tb.scenarioChannel(st.In)
+			msg := tb.latestEmbedIn(c, on)
+			if msg == nil {
+				t.Fatalf("step %d: no embed %q in %s", i, on, c.Name)
+			}
+			if st.Offline {
+				tb.discord.React(c.ID, msg.ID, st.React, tb.user(st.As))
+				continue
+			}
+			tb.bot.HandleReaction(tb.discord, tb.discord.React(c.ID, msg.ID, st.React, tb.user(st.As)))
+			tb.flush()
+		case st.Advance != "":
+			d, err := ParseDuration(st.Advance)
+			if err != nil {
+				t.Fatalf("step %d: %v", i, err)
+			}
+			tb.clock.now = tb.clock.now.Add(d)
+			tb.votes.tick(tb.discord, tb.clock.now)
+			tb.flush()
+		case st.Reconnect:
+			tb.bot.HandleReady(tb.discord, &discordgo.Ready{})
+			tb.flush()
+		case st.Expect != nil:
+			tb.check(i, *st.Expect)
+		default:
+			t.Fatalf("step %d: no action", i)
+		}
+	}
+}
+
+// check e, reporting failures for step i
+func (tb *testBot) check(i int, e expectation) {
+	t := tb.t
+	if e.Embed != "" {
+		_, embed := tb.findEmbedIn(tb.scenarioChannel(e.In), e.Embed)
+		switch {
+		case e.Missing && embed != nil:
+			t.Errorf("step %d: unexpected embed %q", i, embed.Title)
+		case !e.Missing && embed == nil:
+			t.Errorf("step %d: no embed %q", i, e.Embed)
+		case embed != nil:
+			for name, want := range e.Fields {
+				if got := field(embed, name); !strings.Contains(got, want) {
+					t.Errorf("step %d: expected %s of %q to contain %q, got %q", i, name, e.Embed, want, got)
+				}
+			}
+		}
+	}
+	if e.Vote != "" {
+		vote, ok := tb.vote(e.Vote)
+		if !ok {
+			t.Errorf("step %d: no vote %q", i, e.Vote)
+			return
+		}
+		if e.Pro != nil && vote.Pro != *e.Pro {
+			t.Errorf("step %d: expected %d pro ballots on %q, got %d", i, *e.Pro, e.Vote, vote.Pro)
+		}
+		if e.Con != nil && vote.Con != *e.Con {
+			t.Errorf("step %d: expected %d con ballots on %q, got %d", i, *e.Con, e.Vote, vote.Con)
+		}
+		if e.Closed != nil && vote.Closed != *e.Closed {
+			t.Errorf("step %d: expected %q closed %v, got %v", i, e.Vote, *e.Closed, vote.Closed)
+		}
+		if e.Accepted != nil && vote.Accepted() != *e.Accepted {
+			t.Errorf("step %d: expected %q accepted %v, got %v", i, e.Vote, *e.Accepted, vote.Accepted())
+		}
+	}
+	if e.DM != "" {
+		found := false
+		for _, msg := range tb.discord.DirectMessages(tb.user(e.DM)) {
+			text := msg.Content
+			for _, embed := range msg.Embeds {
+				text += embed.Title + embed.Description
+			}
+			if strings.Contains(text, e.Contains) {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("step %d: no direct message to %s containing %q", i, e.DM, e.Contains)
+		}
+	}
+}
+
+// latestEmbedIn channel c titled with prefix, nil if there is none
+func (tb *testBot) latestEmbedIn(c *discordgo.Channel, prefix string) *discordgo.Message {
+	var latest *discordgo.Message
+	for _, msg := range tb.discord.Messages(c.ID) {
+		if len(msg.Embeds) > 0 && strings.HasPrefix(msg.Embeds[0].Title, prefix) {
+			latest = msg
+		}
+	}
+	return latest
+}
+
+// scenarioChannel named name, the democracy channel if name is empty
+func (tb *testBot) scenarioChannel(name string) *discordgo.Channel {
+	if name == "" {
+		return tb.channel
+	}
+	c := tb.discord.ChannelByName(tb.guild, name)
+	if c == nil {
+		tb.t.Fatalf("unknown channel %q", name)
+	}
+	return c
+}
+
+// vote stored with title, including its tally
+func (tb *testBot) vote(title string) (Vote, bool) {
+	votes, err := tb.votes.store.ReadVotes(tb.guild.ID, VoteFilter{})
+	if err != nil {
+		tb.t.Fatalf("unable to read votes: %v", err)
+	}
+	for _, vote := range votes {
+		if vote.Title != title {
+			continue
+		}
+		vote, err = tb.votes.store.GetVoteCount(vote)
+		if err != nil {
+			tb.t.Fatalf("unable to count votes: %v", err)
+		}
+		return vote, true
+	}
+	return Vote{}, false
+}
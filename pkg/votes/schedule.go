@@ -0,0 +1,375 @@
+package votes
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/pkg/errors"
+	"github.com/playnet-public/democracy.bot/pkg/helpers"
+	"go.uber.org/zap"
+)
+
+// DefaultDuration of a vote if none is provided
+const DefaultDuration = 3 * day
+
+// Recurrence rule of a scheduled vote
+type Recurrence string
+
+// Supported recurrence rules
+const (
+	RecurNone    Recurrence = "once"
+	RecurDaily   Recurrence = "daily"
+	RecurWeekly  Recurrence = "weekly"
+	RecurMonthly Recurrence = "monthly"
+)
+
+// ParseRecurrence from user input. An empty rule means the vote runs once.
+func ParseRecurrence(rule string) (Recurrence, error) {
+	switch r := Recurrence(strings.ToLower(strings.TrimSpace(rule))); r {
+	case "", RecurNone:
+		return RecurNone, nil
+	case RecurDaily, RecurWeekly, RecurMonthly:
+		return r, nil
+	}
+	return RecurNone, errors.Errorf("invalid recurrence '%s', expected once, daily, weekly or monthly", rule)
+}
+
+// Next occurrence after the occurrence t keeping the wall clock time in loc.
+// Monthly occurrences fall on the day of the month of anchor, the first occurrence,
+// or on the last day of months too short for it.
+func (r Recurrence) Next(t, anchor time.Time, loc *time.Location) (time.Time, bool) {
+	t = t.In(loc)
+	switch r {
+	case RecurDaily:
+		return t.AddDate(0, 0, 1), true
+	case RecurWeekly:
+		return t.AddDate(0, 0, 7), true
+	case RecurMonthly:
+		anchor = anchor.In(loc)
+		// the first of the following month can not overflow
+		month := time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, loc).AddDate(0, 1, 0)
+		day := anchor.Day()
+		if last := month.AddDate(0, 1, -1).Day(); day > last {
+			day = last
+		}
+		return time.Date(month.Year(), month.Month(), day, anchor.Hour(), anchor.Minute(), anchor.Second(), 0, loc), true
+	}
+	return t, false
+}
+
+// Schedule of a vote opening in the future, optionally repeating
+type Schedule struct {
+	// Vote acting as template for every vote opened by this schedule
+	Vote
+	ID         int64
+	Starts     time.Time
+	Duration   time.Duration
+	Recurrence Recurrence
+	Location   *time.Location
+	// Anchor is the start of the first occurrence
+	Anchor time.Time
+}
+
+// ParseDuration of a vote like 90m, 12h, 3d or 1w. An empty value returns the DefaultDuration.
+func ParseDuration(value string) (time.Duration, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if value == "" {
+		return DefaultDuration, nil
+	}
+	unit := map[byte]time.Duration{'d': day, 'w': 7 * day}[value[len(value)-1]]
+	if unit > 0 {
+		n, err := strconv.Atoi(value[:len(value)-1])
+		if err != nil || n < 1 {
+			return 0, errors.Errorf("invalid duration '%s'", value)
+		}
+		return time.Duration(n) * unit, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		return 0, errors.Errorf("invalid duration '%s', expected something like 90m, 12h, 3d or 1w", value)
+	}
+	return d, nil
+}
+
+// ParseStart of a scheduled vote relative to now. Accepted are absolute dates
+// (2006-01-02 15:04), weekdays (monday 18:00), today/tomorrow 18:00 and
+// relative offsets (in 2h), each optionally followed by a timezone like
+// UTC or Europe/Berlin.
+func ParseStart(spec string, now time.Time) (time.Time, *time.Location, error) {
+	fields := strings.Fields(spec)
+	loc := time.UTC
+	if len(fields) > 1 {
+		last := fields[len(fields)-1]
+		if strings.Contains(last, "/") || strings.ToUpper(last) == "UTC" {
+			l, err := time.LoadLocation(last)
+			if err != nil {
+				return now, loc, errors.Errorf("unknown timezone '%s'", last)
+			}
+			loc = l
+			fields = fields[:len(fields)-1]
+		}
+	}
+	now = now.In(loc)
+	if len(fields) == 0 {
+		return now, loc, errors.New("missing start time")
+	}
+
+	for _, layout := range []string{"2006-01-02 15:04", "2006-01-02T15:04", "2006-01-02"} {
+		t, err := time.ParseInLocation(layout, strings.Join(fields, " "), loc)
+		if err == nil {
+			return t, loc, nil
+		}
+	}
+
+	first := strings.ToLower(fields[0])
+	if first == "in" && len(fields) == 2 {
+		d, err := ParseDuration(fields[1])
+		if err != nil {
+			return now, loc, err
+		}
+		return now.Add(d), loc, nil
+	}
+	if first == "next" && len(fields) > 1 {
+		fields = fields[1:]
+		first = strings.ToLower(fields[0])
+	}
+	clock := "00:00"
+	switch len(fields) {
+	case 1:
+	case 2:
+		clock = fields[1]
+	case 3:
+		if strings.ToLower(fields[1]) != "at" {
+			return now, loc, errors.Errorf("unable to parse start '%s'", spec)
+		}
+		clock = fields[2]
+	default:
+		return now, loc, errors.Errorf("unable to parse start '%s'", spec)
+	}
+	c, err := time.Parse("15:04", clock)
+	if err != nil {
+		return now, loc, errors.Errorf("invalid time of day '%s', expected HH:MM", clock)
+	}
+	date := time.Date(now.Year(), now.Month(), now.Day(), c.Hour(), c.Minute(), 0, 0, loc)
+	switch first {
+	case "today":
+		return date, loc, nil
+	case "tomorrow":
+		return date.AddDate(0, 0, 1), loc, nil
+	}
+	for wd := time.Sunday; wd <= time.Saturday; wd++ {
+		if !strings.HasPrefix(strings.ToLower(wd.String()), first) || len(first) < 3 {
+			continue
+		}
+		offset := (int(wd) - int(now.Weekday()) + 7) % 7
+		date = date.AddDate(0, 0, offset)
+		if !date.After(now) {
+			date = date.AddDate(0, 0, 7)
+		}
+		return date, loc, nil
+	}
+	return now, loc, errors.Errorf("unable to parse start '%s'", spec)
+}
+
+// ScheduleCommand Message Handler for adding, listing and cancelling scheduled votes
+func (v *VoteHandler) ScheduleCommand(c *discordgo.Channel, s Session, m *discordgo.MessageCreate) error {
+	if m.Content == "reset_handler" {
+		return nil
+	}
+
+	args := strings.TrimSpace(strings.TrimPrefix(m.Content, "schedule"))
+	switch {
+	case args == "list":
+		schedules, err := v.db(s).ReadSchedules(c.GuildID)
+		if err != nil {
+			return internalError("unable to read schedules", "Failed to read schedules from DB. Please contact support.", err)
+		}
+		return v.replyEmbed(s, m, newScheduleListEmbed(schedules))
+	case strings.HasPrefix(args, "cancel"):
+		id, err := strconv.ParseInt(strings.TrimSpace(strings.TrimPrefix(args, "cancel")), 10, 64)
+		if err != nil {
+			return userError("invalid schedule", "Invalid schedule. Please follow this schema: '!democracy schedule cancel [id]'")
+		}
+		schedule, err := v.db(s).GetSchedule(c.GuildID, id)
+		if err != nil {
+			return lookupError("schedule not found", fmt.Sprintf("Scheduled vote %d not found.", id), err)
+		}
+		g, err := s.Guild(c.GuildID)
+		if err != nil {
+			return internalError("could not fetch guild", "unable to cancel scheduled vote", err)
+		}
+		if schedule.Author != m.Author.ID && g.OwnerID != m.Author.ID {
+			return permissionError("Only the author or the server owner may cancel a scheduled vote.")
+		}
+		err = v.db(s).DeleteSchedule(schedule)
+		if err != nil {
+			return internalError("unable to delete schedule", "Failed to delete schedule from DB. Please contact support.", err)
+		}
+		return v.reply(s, m, fmt.Sprintf("Scheduled vote %d cancelled", id))
+	case strings.HasPrefix(args, "add "):
+		schedule, err := parseSchedule(strings.TrimPrefix(args, "add "), v.clock.Now())
+		if err != nil {
+			return userError("invalid schedule", err.Error())
+		}
+		schedule.Guild = c.GuildID
+		schedule.Author = m.Author.ID
+		err = v.checkVoteCreation(c.GuildID, m.Author.ID, schedule.Title, schedule.Description)
+		if err != nil {
+			return userError("schedule rejected by rules", err.Error())
+		}
+		rules, err := v.db(s).GetRules(c.GuildID)
+		if err != nil {
+			return internalError("unable to read rules", "Failed to read rules from DB. Please contact support.", err)
+		}
+		err = rules.CheckDuration(schedule.Duration)
+		if err != nil {
+			return userError("schedule rejected by rules", err.Error())
+		}
+		schedule, err = v.db(s).InsertSchedule(schedule)
+		if err != nil {
+			return internalError("unable to store schedule", "Failed to store schedule in DB. Please contact support.", err)
+		}
+		return v.reply(s, m, fmt.Sprintf(
+			"Vote %d scheduled for %s (%s)",
+			schedule.ID,
+			schedule.Starts.In(schedule.Location).Format("02-01-2006 - 15:04 MST"),
+			schedule.Recurrence,
+		))
+	default:
+		return userError(
+			"invalid schedule command",
+			"Invalid schedule command. Please follow this schema: '!democracy schedule add [start]|[once/daily/weekly/monthly]|[duration]|[title]|[text]', '!democracy schedule list' or '!democracy schedule cancel [id]'",
+		)
+	}
+}
+
+// parseSchedule from '[start]|[recurrence]|[duration]|[title]|[text]'
+func parseSchedule(text string, now time.Time) (Schedule, error) {
+	var schedule Schedule
+	parts := strings.SplitN(text, "|", 5)
+	if len(parts) < 5 {
+		return schedule, errors.New("Invalid schedule. Please follow this schema: '!democracy schedule add [start]|[once/daily/weekly/monthly]|[duration]|[title]|[text]'")
+	}
+	starts, loc, err := ParseStart(parts[0], now)
+	if err != nil {
+		return schedule, err
+	}
+	if !starts.After(now) {
+		return schedule, errors.New("the start of a scheduled vote has to be in the future")
+	}
+	recurrence, err := ParseRecurrence(parts[1])
+	if err != nil {
+		return schedule, err
+	}
+	duration, err := ParseDuration(parts[2])
+	if err != nil {
+		return schedule, err
+	}
+	schedule.Starts = starts
+	schedule.Anchor = starts
+	schedule.Location = loc
+	schedule.Recurrence = recurrence
+	schedule.Duration = duration
+	schedule.Title = parts[3]
+	schedule.Description = parts[4]
+	return schedule, nil
+}
+
+// RunScheduler opening due votes, closing expired ones and sending reminders every interval until stop is closed.
+// Schedules are persisted, so votes due while the bot was offline are opened on the next run.
+func (v *VoteHandler) RunScheduler(s Session, interval time.Duration, stop <-chan struct{}) {
+	v.log.Info("starting scheduler", zap.Duration("interval", interval))
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			v.log.Info("stopping scheduler")
+			return
+		case <-ticker.C:
+			v.tick(s, v.clock.Now())
+		}
+	}
+}
+
+// tick of the scheduler at now
+func (v *VoteHandler) tick(s Session, now time.Time) {
+	v.runDueSchedules(s, now)
+	v.checkPendingBrigading(s, now)
+	v.closeDueVotes(s, now)
+	v.sendReminders(s, now)
+	v.expireDrafts(s, now)
+}
+
+func (v *VoteHandler) runDueSchedules(s Session, now time.Time) {
+	schedules, err := v.store.ReadDueSchedules(now)
+	if err != nil {
+		v.log.Error("unable to read due schedules", zap.Error(err))
+		return
+	}
+	for _, schedule := range schedules {
+		c, err := democracyChannel(s, schedule.Guild)
+		if err != nil {
+			v.log.Error("unable to find democracy channel", zap.String("guild", schedule.Guild), zap.Int64("schedule", schedule.ID), zap.Error(err))
+			continue
+		}
+		observeLag(schedule.Starts, now)
+		// the schedule is advanced before the vote is opened, so a failure can not open it twice
+		err = v.advanceSchedule(schedule, now)
+		if err != nil {
+			v.log.Error("unable to advance schedule", zap.String("guild", schedule.Guild), zap.Int64("schedule", schedule.ID), zap.Error(err))
+			continue
+		}
+		vote := schedule.Vote
+		vote.Created = now
+		vote.Expires = now.Add(schedule.Duration)
+		vote, err = v.createVote(s, c.ID, vote)
+		if _, rejected := err.(VoteError); rejected {
+			// the occurrence is skipped, the rules may allow the next one
+			v.log.Info("scheduled vote rejected by rules", zap.String("guild", schedule.Guild), zap.Int64("schedule", schedule.ID), zap.Error(err))
+		} else if err != nil && err != ErrDrafted {
+			v.log.Error("unable to open scheduled vote", zap.String("guild", schedule.Guild), zap.Int64("schedule", schedule.ID), zap.Error(err))
+		} else {
+			v.log.Info("opened scheduled vote", zap.String("guild", schedule.Guild), zap.Int64("schedule", schedule.ID), zap.String("vote", vote.ID), zap.Bool("drafted", err == ErrDrafted))
+		}
+	}
+}
+
+// advanceSchedule to its first occurrence after now, skipping those missed while offline.
+// Schedules without further occurrences are deleted.
+func (v *VoteHandler) advanceSchedule(schedule Schedule, now time.Time) error {
+	next, ok := schedule.Recurrence.Next(schedule.Starts, schedule.Anchor, schedule.Location)
+	for ok && !next.After(now) {
+		next, ok = schedule.Recurrence.Next(next, schedule.Anchor, schedule.Location)
+	}
+	if !ok {
+		return v.store.DeleteSchedule(schedule)
+	}
+	schedule.Starts = next
+	return v.store.UpdateSchedule(schedule)
+}
+
+func newScheduleListEmbed(schedules []Schedule) *discordgo.MessageEmbed {
+	embed := helpers.NewEmbed().
+		SetTitle("Scheduled Votes").
+		SetColor(0x587987)
+	if len(schedules) == 0 {
+		embed.SetDescription("There are no scheduled votes.")
+	}
+	for _, schedule := range schedules {
+		embed.AddField(
+			fmt.Sprintf("#%d %s", schedule.ID, schedule.Title),
+			fmt.Sprintf(
+				"Starts %s, runs %s, %s",
+				schedule.Starts.In(schedule.Location).Format("02-01-2006 - 15:04 MST"),
+				schedule.Duration,
+				schedule.Recurrence,
+			),
+			false,
+		)
+	}
+	return embed.Truncate().MessageEmbed
+}
@@ -0,0 +1,105 @@
+package votes
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRecurrenceNext(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skipf("no timezone data: %v", err)
+	}
+	anchor := time.Date(2018, 1, 31, 18, 0, 0, 0, berlin)
+	want := []time.Time{
+		time.Date(2018, 2, 28, 18, 0, 0, 0, berlin),
+		time.Date(2018, 3, 31, 18, 0, 0, 0, berlin),
+		time.Date(2018, 4, 30, 18, 0, 0, 0, berlin),
+		time.Date(2018, 5, 31, 18, 0, 0, 0, berlin),
+	}
+	next := anchor
+	for i, w := range want {
+		var ok bool
+		next, ok = RecurMonthly.Next(next, anchor, berlin)
+		if !ok || !next.Equal(w) {
+			t.Errorf("occurrence %d: expected %s, got %s", i+1, w, next)
+		}
+	}
+
+	// the wall clock time is kept across the change to daylight saving time
+	daily, _ := RecurDaily.Next(time.Date(2018, 3, 24, 18, 0, 0, 0, berlin), anchor, berlin)
+	if daily.Hour() != 18 || daily.Day() != 25 {
+		t.Errorf("expected 25th 18:00, got %s", daily)
+	}
+	if _, ok := RecurNone.Next(anchor, anchor, berlin); ok {
+		t.Error("expected no occurrence after a single vote")
+	}
+}
+
+func TestParseStart(t *testing.T) {
+	// a thursday
+	now := time.Date(2018, 3, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		spec string
+		want time.Time
+	}{
+		{"2018-03-10 18:00", time.Date(2018, 3, 10, 18, 0, 0, 0, time.UTC)},
+		{"in 2h", now.Add(2 * time.Hour)},
+		{"tomorrow 09:30", time.Date(2018, 3, 2, 9, 30, 0, 0, time.UTC)},
+		{"monday at 18:00", time.Date(2018, 3, 5, 18, 0, 0, 0, time.UTC)},
+		{"thursday 12:00", time.Date(2018, 3, 8, 12, 0, 0, 0, time.UTC)},
+	}
+	for _, test := range tests {
+		got, _, err := ParseStart(test.spec, now)
+		if err != nil || !got.Equal(test.want) {
+			t.Errorf("%s: expected %s, got %s (%v)", test.spec, test.want, got, err)
+		}
+	}
+	if _, _, err := ParseStart("someday", now); err == nil {
+		t.Error("expected an invalid start to fail")
+	}
+}
+
+// failingScheduleStore failing to advance schedules
+type failingScheduleStore struct {
+	Store
+}
+
+func (failingScheduleStore) UpdateSchedule(schedule Schedule) error {
+	return errors.New("connection lost")
+}
+
+func TestBotSchedule(t *testing.T) {
+	tb := newTestBot(t)
+	defer tb.close()
+
+	tb.say(tb.bob, "!democracy schedule add 2018-03-31 18:00|monthly|1d|Budget|Monthly budget")
+	schedules, err := tb.votes.store.ReadSchedules(tb.guild.ID)
+	if err != nil || len(schedules) != 1 {
+		t.Fatalf("expected the schedule to be stored, got %v (%v)", schedules, err)
+	}
+
+	// a schedule that can not be advanced is not opened, so it is not opened twice either
+	store := tb.votes.store
+	tb.votes.SetStore(failingScheduleStore{store})
+	tb.clock.now = time.Date(2018, 3, 31, 18, 0, 0, 0, time.UTC)
+	tb.votes.tick(tb.discord, tb.clock.now)
+	tb.flush()
+	if votes, _ := store.ReadVotes(tb.guild.ID, VoteFilter{}); len(votes) != 0 {
+		t.Fatalf("expected no vote while the schedule can not be advanced, got %v", votes)
+	}
+
+	tb.votes.SetStore(store)
+	tb.votes.tick(tb.discord, tb.clock.now)
+	tb.votes.tick(tb.discord, tb.clock.now)
+	tb.flush()
+	votes, _ := store.ReadVotes(tb.guild.ID, VoteFilter{})
+	if len(votes) != 1 || votes[0].Title != "Budget" {
+		t.Fatalf("expected one vote opened by the schedule, got %v", votes)
+	}
+	schedule, err := store.GetSchedule(tb.guild.ID, schedules[0].ID)
+	if want := time.Date(2018, 4, 30, 18, 0, 0, 0, time.UTC); err != nil || !schedule.Starts.Equal(want) {
+		t.Errorf("expected the next occurrence at %s, got %s (%v)", want, schedule.Starts, err)
+	}
+}
@@ -0,0 +1,179 @@
+package votes
+
+import (
+	"github.com/bwmarrin/discordgo"
+	"go.uber.org/zap"
+)
+
+// VoteError rejecting a proposal for a reason shown to its author
+type VoteError string
+
+func (e VoteError) Error() string { return string(e) }
+
+// Propose a vote by author in the democracy channel of guild, checked against the guilds rules like the vote command.
+// Rule violations are returned as VoteError. If the guild requires cosponsors the vote is posted as a draft
+// and returned without a number together with ErrDrafted.
+func (v *VoteHandler) Propose(s Session, guild, author, title, text, duration string, tags []string) (Vote, error) {
+	if title == "" || text == "" {
+		return Vote{}, VoteError("A proposal needs a title and a text.")
+	}
+	rules, err := v.store.GetRules(guild)
+	if err != nil {
+		return Vote{}, err
+	}
+	d, err := rules.VoteDuration(duration)
+	if err != nil {
+		return Vote{}, VoteError(err.Error())
+	}
+	channel, err := democracyChannel(s, guild)
+	if err != nil {
+		return Vote{}, err
+	}
+	now := v.clock.Now()
+	vote := Vote{
+		Guild:       guild,
+		Title:       title,
+		Description: text,
+		Author:      author,
+		Created:     now,
+		Expires:     now.Add(d),
+		Tags:        tags,
+	}
+	vote, err = v.createVote(s, channel.ID, vote)
+	if err != nil {
+		return vote, err
+	}
+	v.log.Info("vote proposed", zap.String("guild", guild), zap.String("vote", vote.ID), zap.String("author", author))
+	return vote, nil
+}
+
+// createVote in channel once it passed the rules of its guild, the way every vote of a member is created.
+// Rule violations are returned as VoteError. If the guild requires cosponsors the vote is posted as a draft
+// and returned without a number together with ErrDrafted.
+func (v *VoteHandler) createVote(s Session, channel string, vote Vote) (Vote, error) {
+	err := v.checkVoteCreation(vote.Guild, vote.Author, vote.Title, vote.Description)
+	if err != nil {
+		return vote, VoteError(err.Error())
+	}
+	rules, err := v.store.GetRules(vote.Guild)
+	if err != nil {
+		return vote, err
+	}
+	if rules.Cosponsors > 0 {
+		draft, err := v.postDraft(s, channel, vote, rules.Cosponsors)
+		if err != nil {
+			return vote, err
+		}
+		vote.ID = draft.ID
+		vote.Channel = draft.Channel
+		return vote, ErrDrafted
+	}
+	return v.openVote(s, channel, vote)
+}
+
+// createVoteError for a member whose vote could not be created
+func createVoteError(err error) error {
+	if e, ok := err.(VoteError); ok {
+		return userError("vote rejected by rules", e.Error())
+	}
+	return internalError("unable to create vote", "Failed to create vote. Please contact support.", err)
+}
+
+// acceptsBallots of vote, which is neither closed nor expired. Expired votes
+// are closed by the scheduler on its next tick.
+func (v *VoteHandler) acceptsBallots(vote Vote) bool {
+	return !vote.Closed && vote.Expires.After(v.clock.Now())
+}
+
+// CastBallot of user on vote, replacing a previous ballot. A ballot of a user whose earlier ballot
+// is quarantined replaces that one and stays in quarantine.
+// Returns ErrVoteClosed if the vote no longer accepts ballots.
+func (v *VoteHandler) CastBallot(s Session, vote Vote, user string, pro bool) (Vote, error) {
+	if !v.acceptsBallots(vote) {
+		return vote, ErrVoteClosed
+	}
+	held, err := v.holdQuarantined(vote, user, pro)
+	if err != nil || held {
+		return vote, err
+	}
+	vote, _, err = v.recordBallot(s, vote, user, pro)
+	return vote, err
+}
+
+// recordBallot of user on vote, publishing BallotCast or BallotChanged unless it was repeated
+func (v *VoteHandler) recordBallot(s Session, vote Vote, user string, pro bool) (Vote, bool, error) {
+	vote, change, err := v.store.RecordBallot(vote, user, pro)
+	if err != nil {
+		return vote, false, err
+	}
+	switch change {
+	case BallotAdded:
+		v.events.Publish(BallotCast{voteEvent: v.voteEvent(s, vote), User: user, Pro: pro})
+	case BallotReplaced:
+		v.events.Publish(BallotChanged{voteEvent: v.voteEvent(s, vote), User: user, Pro: pro})
+	}
+	return vote, change != BallotUnchanged, nil
+}
+
+// RetractBallot of user on vote.
+// Returns ErrVoteClosed if the vote no longer accepts ballots.
+func (v *VoteHandler) RetractBallot(s Session, vote Vote, user string) (Vote, error) {
+	if !v.acceptsBallots(vote) {
+		return vote, ErrVoteClosed
+	}
+	vote, retracted, err := v.store.RetractBallot(vote, user)
+	if err != nil || !retracted {
+		return vote, err
+	}
+	v.events.Publish(BallotRetracted{voteEvent: v.voteEvent(s, vote), User: user})
+	return vote, nil
+}
+
+// ListVotes of guild matching filter, including their tallies
+func (v *VoteHandler) ListVotes(guild string, filter VoteFilter) ([]Vote, error) {
+	votes, err := v.store.ReadVotes(guild, filter)
+	if err != nil {
+		return nil, err
+	}
+	for i := range votes {
+		votes[i], err = v.store.GetVoteCount(votes[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return votes, nil
+}
+
+// GetVote of guild by its number, including its tally
+func (v *VoteHandler) GetVote(guild string, number int) (Vote, error) {
+	vote, err := v.store.GetVoteByNumber(guild, number)
+	if err != nil {
+		return vote, err
+	}
+	return v.store.GetVoteCount(vote)
+}
+
+// CountVotes of guild matching filter, ignoring its paging
+func (v *VoteHandler) CountVotes(guild string, filter VoteFilter) (int, error) {
+	return v.store.CountVotes(guild, filter)
+}
+
+// Author of a vote, served from the author cache
+func (v *VoteHandler) Author(s Session, id string) (*discordgo.User, error) {
+	return v.authors.get(s, id)
+}
+
+// GuildRules of guild, the zero value if none are set
+func (v *VoteHandler) GuildRules(guild string) (Rules, error) {
+	return v.store.GetRules(guild)
+}
+
+// Schedules of guild opening votes in the future
+func (v *VoteHandler) Schedules(guild string) ([]Schedule, error) {
+	return v.store.ReadSchedules(guild)
+}
+
+// Terms served by user in guild
+func (v *VoteHandler) Terms(guild, user string) ([]Term, error) {
+	return v.store.ReadTerms(guild, user)
+}
@@ -0,0 +1,78 @@
+package votes
+
+import (
+	"github.com/bwmarrin/discordgo"
+)
+
+// Session covering the Discord operations used by the bot.
+// Implemented by a live discordgo session through NewSession and by discordtest.Session in tests.
+type Session interface {
+	// BotUser the session is logged in as
+	BotUser() *discordgo.User
+	// StateGuild from the local state, without a request
+	StateGuild(guildID string) (*discordgo.Guild, error)
+	// StateChannel from the local state, without a request
+	StateChannel(channelID string) (*discordgo.Channel, error)
+	// StateMessage from the local state, without a request
+	StateMessage(channelID, messageID string) (*discordgo.Message, error)
+
+	User(userID string) (*discordgo.User, error)
+	Guild(guildID string) (*discordgo.Guild, error)
+	ChannelMessage(channelID, messageID string) (*discordgo.Message, error)
+	MessageReactions(channelID, messageID, emojiID string, limit int) ([]*discordgo.User, error)
+
+	ChannelMessageSend(channelID, content string) (*discordgo.Message, error)
+	ChannelMessageSendEmbed(channelID string, embed *discordgo.MessageEmbed) (*discordgo.Message, error)
+	ChannelMessageEditComplex(m *discordgo.MessageEdit) (*discordgo.Message, error)
+	ChannelMessageDelete(channelID, messageID string) error
+	MessageReactionAdd(channelID, messageID, emojiID string) error
+	MessageReactionRemove(channelID, messageID, emojiID, userID string) error
+	MessageReactionsRemoveAll(channelID, messageID string) error
+	GuildChannelCreate(guildID, name, ctype string) (*discordgo.Channel, error)
+	ChannelEditComplex(channelID string, data *discordgo.ChannelEdit) (*discordgo.Channel, error)
+	ChannelDelete(channelID string) (*discordgo.Channel, error)
+	UserChannelCreate(recipientID string) (*discordgo.Channel, error)
+}
+
+var _ Session = discordSession{}
+
+// apiKeySession attributing the events caused through it to an API key
+type apiKeySession struct {
+	Session
+	key string
+}
+
+// ViaAPIKey wrapping s, so the events caused through it are audited as acting through the API key named key
+func ViaAPIKey(s Session, key string) Session {
+	return apiKeySession{Session: s, key: key}
+}
+
+// discordSession adding the state lookups of Session to a live discordgo session
+type discordSession struct {
+	*discordgo.Session
+}
+
+// NewSession for the live discordgo session s
+func NewSession(s *discordgo.Session) Session {
+	return discordSession{s}
+}
+
+// BotUser the session is logged in as
+func (s discordSession) BotUser() *discordgo.User {
+	return s.State.User
+}
+
+// StateGuild from the local state
+func (s discordSession) StateGuild(guildID string) (*discordgo.Guild, error) {
+	return s.State.Guild(guildID)
+}
+
+// StateChannel from the local state
+func (s discordSession) StateChannel(channelID string) (*discordgo.Channel, error) {
+	return s.State.Channel(channelID)
+}
+
+// StateMessage from the local state
+func (s discordSession) StateMessage(channelID, messageID string) (*discordgo.Message, error) {
+	return s.State.Message(channelID, messageID)
+}
@@ -0,0 +1,46 @@
+package votes
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// NewSQLiteStore opening or creating the SQLite database file at path, its schema is migrated like the one of PostgreSQL.
+// The SQLite driver needs no cgo but a recent Go, so it is only compiled in with the sqlite build tag.
+func NewSQLiteStore(log *zap.Logger, path string) (*SQLStore, error) {
+	log.Info("opening sqlite db", zap.String("path", path))
+	if !sqliteAvailable() {
+		return nil, errors.New("sqlite support is not compiled in, rebuild with '-tags sqlite'")
+	}
+	// transactions take the write lock when they begin, so concurrent ballots wait for each other instead of failing
+	db, err := sql.Open("sqlite", path+"?_pragma=busy_timeout(5000)&_pragma=foreign_keys(1)&_txlock=immediate")
+	if err != nil {
+		log.Error("unable to open db", zap.String("path", path), zap.Error(err))
+		return nil, err
+	}
+	// SQLite allows a single writer only
+	db.SetMaxOpenConns(1)
+	err = db.Ping()
+	if err != nil {
+		log.Error("unable to open db", zap.String("path", path), zap.Error(err))
+		db.Close()
+		return nil, err
+	}
+	log.Info("database opened", zap.String("path", path))
+	return &SQLStore{
+		log:    log,
+		db:     db,
+		driver: "sqlite",
+	}, nil
+}
+
+func sqliteAvailable() bool {
+	for _, driver := range sql.Drivers() {
+		if driver == "sqlite" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,9 @@
+//go:build sqlite
+// +build sqlite
+
+package votes
+
+import (
+	// Using SQLite
+	_ "modernc.org/sqlite"
+)
@@ -0,0 +1,193 @@
+package votes
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrVoteClosed is returned when recording a ballot on a closed vote
+var ErrVoteClosed = errors.New("vote is closed")
+
+// BallotChange made by recording a ballot
+type BallotChange int
+
+const (
+	// BallotUnchanged as the member repeated their ballot
+	BallotUnchanged BallotChange = iota
+	// BallotAdded as the first ballot of the member
+	BallotAdded
+	// BallotReplaced the previous ballot of the member
+	BallotReplaced
+)
+
+// ErrNotFound is returned by lookups of votes and proposals that do not exist
+var ErrNotFound = errors.New("not found")
+
+// VoteStore persisting votes and their entries
+type VoteStore interface {
+	// ReadVotes of guild matching filter
+	ReadVotes(guild string, filter VoteFilter) ([]Vote, error)
+	// CountVotes of guild matching filter, ignoring its Offset and Limit
+	CountVotes(guild string, filter VoteFilter) (int, error)
+	// GetVote by the ID of any message representing it
+	GetVote(guild, message string) (Vote, error)
+	GetVoteByID(guild, id string) (Vote, error)
+	GetVoteByNumber(guild string, number int) (Vote, error)
+	ReadDueVotes(now time.Time) ([]Vote, error)
+	ReadOpenVotes(now time.Time) ([]Vote, error)
+	ReadExtensions(vote Vote) ([]Vote, error)
+	// InsertVote assigning the next number of its guild
+	InsertVote(vote Vote) (Vote, error)
+	// UpdateVote after reposting it, recording its new message
+	UpdateVote(id string, vote Vote) error
+	// AddVoteMessage recording another message representing vote, like its feedback
+	AddVoteMessage(vote Vote, message string) error
+	CloseVote(vote Vote) error
+	UpdateVoteExpiry(vote Vote) error
+	DeleteVote(vote Vote) error
+
+	GetVoteCount(vote Vote) (Vote, error)
+	ReadVoters(vote Vote) (map[string]bool, error)
+	// RecordBallot atomically, returning the updated vote and whether the ballot was added or replaced a previous one.
+	// Returns ErrVoteClosed if the vote was closed in the meantime.
+	RecordBallot(vote Vote, author string, value bool) (Vote, BallotChange, error)
+	// RetractBallot of author atomically, returning the updated vote and whether there was a ballot.
+	// Returns ErrVoteClosed if the vote was closed in the meantime.
+	RetractBallot(vote Vote, author string) (Vote, bool, error)
+	DeleteVoteEntries(vote Vote) error
+}
+
+// RuleStore persisting guild rules and the terms served by members
+type RuleStore interface {
+	GetRules(guild string) (Rules, error)
+	SetRules(rules Rules) error
+	ReadTerms(guild, user string) ([]Term, error)
+	InsertTerm(term Term) error
+	// EndTerms of user in guild still running at end, cutting them short
+	EndTerms(guild, user string, end time.Time) error
+}
+
+// ProposalStore persisting proposals, their revisions and amendments
+type ProposalStore interface {
+	InsertProposal(proposal Proposal) error
+	GetProposal(guild, id string) (Proposal, error)
+	UpdateProposal(proposal Proposal) error
+	InsertRevision(proposal Proposal, created time.Time) error
+	InsertAmendment(amendment Amendment) error
+	GetAmendment(guild, id string) (Amendment, error)
+	ReadAmendments(guild, proposal string) ([]Amendment, error)
+	UpdateAmendment(amendment Amendment) error
+}
+
+// DraftStore persisting drafts waiting for cosponsors
+type DraftStore interface {
+	InsertDraft(draft Draft) error
+	GetDraft(guild, id string) (Draft, error)
+	ReadDrafts(guild string) ([]Draft, error)
+	// ReadExpiredDrafts of all guilds created before before
+	ReadExpiredDrafts(before time.Time) ([]Draft, error)
+	// DeleteDraft returns false if the draft was deleted before
+	DeleteDraft(draft Draft) (bool, error)
+}
+
+// ScheduleStore persisting scheduled votes
+type ScheduleStore interface {
+	InsertSchedule(schedule Schedule) (Schedule, error)
+	GetSchedule(guild string, id int64) (Schedule, error)
+	ReadSchedules(guild string) ([]Schedule, error)
+	ReadDueSchedules(now time.Time) ([]Schedule, error)
+	UpdateSchedule(schedule Schedule) error
+	DeleteSchedule(schedule Schedule) error
+}
+
+// ReminderStore persisting reminder settings, subscriptions and sent reminders
+type ReminderStore interface {
+	GetReminders(guild string) (Reminders, error)
+	SetReminders(reminders Reminders) error
+	SetNotify(guild, user string, enabled bool) error
+	ReadNotify(guild string) ([]string, error)
+	// ClaimReminder returns false if the reminder was claimed before
+	ClaimReminder(vote Vote, point time.Duration, user string) (bool, error)
+	// ReleaseReminder claimed before but not sent, so it is claimed again on the next attempt
+	ReleaseReminder(vote Vote, point time.Duration, user string) error
+}
+
+// ArchiveStore persisting the channel closed votes are moved to
+type ArchiveStore interface {
+	// GetArchive channel of guild, empty if closed votes stay where they are
+	GetArchive(guild string) (string, error)
+	SetArchive(guild, channel string) error
+}
+
+// AuditStore persisting the governance events of guilds
+type AuditStore interface {
+	// InsertAuditEvent returning the event with its assigned ID
+	InsertAuditEvent(event AuditEvent) (AuditEvent, error)
+	// ReadAuditEvents of guild newest first, only events before the given ID unless it is 0
+	ReadAuditEvents(guild string, before int64, limit int) ([]AuditEvent, error)
+}
+
+// APIKeyStore persisting the API keys of guilds by their hash
+type APIKeyStore interface {
+	InsertAPIKey(key APIKey) error
+	// GetAPIKey by its hash, ErrUnknownAPIKey if there is none
+	GetAPIKey(hash string) (APIKey, error)
+	ReadAPIKeys(guild string) ([]APIKey, error)
+	// DeleteAPIKey returns false if guild has no key with name
+	DeleteAPIKey(guild, name string) (bool, error)
+}
+
+// WebhookStore persisting the webhooks of guilds and their failed deliveries
+type WebhookStore interface {
+	// InsertWebhook returning the webhook with its assigned ID
+	InsertWebhook(hook Webhook) (Webhook, error)
+	ReadWebhooks(guild string) ([]Webhook, error)
+	// DeleteWebhook returns false if guild has no webhook with id
+	DeleteWebhook(guild string, id int64) (bool, error)
+	InsertWebhookFailure(failure WebhookFailure) error
+	// ReadWebhookFailures of guild newest first
+	ReadWebhookFailures(guild string, limit int) ([]WebhookFailure, error)
+}
+
+// BrigadeStore persisting the brigading detection of guilds and the ballots quarantined for review
+type BrigadeStore interface {
+	// GetBrigadeSettings of guild, detection is off if none are stored
+	GetBrigadeSettings(guild string) (BrigadeSettings, error)
+	SetBrigadeSettings(settings BrigadeSettings) error
+	// QuarantineBallot replacing an earlier one of the same user on the vote
+	QuarantineBallot(ballot QuarantinedBallot) error
+	// GetQuarantinedBallot of user on vote, ErrNotFound if there is none
+	GetQuarantinedBallot(guild, vote, user string) (QuarantinedBallot, error)
+	// ReadQuarantinedBallots of vote including the released ones, ordered by user
+	ReadQuarantinedBallots(guild, vote string) ([]QuarantinedBallot, error)
+	// DeleteQuarantinedBallot returns false if it was deleted before
+	DeleteQuarantinedBallot(ballot QuarantinedBallot) (bool, error)
+}
+
+// Store persisting all state of the VoteHandler
+type Store interface {
+	VoteStore
+	RuleStore
+	ProposalStore
+	DraftStore
+	ScheduleStore
+	ReminderStore
+	ArchiveStore
+	AuditStore
+	APIKeyStore
+	WebhookStore
+	BrigadeStore
+	// Ping the backend, checking that it is reachable
+	Ping() error
+}
+
+// SetStore used for persisting votes
+func (v *VoteHandler) SetStore(store Store) {
+	v.store = store
+}
+
+var (
+	_ Store = &SQLStore{}
+	_ Store = &MemoryStore{}
+)
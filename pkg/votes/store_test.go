@@ -0,0 +1,229 @@
+package votes
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// testStores running test against every Store: the memory store, SQLite if it is compiled in
+// and PostgreSQL if TEST_DB_HOST is set. The SQL stores start out empty.
+func testStores(t *testing.T, test func(t *testing.T, store Store)) {
+	t.Run("memory", func(t *testing.T) {
+		test(t, NewMemoryStore())
+	})
+	testSQLStores(t, func(t *testing.T, store *SQLStore) {
+		test(t, store)
+	})
+}
+
+func TestStoreVotes(t *testing.T) {
+	testStores(t, func(t *testing.T, store Store) {
+		now := time.Date(2018, 3, 1, 12, 0, 0, 0, time.UTC)
+		vote := Vote{Guild: "g", ID: "1", Title: "t", Created: now, Expires: now.Add(time.Hour)}
+		vote, err := store.InsertVote(vote)
+		if err != nil || vote.Number != 1 {
+			t.Fatalf("insert: %d %v", vote.Number, err)
+		}
+		if _, err := store.InsertVote(vote); err == nil {
+			t.Fatal("expected duplicate insert to fail")
+		}
+
+		other := vote
+		other.Guild, other.CurrentID = "other", "4"
+		store.UpdateVote(vote.ID, other)
+		if _, err := store.GetVote("g", "4"); err == nil {
+			t.Error("expected an update from another guild to change nothing")
+		}
+		vote.CurrentID = "2"
+		if err := store.UpdateVote(vote.ID, vote); err != nil {
+			t.Fatalf("update: %v", err)
+		}
+		store.AddVoteMessage(vote, "3")
+		for _, message := range []string{"1", "2", "3"} {
+			if got, err := store.GetVote("g", message); err != nil || got.ID != "1" {
+				t.Errorf("expected vote to be found by message %s, got %q (%v)", message, got.ID, err)
+			}
+		}
+		got, err := store.GetVoteByNumber("g", 1)
+		if err != nil || got.CurrentID != "2" {
+			t.Fatalf("get by number: %+v (%v)", got, err)
+		}
+
+		store.RecordBallot(got, "a", true)
+		store.RecordBallot(got, "b", true)
+		if _, change, _ := store.RecordBallot(got, "b", true); change != BallotUnchanged {
+			t.Error("expected repeated ballot to not change the vote")
+		}
+		got, change, err := store.RecordBallot(got, "b", false)
+		if err != nil || change != BallotReplaced {
+			t.Errorf("expected replaced ballot, got %v (%v)", change, err)
+		}
+		if _, change, _ := store.RecordBallot(got, "c", false); change != BallotAdded {
+			t.Errorf("expected added ballot, got %v", change)
+		}
+		got, _, _ = store.RetractBallot(got, "c")
+		if got.Pro != 1 || got.Con != 1 {
+			t.Errorf("expected 1:1, got %d:%d", got.Pro, got.Con)
+		}
+		got, retracted, err := store.RetractBallot(got, "b")
+		if err != nil || !retracted || got.Pro != 1 || got.Con != 0 {
+			t.Errorf("expected retracted ballot and 1:0, got %v %d:%d (%v)", retracted, got.Pro, got.Con, err)
+		}
+		if _, retracted, _ := store.RetractBallot(got, "b"); retracted {
+			t.Error("expected retracting a missing ballot to change nothing")
+		}
+
+		if due, _ := store.ReadDueVotes(now); len(due) != 0 {
+			t.Errorf("expected no due votes, got %d", len(due))
+		}
+		if due, _ := store.ReadDueVotes(now.Add(time.Hour)); len(due) != 1 {
+			t.Errorf("expected one due vote, got %d", len(due))
+		}
+		store.CloseVote(got)
+		if open, _ := store.ReadOpenVotes(now); len(open) != 0 {
+			t.Errorf("expected closed vote to not be open, got %d", len(open))
+		}
+		if _, _, err := store.RecordBallot(got, "c", true); err != ErrVoteClosed {
+			t.Errorf("expected ballot on closed vote to fail, got %v", err)
+		}
+		if _, _, err := store.RetractBallot(got, "a"); err != ErrVoteClosed {
+			t.Errorf("expected retraction on closed vote to fail, got %v", err)
+		}
+	})
+}
+
+func TestStoreDefaults(t *testing.T) {
+	testStores(t, func(t *testing.T, store Store) {
+		rules, err := store.GetRules("g")
+		if err != nil || rules.Guild != "g" || rules.MaxTotalTerms != 0 {
+			t.Errorf("expected default rules, got %+v (%v)", rules, err)
+		}
+		reminders, err := store.GetReminders("g")
+		if err != nil || len(reminders.Points) != len(DefaultReminderPoints) {
+			t.Errorf("expected default reminders, got %+v (%v)", reminders, err)
+		}
+		vote := Vote{Guild: "g", ID: "1"}
+		if ok, _ := store.ClaimReminder(vote, time.Hour, ""); !ok {
+			t.Error("expected first claim to succeed")
+		}
+		if ok, _ := store.ClaimReminder(vote, time.Hour, ""); ok {
+			t.Error("expected second claim to fail")
+		}
+	})
+}
+
+func TestStoreVoteFilter(t *testing.T) {
+	testStores(t, func(t *testing.T, store Store) {
+		now := time.Date(2018, 3, 1, 12, 0, 0, 0, time.UTC)
+		for i, v := range []Vote{
+			{ID: "a", Author: "alice", Tags: []string{"budget"}},
+			{ID: "b", Author: "bob"},
+			{ID: "c", Author: "alice", Tags: []string{"rules", "budget"}},
+			{ID: "d", Author: "bob"},
+		} {
+			v.Guild = "g"
+			v.Created = now.Add(time.Duration(i) * 24 * time.Hour)
+			v.Expires = v.Created.Add(time.Hour)
+			vote, err := store.InsertVote(v)
+			if err != nil {
+				t.Fatalf("insert: %v", err)
+			}
+			if i < 3 {
+				store.RecordBallot(vote, "x", i%2 == 0)
+				store.CloseVote(vote)
+			}
+		}
+
+		ids := func(filter VoteFilter) string {
+			votes, err := store.ReadVotes("g", filter)
+			if err != nil {
+				t.Fatalf("read %v: %v", filter, err)
+			}
+			s := ""
+			for _, v := range votes {
+				s += v.ID
+			}
+			return s
+		}
+		for _, c := range []struct {
+			filter VoteFilter
+			want   string
+		}{
+			{VoteFilter{}, "abcd"},
+			{VoteFilter{Status: StatusOpen}, "d"},
+			{VoteFilter{Status: StatusClosed}, "abc"},
+			{VoteFilter{Status: StatusAccepted}, "ac"},
+			{VoteFilter{Status: StatusRejected}, "b"},
+			{VoteFilter{Author: "alice"}, "ac"},
+			{VoteFilter{Tag: "budget"}, "ac"},
+			{VoteFilter{After: now.Add(24 * time.Hour), Before: now.Add(3 * 24 * time.Hour)}, "bc"},
+			{VoteFilter{Newest: true, Offset: 1, Limit: 2}, "cb"},
+			{VoteFilter{Offset: 4}, ""},
+		} {
+			if got := ids(c.filter); got != c.want {
+				t.Errorf("expected %q for %v, got %q", c.want, c.filter, got)
+			}
+		}
+		if n, _ := store.CountVotes("g", VoteFilter{Status: StatusClosed, Limit: 1}); n != 3 {
+			t.Errorf("expected count to ignore paging, got %d", n)
+		}
+	})
+}
+
+func TestParseVoteFilter(t *testing.T) {
+	f, err := ParseVoteFilter([]string{"accepted", "author:<@!42>", "from:2018-03-01", "to:2018-03-31", "tag:Budget"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Status != StatusAccepted || f.Author != "42" || f.Tag != "budget" {
+		t.Errorf("unexpected filter %+v", f)
+	}
+	if !f.Before.Equal(time.Date(2018, 4, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("expected the to date to be included, got %v", f.Before)
+	}
+	again, err := ParseVoteFilter(strings.Fields(f.String()))
+	if err != nil || again != f {
+		t.Errorf("expected %q to parse to the same filter, got %+v (%v)", f, again, err)
+	}
+	for _, args := range [][]string{{"pending"}, {"from:march"}, {"color:red"}} {
+		if _, err := ParseVoteFilter(args); err == nil {
+			t.Errorf("expected %v to be invalid", args)
+		}
+	}
+}
+
+func TestStoreAuditEvents(t *testing.T) {
+	testStores(t, func(t *testing.T, store Store) {
+		for i, guild := range []string{"g", "other", "g", "g"} {
+			store.InsertAuditEvent(AuditEvent{Guild: guild, Kind: EventBallotCast, Number: i})
+		}
+		events, _ := store.ReadAuditEvents("g", 0, 2)
+		if len(events) != 2 || events[0].ID != 4 || events[1].ID != 3 {
+			t.Fatalf("expected the newest events of the guild, got %+v", events)
+		}
+		events, _ = store.ReadAuditEvents("g", events[1].ID, 2)
+		if len(events) != 1 || events[0].ID != 1 {
+			t.Errorf("expected the page before the cursor, got %+v", events)
+		}
+	})
+}
+
+func TestStoreAPIKeys(t *testing.T) {
+	testStores(t, func(t *testing.T, store Store) {
+		store.InsertAPIKey(APIKey{Guild: "g", Name: "widget", Hash: "h1"})
+		if err := store.InsertAPIKey(APIKey{Guild: "g", Name: "widget", Hash: "h2"}); err == nil {
+			t.Error("expected duplicate key names to fail")
+		}
+		if key, err := store.GetAPIKey("h1"); err != nil || key.Name != "widget" {
+			t.Errorf("expected key by hash, got %+v (%v)", key, err)
+		}
+		if deleted, _ := store.DeleteAPIKey("other", "widget"); deleted {
+			t.Error("expected keys of other guilds to be kept")
+		}
+		store.DeleteAPIKey("g", "widget")
+		if _, err := store.GetAPIKey("h1"); err != ErrUnknownAPIKey {
+			t.Errorf("expected revoked key to be unknown, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,81 @@
+package votes
+
+import (
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// subscribe the side effects of governance events, in the order they have to run
+func (v *VoteHandler) subscribe() {
+	v.events.SubscribeSync("log", v.logEvent)
+	v.events.SubscribeSync("audit", v.auditEvent)
+	v.events.SubscribeSync("embed", v.updateEmbed)
+	v.events.SubscribeSync("brigading", v.detectBrigading)
+	v.events.SubscribeSync("early close", v.closeEarly)
+	v.events.SubscribeSync("archive", v.finishVote)
+	v.events.SubscribeSync("metrics", v.countBallot)
+	v.events.subscribeAsync("webhooks", webhookBuffer, v.webhooks.dispatch, v.webhooks.dropped)
+}
+
+// logEvent of every kind
+func (v *VoteHandler) logEvent(e Event) {
+	fields := []zapcore.Field{zap.String("guild", e.GuildID()), zap.String("event", string(e.Kind())), zap.String("detail", e.Detail())}
+	if ve, ok := e.(VoteEvent); ok {
+		fields = append(fields, zap.String("vote", ve.EventVote().ID), zap.Int("number", ve.EventVote().Number))
+	}
+	v.log.Info("governance event", fields...)
+}
+
+// updateEmbed of a vote after its tally changed
+func (v *VoteHandler) updateEmbed(e Event) {
+	var base eventBase
+	var vote Vote
+	switch e := e.(type) {
+	case BallotCast:
+		base, vote = e.eventBase, e.Vote
+	case BallotChanged:
+		base, vote = e.eventBase, e.Vote
+	case BallotRetracted:
+		base, vote = e.eventBase, e.Vote
+	default:
+		return
+	}
+	channel, err := v.voteChannel(base.session, vote)
+	if err != nil {
+		v.log.Error("unable to find vote channel", zap.String("guild", vote.Guild), zap.String("vote", vote.ID), zap.Error(err))
+		return
+	}
+	v.discord(base.session).Edit(channel, vote.CurrentID, v.embed(base.session, vote))
+}
+
+// closeEarly a vote once a ballot decided it, unless its ballots wait for the brigading check
+func (v *VoteHandler) closeEarly(e Event) {
+	switch e := e.(type) {
+	case BallotCast:
+		if !v.ballots.isPending(e.Vote) {
+			v.checkEarlyClose(e.session, e.Vote)
+		}
+	case BallotChanged:
+		if !v.ballots.isPending(e.Vote) {
+			v.checkEarlyClose(e.session, e.Vote)
+		}
+	}
+}
+
+// finishVote by moving it to the archive channel or showing its result in place
+func (v *VoteHandler) finishVote(e Event) {
+	closed, ok := e.(VoteClosed)
+	if !ok {
+		return
+	}
+	s, vote := closed.session, closed.Vote
+	channel, err := v.voteChannel(s, vote)
+	if err != nil {
+		v.log.Error("unable to find vote channel", zap.String("guild", vote.Guild), zap.String("vote", vote.ID), zap.Error(err))
+		return
+	}
+	if !v.archiveVote(s, vote, channel) {
+		v.discord(s).Edit(channel, vote.CurrentID, v.embed(s, vote))
+		v.discord(s).RemoveAllReactions(channel, vote.CurrentID)
+	}
+}
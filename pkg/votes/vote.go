@@ -1,18 +1,20 @@
 package votes
 
 import (
-	"fmt"
 	"time"
 
 	"github.com/bwmarrin/discordgo"
-	"github.com/playnet-public/democracy.bot/pkg/helpers"
 )
 
 // Vote stores a primitive Vote object
 type Vote struct {
-	Guild       string
-	ID          string
-	CurrentID   string
+	Guild string
+	// ID of the message the vote was first posted as
+	ID string
+	// CurrentID of the message currently representing the vote
+	CurrentID string
+	// Number of the vote within its guild, shown as #Number and stable across reposts
+	Number      int
 	Title       string
 	Description string
 	Author      string
@@ -20,26 +22,55 @@ type Vote struct {
 	Expires     time.Time
 	Pro         int
 	Con         int
+	// Discussion channel of the proposal this vote was opened from
+	Discussion string
+	// Revision of the proposal text frozen for this vote
+	Revision int
+	// Channel the vote was posted to
+	Channel string
+	// Closed votes accept no further ballots
+	Closed bool
+	// Extends is the ID of the vote this vote would extend by Extension
+	Extends   string
+	Extension time.Duration
+	// Nominee elected into Role for Term if the vote is accepted
+	Nominee string
+	Role    string
+	Term    time.Duration
+	// Distrust is the member whose running terms end if the vote is accepted
+	Distrust string
+	// Tags to find the vote by in the history
+	Tags []string
 }
 
 // Embed from Vote
-func (v *Vote) Embed(s *discordgo.Session) *discordgo.MessageEmbed {
+func (v *Vote) Embed(s Session) *discordgo.MessageEmbed {
 	author, err := s.User(v.Author)
 	if err != nil {
 		return nil
 	}
-	embed := helpers.NewEmbed().
-		SetTitle(fmt.Sprintf("[Vote] %s", v.Title)).
-		SetAuthor(author.Username, author.AvatarURL("100x100")).
-		SetColor(0x587987).
-		SetDescription(v.Description).
-		SetTimestamp(v.Created).
-		AddField(
-			"Due Date",
-			fmt.Sprintf("%s", v.Expires.UTC().Format("02-01-2006 - 15:04:05")),
-			false,
-		).
-		AddField("Pro", fmt.Sprintf(":white_check_mark: [ %d ]", v.Pro), true).
-		AddField("Con", fmt.Sprintf(":negative_squared_cross_mark: [ %d ]", v.Con), true)
-	return embed.MessageEmbed
+	return newVoteEmbed(*v, author)
+}
+
+// Accepted reports whether the vote has a majority
+func (v Vote) Accepted() bool {
+	return v.Pro > v.Con
+}
+
+// Rejected reports whether the vote is over without a majority
+func (v Vote) Rejected(now time.Time) bool {
+	return (v.Closed || now.After(v.Expires)) && !v.Accepted()
+}
+
+// Decided reports whether the outcome can no longer change, even if every
+// member of the electorate who did not vote yet would cast a ballot.
+func (v Vote) Decided(electorate int) bool {
+	if electorate < 1 {
+		return false
+	}
+	remaining := electorate - v.Pro - v.Con
+	if remaining < 0 {
+		remaining = 0
+	}
+	return v.Pro > v.Con+remaining || v.Con >= v.Pro+remaining
 }
@@ -1,183 +1,291 @@
 package votes
 
 import (
-	"database/sql"
+	"fmt"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/pkg/errors"
-	"github.com/playnet-public/democracy.bot/pkg/helpers"
 
 	"github.com/bwmarrin/discordgo"
 	"go.uber.org/zap"
 )
 
+// Clock providing the current time
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
 // VoteHandler for primitive votes
 type VoteHandler struct {
-	log *zap.Logger
-	db  *sql.DB
+	log     *zap.Logger
+	store   Store
+	clock   Clock
+	authors *authorCache
+	queues  *actionQueues
+	events  *EventBus
+	// webhooks delivering events to the webhooks of their guild
+	webhooks *webhookDispatcher
+	// reminders sending the reminder DMs apart from the scheduler
+	reminders *reminderSender
+	// audit recording the audit events apart from the handlers publishing them
+	audit *auditWriter
+	// reconciling is set while a reconciliation pass is running
+	reconciling int32
+	// ballots remembering when ballots were cast for the brigading detection
+	ballots *ballotLog
+	brigade BrigadeThresholds
 }
 
 // NewVoteHandler for channel
 func NewVoteHandler(log *zap.Logger) *VoteHandler {
-	return &VoteHandler{
-		log: log,
+	v := &VoteHandler{
+		log:     log,
+		clock:   systemClock{},
+		queues:  newActionQueues(log),
+		events:  NewEventBus(log),
+		ballots: newBallotLog(),
+		brigade: DefaultBrigadeThresholds,
+		audit:   newAuditWriter(log),
 	}
+	v.reminders = newReminderSender(v)
+	v.authors = newAuthorCache(func() time.Time { return v.clock.Now() })
+	v.webhooks = newWebhookDispatcher(log, func() Store { return v.store }, func() time.Time { return v.clock.Now() })
+	v.subscribe()
+	return v
+}
+
+// Register the handlers of v with b
+func (v *VoteHandler) Register(b *Bot) {
+	b.AddMessageHandler("vote", v.Vote)
+	b.AddMessageHandler("rules", v.Rules)
+	b.AddMessageHandler("discuss", v.Discuss)
+	b.AddMessageHandler("amend", v.Amend)
+	b.AddMessageHandler("open", v.OpenProposal)
+	b.AddMessageHandler("schedule", v.ScheduleCommand)
+	b.AddMessageHandler("extend", v.Extend)
+	b.AddMessageHandler("nominate", v.Nominate)
+	b.AddMessageHandler("distrust", v.Distrust)
+	b.AddMessageHandler("show", v.Show)
+	b.AddMessageHandler("notify", v.Notify)
+	b.AddMessageHandler("reminders", v.ReminderCommand)
+	b.AddMessageHandler("archive", v.ArchiveCommand)
+	b.AddMessageHandler("history", v.History)
+	b.AddMessageHandler("apikey", v.APIKeyCommand)
+	b.AddMessageHandler("webhook", v.WebhookCommand)
+	b.AddMessageHandler("brigading", v.BrigadingCommand)
+	b.AddReactionHandler("Vote created", v.React)
+	b.AddReactionHandler("[Vote]", v.React)
+	b.AddReactionHandler("[Amendment]", v.ReactAmendment)
+	b.AddReactionHandler("[Draft]", v.Cosponsor)
+	b.AddReactionHandler("Vote History", v.HistoryReact)
+	b.AddReadyHandler(v.Reconcile)
+}
+
+// SetClock used as the single source of time for all votes
+func (v *VoteHandler) SetClock(clock Clock) {
+	v.clock = clock
 }
 
 // ReloadVotes to channel
-func (v *VoteHandler) ReloadVotes(c *discordgo.Channel, s *discordgo.Session, m *discordgo.MessageCreate) {
-	v.log.Info("reloading votes", zap.String("guild", c.GuildID))
-	votes, err := v.ReadVotes(c.GuildID)
+func (v *VoteHandler) ReloadVotes(c *discordgo.Channel, s Session, m *discordgo.MessageCreate) error {
+	v.logger(s).Info("reloading votes", zap.String("guild", c.GuildID))
+	votes, err := v.db(s).ReadVotes(c.GuildID, VoteFilter{})
+	if err != nil {
+		return internalError("unable to read votes from db", "", err)
+	}
+	archive, err := v.db(s).GetArchive(c.GuildID)
 	if err != nil {
-		v.log.Error("unable to read votes from db", zap.String("guild", c.GuildID), zap.String("msg", m.Content), zap.Error(err))
-		return
+		return internalError("unable to read archive from db", "", err)
 	}
 	for _, vote := range votes {
-		vote, err := v.GetVoteCount(vote)
-		if err != nil {
-			v.log.Error("unable to get vote entries", zap.String("guild", vote.Guild), zap.String("vote", vote.ID), zap.String("msg", m.Content), zap.Error(err))
-			return
+		// archived votes stay in the archive
+		if archive != "" && vote.Channel == archive {
+			continue
 		}
-		embed := vote.Embed(s)
-		voteEmbed, err := s.ChannelMessageSendEmbed(c.ID, embed)
+		vote, err := v.db(s).GetVoteCount(vote)
 		if err != nil {
-			v.log.Error("unable to send embed", zap.String("guild", vote.Guild), zap.String("vote", vote.ID), zap.String("msg", m.Content), zap.Error(err))
-			return
+			return internalError("unable to get vote entries", "", err).forVote(vote)
 		}
-		err = s.MessageReactionAdd(c.ID, voteEmbed.ID, "✅")
+		voteEmbed, err := postVote(v.discord(s), c.ID, v.embed(s, vote))
 		if err != nil {
-			s.ChannelMessageDelete(c.ID, voteEmbed.ID)
-			v.log.Error("unable to add emoji", zap.String("guild", vote.Guild), zap.String("vote", vote.ID), zap.String("msg", m.Content), zap.Error(err))
-			return
-		}
-		err = s.MessageReactionAdd(c.ID, voteEmbed.ID, "❎")
-		if err != nil {
-			s.ChannelMessageDelete(c.ID, voteEmbed.ID)
-			v.log.Error("unable to add emoji", zap.String("guild", vote.Guild), zap.String("vote", vote.ID), zap.String("msg", m.Content), zap.Error(err))
-			return
+			return internalError("unable to post vote", "", err).forVote(vote)
 		}
 		vote.CurrentID = voteEmbed.ID
-		err = v.UpdateVote(vote.ID, vote)
+		vote.Channel = c.ID
+		err = v.db(s).UpdateVote(vote.ID, vote)
 		if err != nil {
-			s.ChannelMessageDelete(c.ID, voteEmbed.ID)
-			v.log.Error("unable to update vote", zap.String("guild", vote.Guild), zap.String("vote", vote.ID), zap.String("msg", m.Content), zap.Error(err))
-			return
+			v.discord(s).Delete(c.ID, voteEmbed.ID)
+			return internalError("unable to update vote", "", err).forVote(vote)
 		}
 	}
+	return nil
 }
 
 // Vote Message Handler
-func (v *VoteHandler) Vote(c *discordgo.Channel, s *discordgo.Session, m *discordgo.MessageCreate) {
+func (v *VoteHandler) Vote(c *discordgo.Channel, s Session, m *discordgo.MessageCreate) error {
 	if m.Content == "reset_handler" {
-		v.ReloadVotes(c, s, m)
-		return
+		return v.ReloadVotes(c, s, m)
 	}
 
-	var r result
-	r = newResult("failed creating vote", "unable to create vote")
-
-	// Send callback with the final result
-	defer func() { v.MessageCallback(s, m, r) }()
-
 	m.Content = strings.TrimPrefix(m.Content, "vote ")
 	vote := strings.Split(m.Content, "|")
 	if len(vote) < 2 {
-		r = newResult(
-			"invalid vote",
-			"Invalid vote text. Please follow this schema: '!democracy vote [title]|[text]'",
-		)
-		return
+		return userError("invalid vote", "Invalid vote text. Please follow this schema: '!democracy vote [title]|[text]|[duration]|[tags]'")
 	}
 
-	embed := newVoteEmbed(vote[0], vote[1], m.Author)
-	voteEmbed, err := s.ChannelMessageSendEmbed(c.ID, embed)
+	rules, err := v.db(s).GetRules(c.GuildID)
 	if err != nil {
-		r = newResult(
-			"unable to send embed",
-			"unable to send embed",
-			err,
-		)
-		return
+		return internalError("unable to read rules", "Failed to read rules from DB. Please contact support.", err)
+	}
+	var duration time.Duration
+	if len(vote) > 2 {
+		duration, err = rules.VoteDuration(vote[2])
+	} else {
+		duration, err = rules.VoteDuration("")
 	}
+	if err != nil {
+		return userError("invalid duration", err.Error())
+	}
+
+	now := v.clock.Now()
 	voteObj := Vote{
 		Guild:       c.GuildID,
-		ID:          voteEmbed.ID,
-		CurrentID:   voteEmbed.ID,
+		Channel:     c.ID,
 		Title:       vote[0],
 		Description: vote[1],
 		Author:      m.Author.ID,
-		Created:     time.Now(),
-		Expires:     time.Now().AddDate(0, 0, 3),
+		Created:     now,
+		Expires:     now.Add(duration),
 		Pro:         0,
 		Con:         0,
 	}
-	err = s.MessageReactionAdd(c.ID, voteEmbed.ID, "✅")
-	if err != nil {
-		s.ChannelMessageDelete(c.ID, voteEmbed.ID)
-		r = newResult(
-			"unable to add emoji",
-			"unable to add emoji ✅",
-			err,
-		)
-		return
-	}
-	err = s.MessageReactionAdd(c.ID, voteEmbed.ID, "❎")
+	if len(vote) > 3 {
+		voteObj.Tags = ParseTags(vote[3])
+	}
+	voteObj, err = v.createVote(s, c.ID, voteObj)
+	if err == ErrDrafted {
+		v.discord(s).Delete(m.ChannelID, m.ID)
+		return nil
+	}
 	if err != nil {
-		s.ChannelMessageDelete(c.ID, voteEmbed.ID)
-		r = newResult(
-			"unable to add emoji",
-			"unable to add emoji ❎",
-			err,
-		)
-		return
-	}
-	err = v.InsertVote(voteObj)
+		return createVoteError(err)
+	}
+	return v.replyVote(s, m, voteObj)
+}
+
+// postVote embed to channel and add the voting reactions
+func postVote(d discordWriter, channel string, embed *discordgo.MessageEmbed) (*discordgo.Message, error) {
+	voteEmbed, err := d.ChannelMessageSendEmbed(channel, embed)
 	if err != nil {
-		s.ChannelMessageDelete(c.ID, voteEmbed.ID)
-		r = newResult(
-			"unable to store vote",
-			"Failed to store vote in DB. Please contact support.",
-			err,
-		)
-		return
-	}
-	r = newResult("", voteEmbed.ID)
+		return nil, errors.Wrap(err, "unable to send embed")
+	}
+	for _, emoji := range []string{"✅", "❎"} {
+		err = d.MessageReactionAdd(channel, voteEmbed.ID, emoji)
+		if err != nil {
+			d.Delete(channel, voteEmbed.ID)
+			return nil, errors.Wrapf(err, "unable to add emoji %s", emoji)
+		}
+	}
+	return voteEmbed, nil
 }
 
-// MessageCallback for handling errors and success messages
-func (v *VoteHandler) MessageCallback(s *discordgo.Session, m *discordgo.MessageCreate, r result) {
-	var err error
-	if r.err != nil {
-		v.log.Error(r.err.Error(), zap.String("msg", m.Content), zap.Error(r.err))
-		err = newVoteFailedEmbed(s, m.ChannelID, r.response, m.Author)
-	} else {
-		v.log.Info("vote success", zap.String("msg", m.Content))
-		err = newVoteSuccessEmbed(s, m.ChannelID, r.response, m.Author)
+// openVote by posting it to channel and persisting it with the resulting message ID
+func (v *VoteHandler) openVote(s Session, channel string, vote Vote) (Vote, error) {
+	msg, err := postVote(v.discord(s), channel, v.embed(s, vote))
+	if err != nil {
+		return vote, err
 	}
+	vote.ID = msg.ID
+	vote.CurrentID = msg.ID
+	vote.Channel = channel
+	vote, err = v.store.InsertVote(vote)
 	if err != nil {
-		v.log.Error("failed to create callback embed", zap.Error(err))
-		return
+		v.discord(s).Delete(channel, msg.ID)
+		return vote, errors.Wrap(err, "unable to store vote")
 	}
+	v.events.Publish(VoteCreated{v.voteEvent(s, vote)})
+	// the number is only known once the vote is stored
+	v.discord(s).Edit(channel, msg.ID, v.embed(s, vote))
+	return vote, nil
+}
 
-	s.ChannelMessageDelete(m.ChannelID, m.ID)
+// findVote by its number like 42 or #42, or by the ID of a message representing it
+func (v *VoteHandler) findVote(guild, ref string) (Vote, error) {
+	number, err := strconv.Atoi(strings.TrimPrefix(ref, "#"))
+	if err == nil {
+		return v.store.GetVoteByNumber(guild, number)
+	}
+	return v.store.GetVote(guild, ref)
 }
 
-type result struct {
-	err      error
-	response string
-	embed    *helpers.Embed
+// Show Message Handler posting a vote by its number with a link to the message currently representing it
+func (v *VoteHandler) Show(c *discordgo.Channel, s Session, m *discordgo.MessageCreate) error {
+	if m.Content == "reset_handler" {
+		return nil
+	}
+
+	args := strings.Fields(strings.TrimPrefix(m.Content, "show"))
+	if len(args) != 1 {
+		return userError("invalid show command", "Invalid show command. Please follow this schema: '!democracy show [vote number]'")
+	}
+	vote, err := v.findVote(c.GuildID, args[0])
+	if err != nil {
+		return lookupError("vote not found", fmt.Sprintf("Vote %s not found.", args[0]), err)
+	}
+	vote, err = v.db(s).GetVoteCount(vote)
+	if err != nil {
+		return internalError("unable to get vote entries", "Failed to read vote from DB. Please contact support.", err).forVote(vote)
+	}
+	embed := v.embed(s, vote)
+	if embed == nil {
+		return internalError("unable to fetch vote author", "Failed to fetch the vote author. Please try again later.", nil).forVote(vote)
+	}
+	// not titled [Vote], so reactions on the copy are not taken as ballots
+	embed.Title = fmt.Sprintf("#%d %s", vote.Number, vote.Title)
+	channel, err := v.voteChannel(s, vote)
+	if err == nil {
+		embed.URL = fmt.Sprintf("https://discordapp.com/channels/%s/%s/%s", vote.Guild, channel, vote.CurrentID)
+	}
+	_, err = v.discord(s).ChannelMessageSendEmbed(m.ChannelID, embed)
+	if err != nil {
+		return internalError("unable to send vote", "", err).forVote(vote)
+	}
+	v.discord(s).Delete(m.ChannelID, m.ID)
+	return nil
 }
 
-func newResult(err, resp string, errs ...error) result {
-	r := result{}
-	if err != "" {
-		if len(errs) > 0 {
-			r.err = errors.Wrap(errors.New(err), errs[0].Error())
-		} else {
-			r.err = errors.New(err)
+// democracyChannel of guild
+func democracyChannel(s Session, guildID string) (*discordgo.Channel, error) {
+	guild, err := s.Guild(guildID)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not fetch guild")
+	}
+	for _, c := range guild.Channels {
+		if c.Name == "democracy" {
+			return c, nil
 		}
 	}
-	r.response = resp
-	return r
+	return nil, errors.New("democracy channel not found")
+}
+
+// replyVote confirming the creation of vote and remove the command.
+// Reacting on the confirmation undoes the vote.
+func (v *VoteHandler) replyVote(s Session, m *discordgo.MessageCreate, vote Vote) error {
+	v.logger(s).Info("vote success", zap.String("msg", m.Content))
+	feedback, err := newVoteSuccessEmbed(v.discord(s), m.ChannelID, fmt.Sprintf("Vote #%d", vote.Number), m.Author)
+	if err != nil {
+		return internalError("failed to create callback embed", "", err).forVote(vote)
+	}
+	err = v.db(s).AddVoteMessage(vote, feedback.ID)
+	if err != nil {
+		return internalError("unable to store vote feedback", "", err).forVote(vote)
+	}
+	v.discord(s).Delete(m.ChannelID, m.ID)
+	return nil
 }
@@ -0,0 +1,619 @@
+package votes
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+const (
+	// webhookMaxAttempts of a delivery before it is recorded as failure
+	webhookMaxAttempts = 5
+	// webhookBackoff before retrying a failed delivery, doubled on every attempt
+	webhookBackoff = 2 * time.Second
+	// webhookTimeout of a single delivery
+	webhookTimeout = 10 * time.Second
+	// webhookBuffer of events waiting for delivery
+	webhookBuffer = 256
+	// webhookWorkers delivering the events of a guild at once
+	webhookWorkers = 4
+	// webhookTestEvent is the kind of the payload sent by the test command
+	webhookTestEvent EventKind = "webhook_test"
+	// WebhookSignatureHeader carries the hex HMAC-SHA256 of the payload signed with the webhook secret
+	WebhookSignatureHeader = "X-Democracy-Signature"
+)
+
+// webhookEvents subscribable by webhooks
+var webhookEvents = []EventKind{
+	EventVoteCreated,
+	EventBallotCast,
+	EventBallotChanged,
+	EventBallotRetracted,
+	EventVoteClosed,
+	EventVoteDeleted,
+	EventActionExecuted,
+	EventElectionPhaseChanged,
+}
+
+// Webhook of a guild receiving its governance events
+type Webhook struct {
+	ID    int64
+	Guild string
+	URL   string
+	// Events delivered to the webhook, all events if empty
+	Events  []EventKind
+	Secret  string
+	Created time.Time
+}
+
+// wants reports whether the webhook subscribed to kind
+func (h Webhook) wants(kind EventKind) bool {
+	if len(h.Events) == 0 || kind == webhookTestEvent {
+		return true
+	}
+	for _, k := range h.Events {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// events subscribed by the webhook as shown in chat
+func (h Webhook) events() string {
+	if len(h.Events) == 0 {
+		return "all"
+	}
+	return joinEvents(h.Events)
+}
+
+// WebhookFailure recording a delivery given up after its retries, so it can be inspected and replayed
+type WebhookFailure struct {
+	ID       int64
+	Guild    string
+	Webhook  int64
+	URL      string
+	Event    EventKind
+	Payload  string
+	Attempts int
+	Error    string
+	Failed   time.Time
+}
+
+// WebhookPayload posted as JSON to the webhooks of a guild
+type WebhookPayload struct {
+	// Delivery identifies the payload across retries
+	Delivery string    `json:"delivery"`
+	Event    EventKind `json:"event"`
+	Guild    string    `json:"guild"`
+	Time     time.Time `json:"time"`
+	Detail   string    `json:"detail,omitempty"`
+	// Vote the event happened to, missing for events not happening to a vote
+	Vote *WebhookVote `json:"vote,omitempty"`
+}
+
+// WebhookVote of a payload with its tally, its outcome is open, accepted or rejected
+type WebhookVote struct {
+	ID      string    `json:"id"`
+	Number  int       `json:"number"`
+	Title   string    `json:"title"`
+	Author  string    `json:"author"`
+	Created time.Time `json:"created"`
+	Expires time.Time `json:"expires"`
+	Pro     int       `json:"pro"`
+	Con     int       `json:"con"`
+	Outcome string    `json:"outcome"`
+	Tags    []string  `json:"tags,omitempty"`
+}
+
+func newWebhookVote(vote Vote) *WebhookVote {
+	outcome := "open"
+	switch {
+	case vote.Closed && vote.Accepted():
+		outcome = "accepted"
+	case vote.Closed:
+		outcome = "rejected"
+	}
+	return &WebhookVote{
+		ID:      vote.ID,
+		Number:  vote.Number,
+		Title:   vote.Title,
+		Author:  vote.Author,
+		Created: vote.Created,
+		Expires: vote.Expires,
+		Pro:     vote.Pro,
+		Con:     vote.Con,
+		Outcome: outcome,
+		Tags:    vote.Tags,
+	}
+}
+
+// SignWebhook payload with secret as sent in the WebhookSignatureHeader
+func SignWebhook(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookDelivery of a payload to a webhook waiting for a worker of its guild
+type webhookDelivery struct {
+	hook    Webhook
+	payload WebhookPayload
+}
+
+// webhookDispatcher delivering events to the webhooks of their guild.
+// Every guild has its own queue and workers, so slow webhooks of one guild do not delay the others.
+type webhookDispatcher struct {
+	log    *zap.Logger
+	store  func() Store
+	now    func() time.Time
+	client *http.Client
+	sleep  func(d time.Duration, stop <-chan struct{}) bool
+	lookup func(host string) ([]net.IP, error)
+	// allowed addresses to deliver to
+	allowed func(ip net.IP) bool
+
+	mu     sync.Mutex
+	queues map[string]chan webhookDelivery
+	closed bool
+	stop   chan struct{}
+	wg     sync.WaitGroup
+}
+
+func newWebhookDispatcher(log *zap.Logger, store func() Store, now func() time.Time) *webhookDispatcher {
+	d := &webhookDispatcher{
+		log:     log,
+		store:   store,
+		now:     now,
+		sleep:   sleepUntil,
+		lookup:  net.LookupIP,
+		allowed: publicAddress,
+		queues:  make(map[string]chan webhookDelivery),
+		stop:    make(chan struct{}),
+	}
+	d.client = &http.Client{
+		Timeout:   webhookTimeout,
+		Transport: &http.Transport{DialContext: d.dial},
+	}
+	return d
+}
+
+// privateNetworks not reachable from the internet
+var privateNetworks = parseNetworks(
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"172.16.0.0/12",
+	"192.168.0.0/16",
+	"fc00::/7",
+)
+
+func parseNetworks(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, len(cidrs))
+	for i, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks[i] = network
+	}
+	return networks
+}
+
+// publicAddress reports whether ip is reachable from the internet, keeping webhooks
+// out of the network of the bot
+func publicAddress(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsUnspecified() {
+		return false
+	}
+	for _, network := range privateNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// dial address resolving its host and checking the addresses again on every connection,
+// a host may resolve differently than when its webhook was added
+func (d *webhookDispatcher) dial(ctx context.Context, network, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	ips, err := d.lookup(host)
+	if err != nil {
+		return nil, err
+	}
+	if len(ips) == 0 {
+		return nil, errors.Errorf("unable to resolve %s", host)
+	}
+	for _, ip := range ips {
+		if !d.allowed(ip) {
+			return nil, errors.Errorf("webhooks may not be delivered to %s", ip)
+		}
+	}
+	dialer := &net.Dialer{Timeout: webhookTimeout}
+	return dialer.DialContext(ctx, network, net.JoinHostPort(ips[0].String(), port))
+}
+
+// checkURL of a new webhook, accepting absolute http and https URLs of hosts with allowed addresses only
+func (d *webhookDispatcher) checkURL(value string) (string, error) {
+	u, err := url.Parse(strings.Trim(value, "<>"))
+	if err != nil || u.Host == "" || u.Scheme != "http" && u.Scheme != "https" {
+		return "", errors.Errorf("invalid url '%s', expected an http or https URL", value)
+	}
+	ips, err := d.lookup(u.Hostname())
+	if err != nil || len(ips) == 0 {
+		return "", errors.Errorf("unable to resolve the host of '%s'", value)
+	}
+	for _, ip := range ips {
+		if !d.allowed(ip) {
+			return "", errors.Errorf("invalid url '%s', webhooks may not point to the private address %s", value, ip)
+		}
+	}
+	return u.String(), nil
+}
+
+// sleepUntil d passed, returning false if stop was closed before
+func sleepUntil(d time.Duration, stop <-chan struct{}) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-stop:
+		return false
+	}
+}
+
+// dispatch e to the queue of its guild once for every subscribed webhook.
+// Deliveries not fitting into the queue are recorded as failures.
+func (d *webhookDispatcher) dispatch(e Event) {
+	hooks, payload, err := d.deliveries(e)
+	if err != nil {
+		d.log.Error("unable to read webhooks", zap.String("guild", e.GuildID()), zap.Error(err))
+		return
+	}
+	var overflow []Webhook
+	d.mu.Lock()
+	if d.closed {
+		d.mu.Unlock()
+		return
+	}
+	queue := d.queue(e.GuildID())
+	for _, hook := range hooks {
+		select {
+		case queue <- webhookDelivery{hook: hook, payload: payload}:
+		default:
+			overflow = append(overflow, hook)
+		}
+	}
+	d.mu.Unlock()
+	for _, hook := range overflow {
+		d.fail(hook, payload, 0, errors.New("delivery queue of the guild is full"))
+	}
+}
+
+// dropped e before it could be dispatched, recording it as failure of every subscribed webhook
+func (d *webhookDispatcher) dropped(e Event) {
+	hooks, payload, err := d.deliveries(e)
+	if err != nil {
+		d.log.Error("unable to read webhooks", zap.String("guild", e.GuildID()), zap.Error(err))
+		return
+	}
+	for _, hook := range hooks {
+		d.fail(hook, payload, 0, errors.New("event queue of the webhooks is full"))
+	}
+}
+
+// deliveries of e, the webhooks of its guild subscribed to it and their payload
+func (d *webhookDispatcher) deliveries(e Event) ([]Webhook, WebhookPayload, error) {
+	payload := WebhookPayload{Event: e.Kind(), Guild: e.GuildID(), Time: e.At(), Detail: e.Detail()}
+	if ve, ok := e.(VoteEvent); ok {
+		payload.Vote = newWebhookVote(ve.EventVote())
+	}
+	hooks, err := d.store().ReadWebhooks(e.GuildID())
+	if err != nil {
+		return nil, payload, err
+	}
+	var subscribed []Webhook
+	for _, hook := range hooks {
+		if hook.wants(e.Kind()) {
+			subscribed = append(subscribed, hook)
+		}
+	}
+	return subscribed, payload, nil
+}
+
+// queue of guild, starting its workers on first use. Requires d.mu.
+func (d *webhookDispatcher) queue(guild string) chan webhookDelivery {
+	queue, ok := d.queues[guild]
+	if ok {
+		return queue
+	}
+	queue = make(chan webhookDelivery, webhookBuffer)
+	d.queues[guild] = queue
+	for i := 0; i < webhookWorkers; i++ {
+		d.wg.Add(1)
+		go func() {
+			defer d.wg.Done()
+			for delivery := range queue {
+				d.deliver(delivery.hook, delivery.payload)
+			}
+		}()
+	}
+	return queue
+}
+
+// deliver payload to hook with retries, recording a WebhookFailure once they are exhausted
+func (d *webhookDispatcher) deliver(hook Webhook, payload WebhookPayload) {
+	payload.Delivery = newDeliveryID()
+	body, err := json.Marshal(payload)
+	if err != nil {
+		d.log.Error("unable to encode webhook payload", zap.String("guild", hook.Guild), zap.Error(err))
+		return
+	}
+	backoff := webhookBackoff
+	attempts := 0
+	for {
+		attempts++
+		err = d.post(hook, payload, body)
+		if err == nil {
+			d.log.Debug("delivered webhook", zap.String("guild", hook.Guild), zap.Int64("webhook", hook.ID), zap.String("event", string(payload.Event)))
+			return
+		}
+		d.log.Info("webhook delivery failed", zap.String("guild", hook.Guild), zap.Int64("webhook", hook.ID), zap.Int("attempt", attempts), zap.Error(err))
+		if attempts >= webhookMaxAttempts || !d.sleep(backoff, d.stop) {
+			break
+		}
+		backoff = backoff * 2
+	}
+	d.fail(hook, payload, attempts, err)
+}
+
+// fail the delivery of payload to hook after attempts, recording it as WebhookFailure
+func (d *webhookDispatcher) fail(hook Webhook, payload WebhookPayload, attempts int, cause error) {
+	if payload.Delivery == "" {
+		payload.Delivery = newDeliveryID()
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		d.log.Error("unable to encode webhook payload", zap.String("guild", hook.Guild), zap.Error(err))
+		return
+	}
+	err = d.store().InsertWebhookFailure(WebhookFailure{
+		Guild:    hook.Guild,
+		Webhook:  hook.ID,
+		URL:      hook.URL,
+		Event:    payload.Event,
+		Payload:  string(body),
+		Attempts: attempts,
+		Error:    cause.Error(),
+		Failed:   d.now(),
+	})
+	if err != nil {
+		d.log.Error("unable to record webhook failure", zap.String("guild", hook.Guild), zap.Int64("webhook", hook.ID), zap.Error(err))
+	}
+}
+
+// post body to hook once, any status but 2xx is a failure
+func (d *webhookDispatcher) post(hook Webhook, payload WebhookPayload, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "democracy.bot")
+	req.Header.Set("X-Democracy-Event", string(payload.Event))
+	req.Header.Set("X-Democracy-Delivery", payload.Delivery)
+	req.Header.Set(WebhookSignatureHeader, SignWebhook(hook.Secret, body))
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return errors.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// Close giving up pending retries, recording them as failures
+func (d *webhookDispatcher) Close() {
+	d.mu.Lock()
+	if d.closed {
+		d.mu.Unlock()
+		return
+	}
+	d.closed = true
+	close(d.stop)
+	for _, queue := range d.queues {
+		close(queue)
+	}
+	d.mu.Unlock()
+	d.wg.Wait()
+}
+
+// newDeliveryID of a payload
+func newDeliveryID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// newWebhookSecret signing the payloads of a webhook
+func newWebhookSecret() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", errors.Wrap(err, "unable to generate webhook secret")
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// parseWebhookEvents from a comma separated list of event kinds or all
+func parseWebhookEvents(value string) ([]EventKind, error) {
+	if value == "" || value == "all" {
+		return nil, nil
+	}
+	var kinds []EventKind
+	for _, name := range strings.Split(value, ",") {
+		kind := EventKind(strings.TrimSpace(name))
+		known := false
+		for _, k := range webhookEvents {
+			known = known || k == kind
+		}
+		if !known {
+			var names []string
+			for _, k := range webhookEvents {
+				names = append(names, string(k))
+			}
+			return nil, errors.Errorf("unknown event '%s', expected all or some of %s", kind, strings.Join(names, ", "))
+		}
+		kinds = append(kinds, kind)
+	}
+	return kinds, nil
+}
+
+// WebhookCommand Message Handler managing the webhooks of the guild
+func (v *VoteHandler) WebhookCommand(c *discordgo.Channel, s Session, m *discordgo.MessageCreate) error {
+	if m.Content == "reset_handler" {
+		return nil
+	}
+
+	usage := "Invalid webhook command. Please follow this schema: '!democracy webhook add [url] [events|all]', '!democracy webhook list', '!democracy webhook remove [id]', '!democracy webhook test [id]' or '!democracy webhook failures'"
+	args := strings.Fields(strings.TrimPrefix(m.Content, "webhook"))
+	if len(args) < 1 {
+		return userError("invalid webhook command", usage)
+	}
+	g, err := s.Guild(c.GuildID)
+	if err != nil {
+		return internalError("could not fetch guild", "unable to update webhooks", err)
+	}
+	if g.OwnerID != m.Author.ID {
+		return permissionError("Only the server owner may manage webhooks.")
+	}
+
+	switch {
+	case args[0] == "add" && (len(args) == 2 || len(args) == 3):
+		return v.addWebhook(s, g, m, args[1:])
+	case args[0] == "list" && len(args) == 1:
+		hooks, err := v.db(s).ReadWebhooks(c.GuildID)
+		if err != nil {
+			return internalError("unable to read webhooks", "Failed to read webhooks from DB. Please contact support.", err)
+		}
+		if len(hooks) == 0 {
+			return v.reply(s, m, "There are no webhooks.")
+		}
+		var lines []string
+		for _, hook := range hooks {
+			lines = append(lines, fmt.Sprintf("%d: <%s> (%s)", hook.ID, hook.URL, hook.events()))
+		}
+		return v.reply(s, m, "Webhooks:\n"+strings.Join(lines, "\n"))
+	case args[0] == "remove" && len(args) == 2:
+		id, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return userError("invalid webhook id", usage)
+		}
+		deleted, err := v.db(s).DeleteWebhook(c.GuildID, id)
+		if err != nil {
+			return internalError("unable to remove webhook", "Failed to remove webhook from DB. Please contact support.", err)
+		}
+		if !deleted {
+			return userError("unknown webhook", fmt.Sprintf("There is no webhook %d.", id))
+		}
+		return v.reply(s, m, fmt.Sprintf("Webhook %d removed.", id))
+	case args[0] == "test" && len(args) == 2:
+		return v.testWebhook(s, m, c.GuildID, args[1])
+	case args[0] == "failures" && len(args) == 1:
+		failures, err := v.db(s).ReadWebhookFailures(c.GuildID, 10)
+		if err != nil {
+			return internalError("unable to read webhook failures", "Failed to read webhook failures from DB. Please contact support.", err)
+		}
+		if len(failures) == 0 {
+			return v.reply(s, m, "No failed webhook deliveries.")
+		}
+		var lines []string
+		for _, f := range failures {
+			lines = append(lines, fmt.Sprintf("%s webhook %d %s after %d attempts: %s", f.Failed.UTC().Format("02-01-2006 - 15:04:05"), f.Webhook, f.Event, f.Attempts, f.Error))
+		}
+		return v.reply(s, m, "Failed deliveries:\n"+strings.Join(lines, "\n"))
+	default:
+		return userError("invalid webhook command", usage)
+	}
+}
+
+// addWebhook from the url and optional events in args, sending its secret to the owner
+func (v *VoteHandler) addWebhook(s Session, g *discordgo.Guild, m *discordgo.MessageCreate, args []string) error {
+	u, err := v.webhooks.checkURL(args[0])
+	if err != nil {
+		return userError("invalid webhook url", err.Error())
+	}
+	var events []EventKind
+	if len(args) > 1 {
+		events, err = parseWebhookEvents(args[1])
+		if err != nil {
+			return userError("invalid webhook events", err.Error())
+		}
+	}
+	secret, err := newWebhookSecret()
+	if err != nil {
+		return internalError("unable to create webhook", "", err)
+	}
+	hook, err := v.db(s).InsertWebhook(Webhook{Guild: g.ID, URL: u, Events: events, Secret: secret, Created: v.clock.Now()})
+	if err != nil {
+		return internalError("unable to store webhook", "Failed to store webhook in DB. Please contact support.", err)
+	}
+	// the secret verifies the payloads, it never shows up in the channel
+	dm, err := v.discord(s).UserChannelCreate(m.Author.ID)
+	if err == nil {
+		_, err = v.discord(s).ChannelMessageSend(dm.ID, fmt.Sprintf(
+			"Webhook %d for %s signs its payloads with the secret `%s`\nVerify the %s header, the HMAC-SHA256 of the body.",
+			hook.ID, g.Name, secret, WebhookSignatureHeader,
+		))
+	}
+	if err != nil {
+		v.db(s).DeleteWebhook(g.ID, hook.ID)
+		return internalError("unable to send webhook secret", "Failed to send you the webhook secret, please allow direct messages from server members.", err)
+	}
+	return v.reply(s, m, fmt.Sprintf("Webhook %d added for %s events, its secret was sent to you.", hook.ID, hook.events()))
+}
+
+// testWebhook of guild by its id, delivering a test payload once
+func (v *VoteHandler) testWebhook(s Session, m *discordgo.MessageCreate, guild, id string) error {
+	hooks, err := v.db(s).ReadWebhooks(guild)
+	if err != nil {
+		return internalError("unable to read webhooks", "Failed to read webhooks from DB. Please contact support.", err)
+	}
+	for _, hook := range hooks {
+		if strconv.FormatInt(hook.ID, 10) != id {
+			continue
+		}
+		payload := WebhookPayload{Delivery: newDeliveryID(), Event: webhookTestEvent, Guild: guild, Time: v.clock.Now(), Detail: "test delivery"}
+		body, _ := json.Marshal(payload)
+		err = v.webhooks.post(hook, payload, body)
+		if err != nil {
+			return userError("webhook test failed", fmt.Sprintf("Test delivery to webhook %d failed: %s", hook.ID, err))
+		}
+		return v.reply(s, m, fmt.Sprintf("Test delivery to webhook %d succeeded.", hook.ID))
+	}
+	return userError("unknown webhook", fmt.Sprintf("There is no webhook %s.", id))
+}
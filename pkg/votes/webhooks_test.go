@@ -0,0 +1,31 @@
+package votes
+
+import (
+	"net"
+	"testing"
+)
+
+func TestPublicAddress(t *testing.T) {
+	tests := map[string]bool{
+		"8.8.8.8":              true,
+		"2001:4860:4860::8888": true,
+		"127.0.0.1":            false,
+		"::1":                  false,
+		"0.0.0.0":              false,
+		"0.1.2.3":              false,
+		"10.1.2.3":             false,
+		"100.64.0.1":           false,
+		"100.128.0.1":          true,
+		"172.16.0.1":           false,
+		"172.32.0.1":           true,
+		"192.168.1.1":          false,
+		"169.254.169.254":      false,
+		"fd00::1":              false,
+		"fe80::1":              false,
+	}
+	for address, want := range tests {
+		if got := publicAddress(net.ParseIP(address)); got != want {
+			t.Errorf("%s: expected public %v, got %v", address, want, got)
+		}
+	}
+}
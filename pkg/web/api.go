@@ -0,0 +1,639 @@
+package web
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/playnet-public/democracy.bot/pkg/votes"
+	"go.uber.org/zap"
+)
+
+const (
+	// APIPrefix the versioned REST API is served at
+	APIPrefix = "/api/v1"
+	// apiPageSize of votes returned without per_page
+	apiPageSize = 20
+	// apiMaxPageSize of votes or events returned at once
+	apiMaxPageSize = 100
+	// apiTokenTTL of the members resolved from Discord OAuth2 tokens
+	apiTokenTTL = 5 * time.Minute
+	// apiMaxBody of requests in bytes
+	apiMaxBody = 1 << 16
+)
+
+// API serving guilds, votes, ballots, election state and audit events as JSON.
+// Clients authenticate with a Discord OAuth2 token or with an API key of one guild.
+type API struct {
+	log     *zap.Logger
+	votes   *votes.VoteHandler
+	session votes.Session
+	// discord is the base URL of the Discord REST API
+	discord string
+	client  *http.Client
+	routes  []route
+
+	mu     sync.Mutex
+	tokens map[string]cachedCaller
+}
+
+// caller of the API, either a Discord member or an API key of a guild
+type caller struct {
+	// User and Guilds of a member authenticated with a Discord OAuth2 token
+	User   string
+	Guilds []string
+	// Key of a guild, proposing on behalf of the users it names
+	Key *votes.APIKey
+}
+
+// cachedCaller resolved from a token until expires
+type cachedCaller struct {
+	caller  caller
+	expires time.Time
+}
+
+// allowed reports whether the caller may access guild
+func (c caller) allowed(guild string) bool {
+	if c.Key != nil {
+		return c.Key.Guild == guild
+	}
+	return member{Guilds: c.Guilds}.member(guild)
+}
+
+// route of the API, documented in its OpenAPI spec
+type route struct {
+	method string
+	// pattern like /guilds/{guild}/votes/{number}
+	pattern string
+	summary string
+	// query parameters understood by the route
+	query []string
+	// body and result are zero values of the request and response types
+	body   interface{}
+	result interface{}
+	// public routes need no authentication
+	public bool
+	// member routes act as the member and need their Discord OAuth2 token, API keys may not cast ballots for anyone
+	member bool
+	// stream routes keep the response open and accept credentials as query parameters,
+	// as browsers can not set headers on WebSockets and EventSources
+	stream bool
+	// accepted describes when the route answers 202 Accepted instead of completing the request
+	accepted string
+	handle   func(w http.ResponseWriter, r *http.Request, c caller, p map[string]string)
+}
+
+// NewAPI backed by the VoteHandler and Discord session of the bot
+func NewAPI(log *zap.Logger, v *votes.VoteHandler, s votes.Session) *API {
+	a := &API{
+		log:     log,
+		votes:   v,
+		session: s,
+		discord: discordAPI,
+		client:  &http.Client{Timeout: 10 * time.Second},
+		tokens:  make(map[string]cachedCaller),
+	}
+	a.routes = []route{
+		{method: http.MethodGet, pattern: "/openapi.json", summary: "OpenAPI spec of this API", public: true, handle: a.spec},
+		{method: http.MethodGet, pattern: "/guilds", summary: "Guilds accessible to the caller", result: []apiGuild{}, handle: a.listGuilds},
+		{method: http.MethodGet, pattern: "/guilds/{guild}", summary: "Guild", result: apiGuild{}, handle: a.getGuild},
+		{method: http.MethodGet, pattern: "/guilds/{guild}/votes", summary: "Votes of the guild, newest first",
+			query: []string{"status", "author", "tag", "from", "to", "page", "per_page"}, result: apiVotePage{}, handle: a.listVotes},
+		{method: http.MethodPost, pattern: "/guilds/{guild}/votes", summary: "Propose a vote, checked against the rules of the guild",
+			body: apiProposal{}, result: apiVote{}, accepted: "Posted as a draft without a number, the guild requires cosponsors", handle: a.createVote},
+		{method: http.MethodGet, pattern: "/guilds/{guild}/votes/{number}", summary: "Vote by its number", result: apiVote{}, handle: a.getVote},
+		{method: http.MethodGet, pattern: "/guilds/{guild}/votes/{number}/tally", summary: "Tally of a vote", result: apiTally{}, handle: a.getTally},
+		{method: http.MethodPut, pattern: "/guilds/{guild}/votes/{number}/ballot", summary: "Cast a ballot, replacing a previous one",
+			body: apiBallot{}, result: apiTally{}, member: true, handle: a.castBallot},
+		{method: http.MethodDelete, pattern: "/guilds/{guild}/votes/{number}/ballot", summary: "Retract a ballot",
+			result: apiTally{}, member: true, handle: a.retractBallot},
+		{method: http.MethodGet, pattern: "/guilds/{guild}/election", summary: "Rules and scheduled votes of the guild", result: apiElection{}, handle: a.getElection},
+		{method: http.MethodGet, pattern: "/guilds/{guild}/members/{user}/terms", summary: "Terms served by a member and whether they may be nominated",
+			result: apiTerms{}, handle: a.getTerms},
+		{method: http.MethodGet, pattern: "/guilds/{guild}/events", summary: "Audit events of the guild, newest first",
+			query: []string{"before", "limit"}, result: apiEventPage{}, handle: a.listEvents},
+		{method: http.MethodGet, pattern: "/guilds/{guild}/stream", summary: "Live events of the guild as WebSocket messages or server-sent events",
+			query: []string{"api_key", "access_token"}, result: apiStreamEvent{}, stream: true, handle: a.stream},
+	}
+	return a
+}
+
+// ServeHTTP routing r below APIPrefix
+func (a *API) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, APIPrefix), "/")
+	var allowed []string
+	for _, rt := range a.routes {
+		p, ok := match(rt.pattern, path)
+		if !ok {
+			continue
+		}
+		if rt.method != r.Method {
+			allowed = append(allowed, rt.method)
+			continue
+		}
+		var c caller
+		if !rt.public {
+			c, ok = a.authenticate(w, r, rt.stream)
+			if !ok {
+				return
+			}
+			if guild, ok := p["guild"]; ok && !c.allowed(guild) {
+				a.error(w, http.StatusForbidden, "guild not accessible")
+				return
+			}
+			if rt.member && c.Key != nil {
+				a.error(w, http.StatusForbidden, "ballots have to be cast with the Discord OAuth2 token of the member")
+				return
+			}
+		}
+		if !rt.stream {
+			r.Body = http.MaxBytesReader(w, r.Body, apiMaxBody)
+		}
+		rt.handle(w, r, c, p)
+		return
+	}
+	if len(allowed) > 0 {
+		w.Header().Set("Allow", strings.Join(allowed, ", "))
+		a.error(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	a.error(w, http.StatusNotFound, "not found")
+}
+
+// match path against pattern, returning the values of its {parameters}
+func match(pattern, path string) (map[string]string, bool) {
+	want := strings.Split(pattern, "/")
+	got := strings.Split(path, "/")
+	if len(want) != len(got) {
+		return nil, false
+	}
+	p := make(map[string]string)
+	for i := range want {
+		if strings.HasPrefix(want[i], "{") {
+			if got[i] == "" {
+				return nil, false
+			}
+			p[strings.Trim(want[i], "{}")] = got[i]
+			continue
+		}
+		if want[i] != got[i] {
+			return nil, false
+		}
+	}
+	return p, true
+}
+
+// authenticate r by its X-API-Key header or its Discord OAuth2 bearer token,
+// for streams also by the api_key or access_token query parameters
+func (a *API) authenticate(w http.ResponseWriter, r *http.Request, stream bool) (caller, bool) {
+	key := r.Header.Get("X-API-Key")
+	auth := r.Header.Get("Authorization")
+	if stream && key == "" && auth == "" {
+		key = r.URL.Query().Get("api_key")
+		if token := r.URL.Query().Get("access_token"); token != "" {
+			auth = "Bearer " + token
+		}
+	}
+	if key != "" {
+		k, err := a.votes.Authenticate(key)
+		if err == votes.ErrUnknownAPIKey {
+			a.error(w, http.StatusUnauthorized, "invalid api key")
+			return caller{}, false
+		}
+		if err != nil {
+			a.fail(w, "unable to authenticate api key", "", err)
+			return caller{}, false
+		}
+		return caller{Key: &k}, true
+	}
+	if !strings.HasPrefix(auth, "Bearer ") {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="democracy.bot"`)
+		a.error(w, http.StatusUnauthorized, "missing Discord OAuth2 bearer token or X-API-Key")
+		return caller{}, false
+	}
+	c, err := a.member(strings.TrimPrefix(auth, "Bearer "))
+	if err != nil {
+		a.log.Debug("invalid bearer token", zap.Error(err))
+		a.error(w, http.StatusUnauthorized, "invalid Discord OAuth2 token")
+		return caller{}, false
+	}
+	return c, true
+}
+
+// member owning the Discord OAuth2 token, cached for apiTokenTTL to spare the Discord rate limits
+func (a *API) member(token string) (caller, error) {
+	sum := sha256.Sum256([]byte(token))
+	key := hex.EncodeToString(sum[:])
+	now := time.Now()
+	a.mu.Lock()
+	cached, ok := a.tokens[key]
+	a.mu.Unlock()
+	if ok && now.Before(cached.expires) {
+		return cached.caller, nil
+	}
+
+	var user struct {
+		ID string `json:"id"`
+	}
+	err := a.get(token, "/users/@me", &user)
+	if err != nil {
+		return caller{}, err
+	}
+	var guilds []struct {
+		ID string `json:"id"`
+	}
+	err = a.get(token, "/users/@me/guilds", &guilds)
+	if err != nil {
+		return caller{}, err
+	}
+	c := caller{User: user.ID}
+	for _, g := range guilds {
+		if _, err := a.session.StateGuild(g.ID); err == nil {
+			c.Guilds = append(c.Guilds, g.ID)
+		}
+	}
+
+	a.mu.Lock()
+	for k, t := range a.tokens {
+		if now.After(t.expires) {
+			delete(a.tokens, k)
+		}
+	}
+	a.tokens[key] = cachedCaller{caller: c, expires: now.Add(apiTokenTTL)}
+	a.mu.Unlock()
+	return c, nil
+}
+
+// get path of the Discord API with token, decoding the response into v
+func (a *API) get(token, path string, v interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, a.discord+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("unexpected status %s for %s", resp.Status, path)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// acting user of a request in guild, the member itself or the author named by an API key client.
+// Named users have to be members of the guild, so a key can not make up authors.
+func (a *API) acting(w http.ResponseWriter, c caller, guild, named string) (string, bool) {
+	if c.Key == nil {
+		return c.User, true
+	}
+	if named == "" {
+		a.error(w, http.StatusBadRequest, "requests with an api key have to name the user")
+		return "", false
+	}
+	g, err := a.session.StateGuild(guild)
+	if err != nil {
+		a.fail(w, "unable to read guild members", guild, err)
+		return "", false
+	}
+	for _, m := range g.Members {
+		if m.User != nil && m.User.ID == named && !m.User.Bot {
+			return named, true
+		}
+	}
+	a.error(w, http.StatusForbidden, "user is not a member of the guild")
+	return "", false
+}
+
+// sessionOf the caller, attributing the events caused by API key clients to their key
+func (a *API) sessionOf(c caller) votes.Session {
+	if c.Key == nil {
+		return a.session
+	}
+	return votes.ViaAPIKey(a.session, c.Key.Name)
+}
+
+// listGuilds accessible to the caller
+func (a *API) listGuilds(w http.ResponseWriter, r *http.Request, c caller, p map[string]string) {
+	ids := c.Guilds
+	if c.Key != nil {
+		ids = []string{c.Key.Guild}
+	}
+	guilds := []apiGuild{}
+	for _, id := range ids {
+		guilds = append(guilds, a.guild(id))
+	}
+	a.write(w, http.StatusOK, guilds)
+}
+
+// getGuild by its ID
+func (a *API) getGuild(w http.ResponseWriter, r *http.Request, c caller, p map[string]string) {
+	a.write(w, http.StatusOK, a.guild(p["guild"]))
+}
+
+// guild from the state of the bot
+func (a *API) guild(id string) apiGuild {
+	g := apiGuild{ID: id, Name: id}
+	if guild, err := a.session.StateGuild(id); err == nil {
+		g.Name = guild.Name
+	}
+	return g
+}
+
+// listVotes of a guild filtered by the query and paged by page and per_page
+func (a *API) listVotes(w http.ResponseWriter, r *http.Request, c caller, p map[string]string) {
+	q := r.URL.Query()
+	var args []string
+	for _, name := range []string{"status", "author", "tag", "from", "to"} {
+		if value := q.Get(name); value != "" {
+			args = append(args, name+":"+value)
+		}
+	}
+	filter, err := votes.ParseVoteFilter(args)
+	if err != nil {
+		a.error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	page, ok := a.intParam(w, q.Get("page"), 1, 1, 0)
+	if !ok {
+		return
+	}
+	perPage, ok := a.intParam(w, q.Get("per_page"), apiPageSize, 1, apiMaxPageSize)
+	if !ok {
+		return
+	}
+
+	total, err := a.votes.CountVotes(p["guild"], filter)
+	if err != nil {
+		a.fail(w, "unable to count votes", p["guild"], err)
+		return
+	}
+	filter.Newest = true
+	filter.Offset = (page - 1) * perPage
+	filter.Limit = perPage
+	list, err := a.votes.ListVotes(p["guild"], filter)
+	if err != nil {
+		a.fail(w, "unable to read votes", p["guild"], err)
+		return
+	}
+	result := apiVotePage{Votes: []apiVote{}, Page: page, PerPage: perPage, Total: total}
+	for _, vote := range list {
+		result.Votes = append(result.Votes, newAPIVote(vote))
+	}
+	a.write(w, http.StatusOK, result)
+}
+
+// intParam parsed from value, def if it is empty. A zero max is unbounded.
+func (a *API) intParam(w http.ResponseWriter, value string, def, min, max int) (int, bool) {
+	if value == "" {
+		return def, true
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < min || max > 0 && n > max {
+		a.error(w, http.StatusBadRequest, "invalid number '"+value+"'")
+		return 0, false
+	}
+	return n, true
+}
+
+// createVote proposed by the member or the author named by an API key client
+func (a *API) createVote(w http.ResponseWriter, r *http.Request, c caller, p map[string]string) {
+	var proposal apiProposal
+	if !a.decode(w, r, &proposal) {
+		return
+	}
+	author, ok := a.acting(w, c, p["guild"], proposal.Author)
+	if !ok {
+		return
+	}
+	var tags []string
+	for _, tag := range proposal.Tags {
+		tags = append(tags, votes.ParseTags(tag)...)
+	}
+	vote, err := a.votes.Propose(a.sessionOf(c), p["guild"], author,
+		strings.TrimSpace(proposal.Title),
+		strings.TrimSpace(proposal.Text),
+		strings.TrimSpace(proposal.Duration),
+		tags,
+	)
+	if verr, ok := err.(votes.VoteError); ok {
+		a.error(w, http.StatusBadRequest, verr.Error())
+		return
+	}
+	if err == votes.ErrDrafted {
+		// drafts are numbered once they open
+		a.log.Info("vote drafted via api", zap.String("guild", p["guild"]), zap.String("draft", vote.ID), zap.String("author", author))
+		a.write(w, http.StatusAccepted, newAPIVote(vote))
+		return
+	}
+	if err != nil {
+		a.fail(w, "unable to propose vote", p["guild"], err)
+		return
+	}
+	a.log.Info("vote proposed via api", zap.String("guild", p["guild"]), zap.Int("vote", vote.Number), zap.String("author", author))
+	w.Header().Set("Location", APIPrefix+"/guilds/"+p["guild"]+"/votes/"+strconv.Itoa(vote.Number))
+	a.write(w, http.StatusCreated, newAPIVote(vote))
+}
+
+// vote of the request by its number, writing a 404 if there is none
+func (a *API) vote(w http.ResponseWriter, p map[string]string) (votes.Vote, bool) {
+	number, err := strconv.Atoi(p["number"])
+	if err != nil {
+		a.error(w, http.StatusNotFound, "vote not found")
+		return votes.Vote{}, false
+	}
+	vote, err := a.votes.GetVote(p["guild"], number)
+	if err != nil {
+		a.error(w, http.StatusNotFound, "vote not found")
+		return vote, false
+	}
+	return vote, true
+}
+
+// getVote by its number
+func (a *API) getVote(w http.ResponseWriter, r *http.Request, c caller, p map[string]string) {
+	vote, ok := a.vote(w, p)
+	if !ok {
+		return
+	}
+	a.write(w, http.StatusOK, newAPIVote(vote))
+}
+
+// getTally of a vote
+func (a *API) getTally(w http.ResponseWriter, r *http.Request, c caller, p map[string]string) {
+	vote, ok := a.vote(w, p)
+	if !ok {
+		return
+	}
+	a.write(w, http.StatusOK, newAPITally(vote))
+}
+
+// castBallot of the member
+func (a *API) castBallot(w http.ResponseWriter, r *http.Request, c caller, p map[string]string) {
+	var ballot apiBallot
+	if !a.decode(w, r, &ballot) {
+		return
+	}
+	if ballot.Ballot != "pro" && ballot.Ballot != "con" {
+		a.error(w, http.StatusBadRequest, "ballot has to be pro or con")
+		return
+	}
+	vote, ok := a.vote(w, p)
+	if !ok {
+		return
+	}
+	user := c.User
+	vote, err := a.votes.CastBallot(a.session, vote, user, ballot.Ballot == "pro")
+	if err == votes.ErrVoteClosed {
+		a.error(w, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		a.fail(w, "unable to cast ballot", p["guild"], err)
+		return
+	}
+	a.log.Info("ballot cast via api", zap.String("guild", p["guild"]), zap.Int("vote", vote.Number), zap.String("user", user))
+	a.write(w, http.StatusOK, newAPITally(vote))
+}
+
+// retractBallot of the member
+func (a *API) retractBallot(w http.ResponseWriter, r *http.Request, c caller, p map[string]string) {
+	vote, ok := a.vote(w, p)
+	if !ok {
+		return
+	}
+	user := c.User
+	vote, err := a.votes.RetractBallot(a.session, vote, user)
+	if err == votes.ErrVoteClosed {
+		a.error(w, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		a.fail(w, "unable to retract ballot", p["guild"], err)
+		return
+	}
+	a.log.Info("ballot retracted via api", zap.String("guild", p["guild"]), zap.Int("vote", vote.Number), zap.String("user", user))
+	a.write(w, http.StatusOK, newAPITally(vote))
+}
+
+// getElection rules and scheduled votes of a guild
+func (a *API) getElection(w http.ResponseWriter, r *http.Request, c caller, p map[string]string) {
+	rules, err := a.votes.GuildRules(p["guild"])
+	if err != nil {
+		a.fail(w, "unable to read rules", p["guild"], err)
+		return
+	}
+	schedules, err := a.votes.Schedules(p["guild"])
+	if err != nil {
+		a.fail(w, "unable to read schedules", p["guild"], err)
+		return
+	}
+	election := apiElection{Rules: newAPIRules(rules), Schedules: []apiSchedule{}}
+	for _, schedule := range schedules {
+		election.Schedules = append(election.Schedules, apiSchedule{
+			ID:         schedule.ID,
+			Title:      schedule.Title,
+			Starts:     schedule.Starts,
+			Duration:   schedule.Duration.String(),
+			Recurrence: string(schedule.Recurrence),
+		})
+	}
+	a.write(w, http.StatusOK, election)
+}
+
+// getTerms of a member and whether the term limits allow nominating them
+func (a *API) getTerms(w http.ResponseWriter, r *http.Request, c caller, p map[string]string) {
+	terms, err := a.votes.Terms(p["guild"], p["user"])
+	if err != nil {
+		a.fail(w, "unable to read terms", p["guild"], err)
+		return
+	}
+	result := apiTerms{User: p["user"], Terms: []apiTerm{}, Eligible: true}
+	for _, term := range terms {
+		result.Terms = append(result.Terms, apiTerm{Role: term.Role, Start: term.Start, End: term.End})
+	}
+	if err := a.votes.CheckNomination(p["guild"], p["user"]); err != nil {
+		result.Eligible = false
+		result.Reason = err.Error()
+	}
+	a.write(w, http.StatusOK, result)
+}
+
+// listEvents of a guild, paged by the ID of the last event seen
+func (a *API) listEvents(w http.ResponseWriter, r *http.Request, c caller, p map[string]string) {
+	q := r.URL.Query()
+	before, err := strconv.ParseInt(q.Get("before"), 10, 64)
+	if q.Get("before") != "" && (err != nil || before < 1) {
+		a.error(w, http.StatusBadRequest, "invalid cursor '"+q.Get("before")+"'")
+		return
+	}
+	limit, ok := a.intParam(w, q.Get("limit"), apiPageSize, 1, apiMaxPageSize)
+	if !ok {
+		return
+	}
+	events, err := a.votes.AuditEvents(p["guild"], before, limit)
+	if err != nil {
+		a.fail(w, "unable to read audit events", p["guild"], err)
+		return
+	}
+	result := apiEventPage{Events: []apiEvent{}}
+	for _, event := range events {
+		result.Events = append(result.Events, apiEvent{
+			ID:      event.ID,
+			Kind:    string(event.Kind),
+			Vote:    event.Number,
+			User:    event.User,
+			Created: event.Created,
+			Detail:  event.Detail,
+			APIKey:  event.Key,
+		})
+	}
+	// a full page may be followed by older events
+	if len(events) == limit {
+		result.Next = events[len(events)-1].ID
+	}
+	a.write(w, http.StatusOK, result)
+}
+
+// spec of the API as OpenAPI document
+func (a *API) spec(w http.ResponseWriter, r *http.Request, c caller, p map[string]string) {
+	a.write(w, http.StatusOK, openAPI(a.routes))
+}
+
+// decode the JSON body of r into v, writing a 400 if it is invalid
+func (a *API) decode(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	err := json.NewDecoder(r.Body).Decode(v)
+	if err != nil {
+		a.error(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return false
+	}
+	return true
+}
+
+// write v as JSON response with status
+func (a *API) write(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		a.log.Error("unable to encode response", zap.Error(err))
+	}
+}
+
+// error response with status and msg
+func (a *API) error(w http.ResponseWriter, status int, msg string) {
+	a.write(w, status, apiError{Error: msg})
+}
+
+// fail the request with an internal error
+func (a *API) fail(w http.ResponseWriter, msg, guild string, err error) {
+	a.log.Error(msg, zap.String("guild", guild), zap.Error(err))
+	a.error(w, http.StatusInternalServerError, "internal error")
+}
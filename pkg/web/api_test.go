@@ -0,0 +1,125 @@
+package web
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+// call the API at path with the headers, returning the status and decoded body
+func (td *testDashboard) call(server *httptest.Server, method, path string, headers map[string]string, body interface{}) (int, map[string]interface{}) {
+	var buf bytes.Buffer
+	if body != nil {
+		json.NewEncoder(&buf).Encode(body)
+	}
+	req, _ := http.NewRequest(method, server.URL+APIPrefix+path, &buf)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		td.t.Fatalf("%s %s: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+	var result map[string]interface{}
+	json.NewDecoder(resp.Body).Decode(&result)
+	return resp.StatusCode, result
+}
+
+var apiKeyPattern = regexp.MustCompile("`(dem_[0-9a-f]+)`")
+
+func TestAPI(t *testing.T) {
+	td := newTestDashboard(t)
+	defer td.close()
+	api := NewAPI(zap.NewNop(), td.votes, td.discord)
+	api.discord = td.api.URL
+	server := httptest.NewServer(api)
+	defer server.Close()
+	alice := map[string]string{"Authorization": "Bearer token"}
+	guild := "/guilds/" + td.guild.ID
+
+	if status, spec := td.call(server, http.MethodGet, "/openapi.json", nil, nil); status != http.StatusOK || spec["paths"].(map[string]interface{})["/guilds/{guild}/votes"] == nil {
+		t.Fatalf("expected the spec without authentication, got %d %v", status, spec)
+	}
+	if status, _ := td.call(server, http.MethodGet, "/guilds", nil, nil); status != http.StatusUnauthorized {
+		t.Errorf("expected unauthenticated requests to fail, got %d", status)
+	}
+	if status, _ := td.call(server, http.MethodGet, "/guilds", map[string]string{"Authorization": "Bearer forged"}, nil); status != http.StatusUnauthorized {
+		t.Errorf("expected invalid tokens to fail, got %d", status)
+	}
+	if status, _ := td.call(server, http.MethodGet, "/guilds/elsewhere/votes", alice, nil); status != http.StatusForbidden {
+		t.Errorf("expected a guild without the bot to be forbidden, got %d", status)
+	}
+
+	status, vote := td.call(server, http.MethodPost, guild+"/votes", alice, apiProposal{Title: "Coffee", Text: "Buy a coffee machine", Duration: "1h", Tags: []string{"Kitchen"}})
+	if status != http.StatusCreated || vote["number"] != 1.0 || vote["author"] != td.guild.OwnerID {
+		t.Fatalf("expected the proposed vote, got %d %v", status, vote)
+	}
+	if status, body := td.call(server, http.MethodPost, guild+"/votes", alice, apiProposal{Title: "Empty"}); status != http.StatusBadRequest {
+		t.Errorf("expected an invalid proposal to be rejected, got %d %v", status, body)
+	}
+	status, tally := td.call(server, http.MethodPut, guild+"/votes/1/ballot", alice, apiBallot{Ballot: "pro"})
+	if status != http.StatusOK || tally["pro"] != 1.0 || tally["status"] != "open" {
+		t.Errorf("expected the ballot in the tally, got %d %v", status, tally)
+	}
+	_, page := td.call(server, http.MethodGet, guild+"/votes?status=open&tag=kitchen&per_page=5", alice, nil)
+	if page["total"] != 1.0 || page["per_page"] != 5.0 || len(page["votes"].([]interface{})) != 1 {
+		t.Errorf("expected the filtered vote, got %v", page)
+	}
+	if status, _ := td.call(server, http.MethodGet, guild+"/votes?per_page=1000", alice, nil); status != http.StatusBadRequest {
+		t.Errorf("expected oversized pages to be rejected, got %d", status)
+	}
+
+	// the owner creates a key in chat and receives it by direct message
+	owner, _ := td.discord.User(td.guild.OwnerID)
+	democracy := td.discord.ChannelByName(td.guild, "democracy")
+	td.votes.APIKeyCommand(democracy, td.discord, td.discord.Message(democracy.ID, owner, "apikey create widget"))
+	td.votes.Flush()
+	dms := td.discord.DirectMessages(owner)
+	if len(dms) != 1 || !apiKeyPattern.MatchString(dms[0].Content) {
+		t.Fatalf("expected the key by direct message, got %v", dms)
+	}
+	key := map[string]string{"X-API-Key": apiKeyPattern.FindStringSubmatch(dms[0].Content)[1]}
+
+	// keys can not cast or retract ballots, not even for a member they name
+	if status, _ := td.call(server, http.MethodPut, guild+"/votes/1/ballot?user="+td.guild.OwnerID, key, map[string]string{"ballot": "con", "user": td.guild.OwnerID}); status != http.StatusForbidden {
+		t.Errorf("expected ballots with a key to be forbidden, got %d", status)
+	}
+	if status, _ := td.call(server, http.MethodDelete, guild+"/votes/1/ballot?user="+td.guild.OwnerID, key, nil); status != http.StatusForbidden {
+		t.Errorf("expected retracting with a key to be forbidden, got %d", status)
+	}
+	status, tally = td.call(server, http.MethodDelete, guild+"/votes/1/ballot", alice, nil)
+	if status != http.StatusOK || tally["pro"] != 0.0 {
+		t.Errorf("expected the retracted ballot, got %d %v", status, tally)
+	}
+	if status, _ := td.call(server, http.MethodPost, guild+"/votes", key, apiProposal{Title: "Tea", Text: "Buy tea", Author: "made-up"}); status != http.StatusForbidden {
+		t.Errorf("expected proposals of users outside the guild to be forbidden, got %d", status)
+	}
+	if status, vote := td.call(server, http.MethodPost, guild+"/votes", key, apiProposal{Title: "Tea", Text: "Buy tea", Author: td.guild.OwnerID}); status != http.StatusCreated || vote["author"] != td.guild.OwnerID {
+		t.Errorf("expected the proposal of the named member, got %d %v", status, vote)
+	}
+
+	_, events := td.call(server, http.MethodGet, guild+"/events?limit=2", key, nil)
+	list := events["events"].([]interface{})
+	if len(list) != 2 || list[0].(map[string]interface{})["kind"] != "vote_created" || list[0].(map[string]interface{})["api_key"] != "widget" || events["next"] == nil {
+		t.Fatalf("expected the newest audit events with a cursor, got %v", events)
+	}
+	_, events = td.call(server, http.MethodGet, guild+"/events?before="+jsonNumber(events["next"]), key, nil)
+	if list := events["events"].([]interface{}); len(list) != 2 || list[1].(map[string]interface{})["kind"] != "vote_created" {
+		t.Errorf("expected the first ballot and vote creation on the next page, got %v", events)
+	}
+	if status, _ := td.call(server, http.MethodGet, "/guilds/elsewhere/votes", key, nil); status != http.StatusForbidden {
+		t.Errorf("expected keys to be limited to their guild, got %d", status)
+	}
+}
+
+// jsonNumber decoded from JSON as query value
+func jsonNumber(v interface{}) string {
+	b, _ := json.Marshal(v)
+	return string(b)
+}
@@ -0,0 +1,161 @@
+package web
+
+import (
+	"time"
+
+	"github.com/playnet-public/democracy.bot/pkg/votes"
+)
+
+// apiError returned with every unsuccessful response
+type apiError struct {
+	Error string `json:"error"`
+}
+
+// apiGuild accessible to the caller
+type apiGuild struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// apiVote of a guild
+type apiVote struct {
+	Number      int       `json:"number"`
+	ID          string    `json:"id"`
+	Title       string    `json:"title"`
+	Description string    `json:"description"`
+	Author      string    `json:"author"`
+	Created     time.Time `json:"created"`
+	Expires     time.Time `json:"expires"`
+	Tags        []string  `json:"tags"`
+	apiTally
+}
+
+// apiTally of a vote, its status is open, accepted or rejected
+type apiTally struct {
+	Status string `json:"status"`
+	Pro    int    `json:"pro"`
+	Con    int    `json:"con"`
+}
+
+// apiVotePage of the votes matching a filter
+type apiVotePage struct {
+	Votes   []apiVote `json:"votes"`
+	Page    int       `json:"page"`
+	PerPage int       `json:"per_page"`
+	Total   int       `json:"total"`
+}
+
+// apiProposal of a new vote. Author is required with an API key only.
+type apiProposal struct {
+	Title    string   `json:"title"`
+	Text     string   `json:"text"`
+	Duration string   `json:"duration,omitempty"`
+	Tags     []string `json:"tags,omitempty"`
+	Author   string   `json:"author,omitempty"`
+}
+
+// apiBallot of pro or con
+type apiBallot struct {
+	Ballot string `json:"ballot"`
+}
+
+// apiElection state of a guild
+type apiElection struct {
+	Rules     apiRules      `json:"rules"`
+	Schedules []apiSchedule `json:"schedules"`
+}
+
+// apiRules of a guild named like in the rules command, durations like 72h0m0s
+type apiRules struct {
+	MaxConsecutiveTerms int    `json:"max_consecutive_terms"`
+	MaxTotalTerms       int    `json:"max_total_terms"`
+	MandatoryBreak      string `json:"mandatory_break"`
+	DistrustCooldown    string `json:"distrust_cooldown"`
+	ReproposeCooldown   string `json:"repropose_cooldown"`
+	MinDuration         string `json:"min_duration"`
+	MaxDuration         string `json:"max_duration"`
+}
+
+// apiSchedule opening votes in the future
+type apiSchedule struct {
+	ID         int64     `json:"id"`
+	Title      string    `json:"title"`
+	Starts     time.Time `json:"starts"`
+	Duration   string    `json:"duration"`
+	Recurrence string    `json:"recurrence"`
+}
+
+// apiTerms served by a member
+type apiTerms struct {
+	User     string    `json:"user"`
+	Terms    []apiTerm `json:"terms"`
+	Eligible bool      `json:"eligible"`
+	// Reason the member may not be nominated
+	Reason string `json:"reason,omitempty"`
+}
+
+// apiTerm in an elected role
+type apiTerm struct {
+	Role  string    `json:"role"`
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// apiEventPage of audit events, Next is the before cursor of the following page
+type apiEventPage struct {
+	Events []apiEvent `json:"events"`
+	Next   int64      `json:"next,omitempty"`
+}
+
+// apiEvent of the audit log
+type apiEvent struct {
+	ID      int64     `json:"id"`
+	Kind    string    `json:"kind"`
+	Vote    int       `json:"vote,omitempty"`
+	User    string    `json:"user,omitempty"`
+	Created time.Time `json:"created"`
+	Detail  string    `json:"detail,omitempty"`
+	// APIKey acting for the user, empty if they acted on Discord
+	APIKey string `json:"api_key,omitempty"`
+}
+
+func newAPIVote(vote votes.Vote) apiVote {
+	tags := vote.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+	return apiVote{
+		Number:      vote.Number,
+		ID:          vote.ID,
+		Title:       vote.Title,
+		Description: vote.Description,
+		Author:      vote.Author,
+		Created:     vote.Created,
+		Expires:     vote.Expires,
+		Tags:        tags,
+		apiTally:    newAPITally(vote),
+	}
+}
+
+func newAPITally(vote votes.Vote) apiTally {
+	t := apiTally{Status: "open", Pro: vote.Pro, Con: vote.Con}
+	switch {
+	case vote.Closed && vote.Accepted():
+		t.Status = "accepted"
+	case vote.Closed:
+		t.Status = "rejected"
+	}
+	return t
+}
+
+func newAPIRules(rules votes.Rules) apiRules {
+	return apiRules{
+		MaxConsecutiveTerms: rules.MaxConsecutiveTerms,
+		MaxTotalTerms:       rules.MaxTotalTerms,
+		MandatoryBreak:      rules.MandatoryBreak.String(),
+		DistrustCooldown:    rules.DistrustCooldown.String(),
+		ReproposeCooldown:   rules.ReproposeCooldown.String(),
+		MinDuration:         rules.MinDuration.String(),
+		MaxDuration:         rules.MaxDuration.String(),
+	}
+}
@@ -0,0 +1,293 @@
+package web
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/context"
+	"github.com/gorilla/sessions"
+	"github.com/playnet-public/democracy.bot/pkg/votes"
+	"go.uber.org/zap"
+	"golang.org/x/net/xsrftoken"
+	"golang.org/x/oauth2"
+)
+
+// historyPageSize of closed votes shown on one page of a guild
+const historyPageSize = 20
+
+// Config of the dashboard
+type Config struct {
+	// ClientID and ClientSecret of the Discord application
+	ClientID     string
+	ClientSecret string
+	// Callback URL registered with the Discord application, served at /callback
+	Callback string
+	// SessionKey signing the session cookies and form tokens
+	SessionKey []byte
+}
+
+// Dashboard serving the votes of all guilds shared by the bot and a member logged in with Discord
+type Dashboard struct {
+	log     *zap.Logger
+	votes   *votes.VoteHandler
+	session votes.Session
+	oauth   *oauth2.Config
+	cookies *sessions.CookieStore
+	key     string
+	// api is the base URL of the Discord REST API
+	api string
+	mux *http.ServeMux
+}
+
+// NewDashboard backed by the VoteHandler and Discord session of the bot
+func NewDashboard(log *zap.Logger, v *votes.VoteHandler, s votes.Session, config Config) *Dashboard {
+	d := &Dashboard{
+		log:     log,
+		votes:   v,
+		session: s,
+		oauth: &oauth2.Config{
+			ClientID:     config.ClientID,
+			ClientSecret: config.ClientSecret,
+			RedirectURL:  config.Callback,
+			Scopes:       []string{"identify", "guilds"},
+			Endpoint:     discordEndpoint,
+		},
+		cookies: sessions.NewCookieStore(config.SessionKey),
+		key:     string(config.SessionKey),
+		api:     discordAPI,
+		mux:     http.NewServeMux(),
+	}
+	d.cookies.Options = &sessions.Options{
+		Path:     "/",
+		MaxAge:   7 * 24 * 60 * 60,
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(config.Callback, "https://"),
+	}
+	d.mux.HandleFunc("/", d.index)
+	d.mux.HandleFunc("/login", d.login)
+	d.mux.HandleFunc("/callback", d.callback)
+	d.mux.HandleFunc("/logout", d.logout)
+	d.mux.HandleFunc("/guilds/", d.guild)
+	return d
+}
+
+// ServeHTTP routing r to the dashboard pages
+func (d *Dashboard) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// the session registry of gorilla/sessions is kept per request in gorilla/context
+	context.ClearHandler(d.mux).ServeHTTP(w, r)
+}
+
+// guildView listed on the index
+type guildView struct {
+	ID   string
+	Name string
+}
+
+// index listing the guilds of the logged in member
+func (d *Dashboard) index(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	u, ok := d.user(r)
+	if !ok {
+		d.render(w, "login", nil)
+		return
+	}
+	var guilds []guildView
+	for _, id := range u.Guilds {
+		guilds = append(guilds, guildView{ID: id, Name: d.guildName(id)})
+	}
+	d.render(w, "index", struct {
+		User   member
+		Guilds []guildView
+		Token  string
+	}{u, guilds, xsrftoken.Generate(d.key, u.ID, "logout")})
+}
+
+// guildName from the state of the bot
+func (d *Dashboard) guildName(id string) string {
+	g, err := d.session.StateGuild(id)
+	if err != nil {
+		return id
+	}
+	return g.Name
+}
+
+// guild routes /guilds/{guild}, /guilds/{guild}/votes and /guilds/{guild}/votes/{number}/ballot
+func (d *Dashboard) guild(w http.ResponseWriter, r *http.Request) {
+	u, ok := d.user(r)
+	if !ok {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/guilds/"), "/"), "/")
+	guild := parts[0]
+	if !u.member(guild) || !d.inGuild(guild, u.ID) {
+		http.Error(w, "You are not a member of this server or it does not use the bot.", http.StatusForbidden)
+		return
+	}
+	switch {
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		d.votesPage(w, r, u, guild, "")
+	case len(parts) == 2 && parts[1] == "votes" && r.Method == http.MethodPost:
+		d.propose(w, r, u, guild)
+	case len(parts) == 4 && parts[1] == "votes" && parts[3] == "ballot" && r.Method == http.MethodPost:
+		number, err := strconv.Atoi(parts[2])
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		d.ballot(w, r, u, guild, number)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// voteView of a vote on the guild page
+type voteView struct {
+	votes.Vote
+	AuthorName string
+	Result     string
+}
+
+// votesPage of guild listing its open votes and a page of closed votes
+func (d *Dashboard) votesPage(w http.ResponseWriter, r *http.Request, u member, guild, message string) {
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	if page < 1 {
+		page = 1
+	}
+	open, err := d.votes.ListVotes(guild, votes.VoteFilter{Status: votes.StatusOpen, Newest: true})
+	if err != nil {
+		d.fail(w, "unable to read open votes", guild, err)
+		return
+	}
+	closedFilter := votes.VoteFilter{Status: votes.StatusClosed}
+	count, err := d.votes.CountVotes(guild, closedFilter)
+	if err != nil {
+		d.fail(w, "unable to count closed votes", guild, err)
+		return
+	}
+	closedFilter.Newest = true
+	closedFilter.Offset = (page - 1) * historyPageSize
+	closedFilter.Limit = historyPageSize
+	closed, err := d.votes.ListVotes(guild, closedFilter)
+	if err != nil {
+		d.fail(w, "unable to read closed votes", guild, err)
+		return
+	}
+	data := struct {
+		User     member
+		Guild    guildView
+		Open     []voteView
+		Closed   []voteView
+		Page     int
+		Previous int
+		Next     int
+		Message  string
+		Token    string
+	}{
+		User:    u,
+		Guild:   guildView{ID: guild, Name: d.guildName(guild)},
+		Open:    d.views(open),
+		Closed:  d.views(closed),
+		Page:    page,
+		Message: message,
+		Token:   xsrftoken.Generate(d.key, u.ID, guild),
+	}
+	if page > 1 {
+		data.Previous = page - 1
+	}
+	if page*historyPageSize < count {
+		data.Next = page + 1
+	}
+	d.render(w, "guild", data)
+}
+
+// views of list with the names of their authors
+func (d *Dashboard) views(list []votes.Vote) []voteView {
+	views := make([]voteView, 0, len(list))
+	for _, vote := range list {
+		view := voteView{Vote: vote, AuthorName: vote.Author}
+		if author, err := d.votes.Author(d.session, vote.Author); err == nil {
+			view.AuthorName = author.Username
+		}
+		switch {
+		case !vote.Closed:
+			view.Result = "Open"
+		case vote.Accepted():
+			view.Result = "Accepted"
+		default:
+			view.Result = "Rejected"
+		}
+		views = append(views, view)
+	}
+	return views
+}
+
+// ballot cast by the member on vote number of guild
+func (d *Dashboard) ballot(w http.ResponseWriter, r *http.Request, u member, guild string, number int) {
+	if !xsrftoken.Valid(r.PostFormValue("token"), d.key, u.ID, guild) {
+		http.Error(w, "Invalid form token, please reload the page.", http.StatusForbidden)
+		return
+	}
+	vote, err := d.votes.GetVote(guild, number)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	_, err = d.votes.CastBallot(d.session, vote, u.ID, r.PostFormValue("ballot") == "pro")
+	if err == votes.ErrVoteClosed {
+		d.votesPage(w, r, u, guild, "Vote #"+strconv.Itoa(number)+" is closed.")
+		return
+	}
+	if err != nil {
+		d.fail(w, "unable to cast ballot", guild, err)
+		return
+	}
+	d.log.Info("ballot cast on dashboard", zap.String("guild", guild), zap.Int("vote", number), zap.String("user", u.ID))
+	http.Redirect(w, r, "/guilds/"+guild, http.StatusSeeOther)
+}
+
+// propose a vote by the member in guild
+func (d *Dashboard) propose(w http.ResponseWriter, r *http.Request, u member, guild string) {
+	if !xsrftoken.Valid(r.PostFormValue("token"), d.key, u.ID, guild) {
+		http.Error(w, "Invalid form token, please reload the page.", http.StatusForbidden)
+		return
+	}
+	vote, err := d.votes.Propose(d.session, guild, u.ID,
+		strings.TrimSpace(r.PostFormValue("title")),
+		strings.TrimSpace(r.PostFormValue("text")),
+		strings.TrimSpace(r.PostFormValue("duration")),
+		votes.ParseTags(r.PostFormValue("tags")),
+	)
+	if verr, ok := err.(votes.VoteError); ok {
+		d.votesPage(w, r, u, guild, verr.Error())
+		return
+	}
+	if err == votes.ErrDrafted {
+		d.votesPage(w, r, u, guild, "Your vote was posted as a draft, it opens once enough members cosponsor it in Discord.")
+		return
+	}
+	if err != nil {
+		d.fail(w, "unable to propose vote", guild, err)
+		return
+	}
+	d.votesPage(w, r, u, guild, "Vote #"+strconv.Itoa(vote.Number)+" is open.")
+}
+
+// fail the request with an internal error
+func (d *Dashboard) fail(w http.ResponseWriter, msg, guild string, err error) {
+	d.log.Error(msg, zap.String("guild", guild), zap.Error(err))
+	http.Error(w, "Something went wrong, please try again later.", http.StatusInternalServerError)
+}
+
+// render the template name with data
+func (d *Dashboard) render(w http.ResponseWriter, name string, data interface{}) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err := templates.ExecuteTemplate(w, name, data)
+	if err != nil {
+		d.log.Error("unable to render template", zap.String("template", name), zap.Error(err))
+	}
+}
@@ -0,0 +1,173 @@
+package web
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/playnet-public/democracy.bot/pkg/discordtest"
+	"github.com/playnet-public/democracy.bot/pkg/votes"
+	"go.uber.org/zap"
+)
+
+// testDashboard logged in as alice, against a fake Discord with OAuth2 and API endpoints
+type testDashboard struct {
+	t       *testing.T
+	discord *discordtest.Session
+	votes   *votes.VoteHandler
+	guild   *discordgo.Guild
+	alice   *discordgo.User
+	server  *httptest.Server
+	api     *httptest.Server
+	client  *http.Client
+}
+
+func newTestDashboard(t *testing.T) *testDashboard {
+	td := &testDashboard{t: t, discord: discordtest.NewSession()}
+	alice := td.discord.AddUser("alice")
+	td.guild = td.discord.AddGuild("guild", alice)
+	td.discord.AddMember(td.guild, alice)
+	td.alice = alice
+	// enough members for a single ballot to not decide a vote
+	for _, name := range []string{"bob", "carol"} {
+		td.discord.AddMember(td.guild, td.discord.AddUser(name))
+	}
+	td.discord.AddChannel(td.guild, "democracy")
+	td.votes = votes.NewVoteHandler(zap.NewNop())
+	td.votes.SetStore(votes.NewMemoryStore())
+
+	d := NewDashboard(zap.NewNop(), td.votes, td.discord, Config{ClientID: "id", ClientSecret: "secret", SessionKey: []byte("key")})
+	td.server = httptest.NewServer(d)
+	td.api = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/oauth2/authorize":
+			q := r.URL.Query()
+			http.Redirect(w, r, q.Get("redirect_uri")+"?code=code&state="+url.QueryEscape(q.Get("state")), http.StatusFound)
+			return
+		case "/oauth2/token":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"access_token":"token","token_type":"Bearer"}`))
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer token" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/users/@me":
+			json.NewEncoder(w).Encode(map[string]string{"id": alice.ID, "username": alice.Username})
+		case "/users/@me/guilds":
+			json.NewEncoder(w).Encode([]map[string]string{{"id": td.guild.ID}, {"id": "elsewhere"}})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	d.api = td.api.URL
+	d.oauth.Endpoint.AuthURL = td.api.URL + "/oauth2/authorize"
+	d.oauth.Endpoint.TokenURL = td.api.URL + "/oauth2/token"
+	d.oauth.RedirectURL = td.server.URL + "/callback"
+
+	jar, _ := cookiejar.New(nil)
+	td.client = &http.Client{Jar: jar}
+	return td
+}
+
+func (td *testDashboard) close() {
+	td.server.Close()
+	td.api.Close()
+	td.votes.Close()
+}
+
+// do the request, returning the status and body of the final response
+func (td *testDashboard) do(method, path string, form url.Values) (int, string) {
+	var resp *http.Response
+	var err error
+	if method == http.MethodPost {
+		resp, err = td.client.PostForm(td.server.URL+path, form)
+	} else {
+		resp, err = td.client.Get(td.server.URL + path)
+	}
+	if err != nil {
+		td.t.Fatalf("%s %s: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	return resp.StatusCode, string(body)
+}
+
+var tokenPattern = regexp.MustCompile(`name="token" value="([^"]+)"`)
+
+// token of the first form in page
+func (td *testDashboard) token(page string) string {
+	m := tokenPattern.FindStringSubmatch(page)
+	if m == nil {
+		td.t.Fatalf("no form token in %s", page)
+	}
+	return m[1]
+}
+
+func TestDashboard(t *testing.T) {
+	td := newTestDashboard(t)
+	defer td.close()
+
+	if _, page := td.do(http.MethodGet, "/", nil); !strings.Contains(page, "Log in with Discord") {
+		t.Fatalf("expected the login page, got %s", page)
+	}
+	status, page := td.do(http.MethodGet, "/login", nil)
+	if status != http.StatusOK || !strings.Contains(page, `href="/guilds/`+td.guild.ID+`">guild<`) {
+		t.Fatalf("expected the shared guild after login, got %d %s", status, page)
+	}
+	if strings.Contains(page, "elsewhere") {
+		t.Error("expected guilds without the bot to be hidden")
+	}
+	if status, _ := td.do(http.MethodGet, "/guilds/elsewhere", nil); status != http.StatusForbidden {
+		t.Errorf("expected a foreign guild to be forbidden, got %d", status)
+	}
+
+	_, page = td.do(http.MethodGet, "/guilds/"+td.guild.ID, nil)
+	token := td.token(page)
+	if status, _ := td.do(http.MethodPost, "/guilds/"+td.guild.ID+"/votes", url.Values{"title": {"Coffee"}, "text": {"Buy a coffee machine"}, "token": {"forged"}}); status != http.StatusForbidden {
+		t.Errorf("expected a forged form to be rejected, got %d", status)
+	}
+	_, page = td.do(http.MethodPost, "/guilds/"+td.guild.ID+"/votes", url.Values{
+		"title": {"Coffee"}, "text": {"Buy a coffee machine"}, "duration": {"1h"}, "tags": {"Kitchen"}, "token": {token},
+	})
+	if !strings.Contains(page, "Vote #1 is open.") || !strings.Contains(page, "#1 Coffee") {
+		t.Fatalf("expected the proposed vote, got %s", page)
+	}
+
+	_, page = td.do(http.MethodPost, "/guilds/"+td.guild.ID+"/votes/1/ballot", url.Values{"ballot": {"pro"}, "token": {token}})
+	if !strings.Contains(page, "Open · Pro 1 · Con 0") {
+		t.Errorf("expected the ballot in the tally, got %s", page)
+	}
+	td.votes.Flush()
+	democracy := td.discord.ChannelByName(td.guild, "democracy")
+	msgs := td.discord.Messages(democracy.ID)
+	if len(msgs) != 1 || msgs[0].Embeds[0].Title != "[Vote] Coffee" {
+		t.Fatalf("expected the vote to be posted to Discord, got %v", msgs)
+	}
+	for _, f := range msgs[0].Embeds[0].Fields {
+		if f.Name == "Pro" && f.Value != ":white_check_mark: [ 1 ]" {
+			t.Errorf("expected the ballot on the Discord embed, got %q", f.Value)
+		}
+	}
+
+	td.discord.RemoveMember(td.guild, td.alice)
+	if status, _ := td.do(http.MethodPost, "/guilds/"+td.guild.ID+"/votes/1/ballot", url.Values{"ballot": {"con"}, "token": {token}}); status != http.StatusForbidden {
+		t.Errorf("expected a ballot after leaving the guild to be forbidden, got %d", status)
+	}
+	td.discord.AddMember(td.guild, td.alice)
+
+	_, page = td.do(http.MethodGet, "/", nil)
+	td.do(http.MethodPost, "/logout", url.Values{"token": {td.token(page)}})
+	if _, page := td.do(http.MethodGet, "/", nil); !strings.Contains(page, "Log in with Discord") {
+		t.Errorf("expected to be logged out, got %s", page)
+	}
+}
@@ -0,0 +1,82 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// Check of a dependency, returning an error while it is unusable
+type Check func() error
+
+// Health serving /healthz, answering as long as the process is running,
+// and /readyz, answering 503 unless all checks pass
+type Health struct {
+	log *zap.Logger
+
+	mu     sync.Mutex
+	names  []string
+	checks map[string]Check
+}
+
+// NewHealth without any checks
+func NewHealth(log *zap.Logger) *Health {
+	return &Health{log: log, checks: make(map[string]Check)}
+}
+
+// Add check by name to the readiness of h
+func (h *Health) Add(name string, check Check) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.checks[name]; !ok {
+		h.names = append(h.names, name)
+	}
+	h.checks[name] = check
+}
+
+// Register /healthz and /readyz of h on mux
+func (h *Health) Register(mux *http.ServeMux) {
+	mux.HandleFunc("/healthz", h.healthz)
+	mux.HandleFunc("/readyz", h.readyz)
+}
+
+func (h *Health) healthz(w http.ResponseWriter, r *http.Request) {
+	h.write(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// readyz running all checks, reporting the error of each failing one
+func (h *Health) readyz(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	names := append([]string(nil), h.names...)
+	checks := make(map[string]Check, len(h.checks))
+	for name, check := range h.checks {
+		checks[name] = check
+	}
+	h.mu.Unlock()
+
+	status := http.StatusOK
+	result := map[string]string{}
+	for _, name := range names {
+		err := checks[name]()
+		if err != nil {
+			h.log.Warn("readiness check failed", zap.String("check", name), zap.Error(err))
+			status = http.StatusServiceUnavailable
+			result[name] = err.Error()
+			continue
+		}
+		result[name] = "ok"
+	}
+	h.write(w, status, result)
+}
+
+func (h *Health) write(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		h.log.Error("unable to write health", zap.Error(err))
+	}
+}
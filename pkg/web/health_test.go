@@ -0,0 +1,38 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestHealth(t *testing.T) {
+	health := NewHealth(zap.NewNop())
+	var discord error
+	health.Add("discord", func() error { return discord })
+	health.Add("store", func() error { return nil })
+	mux := http.NewServeMux()
+	health.Register(mux)
+
+	get := func(path string) (int, map[string]string) {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		var result map[string]string
+		json.NewDecoder(w.Body).Decode(&result)
+		return w.Code, result
+	}
+	if status, result := get("/readyz"); status != http.StatusOK || result["discord"] != "ok" || result["store"] != "ok" {
+		t.Errorf("expected ready, got %d %v", status, result)
+	}
+	discord = errors.New("discord gateway disconnected")
+	if status, result := get("/readyz"); status != http.StatusServiceUnavailable || result["discord"] != "discord gateway disconnected" {
+		t.Errorf("expected the failing check to be reported, got %d %v", status, result)
+	}
+	if status, _ := get("/healthz"); status != http.StatusOK {
+		t.Errorf("expected healthy while not ready, got %d", status)
+	}
+}
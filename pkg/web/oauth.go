@@ -0,0 +1,179 @@
+package web
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"golang.org/x/net/xsrftoken"
+	"golang.org/x/oauth2"
+)
+
+const (
+	// sessionName of the dashboard cookie
+	sessionName = "democracy"
+	// discordAPI base URL
+	discordAPI = "https://discordapp.com/api"
+)
+
+// discordEndpoint for the OAuth2 authorization code flow
+var discordEndpoint = oauth2.Endpoint{
+	AuthURL:  discordAPI + "/oauth2/authorize",
+	TokenURL: discordAPI + "/oauth2/token",
+}
+
+// member logged in to the dashboard
+type member struct {
+	ID   string
+	Name string
+	// Guilds shared by the member and the bot at login
+	Guilds []string
+}
+
+// member reports whether the guild is shared by the member and the bot
+func (m member) member(guild string) bool {
+	for _, id := range m.Guilds {
+		if id == guild {
+			return true
+		}
+	}
+	return false
+}
+
+// inGuild reports whether user is still a member of guild in the state of the bot.
+// The guilds of the session cookie are only as recent as the login.
+func (d *Dashboard) inGuild(guild, user string) bool {
+	g, err := d.session.StateGuild(guild)
+	if err != nil {
+		return false
+	}
+	for _, m := range g.Members {
+		if m.User != nil && m.User.ID == user {
+			return true
+		}
+	}
+	return false
+}
+
+// cookie session of r, a new one if it is missing or invalid
+func (d *Dashboard) cookie(r *http.Request) *sessions.Session {
+	s, err := d.cookies.Get(r, sessionName)
+	if err != nil {
+		d.log.Debug("invalid session cookie", zap.Error(err))
+	}
+	return s
+}
+
+// user logged in with r
+func (d *Dashboard) user(r *http.Request) (member, bool) {
+	s := d.cookie(r)
+	id, ok := s.Values["user"].(string)
+	if !ok || id == "" {
+		return member{}, false
+	}
+	name, _ := s.Values["name"].(string)
+	guilds, _ := s.Values["guilds"].([]string)
+	return member{ID: id, Name: name, Guilds: guilds}, true
+}
+
+// login redirecting to Discord, remembering a random state to check on the callback
+func (d *Dashboard) login(w http.ResponseWriter, r *http.Request) {
+	s := d.cookie(r)
+	state := base64.RawURLEncoding.EncodeToString(securecookie.GenerateRandomKey(16))
+	s.Values["state"] = state
+	err := s.Save(r, w)
+	if err != nil {
+		d.fail(w, "unable to save session", "", err)
+		return
+	}
+	http.Redirect(w, r, d.oauth.AuthCodeURL(state), http.StatusFound)
+}
+
+// callback of Discord after the member authorized the dashboard
+func (d *Dashboard) callback(w http.ResponseWriter, r *http.Request) {
+	s := d.cookie(r)
+	state, _ := s.Values["state"].(string)
+	delete(s.Values, "state")
+	if state == "" || r.URL.Query().Get("state") != state {
+		http.Error(w, "Invalid login state, please try again.", http.StatusBadRequest)
+		return
+	}
+	token, err := d.oauth.Exchange(r.Context(), r.URL.Query().Get("code"))
+	if err != nil {
+		d.log.Info("unable to exchange oauth code", zap.Error(err))
+		http.Error(w, "Login failed, please try again.", http.StatusUnauthorized)
+		return
+	}
+	client := d.oauth.Client(r.Context(), token)
+
+	var user struct {
+		ID       string `json:"id"`
+		Username string `json:"username"`
+	}
+	err = d.get(client, "/users/@me", &user)
+	if err != nil {
+		d.fail(w, "unable to fetch user", "", err)
+		return
+	}
+	var guilds []struct {
+		ID string `json:"id"`
+	}
+	err = d.get(client, "/users/@me/guilds", &guilds)
+	if err != nil {
+		d.fail(w, "unable to fetch guilds", "", err)
+		return
+	}
+	// only guilds the bot is in as well, the session cookie stays small
+	var shared []string
+	for _, g := range guilds {
+		if _, err := d.session.StateGuild(g.ID); err == nil {
+			shared = append(shared, g.ID)
+		}
+	}
+
+	s.Values["user"] = user.ID
+	s.Values["name"] = user.Username
+	s.Values["guilds"] = shared
+	err = s.Save(r, w)
+	if err != nil {
+		d.fail(w, "unable to save session", "", err)
+		return
+	}
+	d.log.Info("dashboard login", zap.String("user", user.ID), zap.Int("guilds", len(shared)))
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// logout removing the session cookie
+func (d *Dashboard) logout(w http.ResponseWriter, r *http.Request) {
+	u, ok := d.user(r)
+	if r.Method != http.MethodPost || ok && !xsrftoken.Valid(r.PostFormValue("token"), d.key, u.ID, "logout") {
+		http.Error(w, "Invalid logout request.", http.StatusBadRequest)
+		return
+	}
+	s := d.cookie(r)
+	s.Values = make(map[interface{}]interface{})
+	s.Options.MaxAge = -1
+	err := s.Save(r, w)
+	if err != nil {
+		d.fail(w, "unable to save session", "", err)
+		return
+	}
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// get path of the Discord API as the logged in member, decoding the response into v
+func (d *Dashboard) get(client *http.Client, path string, v interface{}) error {
+	resp, err := client.Get(d.api + path)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("unexpected status %s for %s", resp.Status, path)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
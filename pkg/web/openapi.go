@@ -0,0 +1,169 @@
+package web
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// queryDocs of the query parameters used by the API routes
+var queryDocs = map[string]string{
+	"status":       "open, closed, accepted, rejected or all (default)",
+	"author":       "Discord user ID of the author",
+	"tag":          "tag of the votes",
+	"from":         "first day of creation as YYYY-MM-DD",
+	"to":           "last day of creation as YYYY-MM-DD",
+	"page":         "page starting at 1",
+	"per_page":     "votes per page, at most 100",
+	"before":       "only events before this event ID, the next cursor of the previous page",
+	"limit":        "events per page, at most 100",
+	"api_key":      "API key of the guild, for clients unable to set the X-API-Key header",
+	"access_token": "Discord OAuth2 token, for clients unable to set the Authorization header",
+}
+
+// openAPI document describing routes
+func openAPI(routes []route) map[string]interface{} {
+	schemas := make(map[string]interface{})
+	paths := make(map[string]map[string]interface{})
+	for _, rt := range routes {
+		op := map[string]interface{}{
+			"summary":   rt.summary,
+			"responses": openAPIResponses(rt, schemas),
+		}
+		var params []interface{}
+		for _, segment := range strings.Split(rt.pattern, "/") {
+			if strings.HasPrefix(segment, "{") {
+				params = append(params, map[string]interface{}{
+					"name":     strings.Trim(segment, "{}"),
+					"in":       "path",
+					"required": true,
+					"schema":   map[string]interface{}{"type": "string"},
+				})
+			}
+		}
+		for _, name := range rt.query {
+			params = append(params, map[string]interface{}{
+				"name":        name,
+				"in":          "query",
+				"description": queryDocs[name],
+				"schema":      map[string]interface{}{"type": "string"},
+			})
+		}
+		if len(params) > 0 {
+			op["parameters"] = params
+		}
+		if rt.body != nil {
+			op["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content":  jsonContent(schema(reflect.TypeOf(rt.body), schemas)),
+			}
+		}
+		if rt.public {
+			op["security"] = []interface{}{}
+		}
+		if rt.member {
+			op["security"] = []interface{}{map[string]interface{}{"discord": []string{}}}
+		}
+		if paths[rt.pattern] == nil {
+			paths[rt.pattern] = make(map[string]interface{})
+		}
+		paths[rt.pattern][strings.ToLower(rt.method)] = op
+	}
+	return map[string]interface{}{
+		"openapi": "3.0.0",
+		"info": map[string]interface{}{
+			"title":   "democracy.bot",
+			"version": "1",
+		},
+		"servers": []interface{}{map[string]interface{}{"url": APIPrefix}},
+		"paths":   paths,
+		"components": map[string]interface{}{
+			"schemas": schemas,
+			"securitySchemes": map[string]interface{}{
+				"discord": map[string]interface{}{"type": "http", "scheme": "bearer", "description": "Discord OAuth2 token with the identify and guilds scopes"},
+				"apiKey":  map[string]interface{}{"type": "apiKey", "in": "header", "name": "X-API-Key", "description": "API key of a guild, created with !democracy apikey create"},
+			},
+		},
+		"security": []interface{}{
+			map[string]interface{}{"discord": []string{}},
+			map[string]interface{}{"apiKey": []string{}},
+		},
+	}
+}
+
+// openAPIResponses of rt, its result and the errors it may return
+func openAPIResponses(rt route, schemas map[string]interface{}) map[string]interface{} {
+	ok := "200"
+	if rt.method == http.MethodPost {
+		ok = "201"
+	}
+	result := map[string]interface{}{"type": "object"}
+	if rt.result != nil {
+		result = schema(reflect.TypeOf(rt.result), schemas)
+	}
+	content := jsonContent(result)
+	if rt.stream {
+		// each server-sent event carries one result as data
+		content = map[string]interface{}{"text/event-stream": map[string]interface{}{"schema": result}}
+	}
+	errorSchema := schema(reflect.TypeOf(apiError{}), schemas)
+	responses := map[string]interface{}{
+		ok:        map[string]interface{}{"description": "OK", "content": content},
+		"default": map[string]interface{}{"description": "Error", "content": jsonContent(errorSchema)},
+	}
+	if rt.accepted != "" {
+		responses["202"] = map[string]interface{}{"description": rt.accepted, "content": content}
+	}
+	return responses
+}
+
+func jsonContent(s map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{"application/json": map[string]interface{}{"schema": s}}
+}
+
+// schema of t, adding named structs to schemas and referencing them
+func schema(t reflect.Type, schemas map[string]interface{}) map[string]interface{} {
+	if t == reflect.TypeOf(time.Time{}) {
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int32:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Int64:
+		return map[string]interface{}{"type": "integer", "format": "int64"}
+	case reflect.Slice:
+		return map[string]interface{}{"type": "array", "items": schema(t.Elem(), schemas)}
+	case reflect.Struct:
+		name := strings.TrimPrefix(t.Name(), "api")
+		if _, ok := schemas[name]; !ok {
+			properties := make(map[string]interface{})
+			// reserve the name, so recursive types terminate
+			schemas[name] = nil
+			structProperties(t, properties, schemas)
+			schemas[name] = map[string]interface{}{"type": "object", "properties": properties}
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+	}
+	return map[string]interface{}{}
+}
+
+// structProperties of t by their JSON names, inlining embedded structs like encoding/json
+func structProperties(t reflect.Type, properties, schemas map[string]interface{}) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Anonymous && f.Type.Kind() == reflect.Struct {
+			structProperties(f.Type, properties, schemas)
+			continue
+		}
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+		properties[name] = schema(f.Type, schemas)
+	}
+}
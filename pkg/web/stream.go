@@ -0,0 +1,174 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/playnet-public/democracy.bot/pkg/votes"
+	"go.uber.org/zap"
+)
+
+const (
+	// streamBuffer of events per client before further events are dropped
+	streamBuffer = 64
+	// streamHeartbeat keeping idle streams open through proxies
+	streamHeartbeat = 30 * time.Second
+	// streamWriteTimeout after which a stalled client is disconnected
+	streamWriteTimeout = 10 * time.Second
+	// streamSnapshot is the kind of the events describing the open votes on connect
+	streamSnapshot = "snapshot"
+)
+
+// upgrader of stream requests to WebSockets. Streams authenticate with tokens instead of cookies,
+// so pages of any origin may open them.
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	CheckOrigin:     func(r *http.Request) bool { return true },
+}
+
+// apiStreamEvent sent for every event of a guild, Vote is missing for events not happening to a vote
+type apiStreamEvent struct {
+	Kind   string    `json:"kind"`
+	Vote   *apiVote  `json:"vote,omitempty"`
+	Detail string    `json:"detail,omitempty"`
+	Time   time.Time `json:"time"`
+}
+
+// stream the events of a guild as WebSocket messages or, for other requests, as server-sent events.
+// Every stream starts with a snapshot of the open votes.
+func (a *API) stream(w http.ResponseWriter, r *http.Request, c caller, p map[string]string) {
+	guild := p["guild"]
+	sub := a.votes.Events().Subscribe(guild, streamBuffer)
+	defer sub.Close()
+	open, err := a.votes.ListVotes(guild, votes.VoteFilter{Status: votes.StatusOpen})
+	if err != nil {
+		a.fail(w, "unable to read open votes", guild, err)
+		return
+	}
+	var snapshot []apiStreamEvent
+	now := time.Now()
+	for _, vote := range open {
+		view := newAPIVote(vote)
+		snapshot = append(snapshot, apiStreamEvent{Kind: streamSnapshot, Vote: &view, Time: now})
+	}
+
+	if websocket.IsWebSocketUpgrade(r) {
+		a.streamWebSocket(w, r, guild, sub, snapshot)
+		return
+	}
+	a.streamSSE(w, r, guild, sub, snapshot)
+}
+
+// streamWebSocket writing each event as JSON text message
+func (a *API) streamWebSocket(w http.ResponseWriter, r *http.Request, guild string, sub *votes.Subscription, snapshot []apiStreamEvent) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// the upgrader already replied with an error
+		a.log.Debug("unable to upgrade stream", zap.String("guild", guild), zap.Error(err))
+		return
+	}
+	defer conn.Close()
+	a.log.Info("websocket stream opened", zap.String("guild", guild))
+
+	// clients only send control frames, reading them notices when they are gone
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		conn.SetReadLimit(512)
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	write := func(e apiStreamEvent) error {
+		conn.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+		return conn.WriteJSON(e)
+	}
+	for _, e := range snapshot {
+		if write(e) != nil {
+			return
+		}
+	}
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-closed:
+			a.log.Info("websocket stream closed", zap.String("guild", guild), zap.Uint64("dropped", sub.Dropped()))
+			return
+		case <-heartbeat.C:
+			err = conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(streamWriteTimeout))
+		case e := <-sub.C:
+			err = write(newAPIStreamEvent(e))
+		}
+		if err != nil {
+			a.log.Info("websocket stream failed", zap.String("guild", guild), zap.Error(err))
+			return
+		}
+	}
+}
+
+// streamSSE writing each event as server-sent event named by its kind
+func (a *API) streamSSE(w http.ResponseWriter, r *http.Request, guild string, sub *votes.Subscription, snapshot []apiStreamEvent) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		a.error(w, http.StatusInternalServerError, "streaming unsupported")
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// proxies like nginx would otherwise buffer the stream
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	a.log.Info("event stream opened", zap.String("guild", guild))
+
+	write := func(e apiStreamEvent) error {
+		data, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Kind, data)
+		flusher.Flush()
+		return err
+	}
+	for _, e := range snapshot {
+		if write(e) != nil {
+			return
+		}
+	}
+	flusher.Flush()
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+	var err error
+	for {
+		select {
+		case <-r.Context().Done():
+			a.log.Info("event stream closed", zap.String("guild", guild), zap.Uint64("dropped", sub.Dropped()))
+			return
+		case <-heartbeat.C:
+			_, err = fmt.Fprint(w, ": ping\n\n")
+			flusher.Flush()
+		case e := <-sub.C:
+			err = write(newAPIStreamEvent(e))
+		}
+		if err != nil {
+			a.log.Info("event stream failed", zap.String("guild", guild), zap.Error(err))
+			return
+		}
+	}
+}
+
+func newAPIStreamEvent(e votes.Event) apiStreamEvent {
+	event := apiStreamEvent{Kind: string(e.Kind()), Detail: e.Detail(), Time: e.At()}
+	if ve, ok := e.(votes.VoteEvent); ok {
+		view := newAPIVote(ve.EventVote())
+		event.Vote = &view
+	}
+	return event
+}
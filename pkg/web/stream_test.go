@@ -0,0 +1,99 @@
+package web
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"go.uber.org/zap"
+)
+
+// newTestStream serving the API of td with a proposed vote
+func newTestStream(t *testing.T, td *testDashboard) *httptest.Server {
+	api := NewAPI(zap.NewNop(), td.votes, td.discord)
+	api.discord = td.api.URL
+	server := httptest.NewServer(api)
+	if _, err := td.votes.Propose(td.discord, td.guild.ID, td.guild.OwnerID, "Coffee", "Buy a coffee machine", "1h", nil); err != nil {
+		t.Fatalf("propose: %v", err)
+	}
+	return server
+}
+
+func TestStreamWebSocket(t *testing.T) {
+	td := newTestDashboard(t)
+	defer td.close()
+	server := newTestStream(t, td)
+	defer server.Close()
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + APIPrefix + "/guilds/" + td.guild.ID + "/stream"
+	if _, resp, err := websocket.DefaultDialer.Dial(url, nil); err == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected unauthenticated streams to fail, got %v", err)
+	}
+	conn, _, err := websocket.DefaultDialer.Dial(url+"?access_token=token", nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	var e apiStreamEvent
+	if err := conn.ReadJSON(&e); err != nil || e.Kind != streamSnapshot || e.Vote == nil || e.Vote.Title != "Coffee" {
+		t.Fatalf("expected a snapshot of the open vote, got %+v (%v)", e, err)
+	}
+	vote, _ := td.votes.GetVote(td.guild.ID, 1)
+	td.votes.CastBallot(td.discord, vote, td.guild.OwnerID, true)
+	if err := conn.ReadJSON(&e); err != nil || e.Kind != "ballot_cast" || e.Vote == nil || e.Vote.Pro != 1 || e.Detail != "pro" {
+		t.Errorf("expected the counted ballot, got %+v (%v)", e, err)
+	}
+}
+
+func TestStreamSSE(t *testing.T) {
+	td := newTestDashboard(t)
+	defer td.close()
+	server := newTestStream(t, td)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + APIPrefix + "/guilds/" + td.guild.ID + "/stream?access_token=token")
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("expected an event stream, got %s", resp.Header.Get("Content-Type"))
+	}
+	events := make(chan apiStreamEvent)
+	go func() {
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			if data := strings.TrimPrefix(scanner.Text(), "data: "); data != scanner.Text() {
+				var e apiStreamEvent
+				json.Unmarshal([]byte(data), &e)
+				events <- e
+			}
+		}
+		close(events)
+	}()
+	next := func() apiStreamEvent {
+		select {
+		case e := <-events:
+			return e
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for event")
+		}
+		return apiStreamEvent{}
+	}
+
+	if e := next(); e.Kind != streamSnapshot {
+		t.Fatalf("expected a snapshot, got %+v", e)
+	}
+	vote, _ := td.votes.GetVote(td.guild.ID, 1)
+	td.votes.CastBallot(td.discord, vote, td.guild.OwnerID, false)
+	if e := next(); e.Kind != "ballot_cast" || e.Vote == nil || e.Vote.Con != 1 {
+		t.Errorf("expected the counted ballot, got %+v", e)
+	}
+}
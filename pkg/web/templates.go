@@ -0,0 +1,79 @@
+package web
+
+import "html/template"
+
+// templates of the dashboard pages
+var templates = template.Must(template.New("").Funcs(template.FuncMap{
+	"date": func(v voteView) string { return v.Expires.UTC().Format("02-01-2006 - 15:04") },
+}).Parse(`
+{{define "header"}}<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>democracy.bot</title>
+<style>
+body { font-family: sans-serif; max-width: 48em; margin: 2em auto; color: #2c2f33; }
+h1, h2 { color: #587987; }
+.vote { border-left: 4px solid #587987; padding: .5em 1em; margin: 1em 0; }
+.tally { font-weight: bold; }
+.message { background: #eef3f5; padding: .5em 1em; }
+form.inline { display: inline; }
+</style>
+</head>
+<body>
+<h1>democracy.bot</h1>
+{{end}}
+
+{{define "footer"}}</body>
+</html>
+{{end}}
+
+{{define "login"}}{{template "header"}}
+<p>This dashboard shows the votes of all Discord servers you share with the bot.</p>
+<p><a href="/login">Log in with Discord</a></p>
+{{template "footer"}}{{end}}
+
+{{define "index"}}{{template "header"}}
+<p>Logged in as {{.User.Name}}.
+<form class="inline" method="post" action="/logout"><input type="hidden" name="token" value="{{.Token}}"><button>Log out</button></form></p>
+<h2>Servers</h2>
+{{range .Guilds}}<p><a href="/guilds/{{.ID}}">{{.Name}}</a></p>
+{{else}}<p>You do not share a server with the bot.</p>
+{{end}}
+{{template "footer"}}{{end}}
+
+{{define "vote"}}<div class="vote">
+<h3>#{{.Number}} {{.Title}}</h3>
+<p>{{.Description}}</p>
+<p>by {{.AuthorName}} · {{if .Closed}}closed{{else}}due{{end}} {{date .}}{{range .Tags}} · {{.}}{{end}}</p>
+<p class="tally">{{.Result}} · Pro {{.Pro}} · Con {{.Con}}</p>
+</div>{{end}}
+
+{{define "guild"}}{{template "header"}}
+<p><a href="/">Servers</a> · {{.Guild.Name}}</p>
+{{if .Message}}<p class="message">{{.Message}}</p>{{end}}
+<h2>Open Votes</h2>
+{{$token := .Token}}{{$guild := .Guild.ID}}
+{{range .Open}}{{template "vote" .}}
+<form method="post" action="/guilds/{{$guild}}/votes/{{.Number}}/ballot">
+<input type="hidden" name="token" value="{{$token}}">
+<button name="ballot" value="pro">Pro</button> <button name="ballot" value="con">Con</button>
+</form>
+{{else}}<p>No open votes.</p>
+{{end}}
+<h2>Propose a Vote</h2>
+<form method="post" action="/guilds/{{.Guild.ID}}/votes">
+<input type="hidden" name="token" value="{{.Token}}">
+<p><input name="title" placeholder="Title" required></p>
+<p><textarea name="text" placeholder="Text" rows="4" cols="60" required></textarea></p>
+<p><input name="duration" placeholder="Duration like 3d"> <input name="tags" placeholder="Tags, comma separated"></p>
+<p><button>Propose</button></p>
+</form>
+<h2>Past Votes</h2>
+{{range .Closed}}{{template "vote" .}}
+{{else}}<p>No closed votes.</p>
+{{end}}
+<p>{{if .Previous}}<a href="/guilds/{{.Guild.ID}}?page={{.Previous}}">Newer</a>{{end}}
+{{if .Next}}<a href="/guilds/{{.Guild.ID}}?page={{.Next}}">Older</a>{{end}}</p>
+{{template "footer"}}{{end}}
+`))